@@ -9,11 +9,15 @@ require (
 	github.com/jung-kurt/gofpdf v1.1.0
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/pdfcpu/pdfcpu v0.2.4
+	golang.org/x/image v0.0.0-20190823064033-3a9bac650e44
+	golang.org/x/net v0.12.0
+	golang.org/x/text v0.13.0
+	software.sslmate.com/src/go-pkcs12 v0.4.0
 )
 
 require (
 	github.com/hhrutter/lzw v0.0.0-20190827003112-58b82c5a41cc // indirect
 	github.com/hhrutter/tiff v0.0.0-20190827003322-d08e2ad45835 // indirect
 	github.com/pkg/errors v0.8.1 // indirect
-	golang.org/x/image v0.0.0-20190823064033-3a9bac650e44 // indirect
+	golang.org/x/crypto v0.11.0 // indirect
 )
@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"golang.org/x/net/html"
+)
+
+// 无障碍检查发现的问题
+type AccessibilityWarning struct {
+	Rule    string `json:"rule"` // html-lang、document-title、img-alt、heading-order
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// HTML 源文件的无障碍检查结果，Title、Lang 取自 <title> 及 <html lang>
+type AccessibilityReport struct {
+	Title    string                  `json:"title"`
+	Lang     string                  `json:"lang"`
+	Warnings []*AccessibilityWarning `json:"warnings"`
+}
+
+// 各标签的开始标签在源文件中的行号，按出现顺序排列。解析后的文档树不记录位置，
+// 按标签名及顺序对应到树中的元素（<image> 解析为 <img>）
+func htmlTagLines(source []byte) map[string][]int {
+	lines := make(map[string][]int)
+	tokenizer := html.NewTokenizer(bytes.NewReader(source))
+	line := 1
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			return lines
+		}
+		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if tag == "image" {
+				tag = "img"
+			}
+			lines[tag] = append(lines[tag], line)
+		}
+		line += bytes.Count(tokenizer.Raw(), []byte("\n"))
+	}
+}
+
+func htmlAttr(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func htmlText(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+	}
+	return b.String()
+}
+
+// 检查 HTML 源文件：图片缺少 alt、缺少 lang 属性及标题、标题层级跳跃
+func LintHTML(source []byte) *AccessibilityReport {
+	report := &AccessibilityReport{Warnings: make([]*AccessibilityWarning, 0)}
+	warn := func(rule string, line int, format string, args ...interface{}) {
+		report.Warnings = append(report.Warnings, &AccessibilityWarning{Rule: rule, Message: fmt.Sprintf(format, args...), Line: line})
+	}
+	doc, err := html.Parse(bytes.NewReader(source))
+	if err != nil {
+		// 从内存读取，解析不会出错
+		return report
+	}
+
+	lines := htmlTagLines(source)
+	seen := make(map[string]int)
+	lineOf := func(name string) int {
+		i := seen[name]
+		seen[name]++
+		if i < len(lines[name]) {
+			return lines[name][i]
+		}
+		return 0
+	}
+
+	hasTitle := false
+	lastLevel := 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		// 只检查 HTML 元素，SVG、MathML 中的同名元素除外
+		if n.Type == html.ElementNode && n.Namespace == "" {
+			name := n.Data
+			switch name {
+			case "html":
+				line := lineOf(name)
+				report.Lang, _ = htmlAttr(n, "lang")
+				report.Lang = strings.TrimSpace(report.Lang)
+				if len(report.Lang) == 0 {
+					lang, _ := htmlAttr(n, "xml:lang")
+					report.Lang = strings.TrimSpace(lang)
+				}
+				if len(report.Lang) == 0 {
+					if line == 0 {
+						warn("html-lang", 0, "the document has no <html> element with a lang attribute")
+					} else {
+						warn("html-lang", line, "the <html> element has no lang attribute")
+					}
+				}
+			case "title":
+				if !hasTitle {
+					hasTitle = true
+					report.Title = strings.TrimSpace(htmlText(n))
+				}
+			case "img":
+				line := lineOf(name)
+				_, hasAlt := htmlAttr(n, "alt")
+				role, _ := htmlAttr(n, "role")
+				role = strings.ToLower(role)
+				label, _ := htmlAttr(n, "aria-label")
+				labelledBy, _ := htmlAttr(n, "aria-labelledby")
+				if !hasAlt && len(label) == 0 && len(labelledBy) == 0 && role != "presentation" && role != "none" {
+					src, _ := htmlAttr(n, "src")
+					warn("img-alt", line, "image %s has no alt text", src)
+				}
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				line := lineOf(name)
+				level := int(name[1] - '0')
+				if level > lastLevel+1 {
+					if lastLevel == 0 {
+						warn("heading-order", line, "the first heading is <%s> instead of <h1>", name)
+					} else {
+						warn("heading-order", line, "heading level skipped from <h%d> to <%s>", lastLevel, name)
+					}
+				}
+				lastLevel = level
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if len(report.Title) == 0 {
+		warn("document-title", 0, "the document has no <title>")
+	}
+	return report
+}
+
+// 读取无障碍参数：accessible 为 true 时检查源文件，并用 <title> 及 lang 设置文档标题、语言。
+// PhantomJS 输出的内容流没有标记内容，无法生成结构树，因此不提供带标签的输出
+func parseAccessibility(request *http.Request) bool {
+	return parseBool(request.FormValue("accessible"))
+}
+
+// 用检查结果补充元数据，显式设置的标题及语言优先，并在阅读器标题栏显示文档标题
+func (o *OutputOptions) applyAccessibility(report *AccessibilityReport) {
+	meta := o.Metadata
+	if meta == nil {
+		meta = &Metadata{}
+	}
+	if len(meta.Title) == 0 {
+		meta.Title = report.Title
+	}
+	if len(meta.Language) == 0 {
+		meta.Language = report.Lang
+	}
+	if !meta.isEmpty() {
+		o.Metadata = meta
+	}
+	o.DisplayDocTitle = true
+}
+
+// 在响应头中返回无障碍问题的数量，问题写入日志
+func setAccessibilityHeaders(writer http.ResponseWriter, report *AccessibilityReport) {
+	if report == nil {
+		return
+	}
+	for _, w := range report.Warnings {
+		Logger.Warningf("accessibility: %s (line %d)", w.Message, w.Line)
+	}
+	writer.Header().Set("X-Accessibility-Warnings", strconv.Itoa(len(report.Warnings)))
+}
+
+// 设置阅读器首选项 DisplayDocTitle（原地修改）
+func SetDisplayDocTitle(pdf_path string) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	preferences, err := ctx.DereferenceDict(root["ViewerPreferences"])
+	if err != nil {
+		return err
+	}
+	if preferences == nil {
+		preferences = pdfcpu.NewDict()
+		root.Update("ViewerPreferences", preferences)
+	}
+	preferences.Update("DisplayDocTitle", pdfcpu.Boolean(true))
+	return writePDFContext(ctx, pdf_path)
+}
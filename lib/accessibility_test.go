@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func Test_LintHTML(t *testing.T) {
+	source := `<!DOCTYPE html>
+<html>
+<head><title>季度 &amp; 报告</title>
+<script>var s = "<img src=x.png>";</script></head>
+<body>
+<!-- <h4>注释</h4> -->
+<h1>标题</h1>
+<img src="logo.png" alt="">
+<img src="chart.png">
+<img src="line.png" role="presentation">
+<h3>小节</h3>
+<h2>章节</h2>
+</body>
+</html>`
+	report := LintHTML([]byte(source))
+	if report.Title != "季度 & 报告" || len(report.Lang) > 0 {
+		t.Errorf("unexpected title or lang: %q %q", report.Title, report.Lang)
+	}
+	expected := []struct {
+		rule string
+		line int
+	}{{"html-lang", 2}, {"img-alt", 9}, {"heading-order", 11}}
+	if len(report.Warnings) != len(expected) {
+		t.Fatalf("unexpected warnings: %+v", report.Warnings)
+	}
+	for i, w := range report.Warnings {
+		if w.Rule != expected[i].rule || w.Line != expected[i].line {
+			t.Errorf("unexpected warning: %+v", w)
+		}
+	}
+
+	report = LintHTML([]byte(`<html lang="zh-CN"><title>a</title><h2>b</h2></html>`))
+	if report.Lang != "zh-CN" || len(report.Warnings) != 1 || report.Warnings[0].Rule != "heading-order" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	// 按文档树检查：textarea 中的文本不是元素，SVG 的 title 不是文档标题，<image> 按 <img> 处理
+	report = LintHTML([]byte(`<html lang="en"><title>a</title>
+<textarea><img src="x.png"></textarea>
+<svg><title>icon</title></svg>
+<h1>b</h1>
+<image src="y.png">`))
+	if report.Title != "a" || len(report.Warnings) != 1 || report.Warnings[0].Rule != "img-alt" || report.Warnings[0].Line != 5 {
+		t.Errorf("unexpected report: %q %+v", report.Title, report.Warnings)
+	}
+	report = LintHTML([]byte(`<svg><title>icon</title></svg>`))
+	if len(report.Title) > 0 {
+		t.Errorf("unexpected title: %q", report.Title)
+	}
+	report = LintHTML([]byte(`<p>text</p>`))
+	if len(report.Warnings) != 2 || report.Warnings[0].Rule != "html-lang" || report.Warnings[1].Rule != "document-title" {
+		t.Errorf("unexpected report: %+v", report.Warnings)
+	}
+}
+
+func Test_ApplyAccessibility(t *testing.T) {
+	if !parseAccessibility(newFormRequest(url.Values{"accessible": {"true"}})) {
+		t.Error("expected accessible output")
+	}
+	output, err := ParseOutputOptions(newFormRequest(url.Values{"title": {"显式标题"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output.applyAccessibility(&AccessibilityReport{Title: "页面标题", Lang: "zh-CN"})
+	if output.Metadata.Title != "显式标题" || output.Metadata.Language != "zh-CN" || !output.DisplayDocTitle {
+		t.Errorf("unexpected output: %+v %+v", output, output.Metadata)
+	}
+
+	output = &OutputOptions{}
+	output.applyAccessibility(&AccessibilityReport{})
+	if output.Metadata != nil || !output.DisplayDocTitle {
+		t.Errorf("unexpected output: %+v", output)
+	}
+}
+
+func Test_SetDisplayDocTitle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "title.pdf")
+	writeTestPDF(t, file, 1)
+	if err := SetDisplayDocTitle(file); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	preferences, err := ctx.DereferenceDict(root["ViewerPreferences"])
+	if err != nil || preferences == nil {
+		t.Fatalf("no viewer preferences: %v", err)
+	}
+	if v, ok := preferences["DisplayDocTitle"].(pdfcpu.Boolean); !ok || !v.Value() {
+		t.Errorf("unexpected DisplayDocTitle: %v", preferences["DisplayDocTitle"])
+	}
+}
+
+func Test_LinkPDFAccessible(t *testing.T) {
+	s := &HTTPService{config: &Config{TempPath: t.TempDir()}}
+	recorder := httptest.NewRecorder()
+	// 检查链接的内容时同样只接受 http(s) 地址
+	s.LINKPDF(recorder, newFormRequest(url.Values{"link": {"file:///etc/passwd"}, "accessible": {"true"}}))
+	if recorder.Code != 500 || !strings.Contains(recorder.Body.String(), "http(s)") {
+		t.Errorf("unexpected response: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
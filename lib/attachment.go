@@ -0,0 +1,485 @@
+package lib
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// PDF 的嵌入文件（附件）
+type Attachment struct {
+	Name         string    `json:"name"`
+	Description  string    `json:"description,omitempty"`
+	MimeType     string    `json:"mime_type,omitempty"`    // 为空时根据扩展名判断
+	Relationship string    `json:"relationship,omitempty"` // PDF/A-3 的 AFRelationship：Source、Data、Alternative、Supplement、Unspecified
+	ModTime      time.Time `json:"mod_time"`
+	Size         int       `json:"size"`
+	Data         []byte    `json:"-"`
+
+	src    string // 附件的 URL 或上传的文件，写入前读取
+	upload *multipart.FileHeader
+}
+
+// 读取附件参数：attachment 可以重复，值为 URL 或上传的文件；attachment_description 可以重复，
+// 按上传的文件、URL 的顺序与附件对应
+func parseAttachments(request *http.Request) []*Attachment {
+	list := make([]*Attachment, 0)
+	if request.MultipartForm != nil {
+		for _, upload := range request.MultipartForm.File["attachment"] {
+			list = append(list, &Attachment{Name: filepath.Base(upload.Filename), upload: upload})
+		}
+	}
+	for _, src := range request.Form["attachment"] {
+		if src = strings.TrimSpace(src); len(src) > 0 {
+			list = append(list, &Attachment{Name: urlFileName(src), src: src})
+		}
+	}
+	for i, description := range request.Form["attachment_description"] {
+		if i < len(list) {
+			list[i].Description = description
+		}
+	}
+	for i, a := range list {
+		if len(a.Name) == 0 {
+			a.Name = fmt.Sprintf("attachment-%d", i+1)
+		}
+	}
+	return list
+}
+
+// 读取上传的附件，下载 URL 附件
+func (pdf *HTMLPDF) loadAttachments(list []*Attachment) error {
+	targets := make([]*Attachment, 0)
+	for _, a := range list {
+		if a.Data != nil {
+			// 拆分时每个文件都会添加附件，只读取一次
+			continue
+		}
+		if a.upload != nil {
+			file, err := a.upload.Open()
+			if err != nil {
+				return err
+			}
+			a.Data, err = io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+			// 浏览器不认识的类型按扩展名判断
+			if mimeType := a.upload.Header.Get("Content-Type"); mimeType != "application/octet-stream" {
+				a.MimeType = mimeType
+			}
+		} else if len(a.src) > 0 {
+			if !isHTTPURL(a.src) {
+				return fmt.Errorf("unsupported attachment url: %s", a.src)
+			}
+			targets = append(targets, a)
+		}
+	}
+	for _, a := range targets {
+		data, err := downloadAttachment(a.src)
+		if err != nil {
+			return err
+		}
+		a.Data = data
+	}
+	return nil
+}
+
+// 附件只接受 http(s) 地址，避免把服务器上的本地文件嵌入输出
+func isHTTPURL(src string) bool {
+	u, err := url.Parse(src)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+}
+
+// 下载 URL 附件。不经过 Downloader（会读取本地路径），下载失败或者服务器返回错误状态时直接报错
+func downloadAttachment(src string) ([]byte, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(src)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %v", src, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download %s: %s", src, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+type nameTreeEntry struct {
+	Key   string
+	Value pdfcpu.Object
+}
+
+// 名称树中的所有条目，按在树中的顺序排列
+func collectNameTreeEntries(xRefTable *pdfcpu.XRefTable, o pdfcpu.Object, entries *[]*nameTreeEntry, depth int) {
+	node, err := xRefTable.DereferenceDict(o)
+	if err != nil || node == nil || depth > 32 {
+		return
+	}
+	if names, err := xRefTable.DereferenceArray(node["Names"]); err == nil {
+		for i := 1; i < len(names); i += 2 {
+			key, _ := xRefTable.DereferenceText(names[i-1])
+			*entries = append(*entries, &nameTreeEntry{Key: key, Value: names[i]})
+		}
+	}
+	if kids, err := xRefTable.DereferenceArray(node["Kids"]); err == nil {
+		for _, kid := range kids {
+			collectNameTreeEntries(xRefTable, kid, entries, depth+1)
+		}
+	}
+}
+
+// 文档的 EmbeddedFiles 名称树条目
+func embeddedFileEntries(ctx *pdfcpu.Context) ([]*nameTreeEntry, error) {
+	entries := make([]*nameTreeEntry, 0)
+	root, err := ctx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	names, err := ctx.DereferenceDict(root["Names"])
+	if err != nil {
+		return nil, err
+	}
+	if names != nil {
+		collectNameTreeEntries(ctx.XRefTable, names["EmbeddedFiles"], &entries, 0)
+	}
+	return entries, nil
+}
+
+// 用排好序的条目重建 EmbeddedFiles 名称树（单个叶子节点），没有条目时删除名称树
+func setEmbeddedFileEntries(ctx *pdfcpu.Context, entries []*nameTreeEntry) error {
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	names, err := ctx.DereferenceDict(root["Names"])
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		if names != nil {
+			names.Delete("EmbeddedFiles")
+			if len(names) == 0 {
+				root.Delete("Names")
+			}
+		}
+		return nil
+	}
+	if names == nil {
+		names = pdfcpu.NewDict()
+		root.Update("Names", names)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	array := make(pdfcpu.Array, 0, len(entries)*2)
+	for _, entry := range entries {
+		array = append(array, pdfTextString(entry.Key), entry.Value)
+	}
+	names.Update("EmbeddedFiles", pdfcpu.Dict{"Names": array})
+	return nil
+}
+
+// 把附件加入文档，同名的附件被替换，返回文件说明字典的引用
+func addAttachment(ctx *pdfcpu.Context, a *Attachment) (*pdfcpu.IndirectRef, error) {
+	mimeType := a.MimeType
+	if len(mimeType) == 0 {
+		mimeType = mime.TypeByExtension(filepath.Ext(a.Name))
+	}
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	if len(mimeType) == 0 {
+		mimeType = "application/octet-stream"
+	}
+	modTime := a.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	stream, err := newFlateStream(pdfcpu.Dict{
+		"Type":    pdfcpu.Name("EmbeddedFile"),
+		"Subtype": pdfName(mimeType),
+		"Params": pdfcpu.Dict{
+			"Size":    pdfcpu.Integer(len(a.Data)),
+			"ModDate": pdfcpu.StringLiteral(pdfDate(modTime)),
+		},
+	}, a.Data)
+	if err != nil {
+		return nil, err
+	}
+	streamRef, err := ctx.IndRefForNewObject(*stream)
+	if err != nil {
+		return nil, err
+	}
+	spec := pdfcpu.Dict{
+		"Type": pdfcpu.Name("Filespec"),
+		"F":    pdfTextString(a.Name),
+		"UF":   pdfTextString(a.Name),
+		"EF":   pdfcpu.Dict{"F": *streamRef, "UF": *streamRef},
+	}
+	if len(a.Description) > 0 {
+		spec.Insert("Desc", pdfTextString(a.Description))
+	}
+	if len(a.Relationship) > 0 {
+		spec.Insert("AFRelationship", pdfcpu.Name(a.Relationship))
+	}
+	specRef, err := ctx.IndRefForNewObject(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := embeddedFileEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kept := make([]*nameTreeEntry, 0, len(entries)+1)
+	for _, entry := range entries {
+		if entry.Key == a.Name {
+			if err = removeAssociatedFile(ctx, entry.Value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	kept = append(kept, &nameTreeEntry{Key: a.Name, Value: *specRef})
+	if err = setEmbeddedFileEntries(ctx, kept); err != nil {
+		return nil, err
+	}
+	return specRef, nil
+}
+
+// 从文档目录的 AF 中去掉被替换或删除的文件说明
+func removeAssociatedFile(ctx *pdfcpu.Context, spec pdfcpu.Object) error {
+	ref, ok := spec.(pdfcpu.IndirectRef)
+	if !ok {
+		return nil
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	af, err := ctx.DereferenceArray(root["AF"])
+	if err != nil || af == nil {
+		return err
+	}
+	kept := make(pdfcpu.Array, 0, len(af))
+	for _, item := range af {
+		if r, ok := item.(pdfcpu.IndirectRef); ok && r.ObjectNumber == ref.ObjectNumber {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	if len(kept) == 0 {
+		root.Delete("AF")
+	} else {
+		root.Update("AF", kept)
+	}
+	return nil
+}
+
+// 把附件加入 PDF（原地修改）
+func AddAttachments(pdf_path string, list []*Attachment) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	for _, a := range list {
+		if _, err = addAttachment(ctx, a); err != nil {
+			return err
+		}
+	}
+	return writePDFContext(ctx, pdf_path)
+}
+
+// 读取文件说明中的附件信息，withData 为 true 时同时读取内容
+func readAttachment(ctx *pdfcpu.Context, entry *nameTreeEntry, withData bool) (*Attachment, error) {
+	a := &Attachment{Name: entry.Key}
+	spec, err := ctx.DereferenceDict(entry.Value)
+	if err != nil || spec == nil {
+		return nil, fmt.Errorf("invalid attachment: %s", entry.Key)
+	}
+	if value, err := ctx.DereferenceText(spec["UF"]); err == nil && len(value) > 0 {
+		a.Name = value
+	} else if value, err := ctx.DereferenceText(spec["F"]); err == nil && len(value) > 0 {
+		a.Name = value
+	}
+	if value, err := ctx.DereferenceText(spec["Desc"]); err == nil {
+		a.Description = value
+	}
+	if relationship := spec.NameEntry("AFRelationship"); relationship != nil {
+		a.Relationship = *relationship
+	}
+
+	ef, _ := ctx.DereferenceDict(spec["EF"])
+	if ef == nil {
+		return a, nil
+	}
+	ref, ok := ef["UF"].(pdfcpu.IndirectRef)
+	if !ok {
+		if ref, ok = ef["F"].(pdfcpu.IndirectRef); !ok {
+			return a, nil
+		}
+	}
+	stream, err := ctx.DereferenceStreamDict(ref)
+	if err != nil || stream == nil {
+		return a, nil
+	}
+	if subtype := stream.Subtype(); subtype != nil {
+		a.MimeType = pdfcpu.Name(*subtype).Value()
+	}
+	if params, _ := ctx.DereferenceDict(stream.Dict["Params"]); params != nil {
+		if size := params.IntEntry("Size"); size != nil {
+			a.Size = *size
+		}
+		if value, err := ctx.DereferenceText(params["ModDate"]); err == nil {
+			if t, ok := parsePDFDate(value); ok {
+				a.ModTime = t
+			}
+		}
+	}
+	if withData {
+		if a.Data, err = pdfcpu.ExtractStreamData(ctx, ref.ObjectNumber.Value()); err != nil {
+			return nil, err
+		}
+		if a.Data == nil {
+			return nil, fmt.Errorf("unsupported filter in attachment %s", a.Name)
+		}
+		a.Size = len(a.Data)
+	}
+	return a, nil
+}
+
+// 列出 PDF 中的附件
+func ListAttachments(src string) ([]*Attachment, error) {
+	return readAttachments(src, nil, false)
+}
+
+// 读取 PDF 中的附件，names 为空时读取全部
+func ExtractAttachments(src string, names []string) ([]*Attachment, error) {
+	return readAttachments(src, names, true)
+}
+
+func readAttachments(src string, names []string, withData bool) ([]*Attachment, error) {
+	ctx, err := readPDFContext(src)
+	if err != nil {
+		return nil, err
+	}
+	return contextAttachments(ctx, names, withData)
+}
+
+func contextAttachments(ctx *pdfcpu.Context, names []string, withData bool) ([]*Attachment, error) {
+	entries, err := embeddedFileEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := selectAttachments(entries, names)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Attachment, 0, len(selected))
+	for _, entry := range selected {
+		a, err := readAttachment(ctx, entry, withData)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+// 按名称选择附件，names 为空时选择全部，名称不存在时返回错误
+func selectAttachments(entries []*nameTreeEntry, names []string) ([]*nameTreeEntry, error) {
+	if len(names) == 0 {
+		return entries, nil
+	}
+	selected := make([]*nameTreeEntry, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, entry := range entries {
+			if entry.Key == name {
+				selected = append(selected, entry)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("attachment not found: %s", name)
+		}
+	}
+	return selected, nil
+}
+
+// 删除 PDF 中的附件，names 为空时删除全部
+func RemoveAttachments(src string, dest string, names []string) error {
+	ctx, err := readPDFContext(src)
+	if err != nil {
+		return err
+	}
+	entries, err := embeddedFileEntries(ctx)
+	if err != nil {
+		return err
+	}
+	removes, err := selectAttachments(entries, names)
+	if err != nil {
+		return err
+	}
+	kept := make([]*nameTreeEntry, 0, len(entries))
+	for _, entry := range entries {
+		removed := false
+		for _, item := range removes {
+			if item == entry {
+				removed = true
+			}
+		}
+		if removed {
+			if err = removeAssociatedFile(ctx, entry.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if err = setEmbeddedFileEntries(ctx, kept); err != nil {
+		return err
+	}
+	return writePDFContext(ctx, dest)
+}
+
+// 把附件打包为 zip，包内使用附件名称
+func ZipAttachments(list []*Attachment, dest string) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := zip.NewWriter(out)
+	for _, a := range list {
+		entry, err := w.Create(filepath.Base(a.Name))
+		if err != nil {
+			return err
+		}
+		if _, err = entry.Write(a.Data); err != nil {
+			return err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+func Test_ParseAttachments(t *testing.T) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("attachment", "data.csv")
+	part.Write([]byte("a,b\n1,2\n"))
+	w.WriteField("attachment", "https://example.com/files/%E6%98%8E%E7%BB%86.json?v=1")
+	w.WriteField("attachment", "https://example.com/")
+	w.WriteField("attachment_description", "源数据")
+	w.WriteField("attachment_description", "明细")
+	w.Close()
+	request, _ := http.NewRequest("POST", "/htmlpdf", &body)
+	request.Header.Set("Content-Type", w.FormDataContentType())
+
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := output.Attachments
+	if len(list) != 3 || output.IsEmpty() {
+		t.Fatalf("unexpected attachments: %v", list)
+	}
+	if list[0].Name != "data.csv" || list[0].upload == nil || list[0].Description != "源数据" {
+		t.Errorf("unexpected upload: %+v", list[0])
+	}
+	if list[1].Name != "明细.json" || list[1].Description != "明细" || list[2].Name != "attachment-3" {
+		t.Errorf("unexpected urls: %+v %+v", list[1], list[2])
+	}
+
+	pdf := &HTMLPDF{config: &Config{TempPath: t.TempDir()}}
+	if err = pdf.loadAttachments(list[:1]); err != nil || string(list[0].Data) != "a,b\n1,2\n" {
+		t.Errorf("unexpected data: %q %v", list[0].Data, err)
+	}
+}
+
+func Test_LoadAttachmentURLs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/data.csv" {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Write([]byte("a,b\n"))
+	}))
+	defer server.Close()
+
+	pdf := &HTMLPDF{config: &Config{TempPath: t.TempDir()}}
+	list := []*Attachment{{Name: "data.csv", src: server.URL + "/data.csv"}}
+	if err := pdf.loadAttachments(list); err != nil || string(list[0].Data) != "a,b\n" {
+		t.Errorf("unexpected data: %q %v", list[0].Data, err)
+	}
+	// 本地文件路径及错误状态都返回错误
+	for _, src := range []string{"/etc/passwd", "file:///etc/passwd", server.URL + "/missing.csv"} {
+		if err := pdf.loadAttachments([]*Attachment{{Name: "x", src: src}}); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+func Test_Attachments(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "report.pdf")
+	writeTestPDF(t, file, 1)
+
+	modified := time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local)
+	err := AddAttachments(file, []*Attachment{
+		{Name: "data.csv", Description: "源数据", Data: []byte("a,b\n1,2\n"), ModTime: modified},
+		{Name: "明细.json", Data: []byte(`{"total":3}`)},
+		{Name: "notes.txt", MimeType: "text/plain; charset=utf-8", Data: []byte("old")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同名附件被替换
+	if err = AddAttachments(file, []*Attachment{{Name: "notes.txt", Data: []byte("new notes")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = api.ValidateFile(file, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ListAttachments(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].Name != "data.csv" || list[1].Name != "notes.txt" || list[2].Name != "明细.json" {
+		t.Fatalf("unexpected attachments: %+v", list)
+	}
+	if list[0].Description != "源数据" || list[0].Size != 8 || !list[0].ModTime.Equal(modified) {
+		t.Errorf("unexpected attachment: %+v", list[0])
+	}
+	if list[1].Size != 9 || list[0].Data != nil {
+		t.Errorf("unexpected attachment: %+v", list[1])
+	}
+
+	extracted, err := ExtractAttachments(file, []string{"明细.json"})
+	if err != nil || len(extracted) != 1 || string(extracted[0].Data) != `{"total":3}` || extracted[0].MimeType != "application/json" {
+		t.Errorf("unexpected extracted attachments: %+v %v", extracted, err)
+	}
+	if _, err = ExtractAttachments(file, []string{"missing.txt"}); err == nil {
+		t.Error("expected error for missing attachment")
+	}
+
+	removed := filepath.Join(dir, "removed.pdf")
+	if err = RemoveAttachments(file, removed, []string{"data.csv", "notes.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if list, err = ListAttachments(removed); err != nil || len(list) != 1 || list[0].Name != "明细.json" {
+		t.Errorf("unexpected attachments after remove: %+v %v", list, err)
+	}
+	if err = RemoveAttachments(removed, removed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if list, err = ListAttachments(removed); err != nil || len(list) != 0 {
+		t.Errorf("unexpected attachments after remove: %+v %v", list, err)
+	}
+}
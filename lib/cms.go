@@ -0,0 +1,296 @@
+package lib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttrContentType      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningCertV2    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidAttrTimeStampToken   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	sha256AlgorithmIdentity = pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+)
+
+// CMS 签名者，Chain[0] 为签名证书
+type cmsSigner struct {
+	Key   crypto.Signer
+	Chain []*x509.Certificate
+}
+
+// DER 编码的 TLV
+func derTLV(tag byte, contents ...[]byte) []byte {
+	var body []byte
+	for _, c := range contents {
+		body = append(body, c...)
+	}
+	out := []byte{tag}
+	switch n := len(body); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	default:
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, body...)
+}
+
+// DER 的 SET OF 需要按编码排序
+func derSetOf(tag byte, items [][]byte) []byte {
+	sorted := append([][]byte{}, items...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	return derTLV(tag, sorted...)
+}
+
+func mustMarshal(v interface{}) []byte {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func cmsAttribute(oid asn1.ObjectIdentifier, value []byte) []byte {
+	return derTLV(0x30, mustMarshal(oid), derTLV(0x31, value))
+}
+
+func (s *cmsSigner) signatureAlgorithm() (pkix.AlgorithmIdentifier, error) {
+	switch s.Key.Public().(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}, nil
+	case *ecdsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+	}
+	return pkix.AlgorithmIdentifier{}, errors.New("unsupported signing key type")
+}
+
+// 生成 CMS SignedData。detached 为 true 时不包含原始内容（PDF 签名使用），
+// timestamp 不为空时用签名值请求时间戳，并作为非签名属性加入
+func (s *cmsSigner) Sign(content []byte, contentType asn1.ObjectIdentifier, detached bool,
+	timestamp func(signature []byte) ([]byte, error)) ([]byte, error) {
+	if len(s.Chain) == 0 {
+		return nil, errors.New("missing signing certificate")
+	}
+	cert := s.Chain[0]
+	sigAlgorithm, err := s.signatureAlgorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(content)
+	certHash := sha256.Sum256(cert.Raw)
+	// SigningCertificateV2 ::= SEQUENCE { certs SEQUENCE OF ESSCertIDv2 }，哈希算法默认为 SHA-256
+	signingCert := derTLV(0x30, derTLV(0x30, derTLV(0x30, mustMarshal(certHash[:]))))
+	attrs := [][]byte{
+		cmsAttribute(oidAttrContentType, mustMarshal(contentType)),
+		cmsAttribute(oidAttrMessageDigest, mustMarshal(digest[:])),
+		cmsAttribute(oidAttrSigningCertV2, signingCert),
+	}
+	signedAttrs := derSetOf(0x31, attrs)
+
+	attrsDigest := sha256.Sum256(signedAttrs)
+	signature, err := s.Key.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	signerInfo := [][]byte{
+		mustMarshal(1),
+		derTLV(0x30, cert.RawIssuer, mustMarshal(cert.SerialNumber)),
+		mustMarshal(sha256AlgorithmIdentity),
+		append([]byte{0xa0}, signedAttrs[1:]...), // [0] IMPLICIT
+		mustMarshal(sigAlgorithm),
+		mustMarshal(signature),
+	}
+	if timestamp != nil {
+		token, err := timestamp(signature)
+		if err != nil {
+			return nil, err
+		}
+		signerInfo = append(signerInfo, derTLV(0xa1, cmsAttribute(oidAttrTimeStampToken, token)))
+	}
+
+	encap := [][]byte{mustMarshal(contentType)}
+	if !detached {
+		encap = append(encap, derTLV(0xa0, mustMarshal(content)))
+	}
+	version := 1
+	if !contentType.Equal(oidData) {
+		version = 3
+	}
+	certs := make([][]byte, 0, len(s.Chain))
+	for _, c := range s.Chain {
+		certs = append(certs, c.Raw)
+	}
+
+	signedData := derTLV(0x30,
+		mustMarshal(version),
+		derTLV(0x31, mustMarshal(sha256AlgorithmIdentity)),
+		derTLV(0x30, encap...),
+		derTLV(0xa0, certs...),
+		derTLV(0x31, derTLV(0x30, signerInfo...)),
+	)
+	return derTLV(0x30, mustMarshal(oidSignedData), derTLV(0xa0, signedData)), nil
+}
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type cmsEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo cmsEncapContentInfo
+}
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int
+	CertReq        bool
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type tstAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time        `asn1:"generalized"`
+	Accuracy       tstAccuracy      `asn1:"optional"`
+	Ordering       bool             `asn1:"optional"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"optional,tag:0"`
+	Extensions     []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+// 向 RFC 3161 时间戳服务请求签名值的时间戳，返回 TimeStampToken
+func requestTimestamp(tsaURL string, signature []byte) ([]byte, error) {
+	digest := sha256.Sum256(signature)
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(timeStampReq{
+		Version:        1,
+		MessageImprint: messageImprint{HashAlgorithm: sha256AlgorithmIdentity, HashedMessage: digest[:]},
+		Nonce:          nonce,
+		CertReq:        true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(tsaURL, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp server returned %s", resp.Status)
+	}
+
+	var tsResp timeStampResp
+	if _, err = asn1.Unmarshal(body, &tsResp); err != nil {
+		return nil, fmt.Errorf("invalid timestamp response: %v", err)
+	}
+	// 0 granted，1 grantedWithMods
+	if (tsResp.Status.Status != 0 && tsResp.Status.Status != 1) || len(tsResp.TimeStampToken.FullBytes) == 0 {
+		return nil, fmt.Errorf("timestamp request rejected: status %d %v", tsResp.Status.Status, tsResp.Status.StatusString)
+	}
+
+	info, err := parseTimestampToken(tsResp.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digest[:]) {
+		return nil, errors.New("timestamp does not match the signature")
+	}
+	// 响应中的 nonce 必须与请求一致，避免接受重放或缓存的时间戳
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("timestamp nonce does not match the request")
+	}
+	return tsResp.TimeStampToken.FullBytes, nil
+}
+
+// 解析时间戳中的 TSTInfo
+func parseTimestampToken(token []byte) (*tstInfo, error) {
+	var ci cmsContentInfo
+	if _, err := asn1.Unmarshal(token, &ci); err != nil {
+		return nil, fmt.Errorf("invalid timestamp token: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("invalid timestamp token: not signed data")
+	}
+	var sd cmsSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("invalid timestamp token: %v", err)
+	}
+	if !sd.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, errors.New("invalid timestamp token: missing TSTInfo")
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent, &info); err != nil {
+		return nil, fmt.Errorf("invalid timestamp token: %v", err)
+	}
+	return &info, nil
+}
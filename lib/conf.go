@@ -6,15 +6,21 @@ import (
 )
 
 type Config struct {
-	Listen     string   `json:"listen"`
-	TempPath   string   `json:"tmp_path"`
-	WebRoot    string   `json:"web_root"`
-	WebKitBin  string   `json:"webkit_bin"`
-	WebKitArgs []string `json:"webkit_args"`
-	Worker     int      `json:"worker"`
-	Timeout    int      `json:"timeout"`
-	CacheTTL   int      `json:"cache_ttl"`
-	save_path  string
+	Listen           string                   `json:"listen"`
+	TempPath         string                   `json:"tmp_path"`
+	WebRoot          string                   `json:"web_root"`
+	WebKitBin        string                   `json:"webkit_bin"`
+	WebKitArgs       []string                 `json:"webkit_args"`
+	Worker           int                      `json:"worker"`
+	Timeout          int                      `json:"timeout"`
+	CacheTTL         int                      `json:"cache_ttl"`
+	MaxPageHeight    float64                  `json:"max_page_height"`
+	Letterheads      map[string]string        `json:"letterheads"`
+	Signers          map[string]*SignerConfig `json:"signers"`
+	TSAURL           string                   `json:"tsa_url"`
+	TOCTemplate      string                   `json:"toc_template"`
+	InvoiceTemplates map[string]string        `json:"invoice_templates"`
+	save_path        string
 }
 
 func NewConfig(filename string) (err error, c *Config) {
@@ -0,0 +1,223 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// 合并时的排版参数：Duplex 为 true 时每个文件（及分隔页）补空白页到偶数页，双面打印时从右页开始；
+// Separator 为 true 时在文件之间插入由模板生成的分隔页，SeparatorFirst 为 true 时第一个文件之前也插入
+type CombineLayout struct {
+	Duplex         bool
+	Separator      bool
+	SeparatorFirst bool
+	Template       string
+}
+
+type separatorData struct {
+	Index int // 从 1 开始
+	Count int
+	Name  string
+	URL   string
+}
+
+// 内置分隔页模板
+const defaultSeparatorTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style>
+body { font-family: sans-serif; margin: 0; color: #222; }
+.sheet { padding: 360px 90px 0; text-align: center; }
+.index { font-size: 24px; color: #888; }
+.name { font-size: 40px; margin-top: 24px; word-break: break-all; }
+</style>
+</head>
+<body>
+<div class="sheet">
+<div class="index">{{.Index}} / {{.Count}}</div>
+<div class="name">{{.Name}}</div>
+</div>
+</body>
+</html>`
+
+// 读取排版参数：duplex、separator、separator_first、separator_template（Go html/template 格式，可以上传文件）
+func parseCombineLayout(request *http.Request) *CombineLayout {
+	layout := &CombineLayout{
+		Duplex:         parseBool(request.FormValue("duplex")),
+		Separator:      parseBool(request.FormValue("separator")),
+		SeparatorFirst: parseBool(request.FormValue("separator_first")),
+	}
+	if tpl, err := readUpload(request, "separator_template"); err == nil {
+		layout.Template = string(tpl)
+		layout.Separator = true
+	}
+	if !layout.Duplex && !layout.Separator {
+		return nil
+	}
+	return layout
+}
+
+// 按排版参数处理合并前的文件，names 为对应的原始地址。
+// 返回用于合并的文件列表（与 files 一一对应）、每个文件中文档之前的分隔页及空白页数，以及需要删除的临时文件
+func (pdf *HTMLPDF) arrangeCombineInputs(files []string, names []string, layout *CombineLayout) ([]string, []int, []string, error) {
+	removes := make([]string, 0)
+	leading := make([]int, len(files))
+	if layout == nil {
+		return files, leading, removes, nil
+	}
+	var tpl *template.Template
+	if layout.Separator {
+		source := layout.Template
+		if len(source) == 0 {
+			source = defaultSeparatorTemplate
+		}
+		var err error
+		if tpl, err = template.New("separator").Parse(source); err != nil {
+			return nil, nil, removes, err
+		}
+	}
+
+	result := make([]string, len(files))
+	for i, file := range files {
+		separator := ""
+		if tpl != nil && (i > 0 || layout.SeparatorFirst) {
+			data := &separatorData{Index: i + 1, Count: len(files)}
+			if i < len(names) {
+				data.URL = names[i]
+				data.Name = documentName(names[i])
+			}
+			var b bytes.Buffer
+			if err := tpl.Execute(&b, data); err != nil {
+				return nil, nil, removes, err
+			}
+			local, err := pdf.BuildFromSource(b.Bytes(), NewRenderOptions())
+			if err != nil {
+				return nil, nil, removes, err
+			}
+			removes = append(removes, local)
+			separator = local
+		}
+		if len(separator) == 0 && !layout.Duplex {
+			result[i] = file
+			continue
+		}
+
+		dest := filepath.Join(pdf.config.TempPath, MakeUUID()+".pdf")
+		removes = append(removes, dest)
+		offset, err := arrangeDocument(file, separator, layout.Duplex, dest)
+		if err != nil {
+			return nil, nil, removes, err
+		}
+		result[i] = dest
+		leading[i] = offset
+	}
+	return result, leading, removes, nil
+}
+
+// 在文档前插入分隔页，duplex 为 true 时分隔页及文档分别补空白页到偶数页。
+// 以文档为主合并，保留文档的元数据及书签，再把分隔页移到最前面。返回文档之前的页数
+func arrangeDocument(file string, separator string, duplex bool, dest string) (int, error) {
+	document, err := readPDFContext(file)
+	if err != nil {
+		return 0, err
+	}
+	documentPages := document.PageCount
+	// 合并时不保留书签，先读出文档的书签
+	items, err := contextOutline(document)
+	if err != nil {
+		return 0, err
+	}
+
+	source := file
+	if len(separator) > 0 {
+		source = dest
+		if err = CombinePDF([]string{file, separator}, dest); err != nil {
+			return 0, err
+		}
+	}
+
+	ctx, err := readPDFContext(source)
+	if err != nil {
+		return 0, err
+	}
+	separatorPages := ctx.PageCount - documentPages
+
+	order := make([]int, 0, ctx.PageCount+2)
+	for n := documentPages + 1; n <= ctx.PageCount; n++ {
+		order = append(order, n)
+	}
+	if duplex && separatorPages%2 == 1 {
+		n, err := appendBlankPage(ctx, ctx.PageCount)
+		if err != nil {
+			return 0, err
+		}
+		order = append(order, n)
+	}
+	offset := len(order)
+	for n := 1; n <= documentPages; n++ {
+		order = append(order, n)
+	}
+	if duplex && documentPages%2 == 1 {
+		n, err := appendBlankPage(ctx, documentPages)
+		if err != nil {
+			return 0, err
+		}
+		order = append(order, n)
+	}
+
+	if err = reorderPages(ctx, order); err != nil {
+		return 0, err
+	}
+	if len(items) > 0 {
+		shiftOutline(items, offset, 1)
+		if err = setContextOutline(ctx, items); err != nil {
+			return 0, err
+		}
+	}
+	return offset, writePDFContext(ctx, dest)
+}
+
+// 在文档末尾添加一个与第 like 页同样大小及方向的空白页，返回新页面的页码
+func appendBlankPage(ctx *pdfcpu.Context, like int) (int, error) {
+	rootRef, err := ctx.Pages()
+	if err != nil {
+		return 0, err
+	}
+	root, err := ctx.DereferenceDict(*rootRef)
+	if err != nil {
+		return 0, err
+	}
+	page, _, err := ctx.PageDict(like)
+	if err != nil {
+		return 0, err
+	}
+	info := pageInfo(ctx.XRefTable, page)
+
+	blank := pdfcpu.Dict{
+		"Type":      pdfcpu.Name("Page"),
+		"Parent":    *rootRef,
+		"MediaBox":  pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Float(info.Width), pdfcpu.Float(info.Height)},
+		"Resources": pdfcpu.Dict{},
+		"Rotate":    pdfcpu.Integer(info.Rotation),
+	}
+	ref, err := ctx.IndRefForNewObject(blank)
+	if err != nil {
+		return 0, err
+	}
+	if err = appendArrayEntry(ctx.XRefTable, root, "Kids", *ref); err != nil {
+		return 0, err
+	}
+	count := root.IntEntry("Count")
+	if count == nil {
+		return 0, fmt.Errorf("page tree without count")
+	}
+	root.Update("Count", pdfcpu.Integer(*count+1))
+	ctx.PageCount++
+	return ctx.PageCount, nil
+}
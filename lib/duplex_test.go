@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ParseCombineLayout(t *testing.T) {
+	if layout := parseCombineLayout(newFormRequest(url.Values{"outline": {"true"}})); layout != nil {
+		t.Errorf("expected no layout, got %+v", layout)
+	}
+	layout := parseCombineLayout(newFormRequest(url.Values{"duplex": {"true"}, "separator_template": {"<p>{{.Name}}</p>"}}))
+	if layout == nil || !layout.Duplex || !layout.Separator || layout.SeparatorFirst || layout.Template != "<p>{{.Name}}</p>" {
+		t.Errorf("unexpected layout: %+v", layout)
+	}
+}
+
+func Test_ArrangeDocument(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "document.pdf")
+	writeOutlinedTestPDF(t, file, 3)
+	if err := SetPDFMetadata(file, &Metadata{Title: "Policy"}); err != nil {
+		t.Fatal(err)
+	}
+	separator := filepath.Join(dir, "separator.pdf")
+	writeTestPDF(t, separator, 1)
+
+	dest := filepath.Join(dir, "arranged.pdf")
+	offset, err := arrangeDocument(file, separator, true, dest)
+	if err != nil || offset != 2 {
+		t.Fatalf("unexpected offset %d: %v", offset, err)
+	}
+	info, err := ReadPageInfo(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info) != 6 || info[5].Width != info[2].Width {
+		t.Fatalf("unexpected pages: %+v", info)
+	}
+	// 分隔页、空白页、文档三页、空白页
+	if pages := outlinePages(t, dest); pages["P1"] != 3 || pages["P3"] != 5 {
+		t.Errorf("unexpected outline: %v", pages)
+	}
+	if meta, err := ReadMetadata(dest); err != nil || meta.Title != "Policy" {
+		t.Errorf("metadata not kept: %+v %v", meta, err)
+	}
+
+	// 只补空白页
+	even := filepath.Join(dir, "even.pdf")
+	writeTestPDF(t, even, 2)
+	inputs, leading, removes, err := (&HTMLPDF{config: &Config{TempPath: dir}}).arrangeCombineInputs([]string{file, even}, nil, &CombineLayout{Duplex: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removes) != 2 || leading[0] != 0 || leading[1] != 0 {
+		t.Errorf("unexpected temporary files: %v %v", removes, leading)
+	}
+	for i, expected := range []int{4, 2} {
+		if info, err := ReadPageInfo(inputs[i]); err != nil || len(info) != expected {
+			t.Errorf("input %d: expected %d pages, got %d %v", i, expected, len(info), err)
+		}
+	}
+}
+
+func Test_DuplexTOC(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.pdf")
+	writeTestPDF(t, first, 1)
+	second := filepath.Join(dir, "second.pdf")
+	writeTestPDF(t, second, 2)
+	inputs, _, _, err := (&HTMLPDF{config: &Config{TempPath: dir}}).arrangeCombineInputs([]string{first, second}, nil, &CombineLayout{Duplex: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "combined.pdf")
+	if err = CombinePDF(inputs, file); err != nil {
+		t.Fatal(err)
+	}
+	items := buildOutlineTree([]*OutlineItem{{Title: "First", Level: 1, Page: 1}, {Title: "Second", Level: 1, Page: 3}})
+	if err = SetPDFOutline(file, items); err != nil {
+		t.Fatal(err)
+	}
+
+	// 目录只有一页，双面打印时补到两页，每个文档仍从右页（奇数页）开始
+	toc_path := filepath.Join(dir, "toc.pdf")
+	writeTestPDF(t, toc_path, 1)
+	pages, err := settleTOCPages(true, func(pages int) (int, error) {
+		return 1, nil
+	})
+	if err != nil || pages != 2 {
+		t.Fatalf("unexpected pages: %d %v", pages, err)
+	}
+	if err = attachTOC(file, toc_path, pages, "Contents", items, tocEntries(items, 2, pages), nil); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := ReadPageInfo(file); err != nil || len(info) != 6 {
+		t.Fatalf("unexpected pages: %d %v", len(info), err)
+	}
+	if pages := outlinePages(t, file); pages["Contents"] != 1 || pages["First"] != 3 || pages["Second"] != 5 {
+		t.Errorf("unexpected outline: %v", pages)
+	}
+}
+
+// 分隔页与文档在同一个文件中，顶级书签指向文档的第一页，子书签与之一致
+func Test_CombineOutlineSeparator(t *testing.T) {
+	dir := t.TempDir()
+	separator := filepath.Join(dir, "separator.pdf")
+	writeTestPDF(t, separator, 1)
+	files := make([]string, 0, 2)
+	leading := make([]int, 0, 2)
+	for i, pages := range []int{3, 2} {
+		file := filepath.Join(dir, fmt.Sprintf("document%d.pdf", i))
+		writeOutlinedTestPDF(t, file, pages)
+		dest := filepath.Join(dir, fmt.Sprintf("arranged%d.pdf", i))
+		offset, err := arrangeDocument(file, separator, false, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, dest)
+		leading = append(leading, offset)
+	}
+
+	items, err := CombineOutline(files, []string{"first.pdf", "second.pdf"}, nil, leading)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Page != 2 || items[1].Page != 6 {
+		t.Fatalf("unexpected outline: %+v %+v", items[0], items[1])
+	}
+	for _, item := range items {
+		if item.Children[0].Title != "P1" || item.Children[0].Page != item.Page {
+			t.Errorf("%s: unexpected children %+v", item.Title, item.Children[0])
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+type EMLPart struct {
+	ContentType string
+	ContentID   string
+	Filename    string
+	Inline      bool
+	Body        []byte
+}
+
+type EMLMessage struct {
+	From        string
+	To          string
+	Cc          string
+	Date        string
+	Subject     string
+	TextBody    string
+	HTMLBody    string
+	Inlines     map[string]*EMLPart
+	Attachments []*EMLPart
+}
+
+var emlCIDPattern = regexp.MustCompile(`(?i)cid:([^"'\s)>]+)`)
+
+// 编码字（=?charset?B?...?=）按 WHATWG 编码名称解码，支持 GB2312/GBK、Big5、ISO-2022-JP 等
+var emlWordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, fmt.Errorf("unhandled charset %q", charset)
+		}
+		return transform.NewReader(input, enc.NewDecoder()), nil
+	},
+}
+
+// 解析 RFC 822 邮件，遍历 MIME 树
+func ParseEML(r io.Reader) (*EMLMessage, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	eml := &EMLMessage{
+		From:    decodeEMLHeader(msg.Header.Get("From")),
+		To:      decodeEMLHeader(msg.Header.Get("To")),
+		Cc:      decodeEMLHeader(msg.Header.Get("Cc")),
+		Date:    msg.Header.Get("Date"),
+		Subject: decodeEMLHeader(msg.Header.Get("Subject")),
+		Inlines: make(map[string]*EMLPart),
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		eml.Date = date.Format("Mon, 02 Jan 2006 15:04:05 -0700")
+	}
+
+	err = eml.walk(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"),
+		msg.Header.Get("Content-Disposition"), msg.Header.Get("Content-ID"), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	return eml, nil
+}
+
+func (eml *EMLMessage) walk(contentType, encoding, disposition, contentID string, body io.Reader) error {
+	if len(contentType) == 0 {
+		contentType = "text/plain; charset=us-ascii"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+		params = map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = eml.walk(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"),
+				part.Header.Get("Content-Disposition"), part.Header.Get("Content-ID"), part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(decodeEMLTransfer(encoding, body))
+	if err != nil {
+		return err
+	}
+
+	dispType, dispParams, _ := mime.ParseMediaType(disposition)
+	filename := decodeEMLHeader(dispParams["filename"])
+	if len(filename) == 0 {
+		filename = decodeEMLHeader(params["name"])
+	}
+
+	if !strings.EqualFold(dispType, "attachment") && len(filename) == 0 {
+		switch mediaType {
+		case "text/html":
+			if len(eml.HTMLBody) == 0 {
+				eml.HTMLBody = decodeEMLCharset(params["charset"], data)
+				return nil
+			}
+		case "text/plain":
+			if len(eml.TextBody) == 0 {
+				eml.TextBody = decodeEMLCharset(params["charset"], data)
+				return nil
+			}
+		}
+	}
+
+	part := &EMLPart{
+		ContentType: mediaType,
+		ContentID:   strings.Trim(strings.TrimSpace(contentID), "<>"),
+		Filename:    filename,
+		Inline:      strings.EqualFold(dispType, "inline"),
+		Body:        data,
+	}
+	if len(part.ContentID) > 0 {
+		eml.Inlines[part.ContentID] = part
+		if part.Inline || len(filename) == 0 {
+			return nil
+		}
+	}
+	eml.Attachments = append(eml.Attachments, part)
+	return nil
+}
+
+// 渲染邮件头部及正文为 HTML，内嵌的 cid: 图片转换为 data URI。
+// 正文先经过 sanitizeEMLHTML 去掉脚本及远程资源
+func (eml *EMLMessage) RenderHTML() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(`<!DOCTYPE html><html><head><meta charset="utf-8">`)
+	buf.WriteString(`<style>.eml-header{font-family:sans-serif;font-size:12px;border-bottom:1px solid #999;margin-bottom:12px;padding-bottom:8px}`)
+	buf.WriteString(`.eml-header th{text-align:left;padding-right:8px;vertical-align:top}`)
+	buf.WriteString(`.eml-text{white-space:pre-wrap;font-family:monospace}</style></head><body>`)
+
+	buf.WriteString(`<table class="eml-header">`)
+	for _, row := range [][2]string{
+		{"From", eml.From},
+		{"To", eml.To},
+		{"Cc", eml.Cc},
+		{"Date", eml.Date},
+		{"Subject", eml.Subject},
+	} {
+		if len(row[1]) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "<tr><th>%s:</th><td>%s</td></tr>", row[0], html.EscapeString(row[1]))
+	}
+	if len(eml.Attachments) > 0 {
+		names := make([]string, len(eml.Attachments))
+		for i, item := range eml.Attachments {
+			names[i] = html.EscapeString(item.Name(i))
+		}
+		fmt.Fprintf(&buf, "<tr><th>Attachments:</th><td>%s</td></tr>", strings.Join(names, "<br>"))
+	}
+	buf.WriteString(`</table>`)
+
+	if len(eml.HTMLBody) > 0 {
+		buf.WriteString(emlCIDPattern.ReplaceAllStringFunc(sanitizeEMLHTML(eml.HTMLBody), func(ref string) string {
+			id := ref[len("cid:"):]
+			part, ok := eml.Inlines[id]
+			if !ok {
+				return ref
+			}
+			return fmt.Sprintf("data:%s;base64,%s", part.ContentType, base64.StdEncoding.EncodeToString(part.Body))
+		}))
+	} else {
+		fmt.Fprintf(&buf, `<div class="eml-text">%s</div>`, html.EscapeString(eml.TextBody))
+	}
+
+	buf.WriteString(`</body></html>`)
+	return buf.Bytes()
+}
+
+func (part *EMLPart) Name(index int) string {
+	if len(part.Filename) > 0 {
+		return filepath.Base(part.Filename)
+	}
+	ext := ".bin"
+	if exts, err := mime.ExtensionsByType(part.ContentType); err == nil && len(exts) > 0 {
+		ext = exts[0]
+	}
+	return fmt.Sprintf("attachment-%d%s", index+1, ext)
+}
+
+func (part *EMLPart) IsPDF() bool {
+	return part.ContentType == "application/pdf" ||
+		strings.EqualFold(filepath.Ext(part.Filename), ".pdf")
+}
+
+func (part *EMLPart) IsImage() bool {
+	return isSupportedImage(part.ContentType, part.Filename)
+}
+
+// 将邮件渲染成 PDF，appendAttachments 为 true 时将附件追加到 PDF 中：
+// PDF 及图片附件作为页面追加，其他类型嵌入为 PDF 附件
+func (pdf *HTMLPDF) BuildFromEML(raw []byte, appendAttachments bool) (local_pdf string, err error) {
+	eml, err := ParseEML(bytes.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+
+	body_pdf, err := pdf.BuildFromSource(eml.RenderHTML(), nil)
+	if err != nil {
+		return "", err
+	}
+	if !appendAttachments || len(eml.Attachments) == 0 {
+		return body_pdf, nil
+	}
+	defer os.Remove(body_pdf)
+
+	work_dir := filepath.Join(pdf.config.TempPath, MakeUUID())
+	if err = os.MkdirAll(work_dir, 0777); err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(work_dir)
+
+	pages := []string{body_pdf}
+	embeds := make([]string, 0)
+	for i, item := range eml.Attachments {
+		item_dir := filepath.Join(work_dir, fmt.Sprintf("%d", i))
+		if err = os.MkdirAll(item_dir, 0777); err != nil {
+			return "", err
+		}
+		item_path := filepath.Join(item_dir, item.Name(i))
+		if err = os.WriteFile(item_path, item.Body, 0666); err != nil {
+			return "", err
+		}
+
+		switch {
+		case item.IsPDF():
+			pages = append(pages, item_path)
+		case item.IsImage():
+			image_pdf := item_path + ".pdf"
+			if err = ConvertToPdf(item_path, image_pdf); err != nil {
+				return "", err
+			}
+			pages = append(pages, image_pdf)
+		default:
+			embeds = append(embeds, item_path)
+		}
+	}
+
+	local_pdf, err = pdf.PDFTK_Combine(pages)
+	if err != nil {
+		return "", err
+	}
+
+	if len(embeds) > 0 {
+		config := pdfcpu.NewDefaultConfiguration()
+		config.ValidationMode = pdfcpu.ValidationRelaxed
+		err = api.AddAttachmentsFile(local_pdf, "", embeds, config)
+		if err != nil {
+			Logger.Error(err)
+			os.Remove(local_pdf)
+			return "", err
+		}
+	}
+
+	return local_pdf, nil
+}
+
+func decodeEMLHeader(value string) string {
+	decoded, err := emlWordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+func decodeEMLTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// 正文转换为 UTF-8，未设置或者不认识的字符集按 UTF-8 处理
+func decodeEMLCharset(charset string, data []byte) string {
+	charset = strings.TrimSpace(charset)
+	if len(charset) == 0 {
+		return string(data)
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return string(data)
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+// 连同内容一起删除的元素
+var emlDroppedElements = map[string]bool{
+	"script": true, "iframe": true, "frame": true, "frameset": true,
+	"object": true, "applet": true, "embed": true, "noembed": true,
+}
+
+// 只删除标签的元素：base、meta（refresh 等）、link（外部样式表等）
+var emlDroppedTags = map[string]bool{
+	"base": true, "meta": true, "link": true,
+}
+
+// 加载资源的属性，只保留 data: 及 cid: 地址
+var emlResourceAttrs = map[string]bool{
+	"src": true, "background": true, "poster": true, "data": true, "lowsrc": true, "dynsrc": true,
+}
+
+// 会发起请求的其他属性，一律删除
+var emlRequestAttrs = map[string]bool{
+	"srcset": true, "ping": true, "action": true, "formaction": true, "manifest": true,
+	"codebase": true, "archive": true, "longdesc": true, "profile": true,
+}
+
+var emlCSSImportPattern = regexp.MustCompile(`(?i)@import[^;]*;?`)
+var emlCSSURLPattern = regexp.MustCompile(`(?i)url\(\s*(?:"[^"]*"|'[^']*'|[^)]*)\s*\)`)
+
+// 清理邮件正文：删除脚本、框架、插件、事件处理属性、javascript: 链接及远程资源
+// （图片、样式表、CSS 中的 url() 及 @import），避免渲染时执行脚本或访问网络（如跟踪像素）
+func sanitizeEMLHTML(body string) string {
+	var buf bytes.Buffer
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	skip := ""
+	depth := 0
+	inStyle := false
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		name := strings.ToLower(token.Data)
+		if len(skip) > 0 {
+			// 跳过被删除元素的内容，同名元素嵌套时计数
+			if name == skip {
+				if tt == html.StartTagToken {
+					depth++
+				} else if tt == html.EndTagToken {
+					if depth--; depth == 0 {
+						skip = ""
+					}
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if emlDroppedElements[name] {
+				if tt == html.StartTagToken && name != "embed" {
+					skip, depth = name, 1
+				}
+				continue
+			}
+			if emlDroppedTags[name] {
+				continue
+			}
+			token.Attr = sanitizeEMLAttrs(name, token.Attr)
+			inStyle = name == "style" && tt == html.StartTagToken
+		case html.EndTagToken:
+			if emlDroppedElements[name] || emlDroppedTags[name] {
+				continue
+			}
+			if name == "style" {
+				inStyle = false
+			}
+		case html.TextToken:
+			if inStyle {
+				// style 元素的内容不转义
+				buf.WriteString(sanitizeEMLCSS(token.Data))
+				continue
+			}
+		}
+		buf.WriteString(token.String())
+	}
+	return buf.String()
+}
+
+func sanitizeEMLAttrs(tag string, attrs []html.Attribute) []html.Attribute {
+	list := make([]html.Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		key := strings.ToLower(attr.Key)
+		if len(attr.Namespace) > 0 {
+			key = strings.ToLower(attr.Namespace) + ":" + key
+		}
+		scheme := emlURLScheme(attr.Val)
+		switch {
+		case strings.HasPrefix(key, "on"), emlRequestAttrs[key]:
+			continue
+		case emlResourceAttrs[key]:
+			if scheme != "data" && scheme != "cid" {
+				continue
+			}
+		case key == "href" || key == "xlink:href":
+			if tag == "a" || tag == "area" {
+				if scheme == "javascript" || scheme == "vbscript" || scheme == "data" {
+					continue
+				}
+			} else if !strings.HasPrefix(strings.TrimSpace(attr.Val), "#") && scheme != "data" {
+				// SVG 的 image、use 等元素引用的外部资源
+				continue
+			}
+		case key == "style":
+			attr.Val = sanitizeEMLCSS(attr.Val)
+		}
+		list = append(list, attr)
+	}
+	return list
+}
+
+// 删除 CSS 中的 @import，url() 只保留 data: 及 cid: 地址。
+// 先解码转义的字母，避免 \75 rl( 这样的写法绕过匹配
+func sanitizeEMLCSS(css string) string {
+	css = decodeCSSLetterEscapes(css)
+	css = emlCSSImportPattern.ReplaceAllString(css, "")
+	return emlCSSURLPattern.ReplaceAllStringFunc(css, func(ref string) string {
+		value := strings.TrimSpace(ref[len("url(") : len(ref)-1])
+		value = strings.Trim(value, `"'`)
+		if scheme := emlURLScheme(value); scheme == "data" || scheme == "cid" {
+			return ref
+		}
+		return "none"
+	})
+}
+
+// 解码结果为 ASCII 字母的 CSS 转义（\75 、\000075、\u），其他转义保持不变：
+// 字母在标识符及字符串中与转义等价，而数字、引号等解码后可能改变 CSS 的含义
+func decodeCSSLetterEscapes(css string) string {
+	if !strings.Contains(css, "\\") {
+		return css
+	}
+	isHex := func(c byte) bool {
+		return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+	}
+	isLetter := func(r rune) bool {
+		return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+	}
+	var buf strings.Builder
+	for i := 0; i < len(css); i++ {
+		if css[i] != '\\' || i+1 >= len(css) {
+			buf.WriteByte(css[i])
+			continue
+		}
+		j := i + 1
+		for j < len(css) && j-i <= 6 && isHex(css[j]) {
+			j++
+		}
+		if j == i+1 {
+			// 非十六进制字符的转义，转义的反斜杠整体跳过
+			if isLetter(rune(css[j])) {
+				buf.WriteByte(css[j])
+			} else {
+				buf.WriteString(css[i : j+1])
+			}
+			i = j
+			continue
+		}
+		code, _ := strconv.ParseUint(css[i+1:j], 16, 32)
+		// 十六进制转义后的一个空白属于转义本身
+		end := j
+		if end < len(css) && (css[end] == ' ' || css[end] == '\t' || css[end] == '\n' || css[end] == '\r' || css[end] == '\f') {
+			end++
+		}
+		if isLetter(rune(code)) {
+			buf.WriteRune(rune(code))
+		} else {
+			buf.WriteString(css[i:end])
+		}
+		i = end - 1
+	}
+	return buf.String()
+}
+
+// 小写的 URL 协议，忽略空白及控制字符（浏览器解析时同样忽略），相对地址返回空字符串
+func emlURLScheme(value string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, value)
+	// 实体引用已由解析器解码
+	i := strings.IndexByte(cleaned, ':')
+	if i <= 0 {
+		return ""
+	}
+	scheme := strings.ToLower(cleaned[:i])
+	for _, r := range scheme {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.') {
+			return ""
+		}
+	}
+	return scheme
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+const testEML = "From: =?UTF-8?B?5byg5LiJ?= <zhang@example.com>\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: Quarterly <report>\r\n" +
+	"Date: Tue, 01 Mar 2022 10:00:00 +0800\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/related; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<p>Hello <img src=3D\"cid:logo@example\"></p>\r\n" +
+	"--inner\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <logo@example>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0K\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/csv; name=\"data.csv\"\r\n" +
+	"Content-Disposition: attachment; filename=\"data.csv\"\r\n" +
+	"\r\n" +
+	"a,b\r\n" +
+	"--outer--\r\n"
+
+func Test_ParseEML(t *testing.T) {
+	eml, err := ParseEML(strings.NewReader(testEML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if eml.From != "张三 <zhang@example.com>" {
+		t.Errorf("unexpected From: %q", eml.From)
+	}
+	if len(eml.Attachments) != 1 || eml.Attachments[0].Name(0) != "data.csv" {
+		t.Errorf("unexpected attachments: %+v", eml.Attachments)
+	}
+	if _, ok := eml.Inlines["logo@example"]; !ok {
+		t.Errorf("inline image not found")
+	}
+
+	html := string(eml.RenderHTML())
+	if !strings.Contains(html, `src="data:image/png;base64,iVBORw0K"`) {
+		t.Errorf("cid image not resolved: %s", html)
+	}
+	if !strings.Contains(html, "Quarterly &lt;report&gt;") {
+		t.Errorf("subject not escaped: %s", html)
+	}
+	if !strings.Contains(html, "data.csv") {
+		t.Errorf("attachment not listed: %s", html)
+	}
+}
+
+func Test_ParseEMLCharsets(t *testing.T) {
+	raw := "From: =?gb2312?B?1tDOxA==?= <a@example.com>\r\n" +
+		"Subject: =?big5?B?pKSk5Q==?= =?iso-2022-jp?B?GyRCRnxLXBsoQg==?=\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=\"b\"\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=windows-1252\r\n" +
+		"\r\n" +
+		"Price: 5\x80\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/html; charset=GBK\r\n" +
+		"\r\n" +
+		"<p>\xd6\xd0\xce\xc4</p>\r\n" +
+		"--b--\r\n"
+	eml, err := ParseEML(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eml.From != "中文 <a@example.com>" || eml.Subject != "中文日本" {
+		t.Errorf("unexpected headers: %q %q", eml.From, eml.Subject)
+	}
+	if !strings.Contains(eml.TextBody, "5€") || !strings.Contains(eml.HTMLBody, "<p>中文</p>") {
+		t.Errorf("unexpected bodies: %q %q", eml.TextBody, eml.HTMLBody)
+	}
+}
+
+func Test_SanitizeEMLHTML(t *testing.T) {
+	body := `<html><head><meta http-equiv="refresh" content="0;url=https://evil.example/">` +
+		`<link rel="stylesheet" href="https://evil.example/a.css">` +
+		`<style>@import url("https://evil.example/b.css"); p { background: url(https://evil.example/c.png) }</style></head>` +
+		`<body onload="alert(1)"><script>alert(2)</script><iframe src="https://evil.example/"><p>x</p></iframe>` +
+		`<p style="background-image:url('http://t.example/pixel.gif')">Hello <b>world</b></p>` +
+		`<img src="https://t.example/pixel.gif" srcset="https://t.example/2x.gif 2x"><img src="cid:logo@example" alt="logo">` +
+		`<a href="https://example.com/" onclick="steal()">link</a><a href="javascript:alert(3)">bad</a>` +
+		`<svg><image xlink:href="https://t.example/x.svg"></image></svg></body></html>`
+	result := sanitizeEMLHTML(body)
+	for _, bad := range []string{"evil.example", "t.example", "alert", "steal", "<script", "<iframe", "<meta", "<link", "onload"} {
+		if strings.Contains(result, bad) {
+			t.Errorf("%s not removed: %s", bad, result)
+		}
+	}
+	for _, good := range []string{"Hello <b>world</b>", `src="cid:logo@example"`, `href="https://example.com/"`, "<style>", "background: none"} {
+		if !strings.Contains(result, good) {
+			t.Errorf("%s missing: %s", good, result)
+		}
+	}
+}
+
+// 转义字母写成的 url()、@import 同样被清理，其他转义保持不变
+func Test_SanitizeEMLCSSEscapes(t *testing.T) {
+	for _, css := range []string{
+		`p { background: \75 rl(https://t.example/a.png) }`,
+		`p { background: \000075RL(https://t.example/a.png) }`,
+		`p { background: \u\r\l(https://t.example/a.png) }`,
+		`@\69mport "https://t.example/a.css"; p {}`,
+	} {
+		if result := sanitizeEMLCSS(css); strings.Contains(result, "t.example") {
+			t.Errorf("%s not sanitized: %s", css, result)
+		}
+	}
+	for css, expected := range map[string]string{
+		`.\31 23 { content: "\201C\"" }`: `.\31 23 { content: "\201C\"" }`,
+		`p { background: \\75 rl }`:      `p { background: \\75 rl }`,
+		`p { font-family: \61rial }`:     `p { font-family: arial }`,
+	} {
+		if result := sanitizeEMLCSS(css); result != expected {
+			t.Errorf("%s: got %s", css, result)
+		}
+	}
+}
+
+func Test_EMLPartIsImage(t *testing.T) {
+	for _, part := range []EMLPart{
+		{ContentType: "image/webp"},
+		{ContentType: "image/BMP"},
+		{ContentType: "application/octet-stream", Filename: "scan.TIFF"},
+		{Filename: "photo.jpg"},
+	} {
+		if !part.IsImage() {
+			t.Errorf("%+v: expected image", part)
+		}
+	}
+	for _, part := range []EMLPart{{ContentType: "image/svg+xml"}, {Filename: "a.pdf"}} {
+		if part.IsImage() {
+			t.Errorf("%+v: unexpected image", part)
+		}
+	}
+}
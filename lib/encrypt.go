@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+const (
+	EncryptAES128 = "aes-128"
+	EncryptAES256 = "aes-256"
+)
+
+// 权限名称对应的 PDF 用户访问权限位
+var permissionBits = map[string]int16{
+	"print":         0x0004 | 0x0800, // 打印（包括高质量打印）
+	"modify":        0x0008,
+	"copy":          0x0010,
+	"annotate":      0x0020,
+	"fill-forms":    0x0100,
+	"accessibility": 0x0200,
+	"assemble":      0x0400,
+}
+
+// PDF 加密参数，密码不会出现在 String() 的输出中，避免被写进日志
+type Encryption struct {
+	UserPassword  string
+	OwnerPassword string
+	Method        string
+	Permissions   []string
+}
+
+func (e Encryption) String() string {
+	return fmt.Sprintf("{Method:%s Permissions:%v UserPassword:%t OwnerPassword:%t}",
+		e.Method, e.Permissions, len(e.UserPassword) > 0, len(e.OwnerPassword) > 0)
+}
+
+// 读取加密参数：user_password、owner_password、encryption（aes-128、aes-256）、
+// permissions（逗号分隔的权限名称，或者 all、none）
+func parseEncryption(request *http.Request) (*Encryption, error) {
+	enc := &Encryption{
+		UserPassword:  request.FormValue("user_password"),
+		OwnerPassword: request.FormValue("owner_password"),
+		Method:        strings.ToLower(request.FormValue("encryption")),
+	}
+	permissions := strings.ToLower(strings.TrimSpace(request.FormValue("permissions")))
+
+	if len(enc.UserPassword) == 0 && len(enc.OwnerPassword) == 0 {
+		if len(enc.Method) > 0 || len(permissions) > 0 {
+			return nil, fmt.Errorf("encryption requires user_password or owner_password")
+		}
+		return nil, nil
+	}
+
+	switch enc.Method {
+	case "":
+		enc.Method = EncryptAES256
+	case EncryptAES128, EncryptAES256:
+	default:
+		return nil, fmt.Errorf("unsupported encryption: %s", enc.Method)
+	}
+
+	switch permissions {
+	case "", "all":
+		for name := range permissionBits {
+			enc.Permissions = append(enc.Permissions, name)
+		}
+		sort.Strings(enc.Permissions)
+	case "none":
+		enc.Permissions = []string{}
+	default:
+		for _, name := range strings.Split(permissions, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := permissionBits[name]; !ok {
+				return nil, fmt.Errorf("unsupported permission: %s", name)
+			}
+			enc.Permissions = append(enc.Permissions, name)
+		}
+	}
+
+	return enc, nil
+}
+
+func (e *Encryption) permissionFlags() int16 {
+	flags := pdfcpu.PermissionsNone
+	for _, name := range e.Permissions {
+		flags |= permissionBits[name]
+	}
+	return flags
+}
+
+// 加密 PDF 文件（原地修改）。只设置了打开密码时生成随机的所有者密码，
+// 否则知道打开密码的人也能解除权限限制
+func EncryptPDFFile(pdf_path string, enc *Encryption) error {
+	owner := enc.OwnerPassword
+	if len(owner) == 0 {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		owner = hex.EncodeToString(random)
+	}
+
+	keyLength := 256
+	if enc.Method == EncryptAES128 {
+		keyLength = 128
+	}
+	conf := pdfcpu.NewAESConfiguration(enc.UserPassword, owner, keyLength)
+	conf.ValidationMode = pdfcpu.ValidationRelaxed
+	conf.Permissions = enc.permissionFlags()
+
+	return api.EncryptFile(pdf_path, "", conf)
+}
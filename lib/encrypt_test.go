@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func Test_ParseEncryption(t *testing.T) {
+	output, err := ParseOutputOptions(newFormRequest(url.Values{
+		"user_password": {"secret-user"},
+		"encryption":    {"AES-128"},
+		"permissions":   {"print, copy"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := output.Encryption
+	if enc == nil || enc.Method != EncryptAES128 || len(enc.Permissions) != 2 {
+		t.Fatalf("unexpected encryption: %v", enc)
+	}
+	// 密码不能出现在日志输出中
+	if text := fmt.Sprintf("%v %+v", enc, *enc); strings.Contains(text, "secret-user") {
+		t.Errorf("password leaked: %s", text)
+	}
+
+	invalid := []url.Values{
+		{"permissions": {"print"}},
+		{"owner_password": {"x"}, "encryption": {"rc4"}},
+		{"owner_password": {"x"}, "permissions": {"print,delete"}},
+	}
+	for _, form := range invalid {
+		if _, err = ParseOutputOptions(newFormRequest(form)); err == nil {
+			t.Errorf("expected error for %v", form)
+		}
+	}
+}
+
+func Test_EncryptPDFFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, method := range []string{EncryptAES128, EncryptAES256} {
+		file := filepath.Join(dir, method+".pdf")
+		writeTestPDF(t, file, 2)
+
+		enc := &Encryption{UserPassword: "user", OwnerPassword: "owner", Method: method, Permissions: []string{"print"}}
+		if err := EncryptPDFFile(file, enc); err != nil {
+			t.Fatal(err)
+		}
+
+		in, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf := pdfcpu.NewAESConfiguration("user", "owner", 256)
+		ctx, err := api.ReadContext(in, conf)
+		in.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctx.E == nil {
+			t.Fatalf("%s: file not encrypted", method)
+		}
+		if ctx.E.P&0x0004 == 0 || ctx.E.P&0x0010 != 0 {
+			t.Errorf("%s: unexpected permissions: %x", method, ctx.E.P)
+		}
+
+		if _, err = readPDFContext(file); err == nil {
+			t.Errorf("%s: expected error without password", method)
+		}
+	}
+}
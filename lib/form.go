@@ -0,0 +1,1445 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// 表单域的描述，填写时的键为完整名称 Name
+type FormField struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"` // text、checkbox、radio、combo、list、button、signature
+	Value       interface{} `json:"value,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Options     []string    `json:"options,omitempty"` // 选择域的导出值，复选框及单选按钮的选中状态
+	Labels      []string    `json:"labels,omitempty"`  // 选择域中与导出值不同的显示文字
+	Tooltip     string      `json:"tooltip,omitempty"`
+	ReadOnly    bool        `json:"read_only,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	MultiLine   bool        `json:"multiline,omitempty"`
+	MultiSelect bool        `json:"multi_select,omitempty"`
+	MaxLen      int         `json:"max_len,omitempty"`
+	Pages       []int       `json:"pages,omitempty"`
+}
+
+// 表单域标志位
+const (
+	fieldReadOnly    = 1 << 0
+	fieldRequired    = 1 << 1
+	fieldMultiLine   = 1 << 12
+	fieldPassword    = 1 << 13
+	fieldRadio       = 1 << 15
+	fieldPushButton  = 1 << 16
+	fieldCombo       = 1 << 17
+	fieldEdit        = 1 << 18
+	fieldMultiSelect = 1 << 21
+	fieldComb        = 1 << 24
+)
+
+// 文档中的一个表单域（终端节点）及其控件
+type formField struct {
+	name    string
+	dict    pdfcpu.Dict
+	ft      string
+	flags   int
+	widgets []*formWidget
+}
+
+type formWidget struct {
+	dict pdfcpu.Dict
+	ref  *pdfcpu.IndirectRef
+	page int
+}
+
+func (f *formField) fieldType() string {
+	switch f.ft {
+	case "Tx":
+		return "text"
+	case "Btn":
+		if f.flags&fieldPushButton != 0 {
+			return "button"
+		}
+		if f.flags&fieldRadio != 0 {
+			return "radio"
+		}
+		return "checkbox"
+	case "Ch":
+		if f.flags&fieldCombo != 0 {
+			return "combo"
+		}
+		return "list"
+	case "Sig":
+		return "signature"
+	}
+	return strings.ToLower(f.ft)
+}
+
+// 读取表单参数：values 为表单域完整名称到值的 JSON 对象，flatten 为 true 时把表单域转为页面内容
+func parseFormValues(request *http.Request) (map[string]interface{}, bool, error) {
+	values := make(map[string]interface{})
+	data, err := readUpload(request, "values")
+	if err == nil && len(bytes.TrimSpace(data)) > 0 {
+		if err = json.Unmarshal(data, &values); err != nil {
+			return nil, false, fmt.Errorf("invalid values: %v", err)
+		}
+	}
+	return values, parseBool(request.FormValue("flatten")), nil
+}
+
+// 表单字典及所有的表单域，没有表单时返回 nil
+func contextFormFields(ctx *pdfcpu.Context) (pdfcpu.Dict, []*formField, error) {
+	root, err := ctx.Catalog()
+	if err != nil {
+		return nil, nil, err
+	}
+	acroForm, err := ctx.DereferenceDict(root["AcroForm"])
+	if err != nil || acroForm == nil {
+		return nil, nil, err
+	}
+	fields, err := ctx.DereferenceArray(acroForm["Fields"])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 控件所在的页码
+	pages := make(map[int]int)
+	for i := 1; i <= ctx.PageCount; i++ {
+		page, _, err := ctx.PageDict(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		annots, _ := ctx.DereferenceArray(page["Annots"])
+		for _, o := range annots {
+			if ref, ok := o.(pdfcpu.IndirectRef); ok {
+				pages[ref.ObjectNumber.Value()] = i
+			}
+		}
+	}
+
+	result := make([]*formField, 0)
+	var walk func(o pdfcpu.Object, parent string, ft string, flags int, depth int) error
+	walk = func(o pdfcpu.Object, parent string, ft string, flags int, depth int) error {
+		d, err := ctx.DereferenceDict(o)
+		if err != nil || d == nil || depth > 32 {
+			return err
+		}
+		name := parent
+		if t, err := ctx.DereferenceText(d["T"]); err == nil && len(t) > 0 {
+			if len(name) > 0 {
+				name += "."
+			}
+			name += t
+		}
+		if value := d.NameEntry("FT"); value != nil {
+			ft = *value
+		}
+		if value := d.IntEntry("Ff"); value != nil {
+			flags = *value
+		}
+
+		kids, _ := ctx.DereferenceArray(d["Kids"])
+		fieldKids := make([]pdfcpu.Object, 0)
+		widgets := make([]*formWidget, 0)
+		for _, kid := range kids {
+			kd, err := ctx.DereferenceDict(kid)
+			if err != nil || kd == nil {
+				continue
+			}
+			if _, ok := kd["T"]; ok {
+				fieldKids = append(fieldKids, kid)
+				continue
+			}
+			widgets = append(widgets, newFormWidget(kid, kd, pages))
+		}
+		if len(fieldKids) > 0 {
+			for _, kid := range fieldKids {
+				if err = walk(kid, name, ft, flags, depth+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		// 没有子控件时表单域与控件合并为同一个字典
+		if len(kids) == 0 {
+			widgets = append(widgets, newFormWidget(o, d, pages))
+		}
+		result = append(result, &formField{name: name, dict: d, ft: ft, flags: flags, widgets: widgets})
+		return nil
+	}
+	for _, o := range fields {
+		if err = walk(o, "", "", 0, 0); err != nil {
+			return nil, nil, err
+		}
+	}
+	return acroForm, result, nil
+}
+
+func newFormWidget(o pdfcpu.Object, d pdfcpu.Dict, pages map[int]int) *formWidget {
+	w := &formWidget{dict: d}
+	if ref, ok := o.(pdfcpu.IndirectRef); ok {
+		w.ref = &ref
+		w.page = pages[ref.ObjectNumber.Value()]
+	}
+	return w
+}
+
+// 可继承的表单域属性
+func (f *formField) inherited(ctx *pdfcpu.Context, key string) pdfcpu.Object {
+	d := f.dict
+	for depth := 0; d != nil && depth < 32; depth++ {
+		if o, found := d.Find(key); found {
+			return o
+		}
+		d, _ = ctx.DereferenceDict(d["Parent"])
+	}
+	return nil
+}
+
+// 复选框及单选按钮控件的选中状态名称
+func widgetOnState(ctx *pdfcpu.Context, w *formWidget) string {
+	ap, _ := ctx.DereferenceDict(w.dict["AP"])
+	if ap == nil {
+		return ""
+	}
+	normal, _ := ctx.DereferenceDict(ap["N"])
+	for key := range normal {
+		if key != "Off" {
+			return key
+		}
+	}
+	return ""
+}
+
+// 选择域的选项：导出值及显示文字
+func (f *formField) choiceOptions(ctx *pdfcpu.Context) ([]string, []string) {
+	exports, labels := make([]string, 0), make([]string, 0)
+	opt, _ := ctx.DereferenceArray(f.inherited(ctx, "Opt"))
+	for _, o := range opt {
+		if pair, err := ctx.DereferenceArray(o); err == nil && len(pair) == 2 {
+			export, _ := ctx.DereferenceText(pair[0])
+			label, _ := ctx.DereferenceText(pair[1])
+			exports = append(exports, export)
+			labels = append(labels, label)
+			continue
+		}
+		text, _ := ctx.DereferenceText(o)
+		exports = append(exports, text)
+		labels = append(labels, text)
+	}
+	return exports, labels
+}
+
+// 表单域的值：文本、名称或文本数组
+func formValue(ctx *pdfcpu.Context, o pdfcpu.Object) interface{} {
+	o, _ = ctx.Dereference(o)
+	switch v := o.(type) {
+	case pdfcpu.Name:
+		return v.Value()
+	case pdfcpu.StringLiteral, pdfcpu.HexLiteral:
+		text, _ := ctx.DereferenceText(v)
+		return text
+	case pdfcpu.Array:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			text, _ := ctx.DereferenceText(item)
+			list = append(list, text)
+		}
+		return list
+	}
+	return nil
+}
+
+// 读取 PDF 的表单域
+func ReadFormFields(src string) ([]*FormField, error) {
+	ctx, err := readPDFContext(src)
+	if err != nil {
+		return nil, err
+	}
+	return contextFormSchema(ctx)
+}
+
+func contextFormSchema(ctx *pdfcpu.Context) ([]*FormField, error) {
+	_, fields, err := contextFormFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*FormField, 0, len(fields))
+	for _, f := range fields {
+		item := &FormField{
+			Name:        f.name,
+			Type:        f.fieldType(),
+			Value:       formValue(ctx, f.inherited(ctx, "V")),
+			Default:     formValue(ctx, f.inherited(ctx, "DV")),
+			ReadOnly:    f.flags&fieldReadOnly != 0,
+			Required:    f.flags&fieldRequired != 0,
+			MultiLine:   f.ft == "Tx" && f.flags&fieldMultiLine != 0,
+			MultiSelect: f.ft == "Ch" && f.flags&fieldMultiSelect != 0,
+		}
+		if tooltip, err := ctx.DereferenceText(f.dict["TU"]); err == nil {
+			item.Tooltip = tooltip
+		}
+		if maxLen, _ := ctx.DereferenceInteger(f.inherited(ctx, "MaxLen")); maxLen != nil {
+			item.MaxLen = maxLen.Value()
+		}
+		switch item.Type {
+		case "combo", "list":
+			exports, labels := f.choiceOptions(ctx)
+			item.Options = exports
+			for i := range exports {
+				if labels[i] != exports[i] {
+					item.Labels = labels
+					break
+				}
+			}
+		case "checkbox", "radio":
+			for _, w := range f.widgets {
+				if state := widgetOnState(ctx, w); len(state) > 0 && !containsString(item.Options, state) {
+					item.Options = append(item.Options, state)
+				}
+			}
+		}
+		for _, w := range f.widgets {
+			if w.page > 0 && !containsInt(item.Pages, w.page) {
+				item.Pages = append(item.Pages, w.page)
+			}
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+// 填写表单（src 与 dest 可以相同），values 的键为表单域的完整名称。
+// 文本及选择域按表单域的字体重新生成外观，字体无法编码的值改为由阅读器生成外观（此时不能扁平化）
+func FillForm(src string, dest string, values map[string]interface{}, flatten bool) error {
+	ctx, err := readPDFContext(src)
+	if err != nil {
+		return err
+	}
+	acroForm, fields, err := contextFormFields(ctx)
+	if err != nil {
+		return err
+	}
+	if acroForm == nil {
+		return fmt.Errorf("the pdf has no form fields")
+	}
+
+	filler := newFormFiller(ctx, acroForm)
+	byName := make(map[string]*formField)
+	for _, f := range fields {
+		byName[f.name] = f
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("unknown form field: %s", name)
+		}
+		if err = filler.fill(f, values[name]); err != nil {
+			return err
+		}
+	}
+
+	if flatten {
+		if len(filler.unsupported) > 0 {
+			return fmt.Errorf("unable to flatten %s: the field font cannot display the value", strings.Join(filler.unsupported, ", "))
+		}
+		if err = flattenForm(ctx, acroForm, fields); err != nil {
+			return err
+		}
+	} else if len(filler.unsupported) > 0 {
+		acroForm.Update("NeedAppearances", pdfcpu.Boolean(true))
+	}
+	return writePDFContext(ctx, dest)
+}
+
+type formFiller struct {
+	ctx         *pdfcpu.Context
+	acroForm    pdfcpu.Dict
+	fonts       map[string]*formFont
+	unsupported []string
+}
+
+func newFormFiller(ctx *pdfcpu.Context, acroForm pdfcpu.Dict) *formFiller {
+	return &formFiller{ctx: ctx, acroForm: acroForm, fonts: make(map[string]*formFont)}
+}
+
+// JSON 值转换为文本
+func formText(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("unsupported value: %v", value)
+}
+
+func (filler *formFiller) fill(f *formField, value interface{}) error {
+	ctx := filler.ctx
+	switch f.fieldType() {
+	case "text":
+		text, err := formText(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.name, err)
+		}
+		if maxLen, _ := ctx.DereferenceInteger(f.inherited(ctx, "MaxLen")); maxLen != nil && len([]rune(text)) > maxLen.Value() {
+			return fmt.Errorf("%s: the value exceeds %d characters", f.name, maxLen.Value())
+		}
+		f.dict.Update("V", pdfTextString(text))
+		f.dict.Delete("RV")
+		return filler.updateTextAppearances(f, text)
+
+	case "checkbox", "radio":
+		state, err := filler.buttonState(f, value)
+		if err != nil {
+			return err
+		}
+		if state == "Off" {
+			f.dict.Update("V", pdfcpu.Name("Off"))
+		} else {
+			f.dict.Update("V", pdfName(state))
+		}
+		for _, w := range f.widgets {
+			on := widgetOnState(ctx, w)
+			if len(on) == 0 {
+				// 没有外观的控件按选中状态生成外观
+				on = pdfName(state).Value()
+				if state == "Off" {
+					on = "Yes"
+				}
+				if err = filler.buttonAppearance(f, w, string(pdfName(on))); err != nil {
+					return err
+				}
+				on = string(pdfName(on))
+			}
+			if state != "Off" && pdfcpu.Name(on).Value() == state {
+				w.dict.Update("AS", pdfcpu.Name(on))
+			} else {
+				w.dict.Update("AS", pdfcpu.Name("Off"))
+			}
+		}
+		return nil
+
+	case "combo", "list":
+		selected, err := formChoices(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.name, err)
+		}
+		exports, labels := f.choiceOptions(ctx)
+		indices := make(pdfcpu.Array, 0)
+		display := make([]string, 0)
+		for i, s := range selected {
+			found := -1
+			for j := range exports {
+				if exports[j] == s || labels[j] == s {
+					found = j
+					break
+				}
+			}
+			if found < 0 {
+				if f.flags&fieldCombo == 0 || f.flags&fieldEdit == 0 {
+					return fmt.Errorf("%s: %s is not an option", f.name, s)
+				}
+				display = append(display, s)
+				continue
+			}
+			selected[i] = exports[found]
+			display = append(display, labels[found])
+			indices = append(indices, pdfcpu.Integer(found))
+		}
+		if len(selected) > 1 && f.flags&fieldMultiSelect == 0 {
+			return fmt.Errorf("%s: only one option can be selected", f.name)
+		}
+		switch len(selected) {
+		case 0:
+			f.dict.Delete("V")
+		case 1:
+			f.dict.Update("V", pdfTextString(selected[0]))
+		default:
+			list := make(pdfcpu.Array, 0, len(selected))
+			for _, s := range selected {
+				list = append(list, pdfTextString(s))
+			}
+			f.dict.Update("V", list)
+		}
+		if len(indices) > 0 {
+			f.dict.Update("I", indices)
+		} else {
+			f.dict.Delete("I")
+		}
+		if f.flags&fieldCombo != 0 {
+			return filler.updateTextAppearances(f, strings.Join(display, ", "))
+		}
+		return filler.updateListAppearances(f, labels, indices)
+	}
+	return fmt.Errorf("%s: %s fields cannot be filled", f.name, f.fieldType())
+}
+
+func formChoices(value interface{}) ([]string, error) {
+	if list, ok := value.([]interface{}); ok {
+		result := make([]string, 0, len(list))
+		for _, item := range list {
+			text, err := formText(item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, text)
+		}
+		return result, nil
+	}
+	text, err := formText(value)
+	if err != nil || len(text) == 0 {
+		return []string{}, err
+	}
+	return []string{text}, nil
+}
+
+// 复选框及单选按钮的目标状态："Off" 或控件的选中状态名称（已解码）
+func (filler *formFiller) buttonState(f *formField, value interface{}) (string, error) {
+	ctx := filler.ctx
+	states := make([]string, 0)
+	for _, w := range f.widgets {
+		if on := widgetOnState(ctx, w); len(on) > 0 && !containsString(states, pdfcpu.Name(on).Value()) {
+			states = append(states, pdfcpu.Name(on).Value())
+		}
+	}
+	exports, _ := f.choiceOptions(ctx)
+
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return "Off", nil
+	case bool:
+		if !v {
+			return "Off", nil
+		}
+		if f.fieldType() == "radio" {
+			return "", fmt.Errorf("%s: select a radio button by its state name %v", f.name, states)
+		}
+		if len(states) > 0 {
+			return states[0], nil
+		}
+		return "Yes", nil
+	default:
+		var err error
+		if text, err = formText(v); err != nil {
+			return "", fmt.Errorf("%s: %v", f.name, err)
+		}
+	}
+	if len(text) == 0 || text == "Off" {
+		return "Off", nil
+	}
+	if containsString(states, text) {
+		return text, nil
+	}
+	// Opt 中的导出值按顺序对应控件的状态
+	for i, export := range exports {
+		if export == text && i < len(f.widgets) {
+			if on := widgetOnState(ctx, f.widgets[i]); len(on) > 0 {
+				return pdfcpu.Name(on).Value(), nil
+			}
+		}
+	}
+	if f.fieldType() == "checkbox" {
+		if checked, err := strconv.ParseBool(text); err == nil {
+			return filler.buttonState(f, checked)
+		}
+	}
+	if len(states) == 0 {
+		return text, nil
+	}
+	return "", fmt.Errorf("%s: %s is not one of %v", f.name, text, states)
+}
+
+// 控件外观的尺寸：宽、高及旋转矩阵
+func widgetBox(ctx *pdfcpu.Context, w *formWidget) (float64, float64, pdfcpu.Array) {
+	rect, _ := ctx.DereferenceArray(w.dict["Rect"])
+	values := make([]float64, 0, 4)
+	for _, o := range rect {
+		if n, ok := pdfNumber(o); ok {
+			values = append(values, n)
+		}
+	}
+	if len(values) != 4 {
+		return 0, 0, nil
+	}
+	width, height := math.Abs(values[2]-values[0]), math.Abs(values[3]-values[1])
+	mk, _ := ctx.DereferenceDict(w.dict["MK"])
+	rotation := 0
+	if mk != nil {
+		if r := mk.IntEntry("R"); r != nil {
+			rotation = ((*r % 360) + 360) % 360
+		}
+	}
+	switch rotation {
+	case 90:
+		return height, width, pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(1), pdfcpu.Integer(-1), pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Integer(0)}
+	case 180:
+		return width, height, pdfcpu.Array{pdfcpu.Integer(-1), pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Integer(-1), pdfcpu.Integer(0), pdfcpu.Integer(0)}
+	case 270:
+		return height, width, pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(-1), pdfcpu.Integer(1), pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Integer(0)}
+	}
+	return width, height, nil
+}
+
+func pdfNumber(o pdfcpu.Object) (float64, bool) {
+	switch v := o.(type) {
+	case pdfcpu.Integer:
+		return float64(v), true
+	case pdfcpu.Float:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// MK 中的颜色数组转换为颜色操作
+func widgetColor(ctx *pdfcpu.Context, o pdfcpu.Object, stroke bool) string {
+	a, _ := ctx.DereferenceArray(o)
+	values := make([]string, 0, len(a))
+	for _, item := range a {
+		if n, ok := pdfNumber(item); ok {
+			values = append(values, strconv.FormatFloat(n, 'f', -1, 64))
+		}
+	}
+	ops := map[int]string{1: "g", 3: "rg", 4: "k"}
+	op, ok := ops[len(values)]
+	if !ok {
+		return ""
+	}
+	if stroke {
+		op = strings.ToUpper(op)
+	}
+	return strings.Join(values, " ") + " " + op
+}
+
+// 控件的背景及边框，返回边框宽度
+func widgetBorder(ctx *pdfcpu.Context, w *formWidget, width, height float64, b *bytes.Buffer) float64 {
+	mk, _ := ctx.DereferenceDict(w.dict["MK"])
+	if mk == nil {
+		return 0
+	}
+	if bg := widgetColor(ctx, mk["BG"], false); len(bg) > 0 {
+		fmt.Fprintf(b, "%s 0 0 %.2f %.2f re f\n", bg, width, height)
+	}
+	bc := widgetColor(ctx, mk["BC"], true)
+	if len(bc) == 0 {
+		return 0
+	}
+	border := 1.0
+	if bs, _ := ctx.DereferenceDict(w.dict["BS"]); bs != nil {
+		if n, ok := pdfNumber(bs["W"]); ok {
+			border = n
+		}
+	}
+	if border > 0 {
+		fmt.Fprintf(b, "%s %.2f w %.2f %.2f %.2f %.2f re S\n", bc, border, border/2, border/2, width-border, height-border)
+	}
+	return border
+}
+
+// 默认外观（DA）中的字体名称、字号及颜色操作
+func parseDefaultAppearance(da string) (string, float64, string) {
+	font, size, color := "Helv", 0.0, "0 g"
+	tokens := strings.Fields(da)
+	for i, token := range tokens {
+		switch token {
+		case "Tf":
+			if i >= 2 {
+				font = strings.TrimPrefix(tokens[i-2], "/")
+				size, _ = strconv.ParseFloat(tokens[i-1], 64)
+			}
+		case "g", "rg", "k":
+			n := map[string]int{"g": 1, "rg": 3, "k": 4}[token]
+			if i >= n {
+				color = strings.Join(tokens[i-n:i+1], " ")
+			}
+		}
+	}
+	return font, size, color
+}
+
+// 表单使用的字体：资源名称、字体对象及字符宽度（WinAnsi 编码，千分之一字号）
+type formFont struct {
+	name      string
+	object    pdfcpu.Object
+	widths    [256]float64
+	encodable bool // 简单字体，可以按 WinAnsi 编码写入文本
+}
+
+// 表单中常用的字体资源名称
+var formFontAliases = map[string]string{
+	"Helv": "Helvetica", "HeBo": "Helvetica-Bold", "TiRo": "Times-Roman", "TiBo": "Times-Bold",
+	"TiIt": "Times-Italic", "Cour": "Courier", "CoBo": "Courier-Bold",
+}
+
+func (filler *formFiller) font(name string) *formFont {
+	if f, ok := filler.fonts[name]; ok {
+		return f
+	}
+	ctx := filler.ctx
+	f := &formFont{name: name, encodable: true}
+	filler.fonts[name] = f
+
+	var d pdfcpu.Dict
+	if dr, _ := ctx.DereferenceDict(filler.acroForm["DR"]); dr != nil {
+		if fonts, _ := ctx.DereferenceDict(dr["Font"]); fonts != nil {
+			if o, found := fonts.Find(name); found {
+				f.object = o
+				d, _ = ctx.DereferenceDict(o)
+			}
+		}
+	}
+	base := formFontAliases[name]
+	if d != nil {
+		if subtype := d.Subtype(); subtype != nil && *subtype == "Type0" {
+			f.encodable = false
+		}
+		if value := d.NameEntry("BaseFont"); value != nil {
+			base = *value
+			if i := strings.IndexByte(base, '+'); i == 6 {
+				base = base[i+1:]
+			}
+		}
+	} else {
+		// DR 中没有的字体使用 Helvetica
+		if len(base) == 0 {
+			base = "Helvetica"
+		}
+		f.object = pdfcpu.Dict{
+			"Type":     pdfcpu.Name("Font"),
+			"Subtype":  pdfcpu.Name("Type1"),
+			"BaseFont": pdfcpu.Name(base),
+			"Encoding": pdfcpu.Name("WinAnsiEncoding"),
+		}
+	}
+	if base == "Symbol" || base == "ZapfDingbats" {
+		f.encodable = false
+	}
+
+	for i := range f.widths {
+		f.widths[i] = 500
+	}
+	if family, ok := standardFonts[base]; ok {
+		metrics := gofpdf.New("P", "pt", "A4", "")
+		metrics.SetFont(family[0], family[1], 1000)
+		for i := 32; i < 256; i++ {
+			f.widths[i] = metrics.GetStringWidth(string([]byte{byte(i)}))
+		}
+	} else if d != nil {
+		widths, _ := ctx.DereferenceArray(d["Widths"])
+		first := d.IntEntry("FirstChar")
+		if first != nil {
+			for i, o := range widths {
+				if n, ok := pdfNumber(o); ok && *first+i < 256 {
+					f.widths[*first+i] = n
+				}
+			}
+		}
+	}
+	return f
+}
+
+func (f *formFont) width(text string, size float64) float64 {
+	w := 0.0
+	for i := 0; i < len(text); i++ {
+		w += f.widths[text[i]]
+	}
+	return w * size / 1000
+}
+
+// 按 WinAnsi 编码文本，有无法编码的字符时返回 false
+func winAnsiEncode(s string) (string, bool) {
+	var b strings.Builder
+	for _, r := range s {
+		c, ok := winAnsiByte(r)
+		if !ok {
+			return "", false
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), true
+}
+
+// 标准字体只支持 WinAnsi 编码，与 winAnsiEncode 相同，但无法编码的字符替换为 ?
+func winAnsiString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		c, ok := winAnsiByte(r)
+		if !ok {
+			c = '?'
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// 字符到 WinAnsi 编码的映射，在包初始化时生成，可以并发读取
+var winAnsiBytes = func() map[rune]byte {
+	table := make(map[rune]byte)
+	for i := 255; i >= 32; i-- {
+		// 0x81、0x8D 等未定义的编码不对应任何字符
+		if r := winAnsiRune(byte(i)); r != 0 {
+			table[r] = byte(i)
+		}
+	}
+	return table
+}()
+
+func winAnsiByte(r rune) (byte, bool) {
+	if r == '\t' {
+		r = ' '
+	}
+	c, ok := winAnsiBytes[r]
+	return c, ok
+}
+
+// 按宽度折行，换行符强制换行
+func wrapFormText(text string, font *formFont, size float64, width float64) []string {
+	lines := make([]string, 0)
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := ""
+		for _, word := range strings.Split(paragraph, " ") {
+			candidate := word
+			if len(line) > 0 {
+				candidate = line + " " + word
+			}
+			if len(line) > 0 && font.width(candidate, size) > width {
+				lines = append(lines, line)
+				line = word
+				continue
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// 文本域及组合框的外观
+func (filler *formFiller) updateTextAppearances(f *formField, text string) error {
+	ctx := filler.ctx
+	da, _ := ctx.DereferenceText(f.inherited(ctx, "DA"))
+	if len(da) == 0 {
+		da, _ = ctx.DereferenceText(filler.acroForm["DA"])
+	}
+	fontName, fontSize, color := parseDefaultAppearance(da)
+	font := filler.font(fontName)
+	if f.flags&fieldPassword != 0 {
+		text = strings.Repeat("*", len([]rune(text)))
+	}
+	encoded, ok := winAnsiEncode(text)
+	if !ok || !font.encodable {
+		filler.unsupported = append(filler.unsupported, f.name)
+		for _, w := range f.widgets {
+			w.dict.Delete("AP")
+		}
+		return nil
+	}
+	quadding := 0
+	if q, _ := ctx.DereferenceInteger(f.inherited(ctx, "Q")); q != nil {
+		quadding = q.Value()
+	} else if q, _ := ctx.DereferenceInteger(filler.acroForm["Q"]); q != nil {
+		quadding = q.Value()
+	}
+	maxLen := 0
+	if n, _ := ctx.DereferenceInteger(f.inherited(ctx, "MaxLen")); n != nil {
+		maxLen = n.Value()
+	}
+
+	for _, w := range f.widgets {
+		width, height, matrix := widgetBox(ctx, w)
+		if width <= 0 || height <= 0 {
+			continue
+		}
+		var b bytes.Buffer
+		border := widgetBorder(ctx, w, width, height, &b)
+		padding := math.Max(border, 1) * 2
+		availW, availH := width-padding*2, height-padding*2
+
+		b.WriteString("/Tx BMC\nq\n")
+		fmt.Fprintf(&b, "%.2f %.2f %.2f %.2f re W n\nBT\n", padding/2, padding/2, width-padding, height-padding)
+		size := fontSize
+		switch {
+		case f.ft == "Tx" && f.flags&fieldMultiLine != 0:
+			if size <= 0 {
+				size = 12
+				for size > 4 && float64(len(wrapFormText(encoded, font, size, availW)))*size*1.15 > availH {
+					size -= 0.5
+				}
+			}
+			fmt.Fprintf(&b, "/%s %.2f Tf %s\n", fontName, size, color)
+			y := height - padding - size*0.8
+			for _, line := range wrapFormText(encoded, font, size, availW) {
+				x := formTextX(quadding, padding, width, font.width(line, size))
+				escaped, _ := pdfcpu.Escape(line)
+				fmt.Fprintf(&b, "1 0 0 1 %.2f %.2f Tm (%s) Tj\n", x, y, *escaped)
+				y -= size * 1.15
+			}
+		case f.ft == "Tx" && f.flags&fieldComb != 0 && maxLen > 0:
+			if size <= 0 {
+				size = math.Min(12, availH/1.15)
+			}
+			fmt.Fprintf(&b, "/%s %.2f Tf %s\n", fontName, size, color)
+			cell := width / float64(maxLen)
+			y := (height - size*0.7) / 2
+			for i := 0; i < len(encoded) && i < maxLen; i++ {
+				c := encoded[i : i+1]
+				escaped, _ := pdfcpu.Escape(c)
+				fmt.Fprintf(&b, "1 0 0 1 %.2f %.2f Tm (%s) Tj\n", cell*float64(i)+(cell-font.width(c, size))/2, y, *escaped)
+			}
+		default:
+			if size <= 0 {
+				size = math.Min(12, availH/1.15)
+				if tw := font.width(encoded, size); tw > availW && tw > 0 {
+					size = math.Max(4, size*availW/tw)
+				}
+			}
+			fmt.Fprintf(&b, "/%s %.2f Tf %s\n", fontName, size, color)
+			x := formTextX(quadding, padding, width, font.width(encoded, size))
+			escaped, _ := pdfcpu.Escape(encoded)
+			fmt.Fprintf(&b, "1 0 0 1 %.2f %.2f Tm (%s) Tj\n", x, (height-size*0.7)/2, *escaped)
+		}
+		b.WriteString("ET\nQ\nEMC\n")
+		if err := filler.setAppearance(w, width, height, matrix, pdfcpu.Dict{font.name: font.object}, b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formTextX(quadding int, padding float64, width float64, textWidth float64) float64 {
+	switch quadding {
+	case 1:
+		return (width - textWidth) / 2
+	case 2:
+		return width - padding - textWidth
+	}
+	return padding
+}
+
+// 列表框的外观：从 TI 开始列出选项，选中的选项加上背景
+func (filler *formFiller) updateListAppearances(f *formField, labels []string, indices pdfcpu.Array) error {
+	ctx := filler.ctx
+	da, _ := ctx.DereferenceText(f.inherited(ctx, "DA"))
+	if len(da) == 0 {
+		da, _ = ctx.DereferenceText(filler.acroForm["DA"])
+	}
+	fontName, size, color := parseDefaultAppearance(da)
+	font := filler.font(fontName)
+	encoded := make([]string, len(labels))
+	for i, label := range labels {
+		var ok bool
+		if encoded[i], ok = winAnsiEncode(label); !ok || !font.encodable {
+			filler.unsupported = append(filler.unsupported, f.name)
+			for _, w := range f.widgets {
+				w.dict.Delete("AP")
+			}
+			return nil
+		}
+	}
+	if size <= 0 {
+		size = 12
+	}
+	top := 0
+	if ti := f.dict.IntEntry("TI"); ti != nil {
+		top = *ti
+	}
+	selected := make(map[int]bool)
+	for _, o := range indices {
+		if n, ok := o.(pdfcpu.Integer); ok {
+			selected[int(n)] = true
+		}
+	}
+
+	for _, w := range f.widgets {
+		width, height, matrix := widgetBox(ctx, w)
+		if width <= 0 || height <= 0 {
+			continue
+		}
+		var b bytes.Buffer
+		border := widgetBorder(ctx, w, width, height, &b)
+		padding := math.Max(border, 1) * 2
+		leading := size * 1.15
+		b.WriteString("/Tx BMC\nq\n")
+		fmt.Fprintf(&b, "%.2f %.2f %.2f %.2f re W n\n", padding/2, padding/2, width-padding, height-padding)
+		y := height - padding/2
+		for i := top; i < len(encoded) && y > 0; i++ {
+			if selected[i] {
+				fmt.Fprintf(&b, "0.6 0.75 0.85 rg %.2f %.2f %.2f %.2f re f\n", padding/2, y-leading, width-padding, leading)
+			}
+			escaped, _ := pdfcpu.Escape(encoded[i])
+			fmt.Fprintf(&b, "BT /%s %.2f Tf %s 1 0 0 1 %.2f %.2f Tm (%s) Tj ET\n", fontName, size, color, padding, y-size*0.9, *escaped)
+			y -= leading
+		}
+		b.WriteString("Q\nEMC\n")
+		if err := filler.setAppearance(w, width, height, matrix, pdfcpu.Dict{font.name: font.object}, b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 没有外观的复选框及单选按钮：用 ZapfDingbats 的符号（MK 中的 CA，默认为勾或圆点）生成选中及未选中外观
+func (filler *formFiller) buttonAppearance(f *formField, w *formWidget, on string) error {
+	ctx := filler.ctx
+	width, height, matrix := widgetBox(ctx, w)
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+	symbol := "4"
+	if f.fieldType() == "radio" {
+		symbol = "l"
+	}
+	if mk, _ := ctx.DereferenceDict(w.dict["MK"]); mk != nil {
+		if ca, err := ctx.DereferenceText(mk["CA"]); err == nil && len(ca) > 0 {
+			symbol = ca
+		}
+	}
+	var off bytes.Buffer
+	widgetBorder(ctx, w, width, height, &off)
+	size := math.Min(width, height) * 0.8
+	var checked bytes.Buffer
+	checked.Write(off.Bytes())
+	escaped, _ := pdfcpu.Escape(symbol)
+	fmt.Fprintf(&checked, "q BT /ZaDb %.2f Tf 0 g 1 0 0 1 %.2f %.2f Tm (%s) Tj ET Q\n", size, (width-size*0.75)/2, (height-size*0.7)/2, *escaped)
+
+	resources := pdfcpu.Dict{"Font": pdfcpu.Dict{"ZaDb": pdfcpu.Dict{
+		"Type":     pdfcpu.Name("Font"),
+		"Subtype":  pdfcpu.Name("Type1"),
+		"BaseFont": pdfcpu.Name("ZapfDingbats"),
+	}}}
+	states := pdfcpu.Dict{}
+	for _, state := range []struct {
+		name    string
+		content []byte
+	}{{on, checked.Bytes()}, {"Off", off.Bytes()}} {
+		ref, err := formXObject(ctx, width, height, matrix, resources, state.content)
+		if err != nil {
+			return err
+		}
+		states.Insert(state.name, *ref)
+	}
+	w.dict.Update("AP", pdfcpu.Dict{"N": states})
+	return nil
+}
+
+func formXObject(ctx *pdfcpu.Context, width, height float64, matrix pdfcpu.Array, resources pdfcpu.Dict, content []byte) (*pdfcpu.IndirectRef, error) {
+	d := pdfcpu.Dict{
+		"Type":      pdfcpu.Name("XObject"),
+		"Subtype":   pdfcpu.Name("Form"),
+		"BBox":      pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Float(width), pdfcpu.Float(height)},
+		"Resources": resources,
+	}
+	if matrix != nil {
+		d.Insert("Matrix", matrix)
+	}
+	stream, err := newFlateStream(d, content)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.IndRefForNewObject(*stream)
+}
+
+func (filler *formFiller) setAppearance(w *formWidget, width, height float64, matrix pdfcpu.Array, fonts pdfcpu.Dict, content []byte) error {
+	ref, err := formXObject(filler.ctx, width, height, matrix, pdfcpu.Dict{"Font": fonts}, content)
+	if err != nil {
+		return err
+	}
+	w.dict.Update("AP", pdfcpu.Dict{"N": *ref})
+	return nil
+}
+
+// 控件当前显示的外观流
+func widgetAppearance(ctx *pdfcpu.Context, w *formWidget) *pdfcpu.IndirectRef {
+	ap, _ := ctx.DereferenceDict(w.dict["AP"])
+	if ap == nil {
+		return nil
+	}
+	normal := ap["N"]
+	if states, err := ctx.DereferenceDict(normal); err == nil && states != nil && states.Type() == nil && states["BBox"] == nil {
+		state := w.dict.NameEntry("AS")
+		if state == nil {
+			return nil
+		}
+		normal = states[*state]
+	}
+	if ref, ok := normal.(pdfcpu.IndirectRef); ok {
+		if sd, err := ctx.DereferenceStreamDict(ref); err == nil && sd != nil {
+			return &ref
+		}
+	}
+	return nil
+}
+
+// 扁平化：把控件的外观绘制到页面内容中，删除控件及表单字典
+func flattenForm(ctx *pdfcpu.Context, acroForm pdfcpu.Dict, fields []*formField) error {
+	dr, _ := ctx.DereferenceDict(acroForm["DR"])
+	widgets := make(map[int]*formWidget)
+	for _, f := range fields {
+		for _, w := range f.widgets {
+			if w.ref != nil {
+				widgets[w.ref.ObjectNumber.Value()] = w
+			}
+		}
+	}
+	save, err := newContentStream(ctx, "q\n")
+	if err != nil {
+		return err
+	}
+
+	for n := 1; n <= ctx.PageCount; n++ {
+		page, _, err := ctx.PageDict(n)
+		if err != nil {
+			return err
+		}
+		annots, _ := ctx.DereferenceArray(page["Annots"])
+		kept := make(pdfcpu.Array, 0, len(annots))
+		var content bytes.Buffer
+		for _, o := range annots {
+			ref, ok := o.(pdfcpu.IndirectRef)
+			var w *formWidget
+			if ok {
+				w = widgets[ref.ObjectNumber.Value()]
+			}
+			if w == nil {
+				kept = append(kept, o)
+				continue
+			}
+			// 隐藏或不显示的控件直接删除
+			if flags := w.dict.IntEntry("F"); flags != nil && *flags&(1<<1|1<<5) != 0 {
+				continue
+			}
+			appearance := widgetAppearance(ctx, w)
+			if appearance == nil {
+				continue
+			}
+			cm, ok := flattenMatrix(ctx, w, *appearance)
+			if !ok {
+				continue
+			}
+			sd, _ := ctx.DereferenceStreamDict(*appearance)
+			sd.Update("Type", pdfcpu.Name("XObject"))
+			sd.Update("Subtype", pdfcpu.Name("Form"))
+			if sd.Dict["Resources"] == nil && dr != nil {
+				sd.Insert("Resources", dr)
+			}
+			name, err := pageXObjectName(ctx.XRefTable, page, *appearance)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&content, "q %s cm /%s Do Q\n", cm, name)
+		}
+		if len(kept) == len(annots) {
+			continue
+		}
+		if len(kept) > 0 {
+			page.Update("Annots", kept)
+		} else {
+			page.Delete("Annots")
+		}
+		if content.Len() > 0 {
+			ref, err := newContentStream(ctx, "Q\n"+content.String())
+			if err != nil {
+				return err
+			}
+			wrapPageContents(ctx.XRefTable, page, *save, *ref)
+		}
+	}
+
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	root.Delete("AcroForm")
+	return nil
+}
+
+// 把外观的 BBox（经过 Matrix 变换）映射到控件 Rect 的矩阵
+func flattenMatrix(ctx *pdfcpu.Context, w *formWidget, appearance pdfcpu.IndirectRef) (string, bool) {
+	numbers := func(o pdfcpu.Object, count int) []float64 {
+		a, _ := ctx.DereferenceArray(o)
+		values := make([]float64, 0, len(a))
+		for _, item := range a {
+			if n, ok := pdfNumber(item); ok {
+				values = append(values, n)
+			}
+		}
+		if len(values) != count {
+			return nil
+		}
+		return values
+	}
+	rect := numbers(w.dict["Rect"], 4)
+	sd, _ := ctx.DereferenceStreamDict(appearance)
+	if rect == nil || sd == nil {
+		return "", false
+	}
+	bbox := numbers(sd.Dict["BBox"], 4)
+	if bbox == nil {
+		return "", false
+	}
+	m := pdfMatrix{1, 0, 0, 1, 0, 0}
+	if values := numbers(sd.Dict["Matrix"], 6); values != nil {
+		copy(m[:], values)
+	}
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, corner := range [][2]float64{{bbox[0], bbox[1]}, {bbox[2], bbox[1]}, {bbox[0], bbox[3]}, {bbox[2], bbox[3]}} {
+		x, y := m.transform(corner[0], corner[1])
+		minX, minY = math.Min(minX, x), math.Min(minY, y)
+		maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+	}
+	if maxX-minX <= 0 || maxY-minY <= 0 {
+		return "", false
+	}
+	left, bottom := math.Min(rect[0], rect[2]), math.Min(rect[1], rect[3])
+	sx := math.Abs(rect[2]-rect[0]) / (maxX - minX)
+	sy := math.Abs(rect[3]-rect[1]) / (maxY - minY)
+	return fmt.Sprintf("%.4f 0 0 %.4f %.4f %.4f", sx, sy, left-minX*sx, bottom-minY*sy), true
+}
+
+// 在页面资源中登记外观 XObject，返回名称。多个页面可能共用同一个资源字典
+func pageXObjectName(xRefTable *pdfcpu.XRefTable, page pdfcpu.Dict, ref pdfcpu.IndirectRef) (string, error) {
+	resources, err := xRefTable.DereferenceDict(inheritedPageEntry(xRefTable, page, "Resources"))
+	if err != nil {
+		return "", err
+	}
+	if resources == nil {
+		resources = pdfcpu.Dict{}
+		page.Update("Resources", resources)
+	}
+	xobjects, err := xRefTable.DereferenceDict(resources["XObject"])
+	if err != nil {
+		return "", err
+	}
+	if xobjects == nil {
+		xobjects = pdfcpu.Dict{}
+		resources.Update("XObject", xobjects)
+	}
+	for i := 0; ; i++ {
+		name := "Fm" + strconv.Itoa(i)
+		o, found := xobjects.Find(name)
+		if !found {
+			xobjects.Insert(name, ref)
+			return name, nil
+		}
+		if r, ok := o.(pdfcpu.IndirectRef); ok && r.ObjectNumber == ref.ObjectNumber {
+			return name, nil
+		}
+	}
+}
+
+// pdfcpu 合并时只保留第一个文件的文档目录，表单字典先暂存在最后一页的 PieceInfo 中随页面一起合并
+// （写入时只保留已知的页面属性），合并后再移回文档目录。写入页面树时会提前写入表单控件引用的页面，
+// 放在最后一页可以保证这些页面都已写入
+const stashedAcroFormKey = "Html2pdfAcroForm"
+
+func StashAcroForm(pdf_path string) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	acroForm, found := root.Find("AcroForm")
+	if !found || ctx.PageCount == 0 {
+		return nil
+	}
+	if _, ok := acroForm.(pdfcpu.IndirectRef); !ok {
+		ref, err := ctx.IndRefForNewObject(acroForm)
+		if err != nil {
+			return err
+		}
+		acroForm = *ref
+	}
+	page, _, err := ctx.PageDict(ctx.PageCount)
+	if err != nil {
+		return err
+	}
+	pieceInfo, err := ctx.DereferenceDict(page["PieceInfo"])
+	if err != nil {
+		return err
+	}
+	if pieceInfo == nil {
+		pieceInfo = pdfcpu.Dict{}
+		page.Update("PieceInfo", pieceInfo)
+	}
+	pieceInfo.Update(stashedAcroFormKey, pdfcpu.Dict{
+		"LastModified": pdfcpu.StringLiteral(pdfDate(time.Now())),
+		"Private":      acroForm,
+	})
+	root.Delete("AcroForm")
+	return writePDFContext(ctx, pdf_path)
+}
+
+// 把暂存的表单字典合并到文档目录：Fields 依次相加，DR 中的资源以先出现的为准。
+// 不同文件中有同名的顶级表单域时返回错误，合并后同一个全名会对应多个表单域
+func RestoreAcroForm(pdf_path string) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	changed := false
+	var merged pdfcpu.Dict
+	names := make(map[string]bool)
+	for n := 1; n <= ctx.PageCount; n++ {
+		page, _, err := ctx.PageDict(n)
+		if err != nil {
+			return err
+		}
+		pieceInfo, _ := ctx.DereferenceDict(page["PieceInfo"])
+		data, _ := ctx.DereferenceDict(pieceInfo[stashedAcroFormKey])
+		if data == nil {
+			continue
+		}
+		changed = true
+		pieceInfo.Delete(stashedAcroFormKey)
+		if len(pieceInfo) == 0 {
+			page.Delete("PieceInfo")
+		}
+		o := data["Private"]
+		acroForm, err := ctx.DereferenceDict(o)
+		if err != nil || acroForm == nil {
+			continue
+		}
+		fields, _ := ctx.DereferenceArray(acroForm["Fields"])
+		for _, field := range fields {
+			name := topLevelFieldName(ctx, field)
+			if len(name) == 0 {
+				continue
+			}
+			if names[name] {
+				return fmt.Errorf("duplicate form field name: %s", name)
+			}
+			names[name] = true
+		}
+		if merged == nil {
+			merged = acroForm
+			root.Update("AcroForm", o)
+			continue
+		}
+		if err = appendArrayEntry(ctx.XRefTable, merged, "Fields", fields...); err != nil {
+			return err
+		}
+		if err = mergeFormResources(ctx, merged, acroForm); err != nil {
+			return err
+		}
+		if v, ok := acroForm["NeedAppearances"].(pdfcpu.Boolean); ok && v.Value() {
+			merged.Update("NeedAppearances", v)
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return writePDFContext(ctx, pdf_path)
+}
+
+// 顶级表单域的名称（即其全名的第一段）
+func topLevelFieldName(ctx *pdfcpu.Context, o pdfcpu.Object) string {
+	field, err := ctx.DereferenceDict(o)
+	if err != nil || field == nil {
+		return ""
+	}
+	name, _ := ctx.DereferenceText(field["T"])
+	return name
+}
+
+func mergeFormResources(ctx *pdfcpu.Context, dest pdfcpu.Dict, src pdfcpu.Dict) error {
+	srcDR, _ := ctx.DereferenceDict(src["DR"])
+	if srcDR == nil {
+		return nil
+	}
+	destDR, _ := ctx.DereferenceDict(dest["DR"])
+	if destDR == nil {
+		dest.Update("DR", srcDR)
+		return nil
+	}
+	for category, o := range srcDR {
+		items, _ := ctx.DereferenceDict(o)
+		if items == nil {
+			continue
+		}
+		destItems, _ := ctx.DereferenceDict(destDR[category])
+		if destItems == nil {
+			destItems = pdfcpu.Dict{}
+			destDR.Update(category, destItems)
+		}
+		for name, item := range items {
+			if _, found := destItems.Find(name); !found {
+				destItems.Insert(name, item)
+			}
+		}
+	}
+	return nil
+}
+
+// 表单与 HTML 生成的页面（pages）合并为 dest，before 为 true 时 HTML 页面在前，两者未扁平化的表单域都保留
+func MergeFormPages(form string, pages string, before bool, dest string) (err error) {
+	stashed := make([]string, 0, 2)
+	// 合并失败时把暂存的表单字典移回各个输入文件
+	defer func() {
+		if err != nil {
+			for _, file := range stashed {
+				if e := RestoreAcroForm(file); e != nil {
+					Logger.Error(e)
+				}
+			}
+		}
+	}()
+	for _, file := range []string{form, pages} {
+		if err = StashAcroForm(file); err != nil {
+			return err
+		}
+		stashed = append(stashed, file)
+	}
+	inputs := []string{form, pages}
+	if before {
+		inputs = []string{pages, form}
+	}
+	if err = CombinePDF(inputs, dest); err != nil {
+		return err
+	}
+	return RestoreAcroForm(dest)
+}
@@ -0,0 +1,336 @@
+package lib
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// 生成带表单的 PDF：文本域 name、address.city（第二页），复选框 agree，单选按钮 gender，组合框 country，多选列表框 colors
+func writeFormTestPDF(t *testing.T, file string) {
+	writeTestPDF(t, file, 2)
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rect := func(x, y, w, h float64) pdfcpu.Array {
+		return pdfcpu.Array{pdfcpu.Float(x), pdfcpu.Float(y), pdfcpu.Float(x + w), pdfcpu.Float(y + h)}
+	}
+	newRef := func(o pdfcpu.Object) pdfcpu.IndirectRef {
+		ref, err := ctx.IndRefForNewObject(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *ref
+	}
+	// gofpdf 生成的页面树只有一层
+	root, _ := ctx.Catalog()
+	pageTree, _ := ctx.DereferenceDict(root["Pages"])
+	kids, _ := ctx.DereferenceArray(pageTree["Kids"])
+	pageRefs := make([]pdfcpu.IndirectRef, 0)
+	for _, kid := range kids {
+		pageRefs = append(pageRefs, kid.(pdfcpu.IndirectRef))
+	}
+	annots := make([]pdfcpu.Array, 2)
+	widget := func(page int, d pdfcpu.Dict) pdfcpu.IndirectRef {
+		d.Insert("Type", pdfcpu.Name("Annot"))
+		d.Insert("Subtype", pdfcpu.Name("Widget"))
+		d.Insert("P", pageRefs[page])
+		ref := newRef(d)
+		annots[page] = append(annots[page], ref)
+		return ref
+	}
+	states := func(on string) pdfcpu.Dict {
+		d := pdfcpu.Dict{}
+		for _, state := range []string{on, "Off"} {
+			stream, err := newFlateStream(pdfcpu.Dict{"BBox": pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Integer(12), pdfcpu.Integer(12)}},
+				[]byte("0 0 12 12 re S"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Insert(state, newRef(*stream))
+		}
+		return pdfcpu.Dict{"N": d}
+	}
+
+	name := widget(0, pdfcpu.Dict{"FT": pdfcpu.Name("Tx"), "T": pdfTextString("name"), "Rect": rect(50, 700, 200, 20),
+		"DA": pdfTextString("/Helv 0 Tf 0 g"), "MK": pdfcpu.Dict{"BC": pdfcpu.Array{pdfcpu.Integer(0)}}})
+	address := newRef(pdfcpu.Dict{"T": pdfTextString("address"), "FT": pdfcpu.Name("Tx")})
+	city := widget(1, pdfcpu.Dict{"T": pdfTextString("city"), "Parent": address, "Rect": rect(50, 700, 150, 40),
+		"Ff": pdfcpu.Integer(fieldMultiLine), "Q": pdfcpu.Integer(1)})
+	addressDict, _ := ctx.DereferenceDict(address)
+	addressDict.Insert("Kids", pdfcpu.Array{city})
+	agree := widget(0, pdfcpu.Dict{"FT": pdfcpu.Name("Btn"), "T": pdfTextString("agree"), "Rect": rect(50, 650, 12, 12),
+		"AP": states("Yes"), "AS": pdfcpu.Name("Off"), "V": pdfcpu.Name("Off")})
+	gender := newRef(pdfcpu.Dict{"FT": pdfcpu.Name("Btn"), "T": pdfTextString("gender"), "Ff": pdfcpu.Integer(fieldRadio)})
+	male := widget(0, pdfcpu.Dict{"Parent": gender, "Rect": rect(50, 620, 12, 12), "AP": states("male"), "AS": pdfcpu.Name("Off")})
+	female := widget(0, pdfcpu.Dict{"Parent": gender, "Rect": rect(80, 620, 12, 12), "AP": states("female"), "AS": pdfcpu.Name("Off")})
+	genderDict, _ := ctx.DereferenceDict(gender)
+	genderDict.Insert("Kids", pdfcpu.Array{male, female})
+	country := widget(0, pdfcpu.Dict{"FT": pdfcpu.Name("Ch"), "T": pdfTextString("country"), "Ff": pdfcpu.Integer(fieldCombo),
+		"Rect": rect(50, 580, 100, 20), "Opt": pdfcpu.Array{
+			pdfcpu.Array{pdfTextString("cn"), pdfTextString("China")},
+			pdfcpu.Array{pdfTextString("de"), pdfTextString("Germany")},
+		}})
+	colors := widget(0, pdfcpu.Dict{"FT": pdfcpu.Name("Ch"), "T": pdfTextString("colors"), "Ff": pdfcpu.Integer(fieldMultiSelect),
+		"Rect": rect(50, 500, 100, 60), "Opt": pdfcpu.Array{pdfTextString("red"), pdfTextString("green"), pdfTextString("blue")}})
+
+	for i, ref := range pageRefs {
+		page, _ := ctx.DereferenceDict(ref)
+		page.Insert("Annots", annots[i])
+	}
+	root.Insert("AcroForm", newRef(pdfcpu.Dict{
+		"Fields": pdfcpu.Array{name, address, agree, gender, country, colors},
+		"DA":     pdfTextString("/Helv 12 Tf 0 g"),
+		"DR": pdfcpu.Dict{"Font": pdfcpu.Dict{"Helv": pdfcpu.Dict{
+			"Type": pdfcpu.Name("Font"), "Subtype": pdfcpu.Name("Type1"),
+			"BaseFont": pdfcpu.Name("Helvetica"), "Encoding": pdfcpu.Name("WinAnsiEncoding"),
+		}}},
+	}))
+	if err = writePDFContext(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func formFieldMap(t *testing.T, file string) map[string]*FormField {
+	list, err := ReadFormFields(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]*FormField)
+	for _, f := range list {
+		fields[f.Name] = f
+	}
+	return fields
+}
+
+func Test_ReadFormFields(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "form.pdf")
+	writeFormTestPDF(t, file)
+	fields := formFieldMap(t, file)
+	if len(fields) != 6 {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+	if f := fields["address.city"]; f == nil || f.Type != "text" || !f.MultiLine || len(f.Pages) != 1 || f.Pages[0] != 2 {
+		t.Errorf("unexpected field: %+v", f)
+	}
+	if f := fields["gender"]; f.Type != "radio" || len(f.Options) != 2 || f.Options[0] != "male" || f.Options[1] != "female" {
+		t.Errorf("unexpected field: %+v", f)
+	}
+	if f := fields["country"]; f.Type != "combo" || f.Options[1] != "de" || f.Labels[1] != "Germany" {
+		t.Errorf("unexpected field: %+v", f)
+	}
+	if f := fields["colors"]; f.Type != "list" || !f.MultiSelect || f.Labels != nil {
+		t.Errorf("unexpected field: %+v", f)
+	}
+	if f := fields["agree"]; f.Type != "checkbox" || f.Value != "Off" || f.Options[0] != "Yes" {
+		t.Errorf("unexpected field: %+v", f)
+	}
+}
+
+func Test_FillForm(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "form.pdf")
+	writeFormTestPDF(t, file)
+
+	filled := filepath.Join(dir, "filled.pdf")
+	values := map[string]interface{}{
+		"name":         "Jürgen (Müller)",
+		"address.city": "Berlin\nMitte",
+		"agree":        true,
+		"gender":       "female",
+		"country":      "Germany",
+		"colors":       []interface{}{"red", "blue"},
+	}
+	if err := FillForm(file, filled, values, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ValidateFile(filled, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+	fields := formFieldMap(t, filled)
+	if fields["name"].Value != "Jürgen (Müller)" || fields["address.city"].Value != "Berlin\nMitte" {
+		t.Errorf("unexpected text values: %v %v", fields["name"].Value, fields["address.city"].Value)
+	}
+	if fields["agree"].Value != "Yes" || fields["gender"].Value != "female" || fields["country"].Value != "de" {
+		t.Errorf("unexpected values: %v %v %v", fields["agree"].Value, fields["gender"].Value, fields["country"].Value)
+	}
+	if colors, ok := fields["colors"].Value.([]string); !ok || len(colors) != 2 || colors[1] != "blue" {
+		t.Errorf("unexpected colors: %v", fields["colors"].Value)
+	}
+
+	ctx, err := readPDFContext(filled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, list, err := contextFormFields(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range list {
+		switch f.name {
+		case "gender":
+			if as := f.widgets[0].dict.NameEntry("AS"); as == nil || *as != "Off" {
+				t.Errorf("unexpected male state: %v", as)
+			}
+			if as := f.widgets[1].dict.NameEntry("AS"); as == nil || *as != "female" {
+				t.Errorf("unexpected female state: %v", as)
+			}
+		case "name":
+			ref := widgetAppearance(ctx, f.widgets[0])
+			if ref == nil {
+				t.Fatal("missing text appearance")
+			}
+			data, err := pdfcpu.ExtractStreamData(ctx, ref.ObjectNumber.Value())
+			if err != nil || !bytes.Contains(data, []byte("(J\xfcrgen \\(M\xfcller\\))")) || !bytes.Contains(data, []byte("/Tx BMC")) {
+				t.Errorf("unexpected appearance: %s %v", data, err)
+			}
+		}
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"missing": "x"},
+		{"gender": "other"},
+		{"country": "France"},
+		{"country": []interface{}{"cn", "de"}},
+	} {
+		if err = FillForm(file, filled, invalid, false); err == nil {
+			t.Errorf("expected error for %v", invalid)
+		}
+	}
+
+	// 字体无法显示的值交给阅读器生成外观，不能扁平化
+	if err = FillForm(file, filled, map[string]interface{}{"name": "张三"}, false); err != nil {
+		t.Fatal(err)
+	}
+	ctx, _ = readPDFContext(filled)
+	acroForm, _, _ := contextFormFields(ctx)
+	if v, ok := acroForm["NeedAppearances"].(pdfcpu.Boolean); !ok || !v.Value() {
+		t.Errorf("expected NeedAppearances: %v", acroForm)
+	}
+	if err = FillForm(file, filled, map[string]interface{}{"name": "张三"}, true); err == nil {
+		t.Error("expected error when flattening unsupported values")
+	}
+}
+
+func Test_FlattenForm(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "form.pdf")
+	writeFormTestPDF(t, file)
+	if err := FillForm(file, file, map[string]interface{}{"name": "Alice", "agree": "Yes", "address.city": "Paris"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ValidateFile(file, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acroForm, fields, err := contextFormFields(ctx); err != nil || acroForm != nil || fields != nil {
+		t.Errorf("form should be removed: %v %v", acroForm, err)
+	}
+	for i := 1; i <= 2; i++ {
+		page, _, _ := ctx.PageDict(i)
+		if _, found := page.Find("Annots"); found {
+			t.Errorf("page %d still has widgets", i)
+		}
+		resources, _ := ctx.DereferenceDict(page["Resources"])
+		xobjects, _ := ctx.DereferenceDict(resources["XObject"])
+		if len(xobjects) == 0 {
+			t.Errorf("page %d has no flattened appearances", i)
+		}
+	}
+}
+
+func Test_MergeFormPages(t *testing.T) {
+	dir := t.TempDir()
+	pages := filepath.Join(dir, "pages.pdf")
+	writeTestPDF(t, pages, 1)
+
+	// HTML 页面在前时表单域的页码后移
+	for before, cityPage := range map[bool]int{true: 3, false: 2} {
+		form := filepath.Join(dir, "form.pdf")
+		writeFormTestPDF(t, form)
+		merged := filepath.Join(dir, "merged.pdf")
+		if err := MergeFormPages(form, pages, before, merged); err != nil {
+			t.Fatal(err)
+		}
+		if err := api.ValidateFile(merged, newPDFConfig()); err != nil {
+			t.Fatal(err)
+		}
+		ctx, err := readPDFContext(merged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctx.PageCount != 3 {
+			t.Errorf("unexpected page count: %d", ctx.PageCount)
+		}
+		for i := 1; i <= ctx.PageCount; i++ {
+			page, _, _ := ctx.PageDict(i)
+			if _, found := page.Find("PieceInfo"); found {
+				t.Errorf("page %d still has the stashed form", i)
+			}
+		}
+		fields := formFieldMap(t, merged)
+		if len(fields) != 6 || fields["address.city"].Pages[0] != cityPage {
+			t.Errorf("unexpected fields after merge: %v", fields)
+		}
+	}
+}
+
+// 两个文件中有同名的顶级表单域时合并失败，输入文件的表单字典恢复原状
+func Test_MergeFormPagesDuplicateNames(t *testing.T) {
+	dir := t.TempDir()
+	form := filepath.Join(dir, "form.pdf")
+	writeFormTestPDF(t, form)
+	pages := filepath.Join(dir, "pages.pdf")
+	writeFormTestPDF(t, pages)
+
+	merged := filepath.Join(dir, "merged.pdf")
+	if err := MergeFormPages(form, pages, false, merged); err == nil || !strings.Contains(err.Error(), "duplicate form field name") {
+		t.Fatalf("expected duplicate name error, got %v", err)
+	}
+	for _, file := range []string{form, pages} {
+		if fields := formFieldMap(t, file); len(fields) == 0 {
+			t.Errorf("%s: form was not restored", filepath.Base(file))
+		}
+		ctx, err := readPDFContext(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, _, _ := ctx.PageDict(ctx.PageCount)
+		if _, found := page.Find("PieceInfo"); found {
+			t.Errorf("%s: stashed form left on the last page", filepath.Base(file))
+		}
+	}
+}
+
+func Test_WinAnsiEncode(t *testing.T) {
+	// 编码表在包初始化时生成，并发调用不需要同步
+	done := make(chan string, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			encoded, _ := winAnsiEncode("€ café\t")
+			done <- encoded
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if encoded := <-done; encoded != "\x80 caf\xe9 " {
+			t.Errorf("unexpected encoding: %q", encoded)
+		}
+	}
+	for _, s := range []string{"中文", "\x00", "\u0081"} {
+		if _, ok := winAnsiEncode(s); ok {
+			t.Errorf("expected %q to be unencodable", s)
+		}
+	}
+	// 签名外观及页码中无法编码的字符替换为 ?
+	if s := winAnsiString("“Signed” by 张三 – €5\n"); s != "\x93Signed\x94 by ?? \x96 \x805?" {
+		t.Errorf("unexpected string: %q", s)
+	}
+}
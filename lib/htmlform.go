@@ -0,0 +1,409 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// 渲染脚本输出的表单元素：名称、类型、打印时的值及位置（点，top 为距页面顶部的距离，读取时按分页信息由文档坐标换算）
+type renderedInput struct {
+	Name      string            `json:"name"`
+	Type      string            `json:"type"` // input 的 type，或 select-one、select-multiple、textarea
+	Value     string            `json:"value"`
+	Checked   bool              `json:"checked"`
+	Required  bool              `json:"required"`
+	ReadOnly  bool              `json:"read_only"`
+	MaxLength int               `json:"max_length"`
+	Size      int               `json:"size"`
+	FontSize  float64           `json:"font_size"`
+	Color     string            `json:"color"`
+	Options   []*renderedOption `json:"options"`
+	Page      int               `json:"page"`
+	Top       float64           `json:"top"`
+	Left      float64           `json:"left"`
+	Width     float64           `json:"width"`
+	Height    float64           `json:"height"`
+}
+
+type renderedOption struct {
+	Value    string `json:"value"`
+	Label    string `json:"label"`
+	Selected bool   `json:"selected"`
+}
+
+func readRenderedInputs(file string) ([]*renderedInput, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Layout *printLayout     `json:"layout"`
+		Inputs []*renderedInput `json:"inputs"`
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid rendered inputs: %v", err)
+	}
+	if err = result.Layout.validate(); err != nil {
+		return nil, err
+	}
+	// 脚本输出的是文档坐标，按分页信息换算为页码及页面内的位置
+	shifts := result.Layout.shifts()
+	list := make([]*renderedInput, 0, len(result.Inputs))
+	for _, in := range result.Inputs {
+		in.Page, in.Top = result.Layout.locate(shifts, in.Top, in.Height)
+		list = append(list, in)
+	}
+	return list, nil
+}
+
+// 表单元素对应的表单域类型
+func (in *renderedInput) fieldType() string {
+	switch in.Type {
+	case "checkbox", "radio":
+		return in.Type
+	case "select-one":
+		if in.Size > 1 {
+			return "list"
+		}
+		return "combo"
+	case "select-multiple":
+		return "list"
+	}
+	return "text"
+}
+
+var cssColorPattern = regexp.MustCompile(`^rgba?\(\s*([\d.]+)\s*,\s*([\d.]+)\s*,\s*([\d.]+)`)
+
+// CSS 计算样式中的颜色（rgb(r, g, b)）转换为颜色操作，无法识别时为黑色
+func cssColorOperator(color string) string {
+	match := cssColorPattern.FindStringSubmatch(strings.TrimSpace(color))
+	if match == nil {
+		return "0 g"
+	}
+	values := make([]string, 3)
+	for i := range values {
+		n, _ := strconv.ParseFloat(match[i+1], 64)
+		values[i] = strconv.FormatFloat(n/255, 'f', 3, 64)
+	}
+	return strings.Join(values, " ") + " rg"
+}
+
+// 按渲染的位置为 HTML 表单元素生成表单域（原地修改）。名称中的 "." 表示层级，完整名称与元素名称相同；
+// 同名的元素合并为一个表单域（单选按钮组），文本及选择域使用第一个元素的值，复选框使用第一个选中元素的值
+func AddFormFields(pdf_path string, inputs []*renderedInput) error {
+	if len(inputs) == 0 {
+		return nil
+	}
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	acroForm, err := ctx.DereferenceDict(root["AcroForm"])
+	if err != nil {
+		return err
+	}
+	if acroForm == nil {
+		acroForm = pdfcpu.Dict{"Fields": pdfcpu.Array{}}
+		ref, err := ctx.IndRefForNewObject(acroForm)
+		if err != nil {
+			return err
+		}
+		root.Insert("AcroForm", *ref)
+	}
+	if err = setFormDefaults(ctx, acroForm); err != nil {
+		return err
+	}
+
+	names := make([]string, 0)
+	groups := make(map[string][]*renderedInput)
+	for _, in := range inputs {
+		if in.Page < 1 || in.Page > ctx.PageCount || len(formNameParts(in.Name)) == 0 {
+			continue
+		}
+		if _, ok := groups[in.Name]; !ok {
+			names = append(names, in.Name)
+		}
+		groups[in.Name] = append(groups[in.Name], in)
+	}
+
+	tree := &formFieldTree{ctx: ctx, acroForm: acroForm, nodes: make(map[string]pdfcpu.Dict),
+		refs: make(map[string]pdfcpu.IndirectRef), leaves: make(map[string]bool)}
+	filler := newFormFiller(ctx, acroForm)
+	for _, name := range names {
+		group := groups[name]
+		fieldType := group[0].fieldType()
+		for _, in := range group[1:] {
+			if in.fieldType() != fieldType {
+				return fmt.Errorf("form field %s mixes %s and %s elements", name, fieldType, in.fieldType())
+			}
+		}
+		d, ref, err := tree.add(name)
+		if err != nil {
+			return err
+		}
+		f := &formField{name: name, dict: d}
+		value := htmlFieldDefaults(f, group)
+
+		for _, in := range group {
+			w := d
+			var wref pdfcpu.IndirectRef
+			if len(group) == 1 {
+				wref = *ref
+			} else {
+				w = pdfcpu.Dict{"Parent": *ref}
+				r, err := ctx.IndRefForNewObject(w)
+				if err != nil {
+					return err
+				}
+				wref = *r
+				if err = appendArrayEntry(ctx.XRefTable, d, "Kids", wref); err != nil {
+					return err
+				}
+			}
+			if err = addWidget(ctx, w, wref, in); err != nil {
+				return err
+			}
+			widget := &formWidget{dict: w, ref: &wref, page: in.Page}
+			f.widgets = append(f.widgets, widget)
+			if fieldType == "checkbox" || fieldType == "radio" {
+				on := in.Value
+				if len(on) == 0 {
+					on = "Yes"
+				}
+				if err = filler.buttonAppearance(f, widget, string(pdfName(on))); err != nil {
+					return err
+				}
+			}
+		}
+		if err = filler.fill(f, value); err != nil {
+			return err
+		}
+	}
+	if len(filler.unsupported) > 0 {
+		acroForm.Update("NeedAppearances", pdfcpu.Boolean(true))
+	}
+	return writePDFContext(ctx, pdf_path)
+}
+
+// 默认外观及资源：Helvetica（Helv）及 ZapfDingbats（ZaDb）
+func setFormDefaults(ctx *pdfcpu.Context, acroForm pdfcpu.Dict) error {
+	if _, found := acroForm.Find("DA"); !found {
+		acroForm.Insert("DA", pdfTextString("/Helv 0 Tf 0 g"))
+	}
+	dr, err := ctx.DereferenceDict(acroForm["DR"])
+	if err != nil {
+		return err
+	}
+	if dr == nil {
+		dr = pdfcpu.Dict{}
+		acroForm.Update("DR", dr)
+	}
+	fonts, err := ctx.DereferenceDict(dr["Font"])
+	if err != nil {
+		return err
+	}
+	if fonts == nil {
+		fonts = pdfcpu.Dict{}
+		dr.Update("Font", fonts)
+	}
+	for name, base := range map[string]string{"Helv": "Helvetica", "ZaDb": "ZapfDingbats"} {
+		if _, found := fonts.Find(name); found {
+			continue
+		}
+		font := pdfcpu.Dict{
+			"Type":     pdfcpu.Name("Font"),
+			"Subtype":  pdfcpu.Name("Type1"),
+			"BaseFont": pdfcpu.Name(base),
+		}
+		if base == "Helvetica" {
+			font.Insert("Encoding", pdfcpu.Name("WinAnsiEncoding"))
+		}
+		ref, err := ctx.IndRefForNewObject(font)
+		if err != nil {
+			return err
+		}
+		fonts.Insert(name, *ref)
+	}
+	return nil
+}
+
+// 名称按 "." 分为各级的部分名称，忽略空的部分
+func formNameParts(name string) []string {
+	parts := make([]string, 0)
+	for _, part := range strings.Split(name, ".") {
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// 按完整名称建立表单域的层级
+type formFieldTree struct {
+	ctx      *pdfcpu.Context
+	acroForm pdfcpu.Dict
+	nodes    map[string]pdfcpu.Dict
+	refs     map[string]pdfcpu.IndirectRef
+	leaves   map[string]bool
+}
+
+func (tree *formFieldTree) add(name string) (pdfcpu.Dict, *pdfcpu.IndirectRef, error) {
+	parts := formNameParts(name)
+	var parent pdfcpu.Dict
+	var parentRef *pdfcpu.IndirectRef
+	path := ""
+	for i, part := range parts {
+		if len(path) > 0 {
+			path += "."
+		}
+		path += part
+		last := i == len(parts)-1
+		if d, ok := tree.nodes[path]; ok {
+			if last || tree.leaves[path] {
+				return nil, nil, fmt.Errorf("form field name %s conflicts with %s", name, path)
+			}
+			ref := tree.refs[path]
+			parent, parentRef = d, &ref
+			continue
+		}
+
+		d := pdfcpu.Dict{"T": pdfTextString(part)}
+		if parentRef != nil {
+			d.Insert("Parent", *parentRef)
+		}
+		ref, err := tree.ctx.IndRefForNewObject(d)
+		if err != nil {
+			return nil, nil, err
+		}
+		if parent != nil {
+			err = appendArrayEntry(tree.ctx.XRefTable, parent, "Kids", *ref)
+		} else {
+			err = appendArrayEntry(tree.ctx.XRefTable, tree.acroForm, "Fields", *ref)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		tree.nodes[path], tree.refs[path], tree.leaves[path] = d, *ref, last
+		parent, parentRef = d, ref
+	}
+	return parent, parentRef, nil
+}
+
+// 按元素设置表单域的类型、标志及默认外观，返回填写的值
+func htmlFieldDefaults(f *formField, group []*renderedInput) interface{} {
+	first := group[0]
+	d := f.dict
+	if first.Required {
+		f.flags |= fieldRequired
+	}
+	if first.ReadOnly {
+		f.flags |= fieldReadOnly
+	}
+	d.Insert("DA", pdfTextString(fmt.Sprintf("/Helv %.2f Tf %s", first.FontSize, cssColorOperator(first.Color))))
+
+	var value interface{}
+	switch first.fieldType() {
+	case "text":
+		f.ft = "Tx"
+		switch first.Type {
+		case "textarea":
+			f.flags |= fieldMultiLine
+		case "password":
+			f.flags |= fieldPassword
+		}
+		if first.MaxLength > 0 {
+			d.Insert("MaxLen", pdfcpu.Integer(first.MaxLength))
+		}
+		// 密码框的值不写入 PDF
+		if f.flags&fieldPassword == 0 {
+			value = first.Value
+		}
+
+	case "checkbox", "radio":
+		f.ft = "Btn"
+		if first.Type == "radio" {
+			// 单选按钮组不能全部取消选中
+			f.flags |= fieldRadio | 1<<14
+		}
+		for _, in := range group {
+			if in.Checked {
+				value = in.Value
+				if len(in.Value) == 0 {
+					value = "Yes"
+				}
+				break
+			}
+		}
+
+	case "combo", "list":
+		f.ft = "Ch"
+		if first.fieldType() == "combo" {
+			f.flags |= fieldCombo
+		}
+		if first.Type == "select-multiple" {
+			f.flags |= fieldMultiSelect
+		}
+		opt := make(pdfcpu.Array, 0, len(first.Options))
+		selected := make([]interface{}, 0)
+		for _, option := range first.Options {
+			if option.Value == option.Label {
+				opt = append(opt, pdfTextString(option.Value))
+			} else {
+				opt = append(opt, pdfcpu.Array{pdfTextString(option.Value), pdfTextString(option.Label)})
+			}
+			if option.Selected {
+				selected = append(selected, option.Value)
+			}
+		}
+		d.Insert("Opt", opt)
+		if len(selected) == 1 {
+			value = selected[0]
+		} else if len(selected) > 1 {
+			value = selected
+		}
+	}
+
+	d.Insert("FT", pdfcpu.Name(f.ft))
+	if f.flags != 0 {
+		d.Insert("Ff", pdfcpu.Integer(f.flags))
+	}
+	return value
+}
+
+// 控件的位置、所在页面及边框，并加入页面的 Annots
+func addWidget(ctx *pdfcpu.Context, w pdfcpu.Dict, ref pdfcpu.IndirectRef, in *renderedInput) error {
+	page, _, err := ctx.PageDict(in.Page)
+	if err != nil {
+		return err
+	}
+	pref, err := pageRef(ctx, in.Page)
+	if err != nil {
+		return err
+	}
+	box, ok := pageBox(ctx.XRefTable, page)
+	if !ok {
+		box = [4]float64{0, 0, 595.27, 841.89}
+	}
+	left, top := box[0]+in.Left, box[3]-in.Top
+	w.Insert("Type", pdfcpu.Name("Annot"))
+	w.Insert("Subtype", pdfcpu.Name("Widget"))
+	w.Insert("Rect", pdfcpu.Array{pdfcpu.Float(left), pdfcpu.Float(top - in.Height), pdfcpu.Float(left + in.Width), pdfcpu.Float(top)})
+	// 打印
+	w.Insert("F", pdfcpu.Integer(4))
+	w.Insert("P", *pref)
+	if in.Type == "checkbox" || in.Type == "radio" {
+		// 渲染时隐藏了复选框及单选按钮，由控件绘制边框
+		w.Insert("MK", pdfcpu.Dict{"BC": pdfcpu.Array{pdfcpu.Float(0.5)}, "BG": pdfcpu.Array{pdfcpu.Integer(1)}})
+	}
+	return appendArrayEntry(ctx.XRefTable, page, "Annots", ref)
+}
@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func Test_CSSColorOperator(t *testing.T) {
+	cases := map[string]string{
+		"rgb(0, 0, 0)":          "0.000 0.000 0.000 rg",
+		"rgba(255, 0, 51, 0.5)": "1.000 0.000 0.200 rg",
+		"transparent":           "0 g",
+	}
+	for color, expected := range cases {
+		if op := cssColorOperator(color); op != expected {
+			t.Errorf("%s: unexpected operator %s", color, op)
+		}
+	}
+}
+
+func Test_AddFormFields(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rendered.pdf")
+	writeTestPDF(t, file, 2)
+
+	inputs := []*renderedInput{
+		{Name: "applicant.name", Type: "text", Value: "Alice", Required: true, MaxLength: 20, FontSize: 10, Color: "rgb(255, 0, 0)",
+			Page: 1, Top: 100, Left: 50, Width: 200, Height: 20},
+		{Name: "applicant.notes", Type: "textarea", Value: "line 1\nline 2", Page: 1, Top: 130, Left: 50, Width: 200, Height: 60},
+		{Name: "secret", Type: "password", Value: "hunter2", Page: 1, Top: 200, Left: 50, Width: 100, Height: 20},
+		{Name: "agree", Type: "checkbox", Value: "on", Checked: true, Page: 1, Top: 230, Left: 50, Width: 12, Height: 12},
+		{Name: "size", Type: "radio", Value: "S", Page: 2, Top: 100, Left: 50, Width: 12, Height: 12},
+		{Name: "size", Type: "radio", Value: "M", Checked: true, Page: 2, Top: 100, Left: 80, Width: 12, Height: 12},
+		{Name: "country", Type: "select-one", Page: 2, Top: 130, Left: 50, Width: 100, Height: 20, ReadOnly: true,
+			Options: []*renderedOption{{Value: "cn", Label: "China"}, {Value: "de", Label: "Germany", Selected: true}}},
+		{Name: "colors", Type: "select-multiple", Page: 2, Top: 160, Left: 50, Width: 100, Height: 50,
+			Options: []*renderedOption{{Value: "red", Label: "red", Selected: true}, {Value: "blue", Label: "blue", Selected: true}}},
+		// 页码超出范围的元素被忽略
+		{Name: "missing", Type: "text", Page: 3},
+	}
+	if err := AddFormFields(file, inputs); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ValidateFile(file, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := formFieldMap(t, file)
+	if len(fields) != 7 {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+	if f := fields["applicant.name"]; f == nil || f.Type != "text" || f.Value != "Alice" || !f.Required || f.MaxLen != 20 || f.Pages[0] != 1 {
+		t.Errorf("unexpected field: %+v", f)
+	}
+	if f := fields["applicant.notes"]; !f.MultiLine || f.Value != "line 1\nline 2" {
+		t.Errorf("unexpected field: %+v", f)
+	}
+	if f := fields["secret"]; f.Value != "" {
+		t.Errorf("password value should not be stored: %+v", f)
+	}
+	if f := fields["agree"]; f.Type != "checkbox" || f.Value != "on" {
+		t.Errorf("unexpected field: %+v", f)
+	}
+	if f := fields["size"]; f.Type != "radio" || f.Value != "M" || len(f.Options) != 2 || f.Pages[0] != 2 {
+		t.Errorf("unexpected field: %+v", f)
+	}
+	if f := fields["country"]; f.Type != "combo" || f.Value != "de" || !f.ReadOnly || f.Labels[1] != "Germany" {
+		t.Errorf("unexpected field: %+v", f)
+	}
+	if f := fields["colors"]; f.Type != "list" || !f.MultiSelect {
+		t.Errorf("unexpected field: %+v", f)
+	}
+
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, list, err := contextFormFields(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range list {
+		if f.name != "applicant.name" {
+			continue
+		}
+		// top 为距页面顶部的距离，A4 页面高 841.89 点
+		rect, _ := ctx.DereferenceArray(f.widgets[0].dict["Rect"])
+		if y, _ := rect[3].(pdfcpu.Float); len(rect) != 4 || y.Value() < 741.8 || y.Value() > 741.9 {
+			t.Errorf("unexpected rect: %v", rect)
+		}
+		if da, _ := ctx.DereferenceText(f.dict["DA"]); da != "/Helv 10.00 Tf 1.000 0.000 0.000 rg" {
+			t.Errorf("unexpected DA: %s", da)
+		}
+	}
+
+	conflict := filepath.Join(t.TempDir(), "conflict.pdf")
+	writeTestPDF(t, conflict, 1)
+	err = AddFormFields(conflict, []*renderedInput{
+		{Name: "a", Type: "text", Page: 1, Width: 10, Height: 10},
+		{Name: "a.b", Type: "text", Page: 1, Width: 10, Height: 10},
+	})
+	if err == nil {
+		t.Error("expected error for conflicting names")
+	}
+}
+
+func Test_ReadRenderedInputs(t *testing.T) {
+	dir := t.TempDir()
+	// A4 两页的表单：email 跨越第一页底部，打印时整体移到第二页，其后的内容随之下移
+	data := `{"layout":{"page_height":841.89,"breaks":[],"blocks":[[100,120],[835,855],[900,920]]},
+		"inputs":[
+			{"name":"name","type":"text","top":100,"left":50,"width":200,"height":20},
+			{"name":"email","type":"text","top":835,"left":50,"width":200,"height":20},
+			{"name":"phone","type":"text","top":900,"left":50,"width":200,"height":20}]}`
+	file := filepath.Join(dir, "inputs.json")
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inputs, err := readRenderedInputs(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf := filepath.Join(dir, "form.pdf")
+	writeTestPDF(t, pdf, 2)
+	if err = AddFormFields(pdf, inputs); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := readPDFContext(pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, list, err := contextFormFields(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][3]float64{
+		"name":  {1, 721.89, 741.89},
+		"email": {2, 821.89, 841.89},
+		"phone": {2, 756.89, 776.89},
+	}
+	if len(list) != len(expected) {
+		t.Fatalf("unexpected fields: %d", len(list))
+	}
+	for _, f := range list {
+		e := expected[f.name]
+		w := f.widgets[0]
+		rect, _ := ctx.DereferenceArray(w.dict["Rect"])
+		if len(rect) != 4 || w.page != int(e[0]) {
+			t.Errorf("%s: unexpected widget on page %d: %v", f.name, w.page, rect)
+			continue
+		}
+		lower, _ := rect[1].(pdfcpu.Float)
+		upper, _ := rect[3].(pdfcpu.Float)
+		if math.Abs(lower.Value()-e[1]) > 0.01 || math.Abs(upper.Value()-e[2]) > 0.01 {
+			t.Errorf("%s: unexpected rect %v", f.name, rect)
+		}
+	}
+}
+
+func Test_MergeGeneratedFormFields(t *testing.T) {
+	dir := t.TempDir()
+	form := filepath.Join(dir, "form.pdf")
+	writeFormTestPDF(t, form)
+	pages := filepath.Join(dir, "pages.pdf")
+	writeTestPDF(t, pages, 1)
+	err := AddFormFields(pages, []*renderedInput{{Name: "signature.date", Type: "text", Value: "2024-05-06", Page: 1, Top: 100, Left: 50, Width: 100, Height: 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := filepath.Join(dir, "merged.pdf")
+	if err = MergeFormPages(form, pages, false, merged); err != nil {
+		t.Fatal(err)
+	}
+	fields := formFieldMap(t, merged)
+	if f := fields["signature.date"]; len(fields) != 7 || f == nil || f.Value != "2024-05-06" || f.Pages[0] != 3 {
+		t.Errorf("unexpected fields after merge: %v", fields)
+	}
+}
@@ -2,12 +2,14 @@ package lib
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -78,16 +80,26 @@ func (t *Task) TaskDone(callback func([]*TaskResult)) {
 	callback(list)
 }
 
-func (pdf *HTMLPDF) run(source_path string, pdf_path string) error {
+func (pdf *HTMLPDF) run(source_path string, pdf_path string, options *RenderOptions) error {
 	pdf.buildJob <- true
 	Logger.Infof("current html2pdf job count:%d\n", len(pdf.buildJob))
 
 	source_path = filepath.ToSlash(source_path)
-	bin_args := append(pdf.config.WebKitArgs, source_path, pdf_path)
+	bin_args := make([]string, 0, len(pdf.config.WebKitArgs)+3)
+	bin_args = append(bin_args, pdf.config.WebKitArgs...)
+	bin_args = append(bin_args, source_path, pdf_path)
+	if options != nil {
+		json_options, err := json.Marshal(options)
+		if err != nil {
+			<-pdf.buildJob
+			return err
+		}
+		bin_args = append(bin_args, string(json_options))
+	}
 	cmd := exec.Command(pdf.config.WebKitBin, bin_args...)
 	var outbuffer bytes.Buffer
 	var errbuffer bytes.Buffer
-	cmd.Stderr = &outbuffer
+	cmd.Stdout = &outbuffer
 	cmd.Stderr = &errbuffer
 	Logger.Debugf("raw command line: %s\n", cmd)
 
@@ -109,24 +121,25 @@ func (pdf *HTMLPDF) run(source_path string, pdf_path string) error {
 	<-pdf.buildJob
 	if err != nil {
 		Logger.Error(err)
+		Logger.Error(outbuffer.String())
 		Logger.Error(errbuffer.String())
 		return err
 	}
 	return nil
 }
 
-func (pdf *HTMLPDF) BuildFromLink(link string) (local_pdf string, err error) {
-	pdf_name := fmt.Sprintf("%s.pdf", MakeUUID())
+func (pdf *HTMLPDF) BuildFromLink(link string, options *RenderOptions) (local_pdf string, err error) {
+	pdf_name := fmt.Sprintf("%s%s", MakeUUID(), options.Ext())
 	pdf_name = path.Join(pdf.config.TempPath, pdf_name)
 
-	err = pdf.run(link, pdf_name)
+	err = pdf.render(link, pdf_name, options)
 	if err != nil {
 		return "", err
 	}
 	return pdf_name, nil
 }
 
-func (pdf *HTMLPDF) BuildFromSource(html []byte) (local_pdf string, err error) {
+func (pdf *HTMLPDF) BuildFromSource(html []byte, options *RenderOptions) (local_pdf string, err error) {
 	tmp_name := fmt.Sprintf("%s.html", MakeUUID())
 	tmp_name = path.Join(pdf.config.TempPath, tmp_name)
 
@@ -136,12 +149,12 @@ func (pdf *HTMLPDF) BuildFromSource(html []byte) (local_pdf string, err error) {
 	}
 	//defer os.Remove(tmp_name)
 
-	pdf_name := fmt.Sprintf("%s.pdf", MakeUUID())
+	pdf_name := fmt.Sprintf("%s%s", MakeUUID(), options.Ext())
 	pdf_name = path.Join(pdf.config.TempPath, pdf_name)
 
 	tmp_name = fmt.Sprintf("file:///%s", tmp_name)
 
-	err = pdf.run(tmp_name, pdf_name)
+	err = pdf.render(tmp_name, pdf_name, options)
 	if err != nil {
 		return "", err
 	}
@@ -149,6 +162,79 @@ func (pdf *HTMLPDF) BuildFromSource(html []byte) (local_pdf string, err error) {
 	return pdf_name, nil
 }
 
+// 渲染 PDF 或截图，WebP 由渲染脚本输出 PNG 后再转换
+func (pdf *HTMLPDF) render(source_path string, output_path string, options *RenderOptions) error {
+	options.limitPageHeight(pdf.config)
+	if options != nil && options.Format == FormatPDF && options.FormFields {
+		return pdf.renderWithFormFields(source_path, output_path, options)
+	}
+	if options != nil && options.Format == FormatPDF && options.Outline {
+		return pdf.renderWithOutline(source_path, output_path, options)
+	}
+	if options == nil || options.Format != FormatWebP {
+		return pdf.run(source_path, output_path, options)
+	}
+
+	png_options := *options
+	png_options.Format = FormatPNG
+	png_options.Quality = 0
+	png_path := strings.TrimSuffix(output_path, options.Ext()) + png_options.Ext()
+	err := pdf.run(source_path, png_path, &png_options)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(png_path)
+
+	return convertPNGToWebP(png_path, output_path, options.Quality)
+}
+
+// 渲染 PDF，并由渲染脚本输出匹配 OutlineSel 的元素位置
+func (pdf *HTMLPDF) renderElements(source_path string, output_path string, options *RenderOptions) ([]*renderedElement, error) {
+	element_options := *options
+	element_options.Outline = true
+	element_options.OutlineFile = output_path + ".elements.json"
+	defer os.Remove(element_options.OutlineFile)
+
+	if err := pdf.run(source_path, output_path, &element_options); err != nil {
+		return nil, err
+	}
+	return readRenderedElements(element_options.OutlineFile)
+}
+
+// 渲染完成后根据标题位置生成书签
+func (pdf *HTMLPDF) renderWithOutline(source_path string, output_path string, options *RenderOptions) error {
+	elements, err := pdf.renderElements(source_path, output_path, options)
+	if err != nil {
+		return err
+	}
+	if len(elements) == 0 {
+		return nil
+	}
+	return SetPDFOutline(output_path, renderedOutline(elements))
+}
+
+// 渲染完成后根据表单元素的位置生成表单域
+func (pdf *HTMLPDF) renderWithFormFields(source_path string, output_path string, options *RenderOptions) error {
+	form_options := *options
+	form_options.FormFile = output_path + ".form.json"
+	defer os.Remove(form_options.FormFile)
+
+	var err error
+	if options.Outline {
+		err = pdf.renderWithOutline(source_path, output_path, &form_options)
+	} else {
+		err = pdf.run(source_path, output_path, &form_options)
+	}
+	if err != nil {
+		return err
+	}
+	inputs, err := readRenderedInputs(form_options.FormFile)
+	if err != nil {
+		return err
+	}
+	return AddFormFields(output_path, inputs)
+}
+
 func (pdf *HTMLPDF) PDFTK_Combine(files []string) (dest_pdf_path string, err error) {
 	pdf_name := fmt.Sprintf("%s.pdf", MakeUUID())
 	pdf_name = path.Join(pdf.config.TempPath, pdf_name)
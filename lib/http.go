@@ -1,14 +1,18 @@
 package lib
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,8 +34,24 @@ func (s *HTTPService) Start() {
 	r.HandleFunc("/", s.RedirectSample)
 	r.HandleFunc("/htmlpdf", s.HTMLPDF)
 	r.HandleFunc("/linkpdf", s.LINKPDF)
+	r.HandleFunc("/emlpdf", s.EMLPDF)
+	r.HandleFunc("/imagepdf", s.IMAGEPDF)
+	r.HandleFunc("/htmllint", s.HTMLLint)
+	r.HandleFunc("/invoice", s.InvoicePDF)
 	r.HandleFunc("/combine", s.COMBINE)
 	r.HandleFunc("/link/combine", s.LinkCombine)
+	r.HandleFunc("/pdf/split", s.SplitPDF)
+	r.HandleFunc("/pdf/extract", s.ExtractPDF)
+	r.HandleFunc("/pdf/remove", s.RemovePDFPages)
+	r.HandleFunc("/pdf/rotate", s.RotatePDF)
+	r.HandleFunc("/pdf/reorder", s.ReorderPDF)
+	r.HandleFunc("/pdf/attachments", s.ListPDFAttachments)
+	r.HandleFunc("/pdf/attachments/add", s.AddPDFAttachments)
+	r.HandleFunc("/pdf/attachments/extract", s.ExtractPDFAttachments)
+	r.HandleFunc("/pdf/attachments/remove", s.RemovePDFAttachments)
+	r.HandleFunc("/form/fields", s.FormFields)
+	r.HandleFunc("/form/fill", s.FillPDFForm)
+	r.HandleFunc("/inspect", s.InspectPDF)
 	r.PathPrefix("/sample/").Handler(http.StripPrefix("/sample/",
 		http.FileServer(http.Dir(fmt.Sprintf("%s/sample", s.config.WebRoot)))))
 	r.NotFoundHandler = http.HandlerFunc(s.NotFoundHandle)
@@ -49,37 +69,284 @@ func (s *HTTPService) RedirectSample(writer http.ResponseWriter, request *http.R
 	http.Redirect(writer, request, "/sample/index.html", 301)
 }
 
+func readUpload(request *http.Request, field string) ([]byte, error) {
+	upload_text := request.FormValue(field)
+	if len(upload_text) > 0 {
+		return []byte(upload_text), nil
+	}
+
+	request.ParseMultipartForm(32 << 20)
+	file, _, err := request.FormFile(field)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
+}
+
+func saveUploadFile(header *multipart.FileHeader, tempPath string) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	local := filepath.Join(tempPath, MakeUUID()+filepath.Ext(header.Filename))
+	out, err := os.Create(local)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, file)
+	return local, err
+}
+
 func (s *HTTPService) HTMLPDF(writer http.ResponseWriter, request *http.Request) {
+	bin, err := readUpload(request, "upload")
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+
+	options, err := ParseRenderOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	toc, err := parseTOC(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	if toc != nil && options.Format == FormatPDF {
+		// 目录根据书签生成
+		options.Outline = true
+	}
+	accessible := parseAccessibility(request)
+	var report *AccessibilityReport
+	if accessible {
+		report = LintHTML(bin)
+		if options.Format == FormatPDF {
+			output.applyAccessibility(report)
+		}
+	}
+
+	htmlpdf := NewHTMLPDF(s.config)
+	file, err := htmlpdf.BuildFromSource(bin, options)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	if options.Format == FormatPDF {
+		if toc != nil {
+			if err = htmlpdf.PrependTOC(file, toc); err != nil {
+				os.Remove(file)
+				Logger.Error(err)
+				http.Error(writer, err.Error(), 500)
+				return
+			}
+		}
+		if err = htmlpdf.ApplyOutput(file, output); err != nil {
+			os.Remove(file)
+			Logger.Error(err)
+			http.Error(writer, err.Error(), 500)
+			return
+		}
+	}
+	setOptimizationHeaders(writer, output)
+	setConformanceHeaders(writer, output)
+	setAccessibilityHeaders(writer, report)
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%d%s", time.Now().UnixNano(), options.Ext()))
+	writer.Header().Set("Content-Type", options.ContentType())
+
+	pdf, err := os.Open(file)
+	if err != nil {
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	defer pdf.Close()
+	defer time.AfterFunc(time.Second*10, func() {
+		os.Remove(file)
+	})
+	_, err = io.Copy(writer, pdf)
+	if err != nil {
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+}
+
+// 电子发票：HTML（upload）或登记的模板（template）加 JSON 数据（data）渲染为 PDF/A-3b，
+// 并嵌入 CII XML（xml），支持与 htmlpdf 相同的后处理参数（加密除外）
+func (s *HTTPService) InvoicePDF(writer http.ResponseWriter, request *http.Request) {
+	options, err := ParseRenderOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	if options.Format != FormatPDF {
+		err = fmt.Errorf("e-invoices only support pdf output")
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	invoice, err := parseInvoice(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	if err = output.setInvoice(invoice); err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
 
-	upload_text := request.FormValue("upload")
+	htmlpdf := NewHTMLPDF(s.config)
 	var bin []byte
-	if len(upload_text) > 0 {
-		bin = []byte(upload_text)
+	if name := request.FormValue("template"); len(name) > 0 {
+		bin, err = htmlpdf.RenderInvoiceTemplate(name, []byte(request.FormValue("data")))
 	} else {
-		request.ParseMultipartForm(32 << 20)
-		file, _, err := request.FormFile("upload")
+		bin, err = readUpload(request, "upload")
+	}
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+
+	file, err := htmlpdf.BuildFromSource(bin, options)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	if err = htmlpdf.ApplyOutput(file, output); err != nil {
+		os.Remove(file)
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	setOptimizationHeaders(writer, output)
+	setConformanceHeaders(writer, output)
+	sendFile(writer, file, ".pdf", "application/pdf")
+}
+
+func (s *HTTPService) LINKPDF(writer http.ResponseWriter, request *http.Request) {
+	link := request.FormValue("link")
+	options, err := ParseRenderOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	accessible := parseAccessibility(request)
+	var report *AccessibilityReport
+	if accessible {
+		// 检查链接返回的 HTML 源文件
+		bin, err := s.downloadSource(link)
 		if err != nil {
 			Logger.Error(err)
 			http.Error(writer, err.Error(), 500)
 			return
 		}
-		defer file.Close()
+		report = LintHTML(bin)
+		if options.Format == FormatPDF {
+			output.applyAccessibility(report)
+		}
+	}
 
-		bin, err = ioutil.ReadAll(file)
-		if err != nil {
+	htmlpdf := NewHTMLPDF(s.config)
+	file, err := htmlpdf.BuildFromLink(link, options)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	if options.Format == FormatPDF {
+		if err = htmlpdf.ApplyOutput(file, output); err != nil {
+			os.Remove(file)
 			Logger.Error(err)
 			http.Error(writer, err.Error(), 500)
 			return
 		}
 	}
+	setOptimizationHeaders(writer, output)
+	setConformanceHeaders(writer, output)
+	setAccessibilityHeaders(writer, report)
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%d%s", time.Now().UnixNano(), options.Ext()))
+	writer.Header().Set("Content-Type", options.ContentType())
+
+	pdf, err := os.Open(file)
+	if err != nil {
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	defer pdf.Close()
+	defer time.AfterFunc(time.Second*10, func() {
+		os.Remove(file)
+	})
+	_, err = io.Copy(writer, pdf)
+	if err != nil {
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+}
+
+func (s *HTTPService) EMLPDF(writer http.ResponseWriter, request *http.Request) {
+	bin, err := readUpload(request, "upload")
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	appendAttachments := strings.EqualFold(request.FormValue("attachments"), "append")
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
 
 	htmlpdf := NewHTMLPDF(s.config)
-	file, err := htmlpdf.BuildFromSource(bin)
+	file, err := htmlpdf.BuildFromEML(bin, appendAttachments)
 	if err != nil {
 		Logger.Error(err)
 		http.Error(writer, err.Error(), 500)
 		return
 	}
+	if err = htmlpdf.ApplyOutput(file, output); err != nil {
+		os.Remove(file)
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	setOptimizationHeaders(writer, output)
+	setConformanceHeaders(writer, output)
 	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%d.pdf", time.Now().UnixNano()))
 	writer.Header().Set("Content-Type", "application/pdf")
 
@@ -99,16 +366,107 @@ func (s *HTTPService) HTMLPDF(writer http.ResponseWriter, request *http.Request)
 	}
 }
 
-func (s *HTTPService) LINKPDF(writer http.ResponseWriter, request *http.Request) {
-	link := request.FormValue("link")
+func (s *HTTPService) IMAGEPDF(writer http.ResponseWriter, request *http.Request) {
+	request.ParseMultipartForm(32 << 20)
 
-	htmlpdf := NewHTMLPDF(s.config)
-	file, err := htmlpdf.BuildFromLink(link)
+	layout := NewImageLayout()
+	if value := request.FormValue("page_size"); len(value) > 0 {
+		layout.PageSize = value
+	}
+	if value := request.FormValue("orientation"); len(value) > 0 {
+		layout.Orientation = value
+	}
+	if value := request.FormValue("fit"); len(value) > 0 {
+		layout.Fit = strings.ToLower(value)
+	}
+	if value := request.FormValue("margin"); len(value) > 0 {
+		margin, err := strconv.ParseFloat(value, 64)
+		if err != nil || margin < 0 {
+			http.Error(writer, fmt.Sprintf("invalid margin: %s", value), 500)
+			return
+		}
+		layout.Margin = margin
+	}
+	if value := request.FormValue("dpi"); len(value) > 0 {
+		dpi, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(writer, err.Error(), 500)
+			return
+		}
+		layout.DPI = dpi
+	}
+	if value := request.FormValue("grid"); len(value) > 0 {
+		columns, rows, err := ParseGrid(value)
+		if err != nil {
+			http.Error(writer, err.Error(), 500)
+			return
+		}
+		layout.Columns, layout.Rows = columns, rows
+	}
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+
+	images := make([]string, 0)
+	if request.MultipartForm != nil {
+		for _, header := range request.MultipartForm.File["upload"] {
+			local, err := saveUploadFile(header, s.config.TempPath)
+			if err != nil {
+				Logger.Error(err)
+				http.Error(writer, err.Error(), 500)
+				return
+			}
+			defer os.Remove(local)
+			images = append(images, local)
+		}
+	}
+	if values := request.Form["file"]; len(values) > 0 {
+		// 只接受 http(s) 地址，Downloader 会直接读取服务器上的本地路径
+		for _, value := range values {
+			if !isHTTPURL(value) {
+				err = fmt.Errorf("file must be an http(s) url: %s", value)
+				Logger.Error(err)
+				http.Error(writer, err.Error(), 500)
+				return
+			}
+		}
+		d := NewDownloader(values, s.config.TempPath, s.config)
+		d.Start()
+		d.Done(func(list []string) {
+			images = append(images, list...)
+
+			defer time.AfterFunc(time.Second*10, func() {
+				for _, item := range list {
+					if !strings.Contains(item, "/cache/") {
+						os.Remove(item)
+					}
+				}
+			})
+		})
+	}
+	if len(images) == 0 {
+		http.Error(writer, "no image uploaded", 500)
+		return
+	}
+
+	file := filepath.Join(s.config.TempPath, fmt.Sprintf("%s.pdf", MakeUUID()))
+	err = ImagesToPDF(images, file, layout)
 	if err != nil {
 		Logger.Error(err)
 		http.Error(writer, err.Error(), 500)
 		return
 	}
+	if err = NewHTMLPDF(s.config).ApplyOutput(file, output); err != nil {
+		os.Remove(file)
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	setOptimizationHeaders(writer, output)
+	setConformanceHeaders(writer, output)
 	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%d.pdf", time.Now().UnixNano()))
 	writer.Header().Set("Content-Type", "application/pdf")
 
@@ -132,9 +490,40 @@ func (s *HTTPService) LinkCombine(writer http.ResponseWriter, request *http.Requ
 	if err := request.ParseForm(); err != nil {
 		http.Error(writer, err.Error(), 500)
 	}
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	outline, titles := parseCombineOutline(request)
+	layout := parseCombineLayout(request)
+	normalization, err := parsePageNormalization(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	toc, err := parseTOC(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	// 目录根据书签生成，双面打印时目录同样补空白页到偶数页
+	outline = outline || toc != nil
+	if toc != nil && layout != nil {
+		toc.Duplex = layout.Duplex
+	}
 
 	for key, values := range request.PostForm {
 		if strings.EqualFold(key, "file") {
+			items, err := parseCombineItems(request, len(values))
+			if err != nil {
+				Logger.Error(err)
+				http.Error(writer, err.Error(), 500)
+				return
+			}
 
 			//先转换非pdf文件的url为本地pdf
 			input_files := make([]string, len(values))
@@ -154,7 +543,14 @@ func (s *HTTPService) LinkCombine(writer http.ResponseWriter, request *http.Requ
 					//判定文件后缀是否pdf
 					if !strings.EqualFold(strings.ToLower(filepath.Ext(urlInfo.Path)), ".pdf") {
 						htmlpdf := NewHTMLPDF(s.config)
-						return htmlpdf.BuildFromLink(file_url)
+						var options *RenderOptions
+						if outline {
+							// 网页自身的标题作为下级书签
+							options = NewRenderOptions()
+							options.Outline = true
+							options.OutlineSel = request.FormValue("outline_selector")
+						}
+						return htmlpdf.BuildFromLink(file_url, options)
 					}
 					return file_url, nil
 				})
@@ -178,22 +574,79 @@ func (s *HTTPService) LinkCombine(writer http.ResponseWriter, request *http.Requ
 
 			d.Start()
 			d.Done(func(list []string) {
+				inputs, removes, err := prepareCombineInputs(list, items, s.config.TempPath)
+				defer func() {
+					for _, item := range removes {
+						os.Remove(item)
+					}
+				}()
+				if err != nil {
+					Logger.Error(err)
+					http.Error(writer, err.Error(), 500)
+					return
+				}
 				htmlpdf := NewHTMLPDF(s.config)
-				savePath, err := htmlpdf.PDFTK_Combine(list)
+				inputs, leading, arranged, err := htmlpdf.arrangeCombineInputs(inputs, values, layout)
+				defer func() {
+					for _, item := range arranged {
+						os.Remove(item)
+					}
+				}()
 				if err != nil {
+					Logger.Error(err)
 					http.Error(writer, err.Error(), 500)
 					return
 				}
 
-				download, err := os.Open(savePath)
+				savePath, err := htmlpdf.PDFTK_Combine(inputs)
 				if err != nil {
 					http.Error(writer, err.Error(), 500)
 					return
 				}
+				if outline {
+					if err = combineOutline(savePath, inputs, values, titles, leading); err != nil {
+						Logger.Error(err)
+						http.Error(writer, err.Error(), 500)
+						return
+					}
+				}
+				if toc != nil {
+					if err = htmlpdf.PrependTOC(savePath, toc); err != nil {
+						Logger.Error(err)
+						http.Error(writer, err.Error(), 500)
+						return
+					}
+				}
+				if normalization != nil {
+					if err = NormalizePDFFile(savePath, normalization); err != nil {
+						Logger.Error(err)
+						http.Error(writer, err.Error(), 500)
+						return
+					}
+				}
+				if err = output.InheritMetadata(list[0]); err != nil {
+					Logger.Error(err)
+					http.Error(writer, err.Error(), 500)
+					return
+				}
+				if err = htmlpdf.ApplyOutput(savePath, output); err != nil {
+					Logger.Error(err)
+					http.Error(writer, err.Error(), 500)
+					return
+				}
 
-				writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%d.pdf", time.Now().UnixNano()))
-				writer.Header().Set("Content-Type", "application/pdf")
-				_, err = io.Copy(writer, download)
+				download, err := os.Open(savePath)
+				if err != nil {
+					http.Error(writer, err.Error(), 500)
+					return
+				}
+
+				setOptimizationHeaders(writer, output)
+
+				setConformanceHeaders(writer, output)
+				writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%d.pdf", time.Now().UnixNano()))
+				writer.Header().Set("Content-Type", "application/pdf")
+				_, err = io.Copy(writer, download)
 				if err != nil {
 					http.Error(writer, err.Error(), 500)
 					return
@@ -220,19 +673,90 @@ func (s *HTTPService) COMBINE(writer http.ResponseWriter, request *http.Request)
 	if err := request.ParseForm(); err != nil {
 		http.Error(writer, err.Error(), 500)
 	}
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	outline, titles := parseCombineOutline(request)
+	layout := parseCombineLayout(request)
+	normalization, err := parsePageNormalization(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
 
 	savePath := filepath.Join(s.config.TempPath, fmt.Sprintf("%d", rand.Int())+".pdf")
 
 	for key, values := range request.PostForm {
 		if strings.EqualFold(key, "file") {
+			items, err := parseCombineItems(request, len(values))
+			if err != nil {
+				Logger.Error(err)
+				http.Error(writer, err.Error(), 500)
+				return
+			}
 			d := NewDownloader(values, s.config.TempPath, s.config)
 
 			Logger.Info(values)
 
 			d.Start()
 			d.Done(func(list []string) {
+				inputs, removes, err := prepareCombineInputs(list, items, s.config.TempPath)
+				defer func() {
+					for _, item := range removes {
+						os.Remove(item)
+					}
+				}()
+				if err != nil {
+					Logger.Error(err)
+					http.Error(writer, err.Error(), 500)
+					return
+				}
+				inputs, leading, arranged, err := NewHTMLPDF(s.config).arrangeCombineInputs(inputs, values, layout)
+				defer func() {
+					for _, item := range arranged {
+						os.Remove(item)
+					}
+				}()
+				if err != nil {
+					Logger.Error(err)
+					http.Error(writer, err.Error(), 500)
+					return
+				}
 
-				CombinePDF(list, savePath)
+				if err := CombinePDF(inputs, savePath); err != nil {
+					os.Remove(savePath)
+					Logger.Error(err)
+					http.Error(writer, err.Error(), 500)
+					return
+				}
+				if outline {
+					if err := combineOutline(savePath, inputs, values, titles, leading); err != nil {
+						Logger.Error(err)
+						http.Error(writer, err.Error(), 500)
+						return
+					}
+				}
+				if normalization != nil {
+					if err := NormalizePDFFile(savePath, normalization); err != nil {
+						Logger.Error(err)
+						http.Error(writer, err.Error(), 500)
+						return
+					}
+				}
+				if err := output.InheritMetadata(list[0]); err != nil {
+					Logger.Error(err)
+					http.Error(writer, err.Error(), 500)
+					return
+				}
+				if err := NewHTMLPDF(s.config).ApplyOutput(savePath, output); err != nil {
+					Logger.Error(err)
+					http.Error(writer, err.Error(), 500)
+					return
+				}
 
 				download, err := os.Open(savePath)
 				if err != nil {
@@ -240,6 +764,9 @@ func (s *HTTPService) COMBINE(writer http.ResponseWriter, request *http.Request)
 					return
 				}
 
+				setOptimizationHeaders(writer, output)
+
+				setConformanceHeaders(writer, output)
 				writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%d.pdf", time.Now().UnixNano()))
 				writer.Header().Set("Content-Type", "application/pdf")
 				_, err = io.Copy(writer, download)
@@ -264,3 +791,406 @@ func (s *HTTPService) COMBINE(writer http.ResponseWriter, request *http.Request)
 		}
 	}
 }
+
+// 检查 HTML 源文件的无障碍问题，upload 为 HTML 内容或文件，也可以用 link 参数指定 URL，返回 JSON
+func (s *HTTPService) HTMLLint(writer http.ResponseWriter, request *http.Request) {
+	bin, err := readUpload(request, "upload")
+	if err != nil {
+		link := request.FormValue("link")
+		if len(link) == 0 {
+			Logger.Error(err)
+			http.Error(writer, err.Error(), 500)
+			return
+		}
+		if bin, err = s.downloadSource(link); err != nil {
+			Logger.Error(err)
+			http.Error(writer, err.Error(), 500)
+			return
+		}
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(writer).Encode(LintHTML(bin)); err != nil {
+		Logger.Error(err)
+	}
+}
+
+// 下载 URL 的内容，只接受 http(s) 地址，不读取服务器上的本地文件。
+// 服务器返回错误状态时直接报错，避免检查错误页面
+func (s *HTTPService) downloadSource(src string) ([]byte, error) {
+	if !isHTTPURL(src) {
+		return nil, fmt.Errorf("link must be an http(s) url: %s", src)
+	}
+	return downloadAttachment(src)
+}
+
+// 页面操作的源文件：上传的 upload 文件，或者 file 参数的 http(s) URL（不接受本地路径）。返回本地文件、文件名及清理函数
+func (s *HTTPService) sourcePDF(request *http.Request) (string, string, func(), error) {
+	request.ParseMultipartForm(32 << 20)
+	if request.MultipartForm != nil {
+		if files := request.MultipartForm.File["upload"]; len(files) > 0 {
+			local, err := saveUploadFile(files[0], s.config.TempPath)
+			return local, documentName(files[0].Filename), func() { os.Remove(local) }, err
+		}
+	}
+
+	src := request.FormValue("file")
+	if len(src) == 0 {
+		return "", "", func() {}, fmt.Errorf("no pdf uploaded")
+	}
+	if !isHTTPURL(src) {
+		return "", "", func() {}, fmt.Errorf("file must be an http(s) url: %s", src)
+	}
+	// Downloader 不检查响应状态，错误页面会被当作 PDF 解析，这里下载失败时直接报错
+	data, err := downloadAttachment(src)
+	if err != nil {
+		return "", "", func() {}, err
+	}
+	local := filepath.Join(s.config.TempPath, fmt.Sprintf("%s.pdf", MakeUUID()))
+	cleanup := func() { os.Remove(local) }
+	if err = os.WriteFile(local, data, 0644); err != nil {
+		return "", "", cleanup, err
+	}
+	return local, documentName(src), cleanup, nil
+}
+
+// 返回生成的文件，发送后删除
+func sendFile(writer http.ResponseWriter, file string, ext string, contentType string) {
+	download, err := os.Open(file)
+	if err != nil {
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	defer download.Close()
+	defer time.AfterFunc(time.Second*10, func() {
+		os.Remove(file)
+	})
+
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%d%s", time.Now().UnixNano(), ext))
+	writer.Header().Set("Content-Type", contentType)
+	if _, err = io.Copy(writer, download); err != nil {
+		http.Error(writer, err.Error(), 500)
+	}
+}
+
+// 对源文件做页面操作，生成一个 PDF 返回
+func (s *HTTPService) pageOperation(writer http.ResponseWriter, request *http.Request, operation func(src string, dest string) error) {
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	src, _, cleanup, err := s.sourcePDF(request)
+	defer cleanup()
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+
+	file := filepath.Join(s.config.TempPath, MakeUUID()+".pdf")
+	if err = operation(src, file); err != nil {
+		os.Remove(file)
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	if err = NewHTMLPDF(s.config).ApplyOutput(file, output); err != nil {
+		os.Remove(file)
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	setOptimizationHeaders(writer, output)
+	setConformanceHeaders(writer, output)
+	sendFile(writer, file, ".pdf", "application/pdf")
+}
+
+func (s *HTTPService) ExtractPDF(writer http.ResponseWriter, request *http.Request) {
+	s.pageOperation(writer, request, func(src string, dest string) error {
+		return ExtractPages(src, dest, request.FormValue("pages"))
+	})
+}
+
+func (s *HTTPService) RemovePDFPages(writer http.ResponseWriter, request *http.Request) {
+	s.pageOperation(writer, request, func(src string, dest string) error {
+		return RemovePages(src, dest, request.FormValue("pages"))
+	})
+}
+
+func (s *HTTPService) ReorderPDF(writer http.ResponseWriter, request *http.Request) {
+	s.pageOperation(writer, request, func(src string, dest string) error {
+		return ReorderPages(src, dest, request.FormValue("order"))
+	})
+}
+
+func (s *HTTPService) RotatePDF(writer http.ResponseWriter, request *http.Request) {
+	s.pageOperation(writer, request, func(src string, dest string) error {
+		angle, err := strconv.Atoi(request.FormValue("angle"))
+		if err != nil {
+			return fmt.Errorf("invalid angle: %s", request.FormValue("angle"))
+		}
+		return RotatePages(src, dest, request.FormValue("pages"), angle)
+	})
+}
+
+// 拆分 PDF，所有文件打包为 zip 返回
+func (s *HTTPService) SplitPDF(writer http.ResponseWriter, request *http.Request) {
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	ranges, every, err := parseSplit(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	src, name, cleanup, err := s.sourcePDF(request)
+	defer cleanup()
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	if len(name) == 0 {
+		name = "document"
+	}
+
+	dir, err := os.MkdirTemp(s.config.TempPath, "split")
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	htmlpdf := NewHTMLPDF(s.config)
+	files, err := SplitPDF(src, dir, name, ranges, every)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	for _, file := range files {
+		if err = htmlpdf.ApplyOutput(file, output); err != nil {
+			Logger.Error(err)
+			http.Error(writer, err.Error(), 500)
+			return
+		}
+	}
+
+	file := filepath.Join(s.config.TempPath, MakeUUID()+".zip")
+	if err = ZipFiles(files, file); err != nil {
+		os.Remove(file)
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	setOptimizationHeaders(writer, output)
+	setConformanceHeaders(writer, output)
+	sendFile(writer, file, ".zip", "application/zip")
+}
+
+// 列出 PDF 中的附件，返回 JSON
+func (s *HTTPService) ListPDFAttachments(writer http.ResponseWriter, request *http.Request) {
+	src, _, cleanup, err := s.sourcePDF(request)
+	defer cleanup()
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	list, err := ListAttachments(src)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(writer).Encode(list); err != nil {
+		Logger.Error(err)
+	}
+}
+
+// 添加附件，附件通过后处理参数 attachment 传入
+func (s *HTTPService) AddPDFAttachments(writer http.ResponseWriter, request *http.Request) {
+	s.pageOperation(writer, request, func(src string, dest string) error {
+		if len(parseAttachments(request)) == 0 {
+			return fmt.Errorf("no attachment uploaded")
+		}
+		// 附件在后处理中添加
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dest, data, 0666)
+	})
+}
+
+// 删除 name 指定的附件（可以重复），未指定时删除全部
+func (s *HTTPService) RemovePDFAttachments(writer http.ResponseWriter, request *http.Request) {
+	s.pageOperation(writer, request, func(src string, dest string) error {
+		return RemoveAttachments(src, dest, request.Form["name"])
+	})
+}
+
+// 提取 name 指定的附件（可以重复），未指定时提取全部。只有一个附件时直接返回该文件，否则打包为 zip
+func (s *HTTPService) ExtractPDFAttachments(writer http.ResponseWriter, request *http.Request) {
+	src, _, cleanup, err := s.sourcePDF(request)
+	defer cleanup()
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	list, err := ExtractAttachments(src, request.Form["name"])
+	if err == nil && len(list) == 0 {
+		err = fmt.Errorf("the pdf has no attachments")
+	}
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+
+	if len(list) == 1 {
+		mimeType := list[0].MimeType
+		if len(mimeType) == 0 {
+			mimeType = "application/octet-stream"
+		}
+		writer.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": list[0].Name}))
+		writer.Header().Set("Content-Type", mimeType)
+		writer.Write(list[0].Data)
+		return
+	}
+
+	file := filepath.Join(s.config.TempPath, MakeUUID()+".zip")
+	if err = ZipAttachments(list, file); err != nil {
+		os.Remove(file)
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	sendFile(writer, file, ".zip", "application/zip")
+}
+
+// 列出 PDF 表单的表单域，返回 JSON
+func (s *HTTPService) FormFields(writer http.ResponseWriter, request *http.Request) {
+	src, _, cleanup, err := s.sourcePDF(request)
+	defer cleanup()
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	list, err := ReadFormFields(src)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(writer).Encode(list); err != nil {
+		Logger.Error(err)
+	}
+}
+
+// 填写 PDF 表单：values 为表单域完整名称到值的 JSON，flatten 为 true 时扁平化。
+// html 为要合并的 HTML，按 html_position（before 或 after，默认 after）放在表单前后，支持后处理参数
+func (s *HTTPService) FillPDFForm(writer http.ResponseWriter, request *http.Request) {
+	output, err := ParseOutputOptions(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	values, flatten, err := parseFormValues(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	src, _, cleanup, err := s.sourcePDF(request)
+	defer cleanup()
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+
+	htmlpdf := NewHTMLPDF(s.config)
+	file := filepath.Join(s.config.TempPath, MakeUUID()+".pdf")
+	if err = FillForm(src, file, values, flatten); err != nil {
+		os.Remove(file)
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	if bin, _ := readUpload(request, "html"); len(bin) > 0 {
+		options, err := ParseRenderOptions(request)
+		if err == nil && options.Format != FormatPDF {
+			err = fmt.Errorf("html pages can only be merged as pdf")
+		}
+		var pages string
+		if err == nil {
+			pages, err = htmlpdf.BuildFromSource(bin, options)
+			defer os.Remove(pages)
+		}
+		merged := filepath.Join(s.config.TempPath, MakeUUID()+".pdf")
+		if err == nil {
+			err = MergeFormPages(file, pages, request.FormValue("html_position") == "before", merged)
+		}
+		os.Remove(file)
+		file = merged
+		if err != nil {
+			os.Remove(file)
+			Logger.Error(err)
+			http.Error(writer, err.Error(), 500)
+			return
+		}
+	}
+	if err = htmlpdf.ApplyOutput(file, output); err != nil {
+		os.Remove(file)
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	setOptimizationHeaders(writer, output)
+	setConformanceHeaders(writer, output)
+	sendFile(writer, file, ".pdf", "application/pdf")
+}
+
+// 检查 PDF 文件，返回页面、元数据、字体、加密、附件、表单域及校验结果的 JSON。
+// password 为加密文件的密码，validation 为 relaxed（默认）或 strict
+func (s *HTTPService) InspectPDF(writer http.ResponseWriter, request *http.Request) {
+	password, mode, err := parseInspection(request)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	src, _, cleanup, err := s.sourcePDF(request)
+	defer cleanup()
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	result, err := InspectPDF(src, password, mode)
+	if err != nil {
+		Logger.Error(err)
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(writer).Encode(result); err != nil {
+		Logger.Error(err)
+	}
+}
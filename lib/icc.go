@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+var (
+	srgbProfileData []byte
+	srgbProfileOnce sync.Once
+)
+
+// 内置的 sRGB IEC61966-2.1 ICC 配置文件（v2，显示器类，矩阵/曲线模型），
+// 按标准的原色（D50 适配）及传输曲线生成，用作 PDF/A 的 OutputIntent
+func srgbICCProfile() []byte {
+	srgbProfileOnce.Do(func() {
+		srgbProfileData = buildSRGBProfile()
+	})
+	return srgbProfileData
+}
+
+type iccTag struct {
+	Signature string
+	Data      []byte
+}
+
+func iccS15Fixed16(v float64) uint32 {
+	return uint32(int32(math.Round(v * 65536)))
+}
+
+func iccXYZ(x, y, z float64) []byte {
+	var b bytes.Buffer
+	b.WriteString("XYZ ")
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	for _, v := range []float64{x, y, z} {
+		binary.Write(&b, binary.BigEndian, iccS15Fixed16(v))
+	}
+	return b.Bytes()
+}
+
+func iccDescription(s string) []byte {
+	var b bytes.Buffer
+	b.WriteString("desc")
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	binary.Write(&b, binary.BigEndian, uint32(len(s)+1))
+	b.WriteString(s)
+	b.WriteByte(0)
+	// Unicode 及 ScriptCode 描述为空
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	binary.Write(&b, binary.BigEndian, uint16(0))
+	b.WriteByte(0)
+	b.Write(make([]byte, 67))
+	return b.Bytes()
+}
+
+func iccText(s string) []byte {
+	var b bytes.Buffer
+	b.WriteString("text")
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	b.WriteString(s)
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+// sRGB 传输曲线的采样
+func iccSRGBCurve() []byte {
+	const count = 1024
+	var b bytes.Buffer
+	b.WriteString("curv")
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	binary.Write(&b, binary.BigEndian, uint32(count))
+	for i := 0; i < count; i++ {
+		v := float64(i) / (count - 1)
+		if v <= 0.04045 {
+			v = v / 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		binary.Write(&b, binary.BigEndian, uint16(math.Round(v*65535)))
+	}
+	return b.Bytes()
+}
+
+func buildSRGBProfile() []byte {
+	curve := iccSRGBCurve()
+	tags := []iccTag{
+		{"desc", iccDescription("sRGB IEC61966-2.1")},
+		{"cprt", iccText("No copyright, use freely")},
+		{"wtpt", iccXYZ(0.9642, 1.0, 0.8249)},
+		{"rXYZ", iccXYZ(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", iccXYZ(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", iccXYZ(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	// 标签数据按 4 字节对齐，三条曲线共用同一份数据
+	offset := 128 + 4 + 12*len(tags)
+	var table, data bytes.Buffer
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	offsets := make(map[*byte]int)
+	for _, tag := range tags {
+		at, ok := offsets[&tag.Data[0]]
+		if !ok {
+			at = offset + data.Len()
+			offsets[&tag.Data[0]] = at
+			data.Write(tag.Data)
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		table.WriteString(tag.Signature)
+		binary.Write(&table, binary.BigEndian, uint32(at))
+		binary.Write(&table, binary.BigEndian, uint32(len(tag.Data)))
+	}
+
+	size := offset + data.Len()
+	var header bytes.Buffer
+	binary.Write(&header, binary.BigEndian, uint32(size))
+	header.Write(make([]byte, 4)) // CMM
+	binary.Write(&header, binary.BigEndian, uint32(0x02100000))
+	header.WriteString("mntrRGB XYZ ")
+	for _, v := range []uint16{2000, 1, 1, 0, 0, 0} {
+		binary.Write(&header, binary.BigEndian, v)
+	}
+	header.WriteString("acsp")
+	header.Write(make([]byte, 4+4+4+4+8+4)) // 平台、标志、厂商、型号、属性、渲染意图
+	for _, v := range []float64{0.9642, 1.0, 0.8249} {
+		binary.Write(&header, binary.BigEndian, iccS15Fixed16(v))
+	}
+	header.Write(make([]byte, 4+44))
+
+	profile := append(header.Bytes(), table.Bytes()...)
+	return append(profile, data.Bytes()...)
+}
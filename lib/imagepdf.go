@@ -0,0 +1,376 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"golang.org/x/image/tiff"
+)
+
+const (
+	ImageFit    = "fit"
+	ImageFill   = "fill"
+	ImageActual = "actual"
+)
+
+// 常用纸张尺寸（毫米，纵向）
+var PageSizes = map[string][2]float64{
+	"a3":      {297, 420},
+	"a4":      {210, 297},
+	"a5":      {148, 210},
+	"b5":      {176, 250},
+	"letter":  {215.9, 279.4},
+	"legal":   {215.9, 355.6},
+	"tabloid": {279.4, 431.8},
+}
+
+type ImageLayout struct {
+	PageSize    string  // 纸张名称，如 A4、Letter，或者 "宽x高"（毫米）
+	Orientation string  // auto、P、L
+	Fit         string  // fit、fill、actual
+	Margin      float64 // 页边距（毫米）
+	DPI         float64 // actual 模式下图片的分辨率
+	Columns     int
+	Rows        int
+}
+
+func NewImageLayout() *ImageLayout {
+	return &ImageLayout{
+		PageSize:    "A4",
+		Orientation: "auto",
+		Fit:         ImageFit,
+		DPI:         96,
+		Columns:     1,
+		Rows:        1,
+	}
+}
+
+// 解析纸张尺寸，返回纵向的宽高（毫米）
+func ParsePageSize(size string) (width float64, height float64, err error) {
+	if dim, ok := PageSizes[strings.ToLower(strings.TrimSpace(size))]; ok {
+		return dim[0], dim[1], nil
+	}
+	parts := strings.Split(strings.ToLower(size), "x")
+	if len(parts) == 2 {
+		w, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		h, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err1 == nil && err2 == nil && w > 0 && h > 0 {
+			return w, h, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid page size: %s", size)
+}
+
+// 解析网格布局，如 "2x3" 表示 2 列 3 行
+func ParseGrid(grid string) (columns int, rows int, err error) {
+	parts := strings.Split(strings.ToLower(grid), "x")
+	if len(parts) == 2 {
+		c, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+		r, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err1 == nil && err2 == nil && c > 0 && r > 0 {
+			return c, r, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid grid: %s", grid)
+}
+
+// 将若干图片按布局输出为一个 PDF，多帧 TIFF 的每一帧作为单独的图片处理
+func ImagesToPDF(src_image_paths []string, dest_pdf_path string, layout *ImageLayout) error {
+	if layout == nil {
+		layout = NewImageLayout()
+	}
+	if layout.Columns < 1 || layout.Rows < 1 {
+		return fmt.Errorf("invalid grid: %dx%d", layout.Columns, layout.Rows)
+	}
+	if layout.Margin < 0 {
+		return fmt.Errorf("invalid margin: %g", layout.Margin)
+	}
+	if layout.DPI <= 0 {
+		layout.DPI = 96
+	}
+	width, height, err := ParsePageSize(layout.PageSize)
+	if err != nil {
+		return err
+	}
+
+	frames := make([]image.Image, 0)
+	for _, src := range src_image_paths {
+		list, err := LoadImageFrames(src)
+		if err != nil {
+			Logger.Error(err)
+			return err
+		}
+		frames = append(frames, list...)
+	}
+	if len(frames) == 0 {
+		return errors.New("no image to convert")
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetAutoPageBreak(false, 0)
+
+	perPage := layout.Columns * layout.Rows
+	for i, frame := range frames {
+		if i%perPage == 0 {
+			orientation := strings.ToUpper(layout.Orientation)
+			if orientation != "P" && orientation != "L" {
+				orientation = "P"
+				rect := frame.Bounds()
+				if perPage == 1 && rect.Dx() > rect.Dy() {
+					orientation = "L"
+				}
+			}
+			pdf.AddPageFormat(orientation, gofpdf.SizeType{Wd: width, Ht: height})
+		}
+
+		pageW, pageH := pdf.GetPageSize()
+		cellW := (pageW - layout.Margin*2) / float64(layout.Columns)
+		cellH := (pageH - layout.Margin*2) / float64(layout.Rows)
+		if cellW <= 0 || cellH <= 0 {
+			return errors.New("margin is larger than the page")
+		}
+		slot := i % perPage
+		cellX := layout.Margin + float64(slot%layout.Columns)*cellW
+		cellY := layout.Margin + float64(slot/layout.Columns)*cellH
+
+		name := fmt.Sprintf("image-%d", i)
+		options, data, err := encodeFrame(frame)
+		if err != nil {
+			return err
+		}
+		pdf.RegisterImageOptionsReader(name, options, bytes.NewReader(data))
+
+		imgW, imgH := placeImage(frame.Bounds(), cellW, cellH, layout)
+		x := cellX + (cellW-imgW)/2
+		y := cellY + (cellH-imgH)/2
+
+		// fill 及 actual 模式下图片可能超出所在的格子，需要裁剪
+		pdf.ClipRect(cellX, cellY, cellW, cellH, false)
+		pdf.ImageOptions(name, x, y, imgW, imgH, false, options, 0, "")
+		pdf.ClipEnd()
+	}
+
+	err = pdf.OutputFileAndClose(dest_pdf_path)
+	if err != nil {
+		Logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// 计算图片在格子里的尺寸（毫米）
+func placeImage(rect image.Rectangle, cellW float64, cellH float64, layout *ImageLayout) (float64, float64) {
+	imgW := float64(rect.Dx())
+	imgH := float64(rect.Dy())
+
+	switch layout.Fit {
+	case ImageActual:
+		return imgW / layout.DPI * 25.4, imgH / layout.DPI * 25.4
+	case ImageFill:
+		scale := math.Max(cellW/imgW, cellH/imgH)
+		return imgW * scale, imgH * scale
+	default:
+		scale := math.Min(cellW/imgW, cellH/imgH)
+		return imgW * scale, imgH * scale
+	}
+}
+
+func encodeFrame(frame image.Image) (gofpdf.ImageOptions, []byte, error) {
+	var buf bytes.Buffer
+	if _, ok := frame.(*image.YCbCr); ok {
+		err := jpeg.Encode(&buf, frame, &jpeg.Options{Quality: 95})
+		return gofpdf.ImageOptions{ImageType: "jpg"}, buf.Bytes(), err
+	}
+	switch frame.(type) {
+	case *image.Paletted, *image.Gray, *image.RGBA, *image.NRGBA:
+	default:
+		// gofpdf 不支持 16 位 PNG，其他类型统一转换为 8 位 NRGBA
+		rect := frame.Bounds()
+		nrgba := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), frame, rect.Min, draw.Src)
+		frame = nrgba
+	}
+	err := png.Encode(&buf, frame)
+	return gofpdf.ImageOptions{ImageType: "png"}, buf.Bytes(), err
+}
+
+// LoadImageFrames 支持的图片类型：扩展名及对应的 MIME 类型，与注册的解码器一致
+var imageFileTypes = map[string]string{
+	".gif": "image/gif", ".png": "image/png", ".jpg": "image/jpeg", ".jpeg": "image/jpeg",
+	".webp": "image/webp", ".bmp": "image/bmp", ".tif": "image/tiff", ".tiff": "image/tiff",
+}
+
+// 按 MIME 类型或文件扩展名判断是否为支持的图片
+func isSupportedImage(contentType string, filename string) bool {
+	if _, ok := imageFileTypes[strings.ToLower(filepath.Ext(filename))]; ok {
+		return true
+	}
+	for _, t := range imageFileTypes {
+		if strings.EqualFold(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取图片的所有帧，JPEG 会根据 EXIF 方向进行旋转
+func LoadImageFrames(src_image_path string) ([]image.Image, error) {
+	data, err := ioutil.ReadFile(src_image_path)
+	if err != nil {
+		return nil, err
+	}
+
+	if isTIFF(data) {
+		return decodeTIFFFrames(data)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", src_image_path, err)
+	}
+	if format == "jpeg" {
+		img = orientImage(img, jpegOrientation(data))
+	}
+	return []image.Image{img}, nil
+}
+
+func isTIFF(data []byte) bool {
+	return len(data) > 8 &&
+		(bytes.Equal(data[:4], []byte("II*\x00")) || bytes.Equal(data[:4], []byte("MM\x00*")))
+}
+
+// x/image/tiff 只解码第一个 IFD，这里沿着 IFD 链改写文件头中的偏移量逐帧解码
+func decodeTIFFFrames(data []byte) ([]image.Image, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	frames := make([]image.Image, 0)
+	patched := make([]byte, len(data))
+	copy(patched, data)
+
+	offset := order.Uint32(data[4:8])
+	seen := make(map[uint32]bool)
+	for offset != 0 && !seen[offset] && int(offset)+2 <= len(data) {
+		seen[offset] = true
+		order.PutUint32(patched[4:8], offset)
+		img, err := tiff.Decode(bytes.NewReader(patched))
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, img)
+
+		count := int(order.Uint16(data[offset : offset+2]))
+		next := int(offset) + 2 + count*12
+		if next+4 > len(data) {
+			break
+		}
+		offset = order.Uint32(data[next : next+4])
+	}
+	return frames, nil
+}
+
+// 读取 JPEG APP1 段中 EXIF 的 Orientation 标签，未找到时返回 1
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		size := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if marker == 0xDA || size < 2 || pos+2+size > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xE1 && len(segment) > 14 && bytes.Equal(segment[:6], []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + size
+	}
+	return 1
+}
+
+func exifOrientation(tiffData []byte) int {
+	var order binary.ByteOrder
+	switch string(tiffData[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiffData[4:8]))
+	if offset+2 > len(tiffData) {
+		return 1
+	}
+	count := int(order.Uint16(tiffData[offset : offset+2]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiffData) {
+			return 1
+		}
+		if order.Uint16(tiffData[entry:entry+2]) == 0x0112 {
+			value := int(order.Uint16(tiffData[entry+8 : entry+10]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// 按 EXIF Orientation 变换图片
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	src := img.Bounds()
+	w, h := src.Dx(), src.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(src.Min.X+x, src.Min.Y+y))
+		}
+	}
+	return dst
+}
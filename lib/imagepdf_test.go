@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"golang.org/x/image/tiff"
+)
+
+func Test_ParsePageSize(t *testing.T) {
+	w, h, err := ParsePageSize("Letter")
+	if err != nil || w != 215.9 || h != 279.4 {
+		t.Errorf("unexpected letter size: %v %v %v", w, h, err)
+	}
+	w, h, err = ParsePageSize("100x150")
+	if err != nil || w != 100 || h != 150 {
+		t.Errorf("unexpected custom size: %v %v %v", w, h, err)
+	}
+	if _, _, err = ParsePageSize("huge"); err == nil {
+		t.Errorf("expected error for invalid size")
+	}
+}
+
+func Test_OrientImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+
+	rotated := orientImage(img, 6)
+	if rotated.Bounds().Dx() != 2 || rotated.Bounds().Dy() != 3 {
+		t.Fatalf("unexpected bounds: %v", rotated.Bounds())
+	}
+	// 顺时针旋转 90 度后，左上角的像素移动到右上角
+	if r, _, _, _ := rotated.At(1, 0).RGBA(); r != 0xffff {
+		t.Errorf("pixel not rotated")
+	}
+}
+
+func Test_ImagesToPDF(t *testing.T) {
+	dir := t.TempDir()
+
+	// 构造一个两帧的 TIFF
+	var frame1, frame2 bytes.Buffer
+	tiff.Encode(&frame1, image.NewGray(image.Rect(0, 0, 40, 20)), nil)
+	tiff.Encode(&frame2, image.NewGray(image.Rect(0, 0, 20, 40)), nil)
+	multi := appendTIFFFrame(frame1.Bytes(), frame2.Bytes())
+
+	src := filepath.Join(dir, "multi.tiff")
+	if err := os.WriteFile(src, multi, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := LoadImageFrames(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(frames))
+	}
+
+	layout := NewImageLayout()
+	layout.Fit = ImageFill
+	layout.Margin = 10
+	dest := filepath.Join(dir, "out.pdf")
+	if err = ImagesToPDF([]string{src}, dest, layout); err != nil {
+		t.Fatal(err)
+	}
+	count, err := api.PageCount(dest)
+	if err != nil || count != 2 {
+		t.Errorf("expected 2 pages, got %d %v", count, err)
+	}
+
+	layout.Columns, layout.Rows = 2, 1
+	if err = ImagesToPDF([]string{src}, dest, layout); err != nil {
+		t.Fatal(err)
+	}
+	count, err = api.PageCount(dest)
+	if err != nil || count != 1 {
+		t.Errorf("expected 1 page, got %d %v", count, err)
+	}
+
+	layout.Margin = -5
+	if err = ImagesToPDF([]string{src}, dest, layout); err == nil {
+		t.Error("expected error for negative margin")
+	}
+}
+
+// 将第二个单帧 TIFF 追加到第一个后面，并链接 IFD
+func appendTIFFFrame(first []byte, second []byte) []byte {
+	base := uint32(len(first))
+	out := append([]byte{}, first...)
+	out = append(out, second...)
+
+	le := func(b []byte) uint32 {
+		return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+	}
+	put := func(b []byte, v uint32) {
+		b[0], b[1], b[2], b[3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+	}
+
+	// 修正第二帧 IFD 中指向数据的偏移量
+	ifd2 := base + le(second[4:8])
+	count := int(out[ifd2]) | int(out[ifd2+1])<<8
+	for i := 0; i < count; i++ {
+		entry := out[int(ifd2)+2+i*12:]
+		typ := int(entry[2]) | int(entry[3])<<8
+		n := le(entry[4:8])
+		size := map[int]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8}[typ] * n
+		tag := int(entry[0]) | int(entry[1])<<8
+		if size > 4 || tag == 273 {
+			put(entry[8:12], le(entry[8:12])+base)
+		}
+	}
+
+	// 第一帧 IFD 的 next 指向第二帧
+	ifd1 := le(first[4:8])
+	count1 := int(out[ifd1]) | int(out[ifd1+1])<<8
+	put(out[int(ifd1)+2+count1*12:], ifd2)
+	return out
+}
+
+// file 参数只接受 http(s) 地址，不读取服务器上的本地图片
+func Test_ImagePDFRejectsLocalFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "local.png")
+	if err := os.WriteFile(file, []byte("not served"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &HTTPService{config: &Config{TempPath: dir}}
+
+	for _, src := range []string{file, "file://" + file} {
+		recorder := httptest.NewRecorder()
+		s.IMAGEPDF(recorder, newFormRequest(url.Values{"file": {src}}))
+		if recorder.Code != 500 || !strings.Contains(recorder.Body.String(), "http(s) url") {
+			t.Errorf("%s: expected rejection, got %d %s", src, recorder.Code, recorder.Body.String())
+		}
+	}
+}
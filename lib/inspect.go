@@ -0,0 +1,321 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// PDF 文件的检查结果
+type Inspection struct {
+	Version     string              `json:"version"`
+	FileSize    int64               `json:"file_size"`
+	PageCount   int                 `json:"page_count"`
+	Pages       []PageInfo          `json:"pages"`
+	Metadata    *InspectionMetadata `json:"metadata"`
+	Fonts       []*FontInfo         `json:"fonts"`
+	Encrypted   bool                `json:"encrypted"`
+	Encryption  *EncryptionInfo     `json:"encryption,omitempty"`
+	Attachments []*Attachment       `json:"attachments"`
+	FormFields  []*FormField        `json:"form_fields"`
+	Validation  *ValidationResult   `json:"validation"`
+}
+
+// 文档元数据，未设置的项不输出
+type InspectionMetadata struct {
+	Title        string            `json:"title,omitempty"`
+	Author       string            `json:"author,omitempty"`
+	Subject      string            `json:"subject,omitempty"`
+	Keywords     string            `json:"keywords,omitempty"`
+	Creator      string            `json:"creator,omitempty"`
+	Producer     string            `json:"producer,omitempty"`
+	CreationDate *time.Time        `json:"creation_date,omitempty"`
+	ModDate      *time.Time        `json:"mod_date,omitempty"`
+	Language     string            `json:"lang,omitempty"`
+	Custom       map[string]string `json:"custom,omitempty"`
+}
+
+// 文档使用的字体，Pages 为通过页面资源（包括 Form XObject）使用该字体的页码
+type FontInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Encoding string `json:"encoding,omitempty"`
+	Embedded bool   `json:"embedded"`
+	Subset   bool   `json:"subset"`
+	Pages    []int  `json:"pages,omitempty"`
+}
+
+// 加密算法及允许的操作（权限名称同加密参数）
+type EncryptionInfo struct {
+	Method      string   `json:"method"`
+	Permissions []string `json:"permissions"`
+}
+
+type ValidationResult struct {
+	Mode  string `json:"mode"`
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	ValidationRelaxed = "relaxed"
+	ValidationStrict  = "strict"
+)
+
+// 读取检查参数：password（加密文件的密码，不会写入日志）、validation（relaxed 或 strict，默认 relaxed）
+func parseInspection(request *http.Request) (string, string, error) {
+	mode := strings.ToLower(request.FormValue("validation"))
+	switch mode {
+	case "":
+		mode = ValidationRelaxed
+	case ValidationRelaxed, ValidationStrict:
+	default:
+		return "", "", fmt.Errorf("unsupported validation mode: %s", mode)
+	}
+	return request.FormValue("password"), mode, nil
+}
+
+func inspectionConfig(password string, mode string) *pdfcpu.Configuration {
+	config := newPDFConfig()
+	if mode == ValidationStrict {
+		config.ValidationMode = pdfcpu.ValidationStrict
+	}
+	config.UserPW = password
+	config.OwnerPW = password
+	return config
+}
+
+// 检查 PDF 文件：页面、元数据、字体、加密、附件、表单域及 pdfcpu 校验结果
+func InspectPDF(src string, password string, mode string) (*Inspection, error) {
+	ctx, err := readPDFContextWithConfig(src, inspectionConfig(password, mode))
+	if err != nil {
+		return nil, err
+	}
+	result := &Inspection{
+		Version:   ctx.VersionString(),
+		FileSize:  ctx.Read.FileSize,
+		PageCount: ctx.PageCount,
+		Encrypted: ctx.Encrypt != nil,
+	}
+	if result.Pages, err = contextPageInfo(ctx); err != nil {
+		return nil, err
+	}
+	meta, err := contextMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.Metadata = inspectionMetadata(meta)
+	if result.Fonts, err = contextFonts(ctx); err != nil {
+		return nil, err
+	}
+	if result.Encrypted {
+		result.Encryption = contextEncryption(ctx)
+	}
+	if result.Attachments, err = contextAttachments(ctx, nil, false); err != nil {
+		return nil, err
+	}
+	if result.FormFields, err = contextFormSchema(ctx); err != nil {
+		return nil, err
+	}
+	result.Validation = validatePDF(src, password, mode)
+	return result, nil
+}
+
+func inspectionMetadata(meta *Metadata) *InspectionMetadata {
+	result := &InspectionMetadata{
+		Title:    meta.Title,
+		Author:   meta.Author,
+		Subject:  meta.Subject,
+		Keywords: meta.Keywords,
+		Creator:  meta.Creator,
+		Producer: meta.Producer,
+		Language: meta.Language,
+	}
+	if !meta.CreationDate.IsZero() {
+		result.CreationDate = &meta.CreationDate
+	}
+	if !meta.ModDate.IsZero() {
+		result.ModDate = &meta.ModDate
+	}
+	if len(meta.Custom) > 0 {
+		result.Custom = meta.Custom
+	}
+	return result
+}
+
+// 用 pdfcpu 校验文件，校验时的异常作为校验失败返回
+func validatePDF(src string, password string, mode string) (result *ValidationResult) {
+	result = &ValidationResult{Mode: mode}
+	defer func() {
+		if err := recover(); err != nil {
+			result.Valid = false
+			result.Error = fmt.Sprintf("validation failed: %v", err)
+		}
+	}()
+	if err := api.ValidateFile(src, inspectionConfig(password, mode)); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Valid = true
+	return result
+}
+
+// 加密算法：V 为 5 时为 AES-256，V 为 4 时取决于加密过滤器的 CFM，更早的版本为 RC4
+func contextEncryption(ctx *pdfcpu.Context) *EncryptionInfo {
+	info := &EncryptionInfo{Method: "rc4", Permissions: make([]string, 0)}
+	if ctx.E != nil {
+		switch {
+		case ctx.E.V >= 5:
+			info.Method = EncryptAES256
+		case ctx.E.V == 4:
+			info.Method = "rc4-128"
+			if d, _ := ctx.DereferenceDict(*ctx.Encrypt); d != nil {
+				filters, _ := ctx.DereferenceDict(d["CF"])
+				filter, _ := ctx.DereferenceDict(filters["StdCF"])
+				if cfm := filter.NameEntry("CFM"); cfm != nil && *cfm == "AESV2" {
+					info.Method = EncryptAES128
+				}
+			}
+		}
+		for name, bits := range permissionBits {
+			if int16(ctx.E.P)&bits == bits {
+				info.Permissions = append(info.Permissions, name)
+			}
+		}
+		sort.Strings(info.Permissions)
+	}
+	return info
+}
+
+// 文档中的字体：先按页面资源记录使用的页码，再补充只在其他地方（如注释外观）使用的字体
+func contextFonts(ctx *pdfcpu.Context) ([]*FontInfo, error) {
+	fonts := make(map[string]*FontInfo)
+	keys := make([]string, 0)
+	add := func(key string, d pdfcpu.Dict, page int) {
+		info, ok := fonts[key]
+		if !ok {
+			if info = fontInfo(ctx, d); info == nil {
+				return
+			}
+			fonts[key] = info
+			keys = append(keys, key)
+		}
+		if page > 0 && !containsInt(info.Pages, page) {
+			info.Pages = append(info.Pages, page)
+		}
+	}
+
+	var walk func(resources pdfcpu.Dict, page int, visited map[int]bool)
+	walk = func(resources pdfcpu.Dict, page int, visited map[int]bool) {
+		fontDict, _ := ctx.DereferenceDict(resources["Font"])
+		for name, o := range fontDict {
+			d, err := ctx.DereferenceDict(o)
+			if err != nil || d == nil {
+				continue
+			}
+			key := fmt.Sprintf("%d/%s", page, name)
+			if ref, ok := o.(pdfcpu.IndirectRef); ok {
+				key = ref.String()
+			}
+			add(key, d, page)
+		}
+		xobjects, _ := ctx.DereferenceDict(resources["XObject"])
+		for _, o := range xobjects {
+			ref, ok := o.(pdfcpu.IndirectRef)
+			if !ok || visited[ref.ObjectNumber.Value()] {
+				continue
+			}
+			visited[ref.ObjectNumber.Value()] = true
+			sd, err := ctx.DereferenceStreamDict(ref)
+			if err != nil || sd == nil || sd.Subtype() == nil || *sd.Subtype() != "Form" {
+				continue
+			}
+			if inner, _ := ctx.DereferenceDict(sd.Dict["Resources"]); inner != nil {
+				walk(inner, page, visited)
+			}
+		}
+	}
+	for i := 1; i <= ctx.PageCount; i++ {
+		page, _, err := ctx.PageDict(i)
+		if err != nil {
+			return nil, err
+		}
+		if resources, _ := ctx.DereferenceDict(inheritedPageEntry(ctx.XRefTable, page, "Resources")); resources != nil {
+			walk(resources, i, make(map[int]bool))
+		}
+	}
+
+	numbers := make([]int, 0, len(ctx.Table))
+	for n := range ctx.Table {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	for _, n := range numbers {
+		entry := ctx.Table[n]
+		if entry == nil || entry.Free {
+			continue
+		}
+		if d, ok := entry.Object.(pdfcpu.Dict); ok && d.Type() != nil && *d.Type() == "Font" {
+			add(pdfcpu.NewIndirectRef(n, *entry.Generation).String(), d, 0)
+		}
+	}
+
+	list := make([]*FontInfo, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, fonts[key])
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// 字体的名称、类型、编码及嵌入情况，CID 字体（复合字体的子字体）不单独列出
+func fontInfo(ctx *pdfcpu.Context, d pdfcpu.Dict) *FontInfo {
+	info := &FontInfo{}
+	if subtype := d.Subtype(); subtype != nil {
+		info.Type = *subtype
+	}
+	if strings.HasPrefix(info.Type, "CIDFontType") {
+		return nil
+	}
+	if name := d.NameEntry("BaseFont"); name != nil {
+		info.Name = pdfcpu.Name(*name).Value()
+	}
+	// 子集字体的名称以 6 个大写字母及 "+" 开头
+	if i := strings.IndexByte(info.Name, '+'); i == 6 && strings.ToUpper(info.Name[:6]) == info.Name[:6] {
+		info.Subset = true
+	}
+	switch encoding := d["Encoding"].(type) {
+	case pdfcpu.Name:
+		info.Encoding = encoding.Value()
+	case pdfcpu.IndirectRef, pdfcpu.Dict:
+		if e, _ := ctx.DereferenceDict(encoding); e != nil {
+			info.Encoding = "custom"
+			if base := e.NameEntry("BaseEncoding"); base != nil {
+				info.Encoding = *base
+			}
+		}
+	}
+
+	switch info.Type {
+	case "Type3":
+		// Type3 字体的字形在文档中定义
+		info.Embedded = true
+	case "Type0":
+		descendants, _ := ctx.DereferenceArray(d["DescendantFonts"])
+		if len(descendants) > 0 {
+			if descendant, _ := ctx.DereferenceDict(descendants[0]); descendant != nil {
+				info.Embedded = fontEmbedded(ctx.XRefTable, descendant)
+			}
+		}
+	default:
+		info.Embedded = fontEmbedded(ctx.XRefTable, d)
+	}
+	return info
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ParseInspection(t *testing.T) {
+	request, _ := http.NewRequest("POST", "/inspect", nil)
+	request.Form = url.Values{"password": {"secret"}}
+	password, mode, err := parseInspection(request)
+	if err != nil || password != "secret" || mode != ValidationRelaxed {
+		t.Errorf("unexpected result: %s %v", mode, err)
+	}
+
+	request.Form = url.Values{"validation": {"paranoid"}}
+	if _, _, err = parseInspection(request); err == nil {
+		t.Error("expected error for unsupported validation mode")
+	}
+}
+
+func Test_InspectPDF(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "form.pdf")
+	writeFormTestPDF(t, file)
+	if err := AddAttachments(file, []*Attachment{{Name: "notes.txt", Data: []byte("notes")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := InspectPDF(file, "", ValidationStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PageCount != 2 || len(result.Pages) != 2 || result.Pages[0].Width < 595 || result.Pages[0].Width > 596 {
+		t.Errorf("unexpected pages: %d %v", result.PageCount, result.Pages)
+	}
+	if result.Encrypted || result.Encryption != nil {
+		t.Error("file should not be encrypted")
+	}
+	if len(result.Attachments) != 1 || result.Attachments[0].Name != "notes.txt" || result.Attachments[0].Size != 5 {
+		t.Errorf("unexpected attachments: %v", result.Attachments)
+	}
+	if len(result.FormFields) == 0 {
+		t.Error("missing form fields")
+	}
+	// 表单默认资源中的 Helvetica 没有嵌入
+	found := false
+	for _, f := range result.Fonts {
+		if f.Name == "Helvetica" {
+			found = true
+			if f.Embedded || f.Subset || f.Type != "Type1" {
+				t.Errorf("unexpected font: %+v", f)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("missing Helvetica: %v", result.Fonts)
+	}
+	// 严格模式要求标准字体也带有 FirstChar 等字宽信息
+	if v := result.Validation; v.Mode != ValidationStrict || v.Valid || len(v.Error) == 0 {
+		t.Errorf("unexpected validation: %+v", v)
+	}
+	if result, err = InspectPDF(file, "", ValidationRelaxed); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Validation.Valid {
+		t.Errorf("unexpected relaxed validation: %+v", result.Validation)
+	}
+}
+
+func Test_InspectEncryptedPDF(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "encrypted.pdf")
+	writeTextTestPDF(t, file)
+	enc := &Encryption{UserPassword: "user", OwnerPassword: "owner", Method: EncryptAES256, Permissions: []string{"print", "copy"}}
+	if err := EncryptPDFFile(file, enc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InspectPDF(file, "", ValidationRelaxed); err == nil {
+		t.Error("expected error without password")
+	}
+	result, err := InspectPDF(file, "owner", ValidationRelaxed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Encrypted || result.Encryption.Method != EncryptAES256 {
+		t.Fatalf("unexpected encryption: %v", result.Encryption)
+	}
+	if p := result.Encryption.Permissions; len(p) != 2 || p[0] != "copy" || p[1] != "print" {
+		t.Errorf("unexpected permissions: %v", p)
+	}
+	if len(result.Fonts) != 1 || result.Fonts[0].Name != "Helvetica-Bold" || result.Fonts[0].Pages[0] != 1 {
+		t.Errorf("unexpected fonts: %v", result.Fonts)
+	}
+	if !result.Validation.Valid {
+		t.Errorf("unexpected validation: %+v", result.Validation)
+	}
+}
@@ -0,0 +1,211 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Factur-X（与 ZUGFeRD 2.1 及以上版本相同）电子发票：嵌入 PDF/A-3 的 CII XML 及其配置级别
+type Invoice struct {
+	XML     []byte
+	Profile string // MINIMUM、BASIC WL、BASIC、EN 16931、EXTENDED、XRECHNUNG
+}
+
+const facturXNamespace = "urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#"
+
+// 配置级别的别名，比较前去掉空格、"-" 及 "_"
+var invoiceProfiles = map[string]string{
+	"MINIMUM":   "MINIMUM",
+	"BASICWL":   "BASIC WL",
+	"BASIC":     "BASIC",
+	"EN16931":   "EN 16931",
+	"COMFORT":   "EN 16931",
+	"EXTENDED":  "EXTENDED",
+	"XRECHNUNG": "XRECHNUNG",
+}
+
+func normalizeInvoiceProfile(value string) (string, bool) {
+	key := strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(value))
+	profile, ok := invoiceProfiles[key]
+	return profile, ok
+}
+
+// 读取电子发票参数：xml（CII XML 内容或上传的文件）、profile（不设置时取自 XML 的
+// GuidelineSpecifiedDocumentContextParameter）
+func parseInvoice(request *http.Request) (*Invoice, error) {
+	data, err := readUpload(request, "xml")
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("no invoice xml uploaded")
+	}
+	invoice := &Invoice{XML: data}
+
+	detected, err := invoiceProfile(data)
+	if err != nil {
+		return nil, err
+	}
+	if value := request.FormValue("profile"); len(value) > 0 {
+		profile, ok := normalizeInvoiceProfile(value)
+		if !ok {
+			return nil, fmt.Errorf("unsupported profile: %s", value)
+		}
+		if len(detected) > 0 && detected != profile {
+			return nil, fmt.Errorf("profile %s does not match the xml profile %s", profile, detected)
+		}
+		invoice.Profile = profile
+	} else {
+		invoice.Profile = detected
+	}
+	if len(invoice.Profile) == 0 {
+		return nil, fmt.Errorf("unable to determine the invoice profile, set profile")
+	}
+	return invoice, nil
+}
+
+// 检查 XML 是否为 CII 发票（CrossIndustryInvoice），返回其中声明的配置级别，无法识别时为空
+func invoiceProfile(data []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	path := make([]string, 0)
+	root, guideline := "", ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid invoice xml: %v", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if len(root) == 0 {
+				root = t.Name.Local
+			}
+			path = append(path, t.Name.Local)
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			n := len(path)
+			if n >= 2 && path[n-1] == "ID" && path[n-2] == "GuidelineSpecifiedDocumentContextParameter" {
+				guideline += string(t)
+			}
+		}
+	}
+	if root != "CrossIndustryInvoice" {
+		return "", fmt.Errorf("the xml is not a CII invoice (CrossIndustryInvoice)")
+	}
+
+	guideline = strings.ToLower(strings.TrimSpace(guideline))
+	switch {
+	case len(guideline) == 0:
+		return "", nil
+	case strings.Contains(guideline, "xrechnung"):
+		return "XRECHNUNG", nil
+	case strings.HasSuffix(guideline, ":extended"):
+		return "EXTENDED", nil
+	case strings.HasSuffix(guideline, ":basicwl"):
+		return "BASIC WL", nil
+	case strings.HasSuffix(guideline, ":basic"):
+		return "BASIC", nil
+	case strings.HasSuffix(guideline, ":minimum"):
+		return "MINIMUM", nil
+	case guideline == "urn:cen.eu:en16931:2017" || strings.HasSuffix(guideline, ":comfort"):
+		return "EN 16931", nil
+	}
+	return "", nil
+}
+
+// 嵌入文件的名称，XRECHNUNG 使用 xrechnung.xml
+func (inv *Invoice) FileName() string {
+	if inv.Profile == "XRECHNUNG" {
+		return "xrechnung.xml"
+	}
+	return "factur-x.xml"
+}
+
+// MINIMUM、BASIC WL 不是完整的发票，XML 只作为数据；其他级别的 XML 与 PDF 等价
+func (inv *Invoice) relationship() string {
+	if inv.Profile == "MINIMUM" || inv.Profile == "BASIC WL" {
+		return "Data"
+	}
+	return "Alternative"
+}
+
+// 输出 PDF/A-3b，XMP 中加入 Factur-X 的说明及扩展模式
+func (inv *Invoice) conformance() *Conformance {
+	description := fmt.Sprintf("  <rdf:Description rdf:about=\"\" xmlns:fx=\"%s\">\n"+
+		"   <fx:DocumentType>INVOICE</fx:DocumentType>\n"+
+		"   <fx:DocumentFileName>%s</fx:DocumentFileName>\n"+
+		"   <fx:Version>1.0</fx:Version>\n"+
+		"   <fx:ConformanceLevel>%s</fx:ConformanceLevel>\n"+
+		"  </rdf:Description>\n", facturXNamespace, inv.FileName(), xmlEscape(inv.Profile))
+	schema := &xmpSchema{Name: "Factur-X PDFA Extension Schema", NamespaceURI: facturXNamespace, Prefix: "fx",
+		Properties: []*xmpProperty{
+			{Name: "DocumentFileName", ValueType: "Text", Category: "external", Description: "name of the embedded XML invoice file"},
+			{Name: "DocumentType", ValueType: "Text", Category: "external", Description: "INVOICE"},
+			{Name: "Version", ValueType: "Text", Category: "external", Description: "The actual version of the Factur-X XML schema"},
+			{Name: "ConformanceLevel", ValueType: "Text", Category: "external", Description: "The conformance level of the embedded Factur-X data"},
+		}}
+	return &Conformance{Part: 3, Level: "b", schemas: []*xmpSchema{schema}, descriptions: []string{description}}
+}
+
+// 电子发票输出 PDF/A-3b，不能与加密或 PDF/A-2 同时使用
+func (o *OutputOptions) setInvoice(inv *Invoice) error {
+	if o.Encryption != nil {
+		return fmt.Errorf("pdf/a does not allow encryption")
+	}
+	if o.Conformance != nil && o.Conformance.Part != 3 {
+		return fmt.Errorf("e-invoices require pdf/a-3, not %s", o.Conformance)
+	}
+	o.Invoice = inv
+	o.Conformance = inv.conformance()
+	return nil
+}
+
+// 把发票 XML 嵌入 PDF（原地修改），AF 及 XMP 在 PDF/A 转换时写入
+func EmbedInvoiceXML(pdf_path string, inv *Invoice) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	_, err = addAttachment(ctx, &Attachment{
+		Name:         inv.FileName(),
+		Description:  fmt.Sprintf("Factur-X invoice (%s)", inv.Profile),
+		MimeType:     "text/xml",
+		Relationship: inv.relationship(),
+		ModTime:      time.Now(),
+		Data:         inv.XML,
+	})
+	if err != nil {
+		return err
+	}
+	return writePDFContext(ctx, pdf_path)
+}
+
+// 用配置中登记的发票模板（Go html/template 格式）及 JSON 数据生成 HTML
+func (pdf *HTMLPDF) RenderInvoiceTemplate(name string, data []byte) ([]byte, error) {
+	file, ok := pdf.config.InvoiceTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown invoice template: %s", name)
+	}
+	var values interface{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("invalid data: %v", err)
+		}
+	}
+	tpl, err := template.ParseFiles(file)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err = tpl.Execute(&b, values); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
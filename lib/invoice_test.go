@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func testInvoiceXML(guideline string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<rsm:CrossIndustryInvoice xmlns:rsm="urn:un:unece:uncefact:data:standard:CrossIndustryInvoice:100"
+  xmlns:ram="urn:un:unece:uncefact:data:standard:ReusableAggregateBusinessInformationEntity:100">
+ <rsm:ExchangedDocumentContext>
+  <ram:GuidelineSpecifiedDocumentContextParameter>
+   <ram:ID>` + guideline + `</ram:ID>
+  </ram:GuidelineSpecifiedDocumentContextParameter>
+ </rsm:ExchangedDocumentContext>
+ <rsm:ExchangedDocument><ram:ID>INV-001</ram:ID><ram:TypeCode>380</ram:TypeCode></rsm:ExchangedDocument>
+</rsm:CrossIndustryInvoice>`
+}
+
+func Test_InvoiceProfile(t *testing.T) {
+	cases := map[string]string{
+		"urn:factur-x.eu:1p0:minimum":                                           "MINIMUM",
+		"urn:factur-x.eu:1p0:basicwl":                                           "BASIC WL",
+		"urn:cen.eu:en16931:2017#compliant#urn:factur-x.eu:1p0:basic":           "BASIC",
+		"urn:cen.eu:en16931:2017":                                               "EN 16931",
+		"urn:cen.eu:en16931:2017#conformant#urn:factur-x.eu:1p0:extended":       "EXTENDED",
+		"urn:cen.eu:en16931:2017#compliant#urn:xeinkauf.de:kosit:xrechnung_3.0": "XRECHNUNG",
+		"urn:example:unknown":                                                   "",
+	}
+	for guideline, expected := range cases {
+		if profile, err := invoiceProfile([]byte(testInvoiceXML(guideline))); err != nil || profile != expected {
+			t.Errorf("%s: unexpected profile %q %v", guideline, profile, err)
+		}
+	}
+	for _, data := range []string{`<Invoice><ID>1</ID></Invoice>`, `<rsm:CrossIndustryInvoice>`} {
+		if _, err := invoiceProfile([]byte(data)); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+}
+
+func Test_ParseInvoice(t *testing.T) {
+	data := testInvoiceXML("urn:cen.eu:en16931:2017")
+	invoice, err := parseInvoice(newFormRequest(url.Values{"xml": {data}, "profile": {"comfort"}}))
+	if err != nil || invoice.Profile != "EN 16931" || invoice.relationship() != "Alternative" {
+		t.Errorf("unexpected invoice: %+v %v", invoice, err)
+	}
+	if _, err = parseInvoice(newFormRequest(url.Values{"xml": {data}, "profile": {"minimum"}})); err == nil {
+		t.Error("expected error for mismatched profile")
+	}
+	unknown := testInvoiceXML("urn:example:unknown")
+	if _, err = parseInvoice(newFormRequest(url.Values{"xml": {unknown}})); err == nil {
+		t.Error("expected error without profile")
+	}
+	invoice, err = parseInvoice(newFormRequest(url.Values{"xml": {unknown}, "profile": {"Basic-WL"}}))
+	if err != nil || invoice.Profile != "BASIC WL" || invoice.relationship() != "Data" {
+		t.Errorf("unexpected invoice: %+v %v", invoice, err)
+	}
+
+	output := &OutputOptions{Conformance: &Conformance{Part: 2, Level: "b"}}
+	if err = output.setInvoice(invoice); err == nil {
+		t.Error("expected error for pdf/a-2")
+	}
+}
+
+func Test_EmbedInvoiceXML(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "invoice.pdf")
+	writeTextTestPDF(t, file)
+	invoice := &Invoice{XML: []byte(testInvoiceXML("urn:cen.eu:en16931:2017")), Profile: "EN 16931"}
+	output := &OutputOptions{}
+	if err := output.setInvoice(invoice); err != nil {
+		t.Fatal(err)
+	}
+	// 重复嵌入时替换原来的附件
+	for i := 0; i < 2; i++ {
+		if err := EmbedInvoiceXML(file, invoice); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ConvertPDFA(file, output.Conformance); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ValidateFile(file, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := embeddedFileEntries(ctx)
+	if err != nil || len(entries) != 1 || entries[0].Key != "factur-x.xml" {
+		t.Fatalf("unexpected embedded files: %v %v", entries, err)
+	}
+	spec, _ := ctx.DereferenceDict(entries[0].Value)
+	if relationship := spec.NameEntry("AFRelationship"); relationship == nil || *relationship != "Alternative" {
+		t.Errorf("unexpected AFRelationship: %v", spec)
+	}
+	ef, _ := ctx.DereferenceDict(spec["EF"])
+	stream, _ := ef["F"].(pdfcpu.IndirectRef)
+	sd, _ := ctx.DereferenceStreamDict(stream)
+	if sd == nil || sd.Subtype() == nil || *sd.Subtype() != "text#2Fxml" {
+		t.Errorf("unexpected embedded file: %v", sd)
+	}
+	data, err := pdfcpu.ExtractStreamData(ctx, stream.ObjectNumber.Value())
+	if err != nil || !bytes.Equal(data, invoice.XML) {
+		t.Errorf("unexpected embedded xml: %v", err)
+	}
+
+	root, _ := ctx.Catalog()
+	af, _ := ctx.DereferenceArray(root["AF"])
+	if ref, ok := entries[0].Value.(pdfcpu.IndirectRef); !ok || len(af) != 1 || af[0] != ref {
+		t.Errorf("unexpected AF: %v", af)
+	}
+	metadata, _ := root["Metadata"].(pdfcpu.IndirectRef)
+	xmp, err := pdfcpu.ExtractStreamData(ctx, metadata.ObjectNumber.Value())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"<pdfaid:part>3</pdfaid:part>", "<fx:ConformanceLevel>EN 16931</fx:ConformanceLevel>",
+		"<fx:DocumentFileName>factur-x.xml</fx:DocumentFileName>", "<pdfaSchema:prefix>fx</pdfaSchema:prefix>"} {
+		if !bytes.Contains(xmp, []byte(expected)) {
+			t.Errorf("xmp does not contain %s", expected)
+		}
+	}
+	if bytes.Count(xmp, []byte("<pdfaExtension:schemas>")) != 1 {
+		t.Error("extension schemas should be in one description")
+	}
+}
+
+func Test_RenderInvoiceTemplate(t *testing.T) {
+	tpl := filepath.Join(t.TempDir(), "invoice.html")
+	if err := os.WriteFile(tpl, []byte(`<h1>{{.number}}</h1>{{range .lines}}<p>{{.}}</p>{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pdf := &HTMLPDF{config: &Config{InvoiceTemplates: map[string]string{"default": tpl}}}
+	html, err := pdf.RenderInvoiceTemplate("default", []byte(`{"number":"INV-001","lines":["a<b"]}`))
+	if err != nil || string(html) != `<h1>INV-001</h1><p>a&lt;b</p>` {
+		t.Errorf("unexpected html: %s %v", html, err)
+	}
+	if _, err = pdf.RenderInvoiceTemplate("missing", nil); err == nil {
+		t.Error("expected error for unknown template")
+	}
+}
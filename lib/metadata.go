@@ -0,0 +1,356 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// 文档元数据，同时写入 Info 字典及 XMP。未设置的项保留文档中原有的值
+type Metadata struct {
+	Title        string
+	Author       string
+	Subject      string
+	Keywords     string
+	Creator      string
+	Producer     string
+	CreationDate time.Time
+	ModDate      time.Time
+	Language     string
+	Custom       map[string]string
+	Inherit      bool // 合并时继承第一个文件的元数据
+}
+
+// 自定义条目的名称同时用作 PDF 名称及 XML 元素名
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+var standardInfoKeys = map[string]bool{
+	"Title": true, "Author": true, "Subject": true, "Keywords": true, "Creator": true,
+	"Producer": true, "CreationDate": true, "ModDate": true, "Trapped": true,
+}
+
+// 读取元数据参数：title、author、subject、keywords、creator、producer、creation_date、mod_date、
+// lang、metadata（自定义条目的 JSON 对象）、metadata_from（合并接口，first 表示继承第一个文件）
+func parseMetadata(request *http.Request) (*Metadata, error) {
+	meta := &Metadata{
+		Title:    request.FormValue("title"),
+		Author:   request.FormValue("author"),
+		Subject:  request.FormValue("subject"),
+		Keywords: request.FormValue("keywords"),
+		Creator:  request.FormValue("creator"),
+		Producer: request.FormValue("producer"),
+		Language: request.FormValue("lang"),
+	}
+
+	var err error
+	if value := request.FormValue("creation_date"); len(value) > 0 {
+		if meta.CreationDate, err = parseMetadataDate(value); err != nil {
+			return nil, fmt.Errorf("invalid creation_date: %s", value)
+		}
+	}
+	if value := request.FormValue("mod_date"); len(value) > 0 {
+		if meta.ModDate, err = parseMetadataDate(value); err != nil {
+			return nil, fmt.Errorf("invalid mod_date: %s", value)
+		}
+	}
+	if value := strings.TrimSpace(request.FormValue("metadata")); len(value) > 0 {
+		if err = json.Unmarshal([]byte(value), &meta.Custom); err != nil {
+			return nil, fmt.Errorf("invalid metadata: %v", err)
+		}
+		for key := range meta.Custom {
+			if !metadataKeyPattern.MatchString(key) || standardInfoKeys[key] {
+				return nil, fmt.Errorf("invalid metadata key: %s", key)
+			}
+		}
+	}
+	switch value := strings.ToLower(request.FormValue("metadata_from")); value {
+	case "":
+	case "first":
+		meta.Inherit = true
+	default:
+		return nil, fmt.Errorf("unsupported metadata_from: %s", value)
+	}
+
+	if meta.isEmpty() {
+		return nil, nil
+	}
+	return meta, nil
+}
+
+func (m *Metadata) isEmpty() bool {
+	return len(m.Title) == 0 && len(m.Author) == 0 && len(m.Subject) == 0 && len(m.Keywords) == 0 &&
+		len(m.Creator) == 0 && len(m.Producer) == 0 && m.CreationDate.IsZero() && m.ModDate.IsZero() &&
+		len(m.Language) == 0 && len(m.Custom) == 0 && !m.Inherit
+}
+
+// 支持 RFC 3339、"2006-01-02 15:04:05"、"2006-01-02" 及 PDF 日期格式
+func parseMetadataDate(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if strings.HasPrefix(value, "D:") {
+		if t, ok := parsePDFDate(value); ok {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %s", value)
+}
+
+// 用 src 中的值补全未设置的项
+func (m *Metadata) merge(src *Metadata) {
+	fill := func(dst *string, value string) {
+		if len(*dst) == 0 {
+			*dst = value
+		}
+	}
+	fill(&m.Title, src.Title)
+	fill(&m.Author, src.Author)
+	fill(&m.Subject, src.Subject)
+	fill(&m.Keywords, src.Keywords)
+	fill(&m.Creator, src.Creator)
+	fill(&m.Producer, src.Producer)
+	fill(&m.Language, src.Language)
+	if m.CreationDate.IsZero() {
+		m.CreationDate = src.CreationDate
+	}
+	if m.ModDate.IsZero() {
+		m.ModDate = src.ModDate
+	}
+	for key, value := range src.Custom {
+		if _, ok := m.Custom[key]; !ok {
+			if m.Custom == nil {
+				m.Custom = make(map[string]string)
+			}
+			m.Custom[key] = value
+		}
+	}
+}
+
+// 合并接口设置了 metadata_from=first 时，继承第一个文件的元数据
+func (o *OutputOptions) InheritMetadata(first string) error {
+	if o == nil || o.Metadata == nil || !o.Metadata.Inherit || len(first) == 0 {
+		return nil
+	}
+	src, err := ReadMetadata(first)
+	if err != nil {
+		return err
+	}
+	o.Metadata.merge(src)
+	return nil
+}
+
+// 读取 PDF 文件的元数据
+func ReadMetadata(file string) (*Metadata, error) {
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		return nil, err
+	}
+	return contextMetadata(ctx)
+}
+
+func contextMetadata(ctx *pdfcpu.Context) (*Metadata, error) {
+	meta := &Metadata{Custom: make(map[string]string)}
+	if root, err := ctx.Catalog(); err == nil {
+		if lang, err := ctx.DereferenceText(root["Lang"]); err == nil {
+			meta.Language = lang
+		}
+	}
+	if ctx.Info == nil {
+		return meta, nil
+	}
+	info, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || info == nil {
+		return meta, err
+	}
+
+	for key, o := range info {
+		value, err := ctx.DereferenceText(o)
+		if err != nil {
+			// 例如 Trapped 为名称
+			continue
+		}
+		switch key {
+		case "Title":
+			meta.Title = value
+		case "Author":
+			meta.Author = value
+		case "Subject":
+			meta.Subject = value
+		case "Keywords":
+			meta.Keywords = value
+		case "Creator":
+			meta.Creator = value
+		case "Producer":
+			meta.Producer = value
+		case "CreationDate":
+			meta.CreationDate, _ = parsePDFDate(value)
+		case "ModDate":
+			meta.ModDate, _ = parsePDFDate(value)
+		default:
+			if metadataKeyPattern.MatchString(key) && !standardInfoKeys[key] {
+				meta.Custom[key] = value
+			}
+		}
+	}
+	return meta, nil
+}
+
+// 设置 PDF 文件的元数据（原地修改），ModDate 未设置时使用当前时间
+func SetPDFMetadata(pdf_path string, meta *Metadata) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	existing, err := contextMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	merged := *meta
+	merged.Custom = make(map[string]string)
+	for key, value := range meta.Custom {
+		merged.Custom[key] = value
+	}
+	if merged.ModDate.IsZero() {
+		merged.ModDate = time.Now()
+	}
+	merged.merge(existing)
+	if merged.CreationDate.IsZero() {
+		merged.CreationDate = merged.ModDate
+	}
+	if err = setContextMetadata(ctx, &merged); err != nil {
+		return err
+	}
+	return writePDFContext(ctx, pdf_path)
+}
+
+// 用 meta 替换 Info 字典及 XMP，descriptions 为 XMP 中附加的 rdf:Description
+func setContextMetadata(ctx *pdfcpu.Context, merged *Metadata, descriptions ...string) error {
+	info := pdfcpu.NewDict()
+	for key, value := range map[string]string{
+		"Title": merged.Title, "Author": merged.Author, "Subject": merged.Subject,
+		"Keywords": merged.Keywords, "Creator": merged.Creator, "Producer": merged.Producer,
+	} {
+		if len(value) > 0 {
+			info.Insert(key, pdfTextString(value))
+		}
+	}
+	info.Insert("CreationDate", pdfcpu.StringLiteral(pdfDate(merged.CreationDate)))
+	info.Insert("ModDate", pdfcpu.StringLiteral(pdfDate(merged.ModDate)))
+	for key, value := range merged.Custom {
+		info.Insert(key, pdfTextString(value))
+	}
+	ref, err := ctx.IndRefForNewObject(info)
+	if err != nil {
+		return err
+	}
+	ctx.Info = ref
+
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	if len(merged.Language) > 0 {
+		root.Update("Lang", pdfTextString(merged.Language))
+	}
+	return setXMPMetadata(ctx, root, buildXMP(merged, descriptions...))
+}
+
+// XMP 元数据流不压缩，便于其他工具直接读取
+func setXMPMetadata(ctx *pdfcpu.Context, root pdfcpu.Dict, xmp []byte) error {
+	length := int64(len(xmp))
+	d := pdfcpu.Dict{
+		"Type":    pdfcpu.Name("Metadata"),
+		"Subtype": pdfcpu.Name("XML"),
+		"Length":  pdfcpu.Integer(length),
+	}
+	sd := pdfcpu.NewStreamDict(d, 0, &length, nil, nil)
+	sd.Content = xmp
+	sd.Raw = xmp
+	ref, err := ctx.IndRefForNewObject(sd)
+	if err != nil {
+		return err
+	}
+	root.Update("Metadata", *ref)
+	return nil
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func xmpDate(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+// 生成与 Info 字典一致的 XMP 数据包，自定义条目放在 pdfx 命名空间中
+func buildXMP(meta *Metadata, descriptions ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("    xmlns:pdf=\"http://ns.adobe.com/pdf/1.3/\"\n")
+	b.WriteString("    xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"\n")
+	b.WriteString("    xmlns:pdfx=\"http://ns.adobe.com/pdfx/1.3/\">\n")
+
+	b.WriteString("   <dc:format>application/pdf</dc:format>\n")
+	if len(meta.Title) > 0 {
+		fmt.Fprintf(&b, "   <dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", xmlEscape(meta.Title))
+	}
+	if len(meta.Author) > 0 {
+		fmt.Fprintf(&b, "   <dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", xmlEscape(meta.Author))
+	}
+	if len(meta.Subject) > 0 {
+		fmt.Fprintf(&b, "   <dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", xmlEscape(meta.Subject))
+	}
+	if len(meta.Language) > 0 {
+		fmt.Fprintf(&b, "   <dc:language><rdf:Bag><rdf:li>%s</rdf:li></rdf:Bag></dc:language>\n", xmlEscape(meta.Language))
+	}
+	if len(meta.Keywords) > 0 {
+		fmt.Fprintf(&b, "   <pdf:Keywords>%s</pdf:Keywords>\n", xmlEscape(meta.Keywords))
+	}
+	if len(meta.Producer) > 0 {
+		fmt.Fprintf(&b, "   <pdf:Producer>%s</pdf:Producer>\n", xmlEscape(meta.Producer))
+	}
+	if len(meta.Creator) > 0 {
+		fmt.Fprintf(&b, "   <xmp:CreatorTool>%s</xmp:CreatorTool>\n", xmlEscape(meta.Creator))
+	}
+	fmt.Fprintf(&b, "   <xmp:CreateDate>%s</xmp:CreateDate>\n", xmpDate(meta.CreationDate))
+	fmt.Fprintf(&b, "   <xmp:ModifyDate>%s</xmp:ModifyDate>\n", xmpDate(meta.ModDate))
+	fmt.Fprintf(&b, "   <xmp:MetadataDate>%s</xmp:MetadataDate>\n", xmpDate(meta.ModDate))
+
+	keys := make([]string, 0, len(meta.Custom))
+	for key := range meta.Custom {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "   <pdfx:%s>%s</pdfx:%s>\n", key, xmlEscape(meta.Custom[key]), key)
+	}
+
+	b.WriteString("  </rdf:Description>\n")
+	for _, description := range descriptions {
+		b.WriteString(description)
+	}
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	// XMP 规范建议预留空白，便于就地修改
+	for i := 0; i < 20; i++ {
+		b.WriteString(strings.Repeat(" ", 99) + "\n")
+	}
+	b.WriteString("<?xpacket end=\"w\"?>")
+	return b.Bytes()
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_ParseMetadata(t *testing.T) {
+	meta, err := parseMetadata(newFormRequest(url.Values{
+		"title":         {"年度报告"},
+		"author":        {"Finance"},
+		"creation_date": {"2024-03-01"},
+		"mod_date":      {"2024-03-02T10:00:00+08:00"},
+		"lang":          {"zh-CN"},
+		"metadata":      {`{"Department":"Finance","Revision":"3"}`},
+		"metadata_from": {"first"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "年度报告" || meta.Language != "zh-CN" || meta.Custom["Revision"] != "3" || !meta.Inherit {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+	if meta.CreationDate.Format("2006-01-02") != "2024-03-01" || meta.ModDate.Hour() != 10 {
+		t.Errorf("unexpected dates: %v %v", meta.CreationDate, meta.ModDate)
+	}
+
+	if meta, err = parseMetadata(newFormRequest(url.Values{"format": {"pdf"}})); err != nil || meta != nil {
+		t.Errorf("expected no metadata, got %v %v", meta, err)
+	}
+
+	invalid := []url.Values{
+		{"creation_date": {"yesterday"}},
+		{"metadata": {`{"Title":"x"}`}},
+		{"metadata": {`{"bad key":"x"}`}},
+		{"metadata": {`["x"]`}},
+		{"metadata_from": {"last"}},
+	}
+	for _, form := range invalid {
+		if _, err = parseMetadata(newFormRequest(form)); err == nil {
+			t.Errorf("expected error for %v", form)
+		}
+	}
+}
+
+func Test_SetPDFMetadata(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "meta.pdf")
+	writeTestPDF(t, file, 2)
+
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.Local)
+	err := SetPDFMetadata(file, &Metadata{
+		Title:        "年度报告",
+		Author:       "Finance",
+		Producer:     "Report Service",
+		CreationDate: created,
+		Language:     "zh-CN",
+		Custom:       map[string]string{"Revision": "3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		meta, err := ReadMetadata(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Title != "年度报告" || meta.Author != "Finance" || meta.Language != "zh-CN" || meta.Custom["Revision"] != "3" {
+			t.Errorf("unexpected metadata: %+v", meta)
+		}
+		// pdfcpu 写文件时会改写 Producer 及日期，这里要求保留设置的值
+		if meta.Producer != "Report Service" || !meta.CreationDate.Equal(created) {
+			t.Errorf("producer or creation date not kept: %s %v", meta.Producer, meta.CreationDate)
+		}
+	}
+	check()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range []string{"<?xpacket begin=", "<pdf:Producer>Report Service</pdf:Producer>", "<pdfx:Revision>3</pdfx:Revision>"} {
+		if !bytes.Contains(data, []byte(item)) {
+			t.Errorf("xmp does not contain %s", item)
+		}
+	}
+
+	// 后续的处理不能覆盖元数据
+	if err = StampPDFFile(file, []*Stamp{{Text: "DRAFT"}}); err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+func Test_InheritMetadata(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.pdf")
+	writeTestPDF(t, first, 1)
+	if err := SetPDFMetadata(first, &Metadata{Title: "First", Author: "Alice", Keywords: "a,b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	output := &OutputOptions{Metadata: &Metadata{Title: "Bundle", Inherit: true}}
+	if err := output.InheritMetadata(first); err != nil {
+		t.Fatal(err)
+	}
+	if output.Metadata.Title != "Bundle" || output.Metadata.Author != "Alice" || output.Metadata.Keywords != "a,b" {
+		t.Errorf("unexpected metadata: %+v", output.Metadata)
+	}
+}
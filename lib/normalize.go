@@ -0,0 +1,234 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// 合并后统一页面尺寸：尺寸不同的页面按 Fit 缩放后居中放在目标尺寸的页面上，
+// fit 完整显示（可能留白），fill 铺满（超出部分裁掉）。尺寸一致的页面不做处理
+type PageNormalization struct {
+	Width       float64 // 点，纵向
+	Height      float64 // 点，纵向
+	Orientation string  // auto（跟随页面）、P、L
+	Fit         string  // fit、fill
+	Margin      float64 // 点
+}
+
+// 变换矩阵 [a b c d e f]
+type pdfMatrix [6]float64
+
+func (m pdfMatrix) multiply(n pdfMatrix) pdfMatrix {
+	return pdfMatrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+func (m pdfMatrix) transform(x, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+// 读取统一页面尺寸的参数，与 imagepdf 相同：page_size（A4、Letter 或 "宽x高" 毫米）、
+// orientation（auto、P、L）、fit（fit、fill）、margin（毫米）。未设置 page_size 时返回 nil
+func parsePageNormalization(request *http.Request) (*PageNormalization, error) {
+	size := request.FormValue("page_size")
+	if len(size) == 0 {
+		return nil, nil
+	}
+	width, height, err := ParsePageSize(size)
+	if err != nil {
+		return nil, err
+	}
+	n := &PageNormalization{
+		Width:       width * 72 / 25.4,
+		Height:      height * 72 / 25.4,
+		Orientation: strings.ToUpper(request.FormValue("orientation")),
+		Fit:         strings.ToLower(request.FormValue("fit")),
+	}
+	switch n.Orientation {
+	case "", "AUTO":
+		n.Orientation = "auto"
+	case "P", "L":
+	default:
+		return nil, fmt.Errorf("unsupported orientation: %s", request.FormValue("orientation"))
+	}
+	switch n.Fit {
+	case "":
+		n.Fit = ImageFit
+	case ImageFit, ImageFill:
+	default:
+		return nil, fmt.Errorf("unsupported fit: %s", n.Fit)
+	}
+	if value := request.FormValue("margin"); len(value) > 0 {
+		margin, err := strconv.ParseFloat(value, 64)
+		if err != nil || margin < 0 {
+			return nil, fmt.Errorf("invalid margin: %s", value)
+		}
+		n.Margin = margin * 72 / 25.4
+	}
+	if n.Margin*2 >= math.Min(n.Width, n.Height) {
+		return nil, fmt.Errorf("margin is larger than the page")
+	}
+	return n, nil
+}
+
+// 统一 PDF 文件的页面尺寸（原地修改），页面上的注释及书签位置随之调整
+func NormalizePDFFile(pdf_path string, n *PageNormalization) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	items, err := contextOutline(ctx)
+	if err != nil {
+		return err
+	}
+
+	restore, err := newContentStream(ctx, "Q\n")
+	if err != nil {
+		return err
+	}
+
+	heights := make([]float64, ctx.PageCount)
+	targets := make([]float64, ctx.PageCount)
+	matrices := make([]*pdfMatrix, ctx.PageCount)
+	changed := false
+	for i := 1; i <= ctx.PageCount; i++ {
+		page, _, err := ctx.PageDict(i)
+		if err != nil {
+			return err
+		}
+		info := pageInfo(ctx.XRefTable, page)
+		heights[i-1] = info.Height
+		m, width, height := n.pageMatrix(ctx.XRefTable, page, info)
+		if m == nil {
+			continue
+		}
+		matrices[i-1] = m
+		targets[i-1] = height
+		changed = true
+
+		content := fmt.Sprintf("q %.2f %.2f %.2f %.2f re W n %.5f %.5f %.5f %.5f %.3f %.3f cm\n",
+			n.Margin, n.Margin, width-n.Margin*2, height-n.Margin*2, m[0], m[1], m[2], m[3], m[4], m[5])
+		save, err := newContentStream(ctx, content)
+		if err != nil {
+			return err
+		}
+		wrapPageContents(ctx.XRefTable, page, *save, *restore)
+
+		for _, key := range []string{"CropBox", "BleedBox", "TrimBox", "ArtBox"} {
+			page.Delete(key)
+		}
+		page.Update("MediaBox", pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Float(width), pdfcpu.Float(height)})
+		page.Update("Rotate", pdfcpu.Integer(0))
+		if err = transformAnnotations(ctx.XRefTable, page, *m); err != nil {
+			return err
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if len(items) > 0 {
+		transformOutline(items, matrices, heights, targets)
+		if err = setContextOutline(ctx, items); err != nil {
+			return err
+		}
+	}
+	return writePDFContext(ctx, pdf_path)
+}
+
+// 页面内容到目标页面的变换矩阵及目标页面尺寸，页面尺寸已经一致时返回 nil
+func (n *PageNormalization) pageMatrix(xRefTable *pdfcpu.XRefTable, page pdfcpu.Dict, info PageInfo) (*pdfMatrix, float64, float64) {
+	x0, y0 := pageOrigin(xRefTable, page)
+	w, h := info.Width, info.Height
+
+	// 先把页面坐标变换为旋转后看到的坐标，原点移到左下角
+	m := pdfMatrix{1, 0, 0, 1, -x0, -y0}
+	visualW, visualH := w, h
+	switch info.Rotation {
+	case 90:
+		m = m.multiply(pdfMatrix{0, -1, 1, 0, 0, w})
+		visualW, visualH = h, w
+	case 180:
+		m = m.multiply(pdfMatrix{-1, 0, 0, -1, w, h})
+	case 270:
+		m = m.multiply(pdfMatrix{0, 1, -1, 0, h, 0})
+		visualW, visualH = h, w
+	}
+
+	width, height := n.Width, n.Height
+	if n.Orientation == "L" || (n.Orientation == "auto" && visualW > visualH) {
+		width, height = n.Height, n.Width
+	}
+	if math.Abs(visualW-width) < 1 && math.Abs(visualH-height) < 1 {
+		return nil, width, height
+	}
+
+	availW, availH := width-n.Margin*2, height-n.Margin*2
+	scale := math.Min(availW/visualW, availH/visualH)
+	if n.Fit == ImageFill {
+		scale = math.Max(availW/visualW, availH/visualH)
+	}
+	m = m.multiply(pdfMatrix{scale, 0, 0, scale, (width - visualW*scale) / 2, (height - visualH*scale) / 2})
+	return &m, width, height
+}
+
+func newContentStream(ctx *pdfcpu.Context, content string) (*pdfcpu.IndirectRef, error) {
+	stream, err := newFlateStream(pdfcpu.Dict{}, []byte(content))
+	if err != nil {
+		return nil, err
+	}
+	return ctx.IndRefForNewObject(*stream)
+}
+
+// 调整页面上注释（链接、表单域等）的位置
+func transformAnnotations(xRefTable *pdfcpu.XRefTable, page pdfcpu.Dict, m pdfMatrix) error {
+	annots, err := xRefTable.DereferenceArray(page["Annots"])
+	if err != nil || annots == nil {
+		return err
+	}
+	for _, o := range annots {
+		annot, err := xRefTable.DereferenceDict(o)
+		if err != nil || annot == nil {
+			continue
+		}
+		rect, err := xRefTable.DereferenceArray(annot["Rect"])
+		if err != nil || len(rect) != 4 {
+			continue
+		}
+		var r [4]float64
+		for i, v := range rect {
+			r[i], _ = xRefTable.DereferenceNumber(v)
+		}
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, corner := range [][2]float64{{r[0], r[1]}, {r[0], r[3]}, {r[2], r[1]}, {r[2], r[3]}} {
+			x, y := m.transform(corner[0], corner[1])
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+		}
+		annot.Update("Rect", pdfcpu.Array{pdfcpu.Float(minX), pdfcpu.Float(minY), pdfcpu.Float(maxX), pdfcpu.Float(maxY)})
+	}
+	return nil
+}
+
+// 按页面的变换调整书签的目标位置，heights、targets 为变换前后的页面高度
+func transformOutline(items []*OutlineItem, matrices []*pdfMatrix, heights []float64, targets []float64) {
+	for _, item := range items {
+		if n := item.Page - 1; n >= 0 && n < len(matrices) && matrices[n] != nil {
+			_, y := matrices[n].transform(0, heights[n]-item.Top)
+			item.Top = math.Max(0, targets[n]-y)
+		}
+		transformOutline(item.Children, matrices, heights, targets)
+	}
+}
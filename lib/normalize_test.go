@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"math"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func Test_ParsePageNormalization(t *testing.T) {
+	if n, err := parsePageNormalization(newFormRequest(url.Values{"fit": {"fill"}})); n != nil || err != nil {
+		t.Errorf("expected no normalization, got %+v %v", n, err)
+	}
+	n, err := parsePageNormalization(newFormRequest(url.Values{"page_size": {"A4"}, "margin": {"10"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n.Width) != 595 || n.Orientation != "auto" || n.Fit != ImageFit || int(n.Margin) != 28 {
+		t.Errorf("unexpected normalization: %+v", n)
+	}
+
+	invalid := []url.Values{
+		{"page_size": {"A9"}},
+		{"page_size": {"A4"}, "orientation": {"X"}},
+		{"page_size": {"A4"}, "fit": {"actual"}},
+		{"page_size": {"A4"}, "margin": {"200"}},
+	}
+	for _, form := range invalid {
+		if _, err = parsePageNormalization(newFormRequest(form)); err == nil {
+			t.Errorf("expected error for %v", form)
+		}
+	}
+}
+
+func Test_PageMatrix(t *testing.T) {
+	n := &PageNormalization{Width: 595.28, Height: 841.89, Orientation: "auto", Fit: ImageFit}
+	page := pdfcpu.Dict{"MediaBox": pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Float(419.53), pdfcpu.Float(595.28)}}
+	ctx, err := pdfcpu.CreateContextWithXRefTable(nil, pdfcpu.PaperSize["A4"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A5 旋转 90 度后为横向，按 auto 放在横向的 A4 上
+	info := PageInfo{Width: 419.53, Height: 595.28, Rotation: 90}
+	m, width, height := n.pageMatrix(ctx.XRefTable, page, info)
+	if m == nil || width < height {
+		t.Fatalf("unexpected target: %v %g x %g", m, width, height)
+	}
+	// 原页面的左下角（旋转后位于左上角）及右上角
+	x, y := m.transform(0, 0)
+	if math.Abs(x-(width-595.28*math.Sqrt2)/2) > 1 || math.Abs(y-height) > 1 {
+		t.Errorf("unexpected corner: %g, %g", x, y)
+	}
+	x, y = m.transform(419.53, 595.28)
+	if math.Abs(x-(width+595.28*math.Sqrt2)/2) > 1 || math.Abs(y) > 1 {
+		t.Errorf("unexpected corner: %g, %g", x, y)
+	}
+
+	info = PageInfo{Width: 841.89, Height: 595.28, Rotation: 0}
+	if m, _, _ = n.pageMatrix(ctx.XRefTable, page, info); m != nil {
+		t.Errorf("landscape A4 should be left untouched: %v", m)
+	}
+}
+
+func Test_NormalizePDFFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mixed.pdf")
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.SetFont("Helvetica", "", 12)
+	pdf.AddPage()
+	pdf.Text(50, 50, "A4")
+	pdf.AddPageFormat("P", gofpdf.SizeType{Wd: 612, Ht: 792})
+	pdf.Text(50, 50, "Letter")
+	pdf.LinkString(100, 100, 200, 50, "https://example.com")
+	if err := pdf.OutputFileAndClose(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPDFOutline(file, []*OutlineItem{{Title: "Letter", Level: 1, Page: 2, Top: 0}}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _, _ := before.PageDict(1)
+	contents := first["Contents"]
+
+	n := &PageNormalization{Width: 595.28, Height: 841.89, Orientation: "auto", Fit: ImageFit}
+	if err = NormalizePDFFile(file, n); err != nil {
+		t.Fatal(err)
+	}
+	if err = api.ValidateFile(file, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := contextPageInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, page := range info {
+		if math.Abs(page.Width-595.28) > 1 || math.Abs(page.Height-841.89) > 1 {
+			t.Errorf("page %d not normalized: %+v", i+1, page)
+		}
+	}
+	page, _, _ := ctx.PageDict(1)
+	if _, ok := page["Contents"].(pdfcpu.Array); ok || page["Contents"].String() != contents.String() {
+		t.Errorf("matching page was modified: %v", page["Contents"])
+	}
+
+	// Letter 页面宽度缩放为 595.28 / 612，链接随之移动
+	page, _, _ = ctx.PageDict(2)
+	annots, err := ctx.DereferenceArray(page["Annots"])
+	if err != nil || len(annots) != 1 {
+		t.Fatalf("unexpected annotations: %v %v", annots, err)
+	}
+	annot, _ := ctx.DereferenceDict(annots[0])
+	rect, _ := ctx.DereferenceArray(annot["Rect"])
+	left, _ := ctx.DereferenceNumber(rect[0])
+	if math.Abs(left-100*595.28/612) > 1 {
+		t.Errorf("unexpected link position: %v", rect)
+	}
+
+	items, err := contextOutline(ctx)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("unexpected outline: %v %v", items, err)
+	}
+	// 缩放后内容上下居中，顶部留白
+	if expected := (841.89 - 792*595.28/612) / 2; math.Abs(items[0].Top-expected) > 1 {
+		t.Errorf("unexpected outline top: %g, expected %g", items[0].Top, expected)
+	}
+}
@@ -0,0 +1,553 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"golang.org/x/image/draw"
+)
+
+// 未设置 jpeg_quality 时，降采样后的 JPEG 图片使用的质量
+const defaultJPEGQuality = 85
+
+// 输出前压缩 PDF：去掉重复的字体及图片，压缩未压缩的流，
+// ImageDPI 大于 0 时把超过该分辨率的图片降采样，JPEGQuality 大于 0 时图片重新以该质量的 JPEG 编码
+type Optimization struct {
+	ImageDPI    int
+	JPEGQuality int
+
+	// 处理前后的文件大小（字节），多个文件时累加
+	OriginalSize  int64
+	OptimizedSize int64
+}
+
+// 读取压缩参数：optimize、image_dpi、jpeg_quality，设置 image_dpi 或 jpeg_quality 时同样启用压缩
+func parseOptimization(request *http.Request) (*Optimization, error) {
+	o := &Optimization{}
+	enabled := parseBool(request.FormValue("optimize"))
+	if value := request.FormValue("image_dpi"); len(value) > 0 {
+		dpi, err := strconv.Atoi(value)
+		if err != nil || dpi < 0 {
+			return nil, fmt.Errorf("invalid image_dpi: %s", value)
+		}
+		o.ImageDPI = dpi
+		enabled = true
+	}
+	if value := request.FormValue("jpeg_quality"); len(value) > 0 {
+		quality, err := strconv.Atoi(value)
+		if err != nil || quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("invalid jpeg_quality: %s", value)
+		}
+		o.JPEGQuality = quality
+		enabled = true
+	}
+	if !enabled {
+		return nil, nil
+	}
+	return o, nil
+}
+
+// 在响应头中返回压缩前后的文件大小
+func setOptimizationHeaders(writer http.ResponseWriter, output *OutputOptions) {
+	if output == nil || output.Optimization == nil || output.Optimization.OriginalSize == 0 {
+		return
+	}
+	writer.Header().Set("X-Original-Size", strconv.FormatInt(output.Optimization.OriginalSize, 10))
+	writer.Header().Set("X-Optimized-Size", strconv.FormatInt(output.Optimization.OptimizedSize, 10))
+}
+
+// 压缩 PDF 文件（原地修改），返回处理前后的文件大小。压缩后反而变大时保留原文件
+func OptimizePDFFile(pdf_path string, o *Optimization) (int64, int64, error) {
+	stat, err := os.Stat(pdf_path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before := stat.Size()
+
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return before, before, err
+	}
+	if ctx.Encrypt != nil {
+		return before, before, fmt.Errorf("optimizing an encrypted pdf is not supported")
+	}
+	// 重复的字体及图片改为引用同一个对象，未被引用的对象在写文件时丢弃
+	if err = pdfcpu.OptimizeXRefTable(ctx); err != nil {
+		return before, before, err
+	}
+	if o.ImageDPI > 0 || o.JPEGQuality > 0 {
+		usage, err := contextImageUsage(ctx)
+		if err != nil {
+			return before, before, err
+		}
+		for objNr, size := range usage {
+			if err = optimizeImage(ctx, objNr, size, o); err != nil {
+				return before, before, err
+			}
+		}
+	}
+	if err = compressStreams(ctx); err != nil {
+		return before, before, err
+	}
+
+	var b bytes.Buffer
+	if err = writePDFContextTo(ctx, &b); err != nil {
+		return before, before, err
+	}
+	if int64(b.Len()) >= before {
+		return before, before, nil
+	}
+	if err = os.WriteFile(pdf_path, b.Bytes(), 0644); err != nil {
+		return before, before, err
+	}
+	return before, int64(b.Len()), nil
+}
+
+// 用 FlateDecode 压缩没有任何过滤器的流，XMP 元数据保持明文
+func compressStreams(ctx *pdfcpu.Context) error {
+	for _, entry := range ctx.Table {
+		if entry == nil || entry.Free || entry.Object == nil {
+			continue
+		}
+		sd, ok := entry.Object.(pdfcpu.StreamDict)
+		if !ok || sd.FilterPipeline != nil || len(sd.Raw) == 0 {
+			continue
+		}
+		if _, found := sd.Find("Filter"); found {
+			continue
+		}
+		if t := sd.Type(); t != nil && (*t == "Metadata" || *t == "XRef" || *t == "ObjStm") {
+			continue
+		}
+		compressed, err := newFlateStream(copyDict(sd.Dict), sd.Raw)
+		if err != nil {
+			return err
+		}
+		if len(compressed.Raw) < len(sd.Raw) {
+			entry.Object = *compressed
+		}
+	}
+	return nil
+}
+
+// 每个图片对象在页面上的最大显示尺寸（点），分别为图片 x、y 方向
+type imageUsage map[int]*[2]float64
+
+// 分析所有页面的内容流，得到图片的显示尺寸。未在页面上显示的图片不在结果中
+func contextImageUsage(ctx *pdfcpu.Context) (imageUsage, error) {
+	usage := make(imageUsage)
+	for i := 1; i <= ctx.PageCount; i++ {
+		page, _, err := ctx.PageDict(i)
+		if err != nil {
+			return nil, err
+		}
+		resources, err := ctx.DereferenceDict(inheritedPageEntry(ctx.XRefTable, page, "Resources"))
+		if err != nil || resources == nil {
+			continue
+		}
+		content, err := pageContentData(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		usage.scan(ctx, resources, content, pdfMatrix{1, 0, 0, 1, 0, 0}, make(map[int]bool))
+	}
+	return usage, nil
+}
+
+// 页面的全部内容流（解码后）
+func pageContentData(ctx *pdfcpu.Context, page pdfcpu.Dict) ([]byte, error) {
+	refs := make([]pdfcpu.IndirectRef, 0)
+	switch contents := page["Contents"].(type) {
+	case pdfcpu.IndirectRef:
+		o, err := ctx.Dereference(contents)
+		if err != nil {
+			return nil, err
+		}
+		if a, ok := o.(pdfcpu.Array); ok {
+			for _, item := range a {
+				if ref, ok := item.(pdfcpu.IndirectRef); ok {
+					refs = append(refs, ref)
+				}
+			}
+		} else {
+			refs = append(refs, contents)
+		}
+	case pdfcpu.Array:
+		for _, item := range contents {
+			if ref, ok := item.(pdfcpu.IndirectRef); ok {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	for _, ref := range refs {
+		data, err := pdfcpu.ExtractStreamData(ctx, ref.ObjectNumber.Value())
+		if err != nil {
+			return nil, err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+// 跟踪内容流中的变换矩阵，记录 Do 绘制的图片尺寸，表单对象递归处理
+func (usage imageUsage) scan(ctx *pdfcpu.Context, resources pdfcpu.Dict, content []byte, ctm pdfMatrix, visiting map[int]bool) {
+	xobjects, _ := ctx.DereferenceDict(resources["XObject"])
+	stack := make([]pdfMatrix, 0)
+	for _, op := range parseContentOps(content) {
+		switch op.Name {
+		case "q":
+			stack = append(stack, ctm)
+		case "Q":
+			if len(stack) > 0 {
+				ctm = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "cm":
+			if m, ok := operandMatrix(op.Operands); ok {
+				ctm = m.multiply(ctm)
+			}
+		case "Do":
+			if len(op.Operands) == 0 || xobjects == nil || !strings.HasPrefix(op.Operands[len(op.Operands)-1], "/") {
+				continue
+			}
+			ref, ok := xobjects[op.Operands[len(op.Operands)-1][1:]].(pdfcpu.IndirectRef)
+			if !ok {
+				continue
+			}
+			objNr := ref.ObjectNumber.Value()
+			sd, err := ctx.DereferenceStreamDict(ref)
+			if err != nil || sd == nil {
+				continue
+			}
+			switch subtype := sd.Subtype(); {
+			case subtype != nil && *subtype == "Image":
+				size := usage[objNr]
+				if size == nil {
+					size = &[2]float64{}
+					usage[objNr] = size
+				}
+				size[0] = math.Max(size[0], math.Hypot(ctm[0], ctm[1]))
+				size[1] = math.Max(size[1], math.Hypot(ctm[2], ctm[3]))
+			case subtype != nil && *subtype == "Form" && !visiting[objNr] && len(visiting) < 16:
+				data, err := pdfcpu.ExtractStreamData(ctx, objNr)
+				if err != nil || data == nil {
+					continue
+				}
+				m := pdfMatrix{1, 0, 0, 1, 0, 0}
+				if a, err := ctx.DereferenceArray(sd.Dict["Matrix"]); err == nil && len(a) == 6 {
+					for i, v := range a {
+						m[i], _ = ctx.DereferenceNumber(v)
+					}
+				}
+				formResources := resources
+				if d, err := ctx.DereferenceDict(sd.Dict["Resources"]); err == nil && d != nil {
+					formResources = d
+				}
+				visiting[objNr] = true
+				usage.scan(ctx, formResources, data, m.multiply(ctm), visiting)
+				delete(visiting, objNr)
+			}
+		}
+	}
+}
+
+func operandMatrix(operands []string) (pdfMatrix, bool) {
+	var m pdfMatrix
+	if len(operands) < 6 {
+		return m, false
+	}
+	for i, s := range operands[len(operands)-6:] {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return m, false
+		}
+		m[i] = v
+	}
+	return m, true
+}
+
+type contentOp struct {
+	Name     string
+	Operands []string
+}
+
+func isContentSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isContentDelimiter(c byte) bool {
+	return isContentSpace(c) || bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+// 把内容流拆分为操作符及其操作数，字符串、数组等操作数按原文保留，内嵌图片的数据被跳过
+func parseContentOps(data []byte) []contentOp {
+	ops := make([]contentOp, 0)
+	operands := make([]string, 0)
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case isContentSpace(c):
+			i++
+		case c == '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			start, depth := i, 0
+			for ; i < len(data); i++ {
+				if data[i] == '\\' {
+					i++
+				} else if data[i] == '(' {
+					depth++
+				} else if data[i] == ')' {
+					if depth--; depth == 0 {
+						i++
+						break
+					}
+				}
+			}
+			if i > len(data) {
+				i = len(data)
+			}
+			operands = append(operands, string(data[start:i]))
+		case c == '<' && i+1 < len(data) && data[i+1] == '<', c == '>' && i+1 < len(data) && data[i+1] == '>':
+			operands = append(operands, string(data[i:i+2]))
+			i += 2
+		case c == '<':
+			start := i
+			for i < len(data) && data[i] != '>' {
+				i++
+			}
+			if i < len(data) {
+				i++
+			}
+			operands = append(operands, string(data[start:i]))
+		case c == '[' || c == ']' || c == '{' || c == '}' || c == '>' || c == ')':
+			operands = append(operands, string(c))
+			i++
+		case c == '/':
+			start := i
+			for i++; i < len(data) && !isContentDelimiter(data[i]); i++ {
+			}
+			operands = append(operands, string(data[start:i]))
+		default:
+			start := i
+			for ; i < len(data) && !isContentDelimiter(data[i]); i++ {
+			}
+			token := string(data[start:i])
+			if _, err := strconv.ParseFloat(token, 64); err == nil || token == "true" || token == "false" || token == "null" {
+				operands = append(operands, token)
+				continue
+			}
+			if token == "ID" {
+				// 内嵌图片的数据直到空白后的 EI
+				i = skipInlineImage(data, i)
+				token = "EI"
+			}
+			ops = append(ops, contentOp{Name: token, Operands: operands})
+			operands = make([]string, 0)
+		}
+	}
+	return ops
+}
+
+func skipInlineImage(data []byte, i int) int {
+	for i++; i+2 <= len(data); i++ {
+		if data[i] == 'E' && data[i+1] == 'I' && isContentSpace(data[i-1]) &&
+			(i+2 == len(data) || isContentDelimiter(data[i+2])) {
+			return i + 2
+		}
+	}
+	return len(data)
+}
+
+// 按显示尺寸降采样图片，或者重新以 JPEG 编码。只处理 8 位的灰度及 RGB 图片，结果没有变小时保留原图
+func optimizeImage(ctx *pdfcpu.Context, objNr int, size *[2]float64, o *Optimization) error {
+	entry, ok := ctx.FindTableEntryLight(objNr)
+	if !ok || entry.Object == nil {
+		return nil
+	}
+	sd, ok := entry.Object.(pdfcpu.StreamDict)
+	if !ok {
+		return nil
+	}
+	if mask := sd.BooleanEntry("ImageMask"); mask != nil && *mask {
+		return nil
+	}
+	// 颜色键蒙版在插值后不再准确
+	if mask, _ := ctx.Dereference(sd.Dict["Mask"]); mask != nil {
+		if _, ok := mask.(pdfcpu.Array); ok {
+			return nil
+		}
+	}
+	if bpc := sd.IntEntry("BitsPerComponent"); bpc == nil || *bpc != 8 {
+		return nil
+	}
+	width, height := sd.IntEntry("Width"), sd.IntEntry("Height")
+	if width == nil || height == nil || *width <= 0 || *height <= 0 {
+		return nil
+	}
+	components := imageComponents(ctx.XRefTable, sd.Dict["ColorSpace"])
+	if components == 0 {
+		return nil
+	}
+
+	isJPEG := len(sd.FilterPipeline) == 1 && sd.FilterPipeline[0].Name == "DCTDecode"
+	img, err := decodePDFImage(ctx, objNr, sd, *width, *height, components, isJPEG)
+	if err != nil || img == nil {
+		// 无法解码的图片保持不变
+		return nil
+	}
+
+	target := img
+	if o.ImageDPI > 0 && size[0] > 0 && size[1] > 0 {
+		scale := math.Max(size[0]/72*float64(o.ImageDPI)/float64(*width), size[1]/72*float64(o.ImageDPI)/float64(*height))
+		if scale < 1 {
+			w := int(math.Max(1, math.Ceil(float64(*width)*scale)))
+			h := int(math.Max(1, math.Ceil(float64(*height)*scale)))
+			var dst draw.Image
+			if components == 1 {
+				dst = image.NewGray(image.Rect(0, 0, w, h))
+			} else {
+				dst = image.NewRGBA(image.Rect(0, 0, w, h))
+			}
+			draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+			target = dst
+		}
+	}
+	if target == img && o.JPEGQuality == 0 {
+		return nil
+	}
+
+	d := copyDict(sd.Dict)
+	d.Update("Width", pdfcpu.Integer(target.Bounds().Dx()))
+	d.Update("Height", pdfcpu.Integer(target.Bounds().Dy()))
+	d.Delete("DecodeParms")
+	var replacement *pdfcpu.StreamDict
+	if isJPEG || o.JPEGQuality > 0 {
+		quality := o.JPEGQuality
+		if quality == 0 {
+			quality = defaultJPEGQuality
+		}
+		var b bytes.Buffer
+		if err = jpeg.Encode(&b, target, &jpeg.Options{Quality: quality}); err != nil {
+			return err
+		}
+		length := int64(b.Len())
+		d.Update("Filter", pdfcpu.Name("DCTDecode"))
+		d.Update("Length", pdfcpu.Integer(length))
+		stream := pdfcpu.NewStreamDict(d, 0, &length, nil, []pdfcpu.PDFFilter{{Name: "DCTDecode"}})
+		stream.Raw = b.Bytes()
+		replacement = &stream
+	} else {
+		if replacement, err = newFlateStream(d, imagePixels(target, components)); err != nil {
+			return err
+		}
+	}
+	if len(replacement.Raw) >= len(sd.Raw) {
+		return nil
+	}
+	entry.Object = *replacement
+	return nil
+}
+
+// 图片颜色空间的分量数，只支持 DeviceGray、DeviceRGB 及对应的 ICCBased，其他返回 0
+func imageComponents(xRefTable *pdfcpu.XRefTable, cs pdfcpu.Object) int {
+	o, err := xRefTable.Dereference(cs)
+	if err != nil {
+		return 0
+	}
+	switch cs := o.(type) {
+	case pdfcpu.Name:
+		switch cs {
+		case "DeviceGray":
+			return 1
+		case "DeviceRGB":
+			return 3
+		}
+	case pdfcpu.Array:
+		if len(cs) == 2 {
+			if name, ok := cs[0].(pdfcpu.Name); ok && name == "ICCBased" {
+				profile, err := xRefTable.DereferenceStreamDict(cs[1])
+				if err != nil || profile == nil {
+					return 0
+				}
+				if n := profile.IntEntry("N"); n != nil && (*n == 1 || *n == 3) {
+					return *n
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// 解码图片对象，不支持的过滤器返回 nil
+func decodePDFImage(ctx *pdfcpu.Context, objNr int, sd pdfcpu.StreamDict, width, height, components int, isJPEG bool) (image.Image, error) {
+	if isJPEG {
+		img, err := jpeg.Decode(bytes.NewReader(sd.Raw))
+		if err != nil {
+			return nil, err
+		}
+		switch img.(type) {
+		case *image.Gray, *image.YCbCr:
+			if img.Bounds().Dx() == width && img.Bounds().Dy() == height {
+				return img, nil
+			}
+		}
+		return nil, nil
+	}
+
+	data, err := pdfcpu.ExtractStreamData(ctx, objNr)
+	if err != nil || data == nil || len(data) < width*height*components {
+		return nil, err
+	}
+	if components == 1 {
+		img := image.NewGray(image.Rect(0, 0, width, height))
+		copy(img.Pix, data)
+		return img, nil
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, j := 0, 0; i < width*height*3; i, j = i+3, j+4 {
+		img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = data[i], data[i+1], data[i+2], 0xff
+	}
+	return img, nil
+}
+
+// 图片的像素数据，每个分量 8 位
+func imagePixels(img image.Image, components int) []byte {
+	bounds := img.Bounds()
+	if gray, ok := img.(*image.Gray); ok && components == 1 {
+		return gray.Pix
+	}
+	data := make([]byte, 0, bounds.Dx()*bounds.Dy()*components)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if components == 1 {
+				data = append(data, byte(r>>8))
+			} else {
+				data = append(data, byte(r>>8), byte(g>>8), byte(b>>8))
+			}
+		}
+	}
+	return data
+}
+
+func copyDict(d pdfcpu.Dict) pdfcpu.Dict {
+	result := pdfcpu.NewDict()
+	for key, value := range d {
+		result[key] = value
+	}
+	return result
+}
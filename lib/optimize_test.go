@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func Test_ParseOptimization(t *testing.T) {
+	if o, err := parseOptimization(newFormRequest(url.Values{"optimize": {"false"}})); o != nil || err != nil {
+		t.Errorf("expected no optimization, got %+v %v", o, err)
+	}
+	o, err := parseOptimization(newFormRequest(url.Values{"image_dpi": {"150"}}))
+	if err != nil || o == nil || o.ImageDPI != 150 || o.JPEGQuality != 0 {
+		t.Errorf("unexpected optimization: %+v %v", o, err)
+	}
+	for _, form := range []url.Values{{"image_dpi": {"-1"}}, {"jpeg_quality": {"0"}}, {"jpeg_quality": {"101"}}} {
+		if _, err = parseOptimization(newFormRequest(form)); err == nil {
+			t.Errorf("expected error for %v", form)
+		}
+	}
+}
+
+func Test_ParseContentOps(t *testing.T) {
+	content := "q 100 0 0 50 10 20 cm /Im1 Do Q\n" +
+		"BT (a \\) (b) Do) Tj ET\n" +
+		"BI /W 2 /H 1 /BPC 8 /CS /G ID \x01\x02EI\x03 EI\n" +
+		"% 注释 cm\n<< /MCID 0 >> BDC [1 2] 0 d EMC"
+	names := make([]string, 0)
+	for _, op := range parseContentOps([]byte(content)) {
+		names = append(names, op.Name)
+	}
+	expected := []string{"q", "cm", "Do", "Q", "BT", "Tj", "ET", "BI", "EI", "BDC", "d", "EMC"}
+	if len(names) != len(expected) {
+		t.Fatalf("unexpected operators: %v", names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("unexpected operators: %v", names)
+		}
+	}
+	if m, ok := operandMatrix(parseContentOps([]byte(content))[1].Operands); !ok || m[0] != 100 || m[5] != 20 {
+		t.Errorf("unexpected matrix: %v", m)
+	}
+}
+
+// 写入一页 PDF，把 2000x1000 像素的图片以 200x100 点显示（720 DPI）
+func writeImageTestPDF(t *testing.T, file string, format string) {
+	img := image.NewRGBA(image.Rect(0, 0, 2000, 1000))
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 2000; x++ {
+			img.Set(x, y, color.RGBA{uint8(x / 8), uint8(y / 4), uint8((x + y) % 256), 0xff})
+		}
+	}
+	var b bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&b, img)
+	} else {
+		err = jpeg.Encode(&b, img, &jpeg.Options{Quality: 95})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.AddPage()
+	pdf.RegisterImageOptionsReader("scan", gofpdf.ImageOptions{ImageType: format}, &b)
+	pdf.ImageOptions("scan", 50, 50, 200, 100, false, gofpdf.ImageOptions{}, 0, "")
+	if err = pdf.OutputFileAndClose(file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func imageSizes(t *testing.T, file string) [][2]int {
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := make([][2]int, 0)
+	for _, entry := range ctx.Table {
+		if sd, ok := entry.Object.(pdfcpu.StreamDict); ok {
+			if subtype := sd.Subtype(); subtype != nil && *subtype == "Image" {
+				sizes = append(sizes, [2]int{*sd.IntEntry("Width"), *sd.IntEntry("Height")})
+			}
+		}
+	}
+	return sizes
+}
+
+func Test_OptimizePDFFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, format := range []string{"jpg", "png"} {
+		file := filepath.Join(dir, "scan-"+format+".pdf")
+		writeImageTestPDF(t, file, format)
+
+		o := &Optimization{ImageDPI: 144}
+		if format == "png" {
+			o.JPEGQuality = 80
+		}
+		before, after, err := OptimizePDFFile(file, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat, _ := os.Stat(file); after >= before || stat.Size() != after {
+			t.Errorf("%s: unexpected sizes %d -> %d", format, before, after)
+		}
+		if err = api.ValidateFile(file, newPDFConfig()); err != nil {
+			t.Fatal(err)
+		}
+		// 200 点宽为 400 像素
+		if sizes := imageSizes(t, file); len(sizes) != 1 || sizes[0] != [2]int{400, 200} {
+			t.Errorf("%s: unexpected images: %v", format, sizes)
+		}
+	}
+
+	// 不降采样时图片保持不变
+	file := filepath.Join(dir, "keep.pdf")
+	writeImageTestPDF(t, file, "jpg")
+	if _, _, err := OptimizePDFFile(file, &Optimization{}); err != nil {
+		t.Fatal(err)
+	}
+	if sizes := imageSizes(t, file); len(sizes) != 1 || sizes[0] != [2]int{2000, 1000} {
+		t.Errorf("unexpected images: %v", sizes)
+	}
+}
@@ -0,0 +1,370 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// PDF 书签，Top 为目标位置距页面顶部的距离（点）
+type OutlineItem struct {
+	Title    string         `json:"title"`
+	Level    int            `json:"level"`
+	Page     int            `json:"page"`
+	Top      float64        `json:"top"`
+	Children []*OutlineItem `json:"children,omitempty"`
+}
+
+// 按层级把标题列表整理为树，跳级的标题挂在最近的上级下面
+func buildOutlineTree(flat []*OutlineItem) []*OutlineItem {
+	roots := make([]*OutlineItem, 0)
+	stack := make([]*OutlineItem, 0)
+	for _, item := range flat {
+		for len(stack) > 0 && stack[len(stack)-1].Level >= item.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, item)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, item)
+		}
+		stack = append(stack, item)
+	}
+	return roots
+}
+
+// 渲染脚本输出的元素位置（点），Top、Left 相对所在页面的左上角（读取时按分页信息由文档坐标换算），
+// Key 为元素的 data-toc-entry 属性
+type renderedElement struct {
+	Title  string  `json:"title"`
+	Level  int     `json:"level"`
+	Page   int     `json:"page"`
+	Top    float64 `json:"top"`
+	Left   float64 `json:"left"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	Key    string  `json:"key"`
+}
+
+func readRenderedElements(file string) ([]*renderedElement, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Layout   *printLayout       `json:"layout"`
+		Elements []*renderedElement `json:"elements"`
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid rendered elements: %v", err)
+	}
+	if err = result.Layout.validate(); err != nil {
+		return nil, err
+	}
+	shifts := result.Layout.shifts()
+	list := make([]*renderedElement, 0, len(result.Elements))
+	for _, el := range result.Elements {
+		el.Page, el.Top = result.Layout.locate(shifts, el.Top, el.Height)
+		list = append(list, el)
+	}
+	return list, nil
+}
+
+// 根据渲染脚本输出的标题位置生成书签
+func renderedOutline(elements []*renderedElement) []*OutlineItem {
+	flat := make([]*OutlineItem, 0, len(elements))
+	for _, el := range elements {
+		flat = append(flat, &OutlineItem{Title: el.Title, Level: el.Level, Page: el.Page, Top: el.Top})
+	}
+	return buildOutlineTree(flat)
+}
+
+// 设置 PDF 文件的书签（原地修改），替换原有的书签
+func SetPDFOutline(pdf_path string, items []*OutlineItem) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	if err = setContextOutline(ctx, items); err != nil {
+		return err
+	}
+	return writePDFContext(ctx, pdf_path)
+}
+
+func setContextOutline(ctx *pdfcpu.Context, items []*OutlineItem) error {
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		root.Delete("Outlines")
+		return nil
+	}
+
+	pages := make([]pdfcpu.IndirectRef, ctx.PageCount)
+	heights := make([]float64, ctx.PageCount)
+	for i := range pages {
+		ref, err := pageRef(ctx, i+1)
+		if err != nil {
+			return err
+		}
+		pages[i] = *ref
+		page, _, err := ctx.PageDict(i + 1)
+		if err != nil {
+			return err
+		}
+		heights[i] = pageInfo(ctx.XRefTable, page).Height
+	}
+
+	outlines := pdfcpu.Dict{"Type": pdfcpu.Name("Outlines")}
+	outlinesRef, err := ctx.IndRefForNewObject(outlines)
+	if err != nil {
+		return err
+	}
+	first, last, err := addOutlineItems(ctx, *outlinesRef, items, pages, heights)
+	if err != nil {
+		return err
+	}
+	outlines.Insert("First", *first)
+	outlines.Insert("Last", *last)
+	outlines.Insert("Count", pdfcpu.Integer(len(items)))
+
+	root.Update("Outlines", *outlinesRef)
+	root.Update("PageMode", pdfcpu.Name("UseOutlines"))
+	return nil
+}
+
+// 生成同一层级的书签，下级书签默认折叠
+func addOutlineItems(ctx *pdfcpu.Context, parent pdfcpu.IndirectRef, items []*OutlineItem,
+	pages []pdfcpu.IndirectRef, heights []float64) (*pdfcpu.IndirectRef, *pdfcpu.IndirectRef, error) {
+	var first, prev *pdfcpu.IndirectRef
+	var prevDict pdfcpu.Dict
+	for _, item := range items {
+		n := item.Page
+		if n < 1 {
+			n = 1
+		} else if n > len(pages) {
+			n = len(pages)
+		}
+		top := heights[n-1] - item.Top
+		if top < 0 {
+			top = 0
+		}
+
+		d := pdfcpu.Dict{
+			"Title":  pdfTextString(item.Title),
+			"Parent": parent,
+			"Dest":   pdfcpu.Array{pages[n-1], pdfcpu.Name("FitH"), pdfcpu.Float(top)},
+		}
+		ref, err := ctx.IndRefForNewObject(d)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(item.Children) > 0 {
+			childFirst, childLast, err := addOutlineItems(ctx, *ref, item.Children, pages, heights)
+			if err != nil {
+				return nil, nil, err
+			}
+			d.Insert("First", *childFirst)
+			d.Insert("Last", *childLast)
+			d.Insert("Count", pdfcpu.Integer(-len(item.Children)))
+		}
+
+		if prev == nil {
+			first = ref
+		} else {
+			prevDict.Insert("Next", *ref)
+			d.Insert("Prev", *prev)
+		}
+		prev, prevDict = ref, d
+	}
+	return first, prev, nil
+}
+
+// 读取 PDF 中已有的书签，无法解析目标的书签指向第一页
+func contextOutline(ctx *pdfcpu.Context) ([]*OutlineItem, error) {
+	root, err := ctx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	outlines, err := ctx.DereferenceDict(root["Outlines"])
+	if err != nil || outlines == nil {
+		return nil, err
+	}
+
+	pageNumbers := make(map[int]int)
+	for i := 1; i <= ctx.PageCount; i++ {
+		ref, err := pageRef(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+		pageNumbers[ref.ObjectNumber.Value()] = i
+	}
+
+	visited := make(map[int]bool)
+	var walk func(o pdfcpu.Object, level int) ([]*OutlineItem, error)
+	walk = func(o pdfcpu.Object, level int) ([]*OutlineItem, error) {
+		items := make([]*OutlineItem, 0)
+		for o != nil {
+			ref, ok := o.(pdfcpu.IndirectRef)
+			if !ok || visited[ref.ObjectNumber.Value()] {
+				break
+			}
+			visited[ref.ObjectNumber.Value()] = true
+			d, err := ctx.DereferenceDict(ref)
+			if err != nil || d == nil {
+				return items, err
+			}
+			title, _ := ctx.DereferenceText(d["Title"])
+			item := &OutlineItem{Title: title, Level: level}
+			item.Page, item.Top = outlineTarget(ctx, d, pageNumbers)
+			if item.Children, err = walk(d["First"], level+1); err != nil {
+				return items, err
+			}
+			items = append(items, item)
+			o = d["Next"]
+		}
+		return items, nil
+	}
+	return walk(outlines["First"], 1)
+}
+
+// 书签的目标页码及位置，支持 Dest 数组及 GoTo 动作
+func outlineTarget(ctx *pdfcpu.Context, d pdfcpu.Dict, pageNumbers map[int]int) (int, float64) {
+	dest := d["Dest"]
+	if dest == nil {
+		if action, err := ctx.DereferenceDict(d["A"]); err == nil && action != nil {
+			dest = action["D"]
+		}
+	}
+	a, err := ctx.DereferenceArray(dest)
+	if err != nil || len(a) == 0 {
+		return 1, 0
+	}
+	ref, ok := a[0].(pdfcpu.IndirectRef)
+	if !ok {
+		return 1, 0
+	}
+	n, ok := pageNumbers[ref.ObjectNumber.Value()]
+	if !ok {
+		return 1, 0
+	}
+
+	// [page /XYZ left top zoom]、[page /FitH top] 或 [page /FitBH top]
+	index := 0
+	if len(a) < 2 {
+		return n, 0
+	}
+	if name, ok := a[1].(pdfcpu.Name); ok {
+		switch name {
+		case "XYZ":
+			index = 3
+		case "FitH", "FitBH":
+			index = 2
+		}
+	}
+	var top float64
+	if index > 0 && index < len(a) {
+		if value, err := ctx.DereferenceNumber(a[index]); err == nil {
+			page, _, _ := ctx.PageDict(n)
+			if top = pageInfo(ctx.XRefTable, page).Height - value; top < 0 {
+				top = 0
+			}
+		}
+	}
+	return n, top
+}
+
+// 合并接口的书签参数：outline 为 true 或设置了 bookmark（与 file 一一对应的书签标题）时生成书签
+func parseCombineOutline(request *http.Request) (bool, []string) {
+	titles := request.Form["bookmark"]
+	return parseBool(request.FormValue("outline")) || len(titles) > 0, titles
+}
+
+// 合并时为每个源文件生成一个顶级书签，源文件原有的书签放在其下。
+// 书签标题依次使用 titles 中的值、文档标题、文件名。leading 为每个文件中文档之前的分隔页及空白页数（可以为空），
+// 顶级书签指向文档的第一页而不是分隔页
+func CombineOutline(files []string, names []string, titles []string, leading []int) ([]*OutlineItem, error) {
+	items := make([]*OutlineItem, 0, len(files))
+	offset := 0
+	for i, file := range files {
+		ctx, err := readPDFContext(file)
+		if err != nil {
+			return nil, err
+		}
+		children, err := contextOutline(ctx)
+		if err != nil {
+			return nil, err
+		}
+		shiftOutline(children, offset, 2)
+
+		title := ""
+		if i < len(titles) {
+			title = strings.TrimSpace(titles[i])
+		}
+		if len(title) == 0 {
+			if meta, err := contextMetadata(ctx); err == nil {
+				title = strings.TrimSpace(meta.Title)
+			}
+		}
+		if len(title) == 0 && i < len(names) {
+			title = documentName(names[i])
+		}
+		if len(title) == 0 {
+			title = fmt.Sprintf("Document %d", i+1)
+		}
+
+		first := offset + 1
+		if i < len(leading) {
+			first += leading[i]
+		}
+		items = append(items, &OutlineItem{Title: title, Level: 1, Page: first, Children: children})
+		offset += ctx.PageCount
+	}
+	return items, nil
+}
+
+// 为合并后的文件生成书签，files 为合并前的本地文件，names 为对应的原始地址
+func combineOutline(pdf_path string, files []string, names []string, titles []string, leading []int) error {
+	items, err := CombineOutline(files, names, titles, leading)
+	if err != nil {
+		return err
+	}
+	return SetPDFOutline(pdf_path, items)
+}
+
+func shiftOutline(items []*OutlineItem, offset int, level int) {
+	for _, item := range items {
+		item.Page += offset
+		item.Level = level
+		shiftOutline(item.Children, offset, level+1)
+	}
+}
+
+// URL 或路径中的文件名（不含扩展名）
+func documentName(src string) string {
+	name := urlFileName(src)
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// URL 或路径中的文件名（包括扩展名）
+func urlFileName(src string) string {
+	if u, err := url.Parse(src); err == nil && len(u.Path) > 0 {
+		src = u.Path
+	}
+	name := path.Base(strings.ReplaceAll(src, "\\", "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return name
+}
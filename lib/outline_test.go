@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_BuildOutlineTree(t *testing.T) {
+	roots := buildOutlineTree([]*OutlineItem{
+		{Title: "1", Level: 1},
+		{Title: "1.1", Level: 2},
+		{Title: "1.1.1", Level: 4},
+		{Title: "1.2", Level: 2},
+		{Title: "2", Level: 1},
+		{Title: "2.1", Level: 3},
+	})
+	if len(roots) != 2 || len(roots[0].Children) != 2 || len(roots[1].Children) != 1 {
+		t.Fatalf("unexpected tree: %+v", roots)
+	}
+	if roots[0].Children[0].Children[0].Title != "1.1.1" || roots[0].Children[1].Title != "1.2" {
+		t.Errorf("unexpected children: %+v", roots[0].Children)
+	}
+}
+
+func Test_SetPDFOutline(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "outline.pdf")
+	writeTestPDF(t, file, 3)
+
+	items := buildOutlineTree([]*OutlineItem{
+		{Title: "概述", Level: 1, Page: 1, Top: 100},
+		{Title: "Details", Level: 2, Page: 2, Top: 50},
+		{Title: "Summary", Level: 1, Page: 3},
+	})
+	if err := SetPDFOutline(file, items); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := contextOutline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 || read[0].Title != "概述" || read[1].Page != 3 || len(read[0].Children) != 1 {
+		t.Fatalf("unexpected outline: %+v", read)
+	}
+	child := read[0].Children[0]
+	if child.Title != "Details" || child.Page != 2 || child.Level != 2 || int(child.Top+0.5) != 50 {
+		t.Errorf("unexpected child: %+v", child)
+	}
+}
+
+func Test_CombineOutline(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.pdf")
+	writeTestPDF(t, first, 2)
+	err := SetPDFOutline(first, []*OutlineItem{{Title: "Intro", Level: 1, Page: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(dir, "second.pdf")
+	writeTestPDF(t, second, 1)
+	if err = SetPDFMetadata(second, &Metadata{Title: "Appendix"}); err != nil {
+		t.Fatal(err)
+	}
+	third := filepath.Join(dir, "third.pdf")
+	writeTestPDF(t, third, 1)
+
+	names := []string{"http://example.com/a.pdf", "http://example.com/b.pdf", "http://example.com/files/Price%20List.pdf"}
+	items, err := CombineOutline([]string{first, second, third}, names, []string{"Report"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("unexpected item count: %d", len(items))
+	}
+	expected := []struct {
+		title string
+		page  int
+	}{{"Report", 1}, {"Appendix", 3}, {"Price List", 4}}
+	for i, item := range items {
+		if item.Title != expected[i].title || item.Page != expected[i].page {
+			t.Errorf("item %d: %s page %d", i, item.Title, item.Page)
+		}
+	}
+	if len(items[0].Children) != 1 || items[0].Children[0].Page != 2 || items[0].Children[0].Level != 2 {
+		t.Errorf("unexpected nested outline: %+v", items[0].Children)
+	}
+
+	merged := filepath.Join(dir, "merged.pdf")
+	if err = CombinePDF([]string{first, second, third}, merged); err != nil {
+		t.Fatal(err)
+	}
+	if err = combineOutline(merged, []string{first, second, third}, names, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := readPDFContext(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := contextOutline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 3 || read[0].Title != "a" || read[2].Page != 4 || read[0].Children[0].Page != 2 {
+		t.Errorf("unexpected merged outline: %+v", read)
+	}
+}
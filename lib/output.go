@@ -0,0 +1,368 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 输出 PDF 前的后处理参数，适用于渲染及合并接口
+type OutputOptions struct {
+	Stamps      []*Stamp
+	Letterhead  *Letterhead
+	Encryption  *Encryption
+	Signature   *Signature
+	Metadata    *Metadata
+	PageNumbers *PageNumbering
+	PageLabels  []*PageLabel
+
+	Optimization    *Optimization
+	Conformance     *Conformance
+	DisplayDocTitle bool // 阅读器标题栏显示文档标题而不是文件名
+	Invoice         *Invoice
+	Attachments     []*Attachment
+}
+
+// 信纸底图，放在页面内容之下。Even 不为空时 Src 用于奇数页，Even 用于偶数页；
+// Src、Even 可以是登记的名称、URL，或者上传的 PDF 文件
+type Letterhead struct {
+	Src   string
+	Even  string
+	Pages string // all 或 first
+
+	upload     *multipart.FileHeader
+	uploadEven *multipart.FileHeader
+}
+
+// 从请求参数中读取后处理参数，stamp 参数可以重复，每个值为一个 JSON 对象或数组
+func ParseOutputOptions(request *http.Request) (*OutputOptions, error) {
+	output := &OutputOptions{}
+
+	request.ParseMultipartForm(32 << 20)
+	for _, value := range request.Form["stamp"] {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			continue
+		}
+		if strings.HasPrefix(value, "[") {
+			list := make([]*Stamp, 0)
+			if err := json.Unmarshal([]byte(value), &list); err != nil {
+				return nil, fmt.Errorf("invalid stamp: %v", err)
+			}
+			output.Stamps = append(output.Stamps, list...)
+			continue
+		}
+		stamp := &Stamp{}
+		if err := json.Unmarshal([]byte(value), stamp); err != nil {
+			return nil, fmt.Errorf("invalid stamp: %v", err)
+		}
+		output.Stamps = append(output.Stamps, stamp)
+	}
+	for _, stamp := range output.Stamps {
+		if err := stamp.normalize(); err != nil {
+			return nil, err
+		}
+	}
+
+	letterhead, err := parseLetterhead(request)
+	if err != nil {
+		return nil, err
+	}
+	output.Letterhead = letterhead
+
+	encryption, err := parseEncryption(request)
+	if err != nil {
+		return nil, err
+	}
+	output.Encryption = encryption
+
+	signature, err := parseSignature(request)
+	if err != nil {
+		return nil, err
+	}
+	if signature != nil && encryption != nil {
+		return nil, fmt.Errorf("signing an encrypted pdf is not supported")
+	}
+	output.Signature = signature
+
+	metadata, err := parseMetadata(request)
+	if err != nil {
+		return nil, err
+	}
+	output.Metadata = metadata
+
+	numbering, labels, err := parsePageNumbering(request)
+	if err != nil {
+		return nil, err
+	}
+	output.PageNumbers = numbering
+	output.PageLabels = labels
+
+	optimization, err := parseOptimization(request)
+	if err != nil {
+		return nil, err
+	}
+	output.Optimization = optimization
+
+	conformance, err := parseConformance(request)
+	if err != nil {
+		return nil, err
+	}
+	if conformance != nil && encryption != nil {
+		return nil, fmt.Errorf("pdf/a does not allow encryption")
+	}
+	output.Conformance = conformance
+	output.DisplayDocTitle = parseBool(request.FormValue("display_doc_title"))
+	output.Attachments = parseAttachments(request)
+
+	return output, nil
+}
+
+func parseLetterhead(request *http.Request) (*Letterhead, error) {
+	letterhead := &Letterhead{
+		Src:   request.FormValue("letterhead"),
+		Even:  request.FormValue("letterhead_even"),
+		Pages: strings.ToLower(request.FormValue("letterhead_pages")),
+	}
+	if request.MultipartForm != nil {
+		if files := request.MultipartForm.File["letterhead"]; len(files) > 0 {
+			letterhead.upload = files[0]
+		}
+		if files := request.MultipartForm.File["letterhead_even"]; len(files) > 0 {
+			letterhead.uploadEven = files[0]
+		}
+	}
+
+	hasOdd := len(letterhead.Src) > 0 || letterhead.upload != nil
+	hasEven := len(letterhead.Even) > 0 || letterhead.uploadEven != nil
+	if !hasOdd && !hasEven {
+		return nil, nil
+	}
+	if !hasOdd {
+		return nil, fmt.Errorf("letterhead_even requires letterhead")
+	}
+	switch letterhead.Pages {
+	case "":
+		letterhead.Pages = "all"
+	case "all":
+	case "first":
+		if hasEven {
+			return nil, fmt.Errorf("letterhead_even cannot be used with letterhead_pages=first")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported letterhead_pages: %s", letterhead.Pages)
+	}
+	return letterhead, nil
+}
+
+func (o *OutputOptions) IsEmpty() bool {
+	return o == nil || (len(o.Stamps) == 0 && o.Letterhead == nil && o.Encryption == nil && o.Signature == nil && o.Metadata == nil &&
+		o.PageNumbers == nil && len(o.PageLabels) == 0 && o.Optimization == nil &&
+		o.Conformance == nil && !o.DisplayDocTitle && o.Invoice == nil &&
+		len(o.Attachments) == 0)
+}
+
+// 对生成的 PDF 做后处理（原地修改）
+func (pdf *HTMLPDF) ApplyOutput(pdf_path string, output *OutputOptions) error {
+	if output.IsEmpty() {
+		return nil
+	}
+
+	// 信纸在其他水印之后添加，pdfcpu 把后添加的底层水印放在更下面，因此信纸位于最底层
+	stamps := append([]*Stamp{}, output.Stamps...)
+	if output.Letterhead != nil {
+		list, removes, err := pdf.letterheadStamps(output.Letterhead)
+		defer func() {
+			for _, item := range removes {
+				os.Remove(item)
+			}
+		}()
+		if err != nil {
+			return err
+		}
+		stamps = append(stamps, list...)
+	}
+
+	if len(stamps) > 0 {
+		cleanup, err := pdf.fetchStampSources(stamps)
+		defer cleanup()
+		if err != nil {
+			return err
+		}
+		if err = StampPDFFile(pdf_path, stamps); err != nil {
+			return err
+		}
+	}
+
+	// 页码绘制在水印之上
+	if output.PageNumbers != nil || len(output.PageLabels) > 0 {
+		if err := NumberPDFFile(pdf_path, output.PageNumbers, output.PageLabels); err != nil {
+			return err
+		}
+	}
+
+	if output.Metadata != nil {
+		if err := SetPDFMetadata(pdf_path, output.Metadata); err != nil {
+			return err
+		}
+	}
+
+	if output.DisplayDocTitle {
+		if err := SetDisplayDocTitle(pdf_path); err != nil {
+			return err
+		}
+	}
+
+	// 附件在 PDF/A 转换之前嵌入，PDF/A-3 转换时补充 AFRelationship 等
+	if len(output.Attachments) > 0 {
+		if err := pdf.loadAttachments(output.Attachments); err != nil {
+			return err
+		}
+		if err := AddAttachments(pdf_path, output.Attachments); err != nil {
+			return err
+		}
+	}
+
+	// 发票 XML 在 PDF/A 转换之前嵌入，转换时加入文档目录的 AF
+	if output.Invoice != nil {
+		if err := EmbedInvoiceXML(pdf_path, output.Invoice); err != nil {
+			return err
+		}
+	}
+
+	// 其他处理都可能引入不符合 PDF/A 的内容，放在它们之后
+	if output.Conformance != nil {
+		if err := ConvertPDFA(pdf_path, output.Conformance); err != nil {
+			return err
+		}
+	}
+
+	// 压缩放在签名及加密之前，压缩后的文件大小通过响应头返回
+	if output.Optimization != nil {
+		before, after, err := OptimizePDFFile(pdf_path, output.Optimization)
+		if err != nil {
+			return err
+		}
+		output.Optimization.OriginalSize += before
+		output.Optimization.OptimizedSize += after
+	}
+
+	// 签名之后不能再修改文件
+	if output.Signature != nil {
+		output.Signature.embedFonts = output.Conformance != nil
+		signer, err := pdf.loadSigner(output.Signature.Signer)
+		if err != nil {
+			return err
+		}
+		if err = SignPDFFile(pdf_path, signer, output.Signature, pdf.config.TSAURL); err != nil {
+			return err
+		}
+	}
+
+	// 加密必须放在最后，加密后的文件不再做其他处理
+	if output.Encryption != nil {
+		if err := EncryptPDFFile(pdf_path, output.Encryption); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 将信纸转换为铺满页面的 PDF 底层水印。上传的文件及配置中登记的本地信纸直接使用，其他的作为 URL 下载
+func (pdf *HTMLPDF) letterheadStamps(letterhead *Letterhead) ([]*Stamp, []string, error) {
+	removes := make([]string, 0)
+	newStamp := func(src string, upload *multipart.FileHeader, pages string) (*Stamp, error) {
+		stamp := &Stamp{Type: StampPDF, Src: src, Scale: 1, Opacity: 1, Pages: pages}
+		if upload != nil {
+			local, err := saveUploadFile(upload, pdf.config.TempPath)
+			if err != nil {
+				return nil, err
+			}
+			removes = append(removes, local)
+			stamp.Src = upload.Filename
+			stamp.localPath = local
+		} else if registered, ok := pdf.config.Letterheads[src]; ok {
+			// 登记的可以是本地路径或 URL
+			stamp.Src = registered
+			if !isHTTPURL(registered) {
+				stamp.localPath = registered
+			}
+		}
+		return stamp, nil
+	}
+
+	if len(letterhead.Even) == 0 && letterhead.uploadEven == nil {
+		pages := ""
+		if letterhead.Pages == "first" {
+			pages = "1"
+		}
+		stamp, err := newStamp(letterhead.Src, letterhead.upload, pages)
+		if err != nil {
+			return nil, removes, err
+		}
+		return []*Stamp{stamp}, removes, nil
+	}
+
+	odd, err := newStamp(letterhead.Src, letterhead.upload, "odd")
+	if err != nil {
+		return nil, removes, err
+	}
+	even, err := newStamp(letterhead.Even, letterhead.uploadEven, "even")
+	if err != nil {
+		return nil, removes, err
+	}
+	return []*Stamp{odd, even}, removes, nil
+}
+
+// 下载图片及 PDF 水印的源文件。已经有本地文件（上传或登记的信纸）的直接使用，
+// 其他的只接受 http(s) 地址，避免把服务器上的本地文件嵌入输出
+func (pdf *HTMLPDF) fetchStampSources(stamps []*Stamp) (func(), error) {
+	targets := make([]*Stamp, 0)
+	for _, stamp := range stamps {
+		if len(stamp.localPath) > 0 || len(stamp.Src) == 0 {
+			continue
+		}
+		if !isHTTPURL(stamp.Src) {
+			return func() {}, fmt.Errorf("stamp source must be an http(s) url: %s", stamp.Src)
+		}
+		targets = append(targets, stamp)
+	}
+	removes := make([]string, 0)
+	cleanup := func() {
+		for _, item := range removes {
+			os.Remove(item)
+		}
+	}
+
+	// 与附件一样不经过 Downloader，服务器返回错误状态时直接报错，也不会缓存错误页面
+	for _, stamp := range targets {
+		data, err := downloadAttachment(stamp.Src)
+		if err != nil {
+			return cleanup, err
+		}
+		local := filepath.Join(pdf.config.TempPath, MakeUUID()+filepath.Ext(urlFileName(stamp.Src)))
+		removes = append(removes, local)
+		if err = os.WriteFile(local, data, 0644); err != nil {
+			return cleanup, err
+		}
+		stamp.localPath = local
+	}
+
+	// pdfcpu 根据扩展名判断水印文件的类型
+	for _, stamp := range stamps {
+		if stamp.Type == StampPDF && len(stamp.localPath) > 0 && !strings.EqualFold(filepath.Ext(stamp.localPath), ".pdf") {
+			copied, err := copyWithExt(stamp.localPath, ".pdf")
+			if err != nil {
+				return cleanup, err
+			}
+			removes = append(removes, copied)
+			stamp.localPath = copied
+		}
+	}
+	return cleanup, nil
+}
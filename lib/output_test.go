@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newFormRequest(form url.Values) *http.Request {
+	request, _ := http.NewRequest("POST", "/combine", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return request
+}
+
+func Test_ParseOutputOptions(t *testing.T) {
+	form := url.Values{}
+	form.Add("stamp", `{"text":"DRAFT","rotation":45,"opacity":0.3}`)
+	form.Add("stamp", `[{"src":"http://example.com/logo.png","position":"top-right"},{"src":"http://example.com/bg.pdf"}]`)
+	output, err := ParseOutputOptions(newFormRequest(form))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output.Stamps) != 3 {
+		t.Fatalf("unexpected stamp count: %d", len(output.Stamps))
+	}
+	if output.Stamps[0].Type != StampText || output.Stamps[1].Type != StampImage || output.Stamps[2].Type != StampPDF {
+		t.Errorf("unexpected stamp types: %s %s %s", output.Stamps[0].Type, output.Stamps[1].Type, output.Stamps[2].Type)
+	}
+
+	form.Set("stamp", `{"text":"DRAFT","position":"middle"}`)
+	if _, err = ParseOutputOptions(newFormRequest(form)); err == nil {
+		t.Errorf("expected error for invalid position")
+	}
+}
+
+func Test_ParseLetterhead(t *testing.T) {
+	output, err := ParseOutputOptions(newFormRequest(url.Values{
+		"letterhead":      {"default"},
+		"letterhead_even": {"http://example.com/even.pdf"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Letterhead == nil || output.Letterhead.Pages != "all" || output.Letterhead.Even != "http://example.com/even.pdf" {
+		t.Errorf("unexpected letterhead: %+v", output.Letterhead)
+	}
+
+	invalid := []url.Values{
+		{"letterhead_even": {"even.pdf"}},
+		{"letterhead": {"a.pdf"}, "letterhead_pages": {"last"}},
+		{"letterhead": {"a.pdf"}, "letterhead_even": {"b.pdf"}, "letterhead_pages": {"first"}},
+	}
+	for _, form := range invalid {
+		if _, err = ParseOutputOptions(newFormRequest(form)); err == nil {
+			t.Errorf("expected error for %v", form)
+		}
+	}
+}
+
+func Test_ApplyLetterhead(t *testing.T) {
+	dir := t.TempDir()
+	odd := filepath.Join(dir, "odd.pdf")
+	even := filepath.Join(dir, "even.pdf")
+	writeTestPDF(t, odd, 1)
+	writeTestPDF(t, even, 1)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	pdf := &HTMLPDF{config: &Config{
+		TempPath:    dir,
+		Letterheads: map[string]string{"default": odd},
+	}}
+	// 单页文档只有奇数页的信纸
+	for pages, groups := range map[int]int{1: 1, 3: 2} {
+		file := filepath.Join(dir, "source.pdf")
+		writeTestPDF(t, file, pages)
+
+		output := &OutputOptions{Letterhead: &Letterhead{Src: "default", Even: server.URL + "/even.pdf", Pages: "all"}}
+		if err := pdf.ApplyOutput(file, output); err != nil {
+			t.Fatal(err)
+		}
+		ctx, err := readPDFContext(file)
+		if err != nil || ctx.PageCount != pages {
+			t.Fatalf("unexpected pages: %v", err)
+		}
+		root, _ := ctx.Catalog()
+		properties, _ := ctx.DereferenceDict(root["OCProperties"])
+		ocgs, _ := ctx.DereferenceArray(properties["OCGs"])
+		if len(ocgs) != groups {
+			t.Errorf("unexpected optional content groups: %v", ocgs)
+		}
+	}
+
+	// 未登记的信纸及水印只接受 http(s) 地址，不读取服务器上的本地文件
+	file := filepath.Join(dir, "source.pdf")
+	for _, output := range []*OutputOptions{
+		{Letterhead: &Letterhead{Src: even, Pages: "all"}},
+		{Stamps: []*Stamp{{Type: StampImage, Src: "file://" + even}}},
+		{Stamps: []*Stamp{{Src: "/etc/passwd"}}},
+	} {
+		if err := pdf.ApplyOutput(file, output); err == nil || !strings.Contains(err.Error(), "http(s)") {
+			t.Errorf("expected error for local source, got %v", err)
+		}
+	}
+
+	// 服务器返回错误状态时报错，而不是把错误页面当作信纸或水印
+	for _, output := range []*OutputOptions{
+		{Letterhead: &Letterhead{Src: server.URL + "/missing.pdf", Pages: "all"}},
+		{Stamps: []*Stamp{{Src: server.URL + "/missing.png"}}},
+	} {
+		if err := pdf.ApplyOutput(file, output); err == nil || !strings.Contains(err.Error(), "404") {
+			t.Errorf("expected download error, got %v", err)
+		}
+	}
+}
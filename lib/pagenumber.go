@@ -0,0 +1,473 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// 连续页码，绘制在页面内容之上。Format 中的 {page}、{total}、{label} 分别替换为
+// 页码、总页数及页面标签；Skip 中的页面（如封面）不绘制页码，也不计入页数
+type PageNumbering struct {
+	Format   string  `json:"format"`
+	Position string  `json:"position"`
+	OffsetX  float64 `json:"offset_x"` // 毫米
+	OffsetY  float64 `json:"offset_y"` // 毫米
+	Margin   float64 `json:"margin"`   // 与页面边缘的距离（毫米）
+	Font     string  `json:"font"`     // PDF 标准字体，如 Helvetica、Times-Roman、Courier-Bold
+	FontSize float64 `json:"font_size"`
+	Color    string  `json:"color"`
+	Start    int     `json:"start"` // 第一个编号页面的页码，可以为 0
+	Skip     string  `json:"skip"`  // 页面选择，如 "1"
+
+	present map[string]bool // JSON 中出现的字段，用于区分未设置与显式设置的 0
+}
+
+// 页面标签的一节，从第 Page 页开始，直到下一节的第一页
+type PageLabel struct {
+	Page   int    `json:"page"`
+	Style  string `json:"style"` // decimal、lower-roman、upper-roman、lower-alpha、upper-alpha、none
+	Prefix string `json:"prefix"`
+	Start  int    `json:"start"`
+
+	present map[string]bool
+}
+
+func (n *PageNumbering) UnmarshalJSON(data []byte) error {
+	type plain PageNumbering
+	if err := json.Unmarshal(data, (*plain)(n)); err != nil {
+		return err
+	}
+	present, err := jsonKeys(data)
+	n.present = present
+	return err
+}
+
+func (label *PageLabel) UnmarshalJSON(data []byte) error {
+	type plain PageLabel
+	if err := json.Unmarshal(data, (*plain)(label)); err != nil {
+		return err
+	}
+	present, err := jsonKeys(data)
+	label.present = present
+	return err
+}
+
+// 标准字体对应 gofpdf 的字体族及样式，用于计算文字宽度
+var standardFonts = map[string][2]string{
+	"Helvetica":             {"Helvetica", ""},
+	"Helvetica-Bold":        {"Helvetica", "B"},
+	"Helvetica-Oblique":     {"Helvetica", "I"},
+	"Helvetica-BoldOblique": {"Helvetica", "BI"},
+	"Times-Roman":           {"Times", ""},
+	"Times-Bold":            {"Times", "B"},
+	"Times-Italic":          {"Times", "I"},
+	"Times-BoldItalic":      {"Times", "BI"},
+	"Courier":               {"Courier", ""},
+	"Courier-Bold":          {"Courier", "B"},
+	"Courier-Oblique":       {"Courier", "I"},
+	"Courier-BoldOblique":   {"Courier", "BI"},
+}
+
+// 标签样式对应的 PDF 编号样式
+var labelStyles = map[string]string{
+	"decimal":     "D",
+	"lower-roman": "r",
+	"upper-roman": "R",
+	"lower-alpha": "a",
+	"upper-alpha": "A",
+	"none":        "",
+}
+
+// 读取页码参数：page_numbers 为 JSON 对象，page_labels 为 JSON 数组
+func parsePageNumbering(request *http.Request) (*PageNumbering, []*PageLabel, error) {
+	var numbering *PageNumbering
+	if value := strings.TrimSpace(request.FormValue("page_numbers")); len(value) > 0 {
+		// page_numbers=true 时使用默认设置
+		numbering = &PageNumbering{}
+		if !parseBool(value) {
+			if err := json.Unmarshal([]byte(value), numbering); err != nil {
+				return nil, nil, fmt.Errorf("invalid page_numbers: %v", err)
+			}
+		}
+		if err := numbering.normalize(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var labels []*PageLabel
+	if value := strings.TrimSpace(request.FormValue("page_labels")); len(value) > 0 {
+		if err := json.Unmarshal([]byte(value), &labels); err != nil {
+			return nil, nil, fmt.Errorf("invalid page_labels: %v", err)
+		}
+		if err := normalizePageLabels(labels); err != nil {
+			return nil, nil, err
+		}
+	}
+	if numbering != nil {
+		if err := checkPageNumberText(numbering, labels); err != nil {
+			return nil, nil, err
+		}
+	}
+	return numbering, labels, nil
+}
+
+// 页码使用标准字体（WinAnsi 编码）绘制，格式及 {label} 用到的标签前缀中含有无法显示的字符时报错，
+// 而不是输出 "?"。只设置页面标签时前缀写入 /PageLabels，不受此限制
+func checkPageNumberText(numbering *PageNumbering, labels []*PageLabel) error {
+	if _, ok := winAnsiEncode(numbering.Format); !ok {
+		return fmt.Errorf("page number format contains characters not supported by the standard fonts: %s", numbering.Format)
+	}
+	if !strings.Contains(numbering.Format, "{label}") {
+		return nil
+	}
+	for _, label := range labels {
+		if _, ok := winAnsiEncode(label.Prefix); !ok {
+			return fmt.Errorf("page label prefix contains characters not supported by the standard fonts: %s", label.Prefix)
+		}
+	}
+	return nil
+}
+
+func (n *PageNumbering) normalize() error {
+	if len(n.Format) == 0 {
+		n.Format = "Page {page} of {total}"
+	}
+	if len(n.Position) == 0 {
+		n.Position = "bottom"
+	}
+	if _, _, ok := stampAnchor(n.Position); !ok {
+		return fmt.Errorf("unsupported page number position: %s", n.Position)
+	}
+	if len(n.Font) == 0 {
+		n.Font = "Helvetica"
+	}
+	if _, ok := standardFonts[n.Font]; !ok {
+		return fmt.Errorf("unsupported page number font: %s", n.Font)
+	}
+	if n.FontSize == 0 && !n.present["font_size"] {
+		n.FontSize = 10
+	} else if n.FontSize <= 0 {
+		return fmt.Errorf("page number font_size must be positive")
+	}
+	if len(n.Color) == 0 {
+		n.Color = "#000000"
+	}
+	if _, _, _, err := parseHexColor(n.Color); err != nil {
+		return err
+	}
+	if n.Margin == 0 && !n.present["margin"] {
+		n.Margin = 10
+	} else if n.Margin < 0 {
+		return fmt.Errorf("page number margin must not be negative")
+	}
+	if n.Start == 0 && !n.present["start"] {
+		n.Start = 1
+	} else if n.Start < 0 {
+		return fmt.Errorf("page number start must not be negative")
+	}
+	return nil
+}
+
+// 检查页面标签并按起始页排序
+func normalizePageLabels(labels []*PageLabel) error {
+	pages := make(map[int]bool)
+	for _, label := range labels {
+		if label.Page == 0 && !label.present["page"] {
+			label.Page = 1
+		} else if label.Page < 1 {
+			return fmt.Errorf("page label page must be at least 1")
+		}
+		if pages[label.Page] {
+			return fmt.Errorf("duplicate page label section at page %d", label.Page)
+		}
+		pages[label.Page] = true
+		if len(label.Style) == 0 {
+			label.Style = "decimal"
+		}
+		if _, ok := labelStyles[label.Style]; !ok {
+			return fmt.Errorf("unsupported page label style: %s", label.Style)
+		}
+		// PDF 页面标签的起始编号至少为 1
+		if label.Start == 0 && !label.present["start"] {
+			label.Start = 1
+		} else if label.Start < 1 {
+			return fmt.Errorf("page label start must be at least 1")
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Page < labels[j].Page })
+	return nil
+}
+
+// 第 n 页（从 1 开始）的页面标签，没有设置标签时为页码
+func pageLabelText(labels []*PageLabel, n int) string {
+	var section *PageLabel
+	for _, label := range labels {
+		if label.Page <= n {
+			section = label
+		}
+	}
+	if section == nil {
+		return strconv.Itoa(n)
+	}
+	value := section.Start + n - section.Page
+	switch section.Style {
+	case "decimal":
+		return section.Prefix + strconv.Itoa(value)
+	case "lower-roman":
+		return section.Prefix + strings.ToLower(romanNumeral(value))
+	case "upper-roman":
+		return section.Prefix + romanNumeral(value)
+	case "lower-alpha":
+		return section.Prefix + strings.ToLower(alphaNumeral(value))
+	case "upper-alpha":
+		return section.Prefix + alphaNumeral(value)
+	}
+	return section.Prefix
+}
+
+func romanNumeral(n int) string {
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	symbols := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var b strings.Builder
+	for i, v := range values {
+		for n >= v {
+			b.WriteString(symbols[i])
+			n -= v
+		}
+	}
+	return b.String()
+}
+
+// PDF 的字母编号：A-Z，之后为 AA-ZZ、AAA-ZZZ
+func alphaNumeral(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat(string(rune('A'+(n-1)%26)), (n-1)/26+1)
+}
+
+// 添加页码及页面标签（原地修改），numbering、labels 可以为空
+func NumberPDFFile(pdf_path string, numbering *PageNumbering, labels []*PageLabel) error {
+	if err := normalizePageLabels(labels); err != nil {
+		return err
+	}
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	if numbering != nil {
+		if err = addPageNumbers(ctx, numbering, labels); err != nil {
+			return err
+		}
+	}
+	if len(labels) > 0 {
+		if err = setPageLabels(ctx, labels); err != nil {
+			return err
+		}
+	}
+	return writePDFContext(ctx, pdf_path)
+}
+
+func addPageNumbers(ctx *pdfcpu.Context, numbering *PageNumbering, labels []*PageLabel) error {
+	if err := numbering.normalize(); err != nil {
+		return err
+	}
+	if err := checkPageNumberText(numbering, labels); err != nil {
+		return err
+	}
+	skipped := make(map[int]bool)
+	if len(numbering.Skip) > 0 {
+		pages, err := ParsePageRanges(numbering.Skip, ctx.PageCount)
+		if err != nil {
+			return err
+		}
+		for _, n := range pages {
+			skipped[n] = true
+		}
+	}
+	total := numbering.Start - 1 + ctx.PageCount - len(skipped)
+
+	font := pdfcpu.Dict{
+		"Type":     pdfcpu.Name("Font"),
+		"Subtype":  pdfcpu.Name("Type1"),
+		"BaseFont": pdfcpu.Name(numbering.Font),
+		"Encoding": pdfcpu.Name("WinAnsiEncoding"),
+	}
+	fontRef, err := ctx.IndRefForNewObject(font)
+	if err != nil {
+		return err
+	}
+	// 原有内容放在 q/Q 之间，避免未恢复的图形状态影响页码
+	save, err := newFlateStream(pdfcpu.Dict{}, []byte("q\n"))
+	if err != nil {
+		return err
+	}
+	saveRef, err := ctx.IndRefForNewObject(*save)
+	if err != nil {
+		return err
+	}
+
+	metrics := gofpdf.New("P", "pt", "A4", "")
+	family := standardFonts[numbering.Font]
+	metrics.SetFont(family[0], family[1], numbering.FontSize)
+	r, g, b, _ := parseHexColor(numbering.Color)
+
+	number := numbering.Start
+	for n := 1; n <= ctx.PageCount; n++ {
+		if skipped[n] {
+			continue
+		}
+		text := strings.NewReplacer("{page}", strconv.Itoa(number), "{total}", strconv.Itoa(total),
+			"{label}", pageLabelText(labels, n)).Replace(numbering.Format)
+		text = winAnsiString(text)
+		number++
+
+		page, _, err := ctx.PageDict(n)
+		if err != nil {
+			return err
+		}
+		name, err := pageFontName(ctx.XRefTable, page, *fontRef)
+		if err != nil {
+			return err
+		}
+
+		// 按旋转后看到的页面计算位置，再变换回页面坐标
+		info := pageInfo(ctx.XRefTable, page)
+		x0, y0 := pageOrigin(ctx.XRefTable, page)
+		width, height := info.Width, info.Height
+		var cm string
+		switch info.Rotation {
+		case 90:
+			cm = fmt.Sprintf("0 1 -1 0 %.2f %.2f", x0+width, y0)
+			width, height = height, width
+		case 180:
+			cm = fmt.Sprintf("-1 0 0 -1 %.2f %.2f", x0+width, y0+height)
+		case 270:
+			cm = fmt.Sprintf("0 -1 1 0 %.2f %.2f", x0, y0+height)
+			width, height = height, width
+		default:
+			cm = fmt.Sprintf("1 0 0 1 %.2f %.2f", x0, y0)
+		}
+
+		boxW := metrics.GetStringWidth(text)
+		boxH := numbering.FontSize
+		margin := numbering.Margin * 72 / 25.4
+		horizontal, vertical, _ := stampAnchor(numbering.Position)
+		x := (width-boxW)/2 + float64(horizontal)*((width-boxW)/2-margin) + numbering.OffsetX*72/25.4
+		// 垂直方向以页面顶部为原点计算，再换算为基线的 PDF 坐标
+		top := (height-boxH)/2 + float64(vertical)*((height-boxH)/2-margin) + numbering.OffsetY*72/25.4
+		baseline := height - top - numbering.FontSize*0.8
+
+		escaped, _ := pdfcpu.Escape(text)
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "Q q %s cm BT /%s %.2f Tf %.3f %.3f %.3f rg 1 0 0 1 %.2f %.2f Tm (%s) Tj ET Q\n",
+			cm, name, numbering.FontSize, float64(r)/255, float64(g)/255, float64(b)/255, x, baseline, *escaped)
+		stream, err := newFlateStream(pdfcpu.Dict{}, content.Bytes())
+		if err != nil {
+			return err
+		}
+		ref, err := ctx.IndRefForNewObject(*stream)
+		if err != nil {
+			return err
+		}
+		wrapPageContents(ctx.XRefTable, page, *saveRef, *ref)
+	}
+	return nil
+}
+
+// 页面可见区域左下角的坐标
+func pageOrigin(xRefTable *pdfcpu.XRefTable, page pdfcpu.Dict) (float64, float64) {
+	rect, ok := pageBox(xRefTable, page)
+	if !ok {
+		return 0, 0
+	}
+	return math.Min(rect[0], rect[2]), math.Min(rect[1], rect[3])
+}
+
+// 在页面资源中登记页码字体，返回字体名称。多个页面可能共用同一个资源字典
+func pageFontName(xRefTable *pdfcpu.XRefTable, page pdfcpu.Dict, fontRef pdfcpu.IndirectRef) (string, error) {
+	resources, err := xRefTable.DereferenceDict(inheritedPageEntry(xRefTable, page, "Resources"))
+	if err != nil {
+		return "", err
+	}
+	if resources == nil {
+		resources = pdfcpu.Dict{}
+		page.Update("Resources", resources)
+	}
+	fonts, err := xRefTable.DereferenceDict(resources["Font"])
+	if err != nil {
+		return "", err
+	}
+	if fonts == nil {
+		fonts = pdfcpu.Dict{}
+		resources.Update("Font", fonts)
+	}
+	for i := 0; ; i++ {
+		name := "PgNum" + strconv.Itoa(i)
+		o, found := fonts.Find(name)
+		if !found {
+			fonts.Insert(name, fontRef)
+			return name, nil
+		}
+		if ref, ok := o.(pdfcpu.IndirectRef); ok && ref.ObjectNumber == fontRef.ObjectNumber {
+			return name, nil
+		}
+	}
+}
+
+// 把页面内容改为 [before 原有内容 after]
+func wrapPageContents(xRefTable *pdfcpu.XRefTable, page pdfcpu.Dict, before pdfcpu.IndirectRef, after pdfcpu.IndirectRef) {
+	contents := pdfcpu.Array{before}
+	if o, found := page.Find("Contents"); found {
+		if a, err := xRefTable.DereferenceArray(o); err == nil && a != nil {
+			contents = append(contents, a...)
+		} else {
+			contents = append(contents, o)
+		}
+	}
+	page.Update("Contents", append(contents, after))
+}
+
+// 设置文档的页面标签，阅读器中显示的页码
+func setPageLabels(ctx *pdfcpu.Context, labels []*PageLabel) error {
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	nums := pdfcpu.Array{}
+	if labels[0].Page > 1 {
+		// 第一节之前的页面使用默认编号
+		nums = append(nums, pdfcpu.Integer(0), pdfcpu.Dict{"S": pdfcpu.Name("D")})
+	}
+	for _, label := range labels {
+		if label.Page > ctx.PageCount {
+			break
+		}
+		d := pdfcpu.Dict{}
+		if style := labelStyles[label.Style]; len(style) > 0 {
+			d.Insert("S", pdfcpu.Name(style))
+		}
+		if len(label.Prefix) > 0 {
+			d.Insert("P", pdfTextString(label.Prefix))
+		}
+		if label.Start > 1 {
+			d.Insert("St", pdfcpu.Integer(label.Start))
+		}
+		nums = append(nums, pdfcpu.Integer(label.Page-1), d)
+	}
+	ref, err := ctx.IndRefForNewObject(pdfcpu.Dict{"Nums": nums})
+	if err != nil {
+		return err
+	}
+	root.Update("PageLabels", *ref)
+	return nil
+}
@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func Test_ParsePageNumbering(t *testing.T) {
+	numbering, labels, err := parsePageNumbering(newFormRequest(url.Values{
+		"page_numbers": {"true"},
+		"page_labels":  {`[{"page":3,"prefix":"A-"},{"page":1,"style":"lower-roman"}]`},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numbering.Format != "Page {page} of {total}" || numbering.Position != "bottom" || numbering.Start != 1 {
+		t.Errorf("unexpected defaults: %+v", numbering)
+	}
+	if len(labels) != 2 || labels[0].Page != 1 || labels[1].Style != "decimal" {
+		t.Errorf("unexpected labels: %+v %+v", labels[0], labels[1])
+	}
+
+	invalid := []url.Values{
+		{"page_numbers": {`{"font":"Arial"}`}},
+		{"page_numbers": {`{"position":"middle"}`}},
+		{"page_numbers": {`{"color":"red"}`}},
+		{"page_labels": {`[{"page":1,"style":"greek"}]`}},
+		{"page_labels": {`[{"page":2},{"page":2}]`}},
+		{"page_numbers": {`{"start":-1}`}},
+		{"page_numbers": {`{"margin":-5}`}},
+		{"page_numbers": {`{"font_size":0}`}},
+		{"page_labels": {`[{"page":0}]`}},
+		{"page_labels": {`[{"page":1,"start":0}]`}},
+		{"page_numbers": {`{"format":"第 {page} 页"}`}},
+		{"page_numbers": {`{"format":"{label}"}`}, "page_labels": {`[{"page":1,"prefix":"附录-"}]`}},
+	}
+	for _, form := range invalid {
+		if _, _, err = parsePageNumbering(newFormRequest(form)); err == nil {
+			t.Errorf("expected error for %v", form)
+		}
+	}
+
+	// 显式设置的 0 保留：从第 0 页开始编号，页码贴着页面边缘
+	if numbering, _, err = parsePageNumbering(newFormRequest(url.Values{
+		"page_numbers": {`{"start":0,"margin":0}`},
+	})); err != nil || numbering.Start != 0 || numbering.Margin != 0 || numbering.FontSize != 10 {
+		t.Errorf("unexpected numbering: %+v %v", numbering, err)
+	}
+
+	// 页码不显示标签时，前缀只写入 /PageLabels
+	if _, _, err = parsePageNumbering(newFormRequest(url.Values{
+		"page_numbers": {"true"},
+		"page_labels":  {`[{"page":1,"prefix":"附录-"}]`},
+	})); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_PageLabelText(t *testing.T) {
+	labels := []*PageLabel{
+		{Page: 1, Style: "none", Prefix: "Cover"},
+		{Page: 2, Style: "lower-roman", Start: 1},
+		{Page: 4, Style: "decimal", Start: 1},
+		{Page: 10, Style: "upper-alpha", Prefix: "A-", Start: 27},
+	}
+	expected := map[int]string{1: "Cover", 2: "i", 3: "ii", 4: "1", 9: "6", 10: "A-AA"}
+	for n, label := range expected {
+		if text := pageLabelText(labels, n); text != label {
+			t.Errorf("page %d: expected %s, got %s", n, label, text)
+		}
+	}
+	if text := pageLabelText(nil, 5); text != "5" {
+		t.Errorf("unexpected default label: %s", text)
+	}
+	if romanNumeral(1994) != "MCMXCIV" {
+		t.Errorf("unexpected roman numeral: %s", romanNumeral(1994))
+	}
+}
+
+func pageContent(t *testing.T, ctx *pdfcpu.Context, n int) []byte {
+	page, _, err := ctx.PageDict(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, ok := page["Contents"].(pdfcpu.Array)
+	if !ok {
+		contents = pdfcpu.Array{page["Contents"]}
+	}
+	var b bytes.Buffer
+	for _, o := range contents {
+		sd, err := ctx.DereferenceStreamDict(o)
+		if err != nil || sd == nil {
+			t.Fatal(err)
+		}
+		content := sd.Content
+		if len(content) == 0 {
+			r, err := zlib.NewReader(bytes.NewReader(sd.Raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ = io.ReadAll(r)
+		}
+		b.Write(content)
+	}
+	return b.Bytes()
+}
+
+func Test_NumberPDFFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "numbers.pdf")
+	writeTestPDF(t, file, 4)
+
+	numbering := &PageNumbering{Format: "Page {page} of {total} ({label})", Skip: "1", Position: "bottom-right"}
+	labels := []*PageLabel{{Page: 2, Style: "lower-roman"}, {Page: 4, Style: "decimal", Prefix: "A-"}}
+	if err := NumberPDFFile(file, numbering, labels); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ValidateFile(file, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := pageContent(t, ctx, 1); bytes.Contains(content, []byte("Page")) {
+		t.Errorf("skipped page is numbered: %s", content)
+	}
+	expected := map[int]string{2: "(Page 1 of 3 \\(i\\)) Tj", 3: "(Page 2 of 3 \\(ii\\)) Tj", 4: "(Page 3 of 3 \\(A-1\\)) Tj"}
+	for n, text := range expected {
+		if content := pageContent(t, ctx, n); !bytes.Contains(content, []byte(text)) {
+			t.Errorf("page %d does not contain %s: %s", n, text, content)
+		}
+	}
+
+	root, err := ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := ctx.DereferenceDict(root["PageLabels"])
+	if err != nil || d == nil {
+		t.Fatalf("missing page labels: %v", err)
+	}
+	nums, err := ctx.DereferenceArray(d["Nums"])
+	if err != nil || len(nums) != 6 {
+		t.Fatalf("unexpected page labels: %v %v", nums, err)
+	}
+	if index, ok := nums[4].(pdfcpu.Integer); !ok || index != 3 {
+		t.Errorf("unexpected section start: %v", nums[4])
+	}
+	if prefix, _ := ctx.DereferenceText(nums[5].(pdfcpu.Dict)["P"]); prefix != "A-" {
+		t.Errorf("unexpected prefix: %s", prefix)
+	}
+}
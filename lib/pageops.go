@@ -0,0 +1,345 @@
+package lib
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// 按 pages（从 1 开始，按顺序）生成新的 PDF
+func selectPages(pdf_path string, dest string, pages []int) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	if err = selectContextPages(ctx, pages); err != nil {
+		return err
+	}
+	return writePDFContext(ctx, dest)
+}
+
+// 只保留 pages 中的页面并按其顺序排列，书签随页面调整，指向被删除页面的书签被移除
+func selectContextPages(ctx *pdfcpu.Context, pages []int) error {
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages selected")
+	}
+	items, err := contextOutline(ctx)
+	if err != nil {
+		return err
+	}
+	if err = reorderPages(ctx, pages); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	mapping := make(map[int]int)
+	for i, n := range pages {
+		mapping[n] = i + 1
+	}
+	return setContextOutline(ctx, remapOutline(items, mapping))
+}
+
+// 按新的页码调整书签，目标页面被删除的书签由其保留下来的下级书签代替
+func remapOutline(items []*OutlineItem, mapping map[int]int) []*OutlineItem {
+	result := make([]*OutlineItem, 0, len(items))
+	for _, item := range items {
+		children := remapOutline(item.Children, mapping)
+		n, ok := mapping[item.Page]
+		if !ok {
+			result = append(result, children...)
+			continue
+		}
+		item.Page = n
+		item.Children = children
+		result = append(result, item)
+	}
+	return result
+}
+
+// 提取选中的页面，按选择的顺序排列
+func ExtractPages(pdf_path string, dest string, selection string) error {
+	info, err := ReadPageInfo(pdf_path)
+	if err != nil {
+		return err
+	}
+	pages, err := ParsePageRanges(selection, len(info))
+	if err != nil {
+		return err
+	}
+	return selectPages(pdf_path, dest, pages)
+}
+
+// 删除选中的页面
+func RemovePages(pdf_path string, dest string, selection string) error {
+	info, err := ReadPageInfo(pdf_path)
+	if err != nil {
+		return err
+	}
+	removed, err := ParsePageRanges(selection, len(info))
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		return fmt.Errorf("no pages selected")
+	}
+	excluded := make(map[int]bool)
+	for _, n := range removed {
+		excluded[n] = true
+	}
+	pages := make([]int, 0, len(info))
+	for n := 1; n <= len(info); n++ {
+		if !excluded[n] {
+			pages = append(pages, n)
+		}
+	}
+	if len(pages) == 0 {
+		return fmt.Errorf("cannot remove all pages")
+	}
+	return selectPages(pdf_path, dest, pages)
+}
+
+// 调整页面顺序，order 中列出的页面排在前面，未列出的页面按原顺序排在后面
+func ReorderPages(pdf_path string, dest string, order string) error {
+	info, err := ReadPageInfo(pdf_path)
+	if err != nil {
+		return err
+	}
+	pages, err := ParsePageRanges(order, len(info))
+	if err != nil {
+		return err
+	}
+	listed := make(map[int]bool)
+	for _, n := range pages {
+		listed[n] = true
+	}
+	for n := 1; n <= len(info); n++ {
+		if !listed[n] {
+			pages = append(pages, n)
+		}
+	}
+	return selectPages(pdf_path, dest, pages)
+}
+
+// 顺时针旋转选中的页面，angle 为 90 的倍数，selection 为空时旋转全部页面
+func RotatePages(pdf_path string, dest string, selection string, angle int) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	if len(selection) == 0 {
+		selection = "1-"
+	}
+	pages, err := ParsePageRanges(selection, ctx.PageCount)
+	if err != nil {
+		return err
+	}
+	if err = rotateContextPages(ctx, pages, angle); err != nil {
+		return err
+	}
+	return writePDFContext(ctx, dest)
+}
+
+func rotateContextPages(ctx *pdfcpu.Context, pages []int, angle int) error {
+	if angle%90 != 0 {
+		return fmt.Errorf("rotation must be a multiple of 90: %d", angle)
+	}
+	for _, n := range pages {
+		page, _, err := ctx.PageDict(n)
+		if err != nil {
+			return err
+		}
+		rotation := pageInfo(ctx.XRefTable, page).Rotation
+		page.Update("Rotate", pdfcpu.Integer(((rotation+angle)%360+360)%360))
+	}
+	return nil
+}
+
+// 拆分 PDF，ranges 中每一项生成一个文件；ranges 为空时每 every 页生成一个文件。
+// 生成的文件保存在 dir 中，文件名为 name-序号.pdf
+func SplitPDF(pdf_path string, dir string, name string, ranges []string, every int) ([]string, error) {
+	info, err := ReadPageInfo(pdf_path)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([][]int, 0)
+	if len(ranges) > 0 {
+		for _, selection := range ranges {
+			pages, err := ParsePageRanges(selection, len(info))
+			if err != nil {
+				return nil, err
+			}
+			if len(pages) == 0 {
+				return nil, fmt.Errorf("no pages selected by %s", selection)
+			}
+			parts = append(parts, pages)
+		}
+	} else {
+		if every <= 0 {
+			return nil, fmt.Errorf("split requires ranges or every")
+		}
+		for start := 1; start <= len(info); start += every {
+			pages := make([]int, 0, every)
+			for n := start; n < start+every && n <= len(info); n++ {
+				pages = append(pages, n)
+			}
+			parts = append(parts, pages)
+		}
+	}
+
+	files := make([]string, 0, len(parts))
+	for i, pages := range parts {
+		file := filepath.Join(dir, fmt.Sprintf("%s-%d.pdf", name, i+1))
+		if err = selectPages(pdf_path, file, pages); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// 读取拆分参数：ranges 可以重复，每个值为一个页面选择，多个选择也可以用 ";" 分隔；
+// every 为每个文件的页数
+func parseSplit(request *http.Request) ([]string, int, error) {
+	ranges := make([]string, 0)
+	for _, value := range request.Form["ranges"] {
+		for _, item := range strings.Split(value, ";") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				ranges = append(ranges, item)
+			}
+		}
+	}
+	every := 0
+	if value := request.FormValue("every"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, 0, fmt.Errorf("invalid every: %s", value)
+		}
+		every = n
+	}
+	if len(ranges) == 0 && every == 0 {
+		return nil, 0, fmt.Errorf("split requires ranges or every")
+	}
+	if len(ranges) > 0 && every > 0 {
+		return nil, 0, fmt.Errorf("ranges and every cannot be used together")
+	}
+	return ranges, every, nil
+}
+
+// 合并接口中单个文件的页面选择及旋转
+type CombineItem struct {
+	Pages  string
+	Rotate int
+}
+
+// 读取合并接口的 pages、rotate 参数，可以重复，与 file 按顺序对应，空值表示全部页面或不旋转。
+// 返回的列表与 file 等长，没有设置的文件对应 nil
+func parseCombineItems(request *http.Request, count int) ([]*CombineItem, error) {
+	pages := request.Form["pages"]
+	rotates := request.Form["rotate"]
+	if len(pages) > count || len(rotates) > count {
+		return nil, fmt.Errorf("more pages or rotate values than files")
+	}
+	items := make([]*CombineItem, count)
+	for i := 0; i < count; i++ {
+		item := &CombineItem{}
+		if i < len(pages) {
+			item.Pages = strings.TrimSpace(pages[i])
+		}
+		if i < len(rotates) && len(strings.TrimSpace(rotates[i])) > 0 {
+			angle, err := strconv.Atoi(strings.TrimSpace(rotates[i]))
+			if err != nil || angle%90 != 0 {
+				return nil, fmt.Errorf("invalid rotate: %s", rotates[i])
+			}
+			item.Rotate = angle
+		}
+		if len(item.Pages) > 0 || item.Rotate != 0 {
+			items[i] = item
+		}
+	}
+	return items, nil
+}
+
+// 合并前对源文件做页面选择及旋转，处理后的文件保存在 dir 中。
+// 返回用于合并的文件列表，以及需要删除的临时文件
+func prepareCombineInputs(files []string, items []*CombineItem, dir string) ([]string, []string, error) {
+	result := append([]string{}, files...)
+	removes := make([]string, 0)
+	for i, item := range items {
+		if item == nil || i >= len(files) {
+			continue
+		}
+		ctx, err := readPDFContext(files[i])
+		if err != nil {
+			return nil, removes, err
+		}
+		pages := make([]int, 0, ctx.PageCount)
+		if len(item.Pages) > 0 {
+			if pages, err = ParsePageRanges(item.Pages, ctx.PageCount); err != nil {
+				return nil, removes, err
+			}
+			if err = selectContextPages(ctx, pages); err != nil {
+				return nil, removes, fmt.Errorf("file %d: %v", i+1, err)
+			}
+		}
+		if item.Rotate != 0 {
+			pages = pages[:0]
+			for n := 1; n <= ctx.PageCount; n++ {
+				pages = append(pages, n)
+			}
+			if err = rotateContextPages(ctx, pages, item.Rotate); err != nil {
+				return nil, removes, err
+			}
+		}
+
+		dest := filepath.Join(dir, MakeUUID()+".pdf")
+		removes = append(removes, dest)
+		if err = writePDFContext(ctx, dest); err != nil {
+			return nil, removes, err
+		}
+		result[i] = dest
+	}
+	return result, removes, nil
+}
+
+// 把文件打包为 zip，包内使用文件名
+func ZipFiles(files []string, dest string) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := zip.NewWriter(out)
+	for _, file := range files {
+		if err = addZipFile(w, file); err != nil {
+			return err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func addZipFile(w *zip.Writer, file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	entry, err := w.Create(filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, in)
+	return err
+}
@@ -0,0 +1,243 @@
+package lib
+
+import (
+	"archive/zip"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 每一页带一个书签 P1、P2……，用于检查页面操作后的顺序
+func writeOutlinedTestPDF(t *testing.T, file string, pages int) {
+	writeTestPDF(t, file, pages)
+	items := make([]*OutlineItem, 0, pages)
+	for i := 1; i <= pages; i++ {
+		items = append(items, &OutlineItem{Title: "P" + string(rune('0'+i)), Level: 1, Page: i})
+	}
+	if err := SetPDFOutline(file, items); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 返回每个书签所在的页码
+func outlinePages(t *testing.T, file string) map[string]int {
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := contextOutline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := make(map[string]int)
+	for _, item := range items {
+		pages[item.Title] = item.Page
+	}
+	return pages
+}
+
+func Test_PageOperations(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "source.pdf")
+	writeOutlinedTestPDF(t, src, 5)
+	dest := filepath.Join(dir, "dest.pdf")
+
+	if err := ExtractPages(src, dest, "4,2"); err != nil {
+		t.Fatal(err)
+	}
+	pages := outlinePages(t, dest)
+	if len(pages) != 2 || pages["P4"] != 1 || pages["P2"] != 2 {
+		t.Errorf("unexpected extracted pages: %v", pages)
+	}
+
+	if err := RemovePages(src, dest, "1,last"); err != nil {
+		t.Fatal(err)
+	}
+	pages = outlinePages(t, dest)
+	if len(pages) != 3 || pages["P2"] != 1 || pages["P4"] != 3 {
+		t.Errorf("unexpected remaining pages: %v", pages)
+	}
+	if err := RemovePages(src, dest, "1-"); err == nil {
+		t.Error("expected error when removing all pages")
+	}
+
+	if err := ReorderPages(src, dest, "5,3"); err != nil {
+		t.Fatal(err)
+	}
+	pages = outlinePages(t, dest)
+	if pages["P5"] != 1 || pages["P3"] != 2 || pages["P1"] != 3 || pages["P4"] != 5 {
+		t.Errorf("unexpected order: %v", pages)
+	}
+
+	if err := RotatePages(src, dest, "2", -90); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ReadPageInfo(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info[0].Rotation != 0 || info[1].Rotation != 270 {
+		t.Errorf("unexpected rotation: %+v", info)
+	}
+	if err = RotatePages(src, dest, "", 45); err == nil {
+		t.Error("expected error for rotation 45")
+	}
+}
+
+func Test_SplitPDF(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "source.pdf")
+	writeOutlinedTestPDF(t, src, 5)
+
+	files, err := SplitPDF(src, dir, "report", nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || filepath.Base(files[2]) != "report-3.pdf" {
+		t.Fatalf("unexpected files: %v", files)
+	}
+	if pages := outlinePages(t, files[2]); len(pages) != 1 || pages["P5"] != 1 {
+		t.Errorf("unexpected last part: %v", pages)
+	}
+
+	files, err = SplitPDF(src, dir, "part", []string{"1", "2-4"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages := outlinePages(t, files[1]); len(pages) != 3 || pages["P2"] != 1 {
+		t.Errorf("unexpected second part: %v", pages)
+	}
+
+	archive := filepath.Join(dir, "parts.zip")
+	if err = ZipFiles(files, archive); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.File) != 2 || r.File[0].Name != "part-1.pdf" {
+		t.Errorf("unexpected archive entries: %v", r.File)
+	}
+}
+
+func Test_ParseSplit(t *testing.T) {
+	request := newFormRequest(url.Values{"ranges": {"1-3;4-", "last"}})
+	request.ParseForm()
+	ranges, every, err := parseSplit(request)
+	if err != nil || len(ranges) != 3 || ranges[1] != "4-" || every != 0 {
+		t.Errorf("unexpected split: %v %d %v", ranges, every, err)
+	}
+
+	invalid := []url.Values{
+		{},
+		{"every": {"0"}},
+		{"every": {"2"}, "ranges": {"1"}},
+	}
+	for _, form := range invalid {
+		request = newFormRequest(form)
+		request.ParseForm()
+		if _, _, err = parseSplit(request); err == nil {
+			t.Errorf("expected error for %v", form)
+		}
+	}
+}
+
+func Test_ParseCombineItems(t *testing.T) {
+	request := newFormRequest(url.Values{"pages": {"1-2", "", "last"}, "rotate": {"", "90"}})
+	request.ParseForm()
+	items, err := parseCombineItems(request, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 4 || items[0].Pages != "1-2" || items[0].Rotate != 0 || items[1].Rotate != 90 ||
+		items[2].Pages != "last" || items[3] != nil {
+		t.Errorf("unexpected items: %+v", items)
+	}
+
+	invalid := []url.Values{
+		{"rotate": {"45"}},
+		{"rotate": {"x"}},
+		{"pages": {"1", "2", "3", "4", "5"}},
+	}
+	for _, form := range invalid {
+		request = newFormRequest(form)
+		request.ParseForm()
+		if _, err = parseCombineItems(request, 4); err == nil {
+			t.Errorf("expected error for %v", form)
+		}
+	}
+}
+
+func Test_PrepareCombineInputs(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.pdf")
+	writeOutlinedTestPDF(t, first, 4)
+	second := filepath.Join(dir, "second.pdf")
+	writeTestPDF(t, second, 2)
+
+	items := []*CombineItem{{Pages: "2,1", Rotate: 90}, nil}
+	inputs, removes, err := prepareCombineInputs([]string{first, second}, items, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removes) != 1 || inputs[0] != removes[0] || inputs[1] != second {
+		t.Fatalf("unexpected inputs: %v %v", inputs, removes)
+	}
+	if pages := outlinePages(t, inputs[0]); len(pages) != 2 || pages["P2"] != 1 {
+		t.Errorf("unexpected selected pages: %v", pages)
+	}
+	info, err := ReadPageInfo(inputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info) != 2 || info[0].Rotation != 90 || info[1].Rotation != 90 {
+		t.Errorf("unexpected page info: %+v", info)
+	}
+	if source, _ := ReadPageInfo(first); len(source) != 4 || source[0].Rotation != 0 {
+		t.Errorf("source file was modified: %+v", source)
+	}
+}
+
+// 源文件只接受上传的文件或 http(s) URL，不读取服务器上的本地文件
+func Test_SourcePDF(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "source.pdf")
+	writeTestPDF(t, file, 1)
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+	s := &HTTPService{config: &Config{TempPath: dir}}
+
+	for _, src := range []string{file, "file://" + file, "ftp://example.com/a.pdf"} {
+		_, _, cleanup, err := s.sourcePDF(newFormRequest(url.Values{"file": {src}}))
+		cleanup()
+		if err == nil {
+			t.Errorf("%s: expected error for non-http source", src)
+		}
+		if _, err = s.downloadSource(src); err == nil {
+			t.Errorf("%s: expected error for non-http link", src)
+		}
+	}
+
+	_, _, cleanup, err := s.sourcePDF(newFormRequest(url.Values{"file": {server.URL + "/missing.pdf"}}))
+	cleanup()
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected download error for missing file, got %v", err)
+	}
+	if _, err = s.downloadSource(server.URL + "/missing.html"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected download error for missing link, got %v", err)
+	}
+
+	local, name, cleanup, err := s.sourcePDF(newFormRequest(url.Values{"file": {server.URL + "/source.pdf"}}))
+	defer cleanup()
+	if err != nil || name != "source" {
+		t.Fatalf("unexpected source: %s %v", name, err)
+	}
+	if info, err := ReadPageInfo(local); err != nil || len(info) != 1 {
+		t.Errorf("unexpected download: %v %v", info, err)
+	}
+}
@@ -3,7 +3,6 @@ package lib
 import (
 	"crypto/md5"
 	"fmt"
-	"image"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -17,7 +16,9 @@ import (
 	_ "image/png"
 	"os"
 
-	"github.com/jung-kurt/gofpdf"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 
 	"github.com/pdfcpu/pdfcpu/pkg/cli"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
@@ -214,50 +215,22 @@ func Filter(vs []string, f func(string) bool) []string {
 }
 
 func ConvertToPdf(src_image_path string, dest_pdf_path string) error {
-	src_image, err := os.Open(src_image_path)
-	if err != nil {
-		Logger.Error(err)
-		return err
-	}
-	defer src_image.Close()
-	img, _, err := image.Decode(src_image)
-	if err != nil {
-		Logger.Error(err)
-		return err
-	}
-	rect := img.Bounds()
-
-	pdfTpye := "P"
-	if rect.Dx() > rect.Dy() {
-		pdfTpye = "L"
-	}
-
-	pdf := gofpdf.New(pdfTpye, "mm", "A4", ".")
-	pdf.AddPage()
-	w, _ := pdf.GetPageSize()
-	pdf.Image(src_image_path, 0, 0, w, 0, false, "", 0, "")
-	err = pdf.OutputFileAndClose(dest_pdf_path)
-	if err != nil {
-		Logger.Error(err)
-		return err
-	}
-
-	return nil
+	return ImagesToPDF([]string{src_image_path}, dest_pdf_path, NewImageLayout())
 }
 
-func CombinePDF(files []string, dest_pdf_path string) error {
-	//处理合并过程中可能出现的异常
+func CombinePDF(files []string, dest_pdf_path string) (err error) {
+	//处理合并过程中可能出现的异常，返回错误而不是把未完成的文件当作合并结果
 	defer func() {
-		if err := recover(); err != nil {
-			Logger.Error(err)
+		if r := recover(); r != nil {
+			Logger.Error(r)
+			err = fmt.Errorf("merge failed: %v", r)
 		}
 	}()
 
 	config := pdfcpu.NewDefaultConfiguration()
 	config.ValidationMode = pdfcpu.ValidationRelaxed
 	cmd := cli.MergeCommand(files, dest_pdf_path, config)
-	_, err := cli.Process(cmd)
-	if err != nil {
+	if _, err = cli.Process(cmd); err != nil {
 		Logger.Error(err)
 		return err
 	}
@@ -0,0 +1,925 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/gomonobolditalic"
+	"golang.org/x/image/font/gofont/gomonoitalic"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// PDF/A 归档输出，Part 为 2 或 3，Level 目前只支持 b（基本级别）
+type Conformance struct {
+	Part  int
+	Level string
+
+	// 转换时用内置字体替换的未嵌入字体，通过响应头返回
+	Substitutions []*FontSubstitution
+
+	schemas      []*xmpSchema // 其他处理（如电子发票）附加的 XMP 扩展模式及说明
+	descriptions []string
+}
+
+func (c *Conformance) String() string {
+	return fmt.Sprintf("PDF/A-%d%s", c.Part, c.Level)
+}
+
+// 未嵌入的字体及替换它的内置字体
+type FontSubstitution struct {
+	Font       string
+	Substitute string
+}
+
+func (s *FontSubstitution) String() string {
+	return s.Font + "=" + s.Substitute
+}
+
+// 无法转换为 PDF/A 的内容，文件不会被修改
+type ConformanceError struct {
+	Conformance *Conformance
+	Issues      []string
+}
+
+func (e *ConformanceError) Error() string {
+	return fmt.Sprintf("unable to produce %s: %s", e.Conformance, strings.Join(e.Issues, "; "))
+}
+
+// 读取 PDF/A 参数：pdfa 为 2b 或 3b（也可以写作 PDF/A-2b）
+func parseConformance(request *http.Request) (*Conformance, error) {
+	value := strings.ToLower(strings.TrimSpace(request.FormValue("pdfa")))
+	if len(value) == 0 {
+		return nil, nil
+	}
+	switch strings.TrimPrefix(strings.TrimPrefix(value, "pdf/"), "a-") {
+	case "2b":
+		return &Conformance{Part: 2, Level: "b"}, nil
+	case "3b":
+		return &Conformance{Part: 3, Level: "b"}, nil
+	}
+	return nil, fmt.Errorf("unsupported pdfa: %s", request.FormValue("pdfa"))
+}
+
+// PDF/A 禁止的动作
+var forbiddenActions = map[string]bool{
+	"Launch": true, "Sound": true, "Movie": true, "ResetForm": true, "ImportData": true, "JavaScript": true,
+	"Hide": true, "SetOCGState": true, "Rendition": true, "Trans": true, "GoTo3DView": true,
+}
+
+// PDF/A 允许的命名动作
+var allowedNamedActions = map[string]bool{"NextPage": true, "PrevPage": true, "FirstPage": true, "LastPage": true}
+
+// PDF/A 禁止的注释类型，转换时删除
+var forbiddenAnnotations = map[string]bool{"Sound": true, "Movie": true, "Screen": true, "3D": true, "RichMedia": true}
+
+type pdfaConverter struct {
+	ctx           *pdfcpu.Context
+	part          int
+	issues        []string
+	fonts         map[string]*substituteFont
+	substitutions []*FontSubstitution
+}
+
+func newPDFAConverter(ctx *pdfcpu.Context, part int) *pdfaConverter {
+	return &pdfaConverter{ctx: ctx, part: part, fonts: make(map[string]*substituteFont)}
+}
+
+func (conv *pdfaConverter) issue(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	for _, item := range conv.issues {
+		if item == message {
+			return
+		}
+	}
+	conv.issues = append(conv.issues, message)
+}
+
+// 记录字体替换，同名字体只记录一次
+func (conv *pdfaConverter) substituted(font string, substitute string) {
+	for _, item := range conv.substitutions {
+		if item.Font == font {
+			return
+		}
+	}
+	conv.substitutions = append(conv.substitutions, &FontSubstitution{Font: font, Substitute: substitute})
+}
+
+// 把 PDF 文件转换为 PDF/A（原地修改）：嵌入字体（未嵌入的标准字体用内置的 Go 字体替换，替换记录在 c.Substitutions 中），
+// 添加 sRGB OutputIntent 及带 pdfaid 的 XMP，删除禁止的动作、注释及其他内容。
+// 无法自动处理的问题通过 ConformanceError 返回，此时文件保持不变
+func ConvertPDFA(pdf_path string, c *Conformance) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	if ctx.Encrypt != nil {
+		return &ConformanceError{Conformance: c, Issues: []string{"the pdf is encrypted"}}
+	}
+	conv := newPDFAConverter(ctx, c.Part)
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+
+	if err = conv.fixStreams(); err != nil {
+		return err
+	}
+	conv.fixObjects()
+	if err = conv.fixPages(); err != nil {
+		return err
+	}
+	if err = conv.fixCatalog(root); err != nil {
+		return err
+	}
+	if err = conv.fixEmbeddedFiles(root); err != nil {
+		return err
+	}
+	if err = setOutputIntent(ctx, root); err != nil {
+		return err
+	}
+	if len(conv.issues) > 0 {
+		return &ConformanceError{Conformance: c, Issues: conv.issues}
+	}
+
+	meta, err := contextMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	if meta.ModDate.IsZero() {
+		meta.ModDate = time.Now()
+	}
+	if meta.CreationDate.IsZero() {
+		meta.CreationDate = meta.ModDate
+	}
+	// 没有 Producer 时 pdfcpu 写入自己的值，与 XMP 不一致
+	if len(meta.Producer) == 0 {
+		meta.Producer = "html2pdf"
+	}
+	if err = setContextMetadata(ctx, meta, pdfaXMP(c, meta)...); err != nil {
+		return err
+	}
+	if err = writePDFContext(ctx, pdf_path); err != nil {
+		return err
+	}
+	c.Substitutions = append(c.Substitutions, conv.substitutions...)
+	return nil
+}
+
+// 在响应头中返回 PDF/A 转换时替换的字体（原字体=替换字体，逗号分隔），替换写入日志
+func setConformanceHeaders(writer http.ResponseWriter, output *OutputOptions) {
+	if output == nil || output.Conformance == nil || len(output.Conformance.Substitutions) == 0 {
+		return
+	}
+	list := make([]string, 0, len(output.Conformance.Substitutions))
+	for _, s := range output.Conformance.Substitutions {
+		Logger.Warningf("%s: font %s is not embedded, substituted with %s", output.Conformance, s.Font, s.Substitute)
+		list = append(list, headerSafe(s.String()))
+	}
+	writer.Header().Set("X-PDFA-Font-Substitutions", strings.Join(list, ", "))
+}
+
+// 响应头中只保留可打印的 ASCII 字符
+func headerSafe(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == ',' {
+			return '?'
+		}
+		return r
+	}, value)
+}
+
+// XMP 中的 pdfaid、附加的说明及扩展模式（包括自定义条目）
+func pdfaXMP(c *Conformance, meta *Metadata) []string {
+	descriptions := []string{fmt.Sprintf("  <rdf:Description rdf:about=\"\" xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\">\n"+
+		"   <pdfaid:part>%d</pdfaid:part>\n   <pdfaid:conformance>%s</pdfaid:conformance>\n  </rdf:Description>\n",
+		c.Part, strings.ToUpper(c.Level))}
+	descriptions = append(descriptions, c.descriptions...)
+	schemas := append([]*xmpSchema{}, c.schemas...)
+	if len(meta.Custom) > 0 {
+		schema := &xmpSchema{Name: "Custom document properties", NamespaceURI: "http://ns.adobe.com/pdfx/1.3/", Prefix: "pdfx"}
+		keys := make([]string, 0, len(meta.Custom))
+		for key := range meta.Custom {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			schema.Properties = append(schema.Properties, &xmpProperty{Name: key, ValueType: "Text", Category: "external", Description: key})
+		}
+		schemas = append(schemas, schema)
+	}
+	if len(schemas) > 0 {
+		descriptions = append(descriptions, xmpExtensionSchemas(schemas))
+	}
+	return descriptions
+}
+
+// PDF/A 扩展模式，用于说明 XMP 中非预定义的命名空间
+type xmpSchema struct {
+	Name         string
+	NamespaceURI string
+	Prefix       string
+	Properties   []*xmpProperty
+}
+
+type xmpProperty struct {
+	Name        string
+	ValueType   string
+	Category    string // internal 或 external
+	Description string
+}
+
+func xmpExtensionSchemas(schemas []*xmpSchema) string {
+	var b bytes.Buffer
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:pdfaExtension=\"http://www.aiim.org/pdfa/ns/extension/\"\n")
+	b.WriteString("    xmlns:pdfaSchema=\"http://www.aiim.org/pdfa/ns/schema#\"\n")
+	b.WriteString("    xmlns:pdfaProperty=\"http://www.aiim.org/pdfa/ns/property#\">\n")
+	b.WriteString("   <pdfaExtension:schemas>\n    <rdf:Bag>\n")
+	for _, schema := range schemas {
+		b.WriteString("     <rdf:li rdf:parseType=\"Resource\">\n")
+		fmt.Fprintf(&b, "      <pdfaSchema:schema>%s</pdfaSchema:schema>\n", xmlEscape(schema.Name))
+		fmt.Fprintf(&b, "      <pdfaSchema:namespaceURI>%s</pdfaSchema:namespaceURI>\n", xmlEscape(schema.NamespaceURI))
+		fmt.Fprintf(&b, "      <pdfaSchema:prefix>%s</pdfaSchema:prefix>\n", schema.Prefix)
+		b.WriteString("      <pdfaSchema:property>\n       <rdf:Seq>\n")
+		for _, p := range schema.Properties {
+			b.WriteString("        <rdf:li rdf:parseType=\"Resource\">\n")
+			fmt.Fprintf(&b, "         <pdfaProperty:name>%s</pdfaProperty:name>\n", p.Name)
+			fmt.Fprintf(&b, "         <pdfaProperty:valueType>%s</pdfaProperty:valueType>\n", p.ValueType)
+			fmt.Fprintf(&b, "         <pdfaProperty:category>%s</pdfaProperty:category>\n", p.Category)
+			fmt.Fprintf(&b, "         <pdfaProperty:description>%s</pdfaProperty:description>\n", xmlEscape(p.Description))
+			b.WriteString("        </rdf:li>\n")
+		}
+		b.WriteString("       </rdf:Seq>\n      </pdfaSchema:property>\n     </rdf:li>\n")
+	}
+	b.WriteString("    </rdf:Bag>\n   </pdfaExtension:schemas>\n  </rdf:Description>\n")
+	return b.String()
+}
+
+// 添加使用内置 sRGB 配置文件的 OutputIntent，已有 PDF/A OutputIntent 时不做处理
+func setOutputIntent(ctx *pdfcpu.Context, root pdfcpu.Dict) error {
+	intents, err := ctx.DereferenceArray(root["OutputIntents"])
+	if err != nil {
+		return err
+	}
+	for _, o := range intents {
+		intent, err := ctx.DereferenceDict(o)
+		if err == nil && intent != nil && intent.NameEntry("S") != nil && *intent.NameEntry("S") == "GTS_PDFA1" && intent["DestOutputProfile"] != nil {
+			return nil
+		}
+	}
+	profile, err := newFlateStream(pdfcpu.Dict{"N": pdfcpu.Integer(3)}, srgbICCProfile())
+	if err != nil {
+		return err
+	}
+	profileRef, err := ctx.IndRefForNewObject(*profile)
+	if err != nil {
+		return err
+	}
+	intent := pdfcpu.Dict{
+		"Type":                      pdfcpu.Name("OutputIntent"),
+		"S":                         pdfcpu.Name("GTS_PDFA1"),
+		"OutputConditionIdentifier": pdfcpu.StringLiteral("sRGB IEC61966-2.1"),
+		"Info":                      pdfcpu.StringLiteral("sRGB IEC61966-2.1"),
+		"RegistryName":              pdfcpu.StringLiteral("http://www.color.org"),
+		"DestOutputProfile":         *profileRef,
+	}
+	return appendArrayEntry(ctx.XRefTable, root, "OutputIntents", intent)
+}
+
+// 外部流数据不能转换；LZW 改为 Flate，元数据流改为不压缩
+func (conv *pdfaConverter) fixStreams() error {
+	for objNr, entry := range conv.ctx.Table {
+		if entry == nil || entry.Free || entry.Object == nil {
+			continue
+		}
+		sd, ok := entry.Object.(pdfcpu.StreamDict)
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"F", "FFilter", "FDecodeParms"} {
+			if _, found := sd.Find(key); found {
+				conv.issue("object %d refers to external stream data", objNr)
+			}
+		}
+
+		isMetadata := sd.Type() != nil && *sd.Type() == "Metadata"
+		lzw := false
+		for _, f := range sd.FilterPipeline {
+			lzw = lzw || f.Name == "LZWDecode"
+		}
+		if !lzw && !(isMetadata && sd.FilterPipeline != nil) {
+			continue
+		}
+		data, err := pdfcpu.ExtractStreamData(conv.ctx, objNr)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			conv.issue("object %d uses an unsupported filter", objNr)
+			continue
+		}
+		d := copyDict(sd.Dict)
+		d.Delete("Filter")
+		d.Delete("DecodeParms")
+		if isMetadata {
+			length := int64(len(data))
+			d.Update("Length", pdfcpu.Integer(length))
+			stream := pdfcpu.NewStreamDict(d, 0, &length, nil, nil)
+			stream.Content, stream.Raw = data, data
+			entry.Object = stream
+			continue
+		}
+		stream, err := newFlateStream(d, data)
+		if err != nil {
+			return err
+		}
+		entry.Object = *stream
+	}
+	return nil
+}
+
+// 遍历所有对象（包括直接对象）中的字典，处理字体、图片、图形状态及动作
+func (conv *pdfaConverter) fixObjects() {
+	for _, entry := range conv.ctx.Table {
+		if entry == nil || entry.Free || entry.Object == nil {
+			continue
+		}
+		conv.walk(entry.Object, 0)
+	}
+}
+
+func (conv *pdfaConverter) walk(o pdfcpu.Object, depth int) {
+	if depth > 32 {
+		return
+	}
+	switch o := o.(type) {
+	case pdfcpu.StreamDict:
+		conv.fixDict(o.Dict, true)
+		for _, value := range o.Dict {
+			conv.walk(value, depth+1)
+		}
+	case pdfcpu.Dict:
+		conv.fixDict(o, false)
+		for _, value := range o {
+			conv.walk(value, depth+1)
+		}
+	case pdfcpu.Array:
+		for _, value := range o {
+			conv.walk(value, depth+1)
+		}
+	}
+}
+
+func (conv *pdfaConverter) fixDict(d pdfcpu.Dict, stream bool) {
+	xRefTable := conv.ctx.XRefTable
+	d.Delete("AA")
+	if action, err := xRefTable.DereferenceDict(d["A"]); err == nil && action != nil && conv.forbiddenAction(action) {
+		d.Delete("A")
+	}
+	// 传输函数
+	d.Delete("TR")
+	if tr, found := d.Find("TR2"); found {
+		if name, ok := tr.(pdfcpu.Name); !ok || name != "Default" {
+			d.Delete("TR2")
+		}
+	}
+
+	typ, subtype := d.Type(), d.Subtype()
+	switch {
+	case typ != nil && *typ == "Font":
+		conv.fixFont(d)
+	case typ != nil && *typ == "FontDescriptor":
+		// PDF/A-2 不要求 CIDSet 及 CharSet，不准确时反而不符合规范
+		d.Delete("CIDSet")
+		d.Delete("CharSet")
+	case stream && subtype != nil && *subtype == "Image":
+		d.Delete("Interpolate")
+		d.Delete("Alternates")
+		d.Delete("OPI")
+		if cs, ok := d["ColorSpace"].(pdfcpu.Name); ok && cs == "DeviceCMYK" {
+			conv.issue("DeviceCMYK images require a CMYK output intent")
+		}
+	case stream && subtype != nil && *subtype == "Form":
+		d.Delete("OPI")
+		if ps := d.NameEntry("Subtype2"); ps != nil && *ps == "PS" {
+			conv.issue("PostScript XObjects are not allowed")
+		}
+	case stream && subtype != nil && *subtype == "PS":
+		conv.issue("PostScript XObjects are not allowed")
+	}
+}
+
+func (conv *pdfaConverter) forbiddenAction(action pdfcpu.Dict) bool {
+	for depth := 0; action != nil && depth < 16; depth++ {
+		s := action.NameEntry("S")
+		if s == nil {
+			return false
+		}
+		if forbiddenActions[*s] {
+			return true
+		}
+		if *s == "Named" {
+			if n := action.NameEntry("N"); n == nil || !allowedNamedActions[*n] {
+				return true
+			}
+		}
+		next, err := conv.ctx.DereferenceDict(action["Next"])
+		if err != nil {
+			return true
+		}
+		action = next
+	}
+	return false
+}
+
+// 处理页面上的注释，检查 DeviceCMYK 的使用
+func (conv *pdfaConverter) fixPages() error {
+	ctx := conv.ctx
+	for i := 1; i <= ctx.PageCount; i++ {
+		page, _, err := ctx.PageDict(i)
+		if err != nil {
+			return err
+		}
+		if err = conv.fixAnnotations(page, i); err != nil {
+			return err
+		}
+
+		resources, _ := ctx.DereferenceDict(inheritedPageEntry(ctx.XRefTable, page, "Resources"))
+		if resources != nil {
+			if colorSpaces, _ := ctx.DereferenceDict(resources["ColorSpace"]); colorSpaces != nil && colorSpaces["DefaultCMYK"] != nil {
+				continue
+			}
+		}
+		content, err := pageContentData(ctx, page)
+		if err != nil {
+			return err
+		}
+		for _, op := range parseContentOps(content) {
+			cmyk := op.Name == "k" || op.Name == "K"
+			if (op.Name == "cs" || op.Name == "CS") && len(op.Operands) > 0 {
+				cmyk = op.Operands[len(op.Operands)-1] == "/DeviceCMYK"
+			}
+			if cmyk {
+				conv.issue("page %d uses DeviceCMYK without a CMYK output intent", i)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (conv *pdfaConverter) fixAnnotations(page pdfcpu.Dict, pageNr int) error {
+	ctx := conv.ctx
+	annots, err := ctx.DereferenceArray(page["Annots"])
+	if err != nil || annots == nil {
+		return err
+	}
+	kept := make(pdfcpu.Array, 0, len(annots))
+	for _, o := range annots {
+		annot, err := ctx.DereferenceDict(o)
+		if err != nil || annot == nil {
+			continue
+		}
+		subtype := ""
+		if s := annot.Subtype(); s != nil {
+			subtype = *s
+		}
+		if forbiddenAnnotations[subtype] {
+			continue
+		}
+		kept = append(kept, o)
+
+		// 必须打印，不能隐藏
+		flags := 0
+		if f := annot.IntEntry("F"); f != nil {
+			flags = *f
+		}
+		annot.Update("F", pdfcpu.Integer((flags|4)&^(1|2|32|256)))
+
+		ap, _ := ctx.DereferenceDict(annot["AP"])
+		if ap != nil {
+			ap.Delete("D")
+			ap.Delete("R")
+		}
+		if subtype == "Popup" || subtype == "Link" || ap != nil && ap["N"] != nil {
+			continue
+		}
+		if rect, _ := ctx.DereferenceArray(annot["Rect"]); len(rect) == 4 {
+			var r [4]float64
+			for i, v := range rect {
+				r[i], _ = ctx.DereferenceNumber(v)
+			}
+			if r[0] == r[2] || r[1] == r[3] {
+				continue
+			}
+		}
+		conv.issue("%s annotation on page %d has no appearance stream", subtype, pageNr)
+	}
+	if len(kept) != len(annots) {
+		page.Update("Annots", kept)
+	}
+	return nil
+}
+
+func (conv *pdfaConverter) fixCatalog(root pdfcpu.Dict) error {
+	ctx := conv.ctx
+	root.Delete("Requirements")
+	if names, err := ctx.DereferenceDict(root["Names"]); err == nil && names != nil {
+		names.Delete("JavaScript")
+	}
+	if action, err := ctx.DereferenceDict(root["OpenAction"]); err == nil && action != nil && conv.forbiddenAction(action) {
+		root.Delete("OpenAction")
+	}
+
+	if acroForm, err := ctx.DereferenceDict(root["AcroForm"]); err == nil && acroForm != nil {
+		acroForm.Delete("XFA")
+		acroForm.Delete("NeedAppearances")
+	}
+
+	// 可选内容的每个配置必须有名称，不能有 AS
+	if ocProperties, err := ctx.DereferenceDict(root["OCProperties"]); err == nil && ocProperties != nil {
+		configs := make([]pdfcpu.Object, 0)
+		if d, found := ocProperties.Find("D"); found {
+			configs = append(configs, d)
+		}
+		if list, err := ctx.DereferenceArray(ocProperties["Configs"]); err == nil {
+			configs = append(configs, list...)
+		}
+		for i, o := range configs {
+			config, err := ctx.DereferenceDict(o)
+			if err != nil || config == nil {
+				continue
+			}
+			config.Delete("AS")
+			if config["Name"] == nil {
+				config.Insert("Name", pdfcpu.StringLiteral(fmt.Sprintf("Config%d", i+1)))
+			}
+		}
+	}
+	return nil
+}
+
+// PDF/A-3 的嵌入文件需要 AFRelationship、MIME 类型，并从文档目录的 AF 引用；
+// PDF/A-2 只允许嵌入 PDF/A 文件
+func (conv *pdfaConverter) fixEmbeddedFiles(root pdfcpu.Dict) error {
+	ctx := conv.ctx
+	specs := make([]pdfcpu.Object, 0)
+	if names, err := ctx.DereferenceDict(root["Names"]); err == nil && names != nil {
+		collectNameTreeValues(ctx.XRefTable, names["EmbeddedFiles"], &specs, 0)
+	}
+	for i := 1; i <= ctx.PageCount; i++ {
+		page, _, err := ctx.PageDict(i)
+		if err != nil {
+			return err
+		}
+		annots, _ := ctx.DereferenceArray(page["Annots"])
+		for _, o := range annots {
+			annot, err := ctx.DereferenceDict(o)
+			if err == nil && annot != nil && annot.Subtype() != nil && *annot.Subtype() == "FileAttachment" && annot["FS"] != nil {
+				specs = append(specs, annot["FS"])
+			}
+		}
+	}
+
+	af, _ := ctx.DereferenceArray(root["AF"])
+	for _, o := range specs {
+		spec, err := ctx.DereferenceDict(o)
+		if err != nil || spec == nil {
+			continue
+		}
+		name := ""
+		if value, err := ctx.DereferenceText(spec["UF"]); err == nil && len(value) > 0 {
+			name = value
+		} else if value, err := ctx.DereferenceText(spec["F"]); err == nil {
+			name = value
+		}
+		ef, _ := ctx.DereferenceDict(spec["EF"])
+		if ef == nil {
+			conv.issue("file specification %s has no embedded file", name)
+			continue
+		}
+		streamRef, ok := ef["F"].(pdfcpu.IndirectRef)
+		if !ok {
+			conv.issue("embedded file %s is not an indirect stream", name)
+			continue
+		}
+
+		if conv.part == 2 {
+			data, err := pdfcpu.ExtractStreamData(ctx, streamRef.ObjectNumber.Value())
+			if err != nil {
+				return err
+			}
+			if !bytes.HasPrefix(data, []byte("%PDF-")) || !bytes.Contains(data, []byte("pdfaid")) {
+				conv.issue("embedded file %s is not a PDF/A file, use PDF/A-3", name)
+			}
+			continue
+		}
+
+		if spec["AFRelationship"] == nil {
+			spec.Insert("AFRelationship", pdfcpu.Name("Unspecified"))
+		}
+		if spec["F"] == nil {
+			spec.Insert("F", pdfTextString(name))
+		}
+		if spec["UF"] == nil {
+			spec.Insert("UF", pdfTextString(name))
+		}
+		stream, err := ctx.DereferenceStreamDict(streamRef)
+		if err != nil || stream == nil {
+			conv.issue("embedded file %s is missing", name)
+			continue
+		}
+		if stream.Subtype() == nil {
+			mimeType := mime.TypeByExtension(filepath.Ext(name))
+			if len(mimeType) == 0 {
+				mimeType = "application/octet-stream"
+			}
+			if i := strings.Index(mimeType, ";"); i >= 0 {
+				mimeType = mimeType[:i]
+			}
+			stream.Insert("Subtype", pdfName(mimeType))
+		}
+		params, _ := ctx.DereferenceDict(stream.Dict["Params"])
+		if params == nil {
+			params = pdfcpu.NewDict()
+			stream.Insert("Params", params)
+		}
+		if params["ModDate"] == nil {
+			params.Insert("ModDate", pdfcpu.StringLiteral(pdfDate(time.Now())))
+		}
+
+		if ref, ok := o.(pdfcpu.IndirectRef); ok {
+			found := false
+			for _, item := range af {
+				if r, ok := item.(pdfcpu.IndirectRef); ok && r.ObjectNumber == ref.ObjectNumber {
+					found = true
+				}
+			}
+			if !found {
+				af = append(af, ref)
+			}
+		} else {
+			conv.issue("file specification %s is not an indirect object", name)
+		}
+	}
+	if len(af) > 0 {
+		root.Update("AF", af)
+	}
+	return nil
+}
+
+// 名称树中的所有值
+func collectNameTreeValues(xRefTable *pdfcpu.XRefTable, o pdfcpu.Object, values *[]pdfcpu.Object, depth int) {
+	node, err := xRefTable.DereferenceDict(o)
+	if err != nil || node == nil || depth > 32 {
+		return
+	}
+	if names, err := xRefTable.DereferenceArray(node["Names"]); err == nil {
+		for i := 1; i < len(names); i += 2 {
+			*values = append(*values, names[i])
+		}
+	}
+	if kids, err := xRefTable.DereferenceArray(node["Kids"]); err == nil {
+		for _, kid := range kids {
+			collectNameTreeValues(xRefTable, kid, values, depth+1)
+		}
+	}
+}
+
+// 检查字体是否嵌入，未嵌入的简单字体用内置的 Go 字体替换
+func (conv *pdfaConverter) fixFont(d pdfcpu.Dict) {
+	ctx := conv.ctx
+	subtype := ""
+	if s := d.Subtype(); s != nil {
+		subtype = *s
+	}
+	name := ""
+	if n := d.NameEntry("BaseFont"); n != nil {
+		name = *n
+	}
+	switch subtype {
+	case "Type3":
+		return
+	case "Type0":
+		fonts, _ := ctx.DereferenceArray(d["DescendantFonts"])
+		if len(fonts) == 0 {
+			conv.issue("font %s has no descendant font", name)
+			return
+		}
+		descendant, _ := ctx.DereferenceDict(fonts[0])
+		if descendant == nil || !fontEmbedded(ctx.XRefTable, descendant) {
+			conv.issue("font %s is not embedded", name)
+		}
+		return
+	}
+	if fontEmbedded(ctx.XRefTable, d) {
+		return
+	}
+
+	// 只替换使用 WinAnsi 或标准编码的非符号字体
+	encoding, _ := ctx.Dereference(d["Encoding"])
+	switch e := encoding.(type) {
+	case nil:
+	case pdfcpu.Name:
+		if e != "WinAnsiEncoding" && e != "StandardEncoding" {
+			conv.issue("font %s is not embedded", name)
+			return
+		}
+	default:
+		conv.issue("font %s is not embedded", name)
+		return
+	}
+	lower := strings.ToLower(name)
+	if strings.Contains(lower, "symbol") || strings.Contains(lower, "dingbats") {
+		conv.issue("font %s is not embedded", name)
+		return
+	}
+
+	substitute, err := conv.substituteFont(name)
+	if err != nil {
+		conv.issue("font %s is not embedded: %v", name, err)
+		return
+	}
+	conv.substituted(pdfcpu.Name(name).Value(), substitute.Name)
+	d.Update("Subtype", pdfcpu.Name("TrueType"))
+	d.Update("BaseFont", pdfcpu.Name(substitute.Name))
+	d.Update("Encoding", pdfcpu.Name("WinAnsiEncoding"))
+	d.Update("FirstChar", pdfcpu.Integer(32))
+	d.Update("LastChar", pdfcpu.Integer(255))
+	d.Update("Widths", substitute.Widths)
+	d.Update("FontDescriptor", substitute.Descriptor)
+}
+
+func fontEmbedded(xRefTable *pdfcpu.XRefTable, d pdfcpu.Dict) bool {
+	descriptor, err := xRefTable.DereferenceDict(d["FontDescriptor"])
+	if err != nil || descriptor == nil {
+		return false
+	}
+	for _, key := range []string{"FontFile", "FontFile2", "FontFile3"} {
+		if descriptor[key] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// 嵌入签名外观等资源中的字体，用于 PDF/A 文件
+func embedResourceFonts(ctx *pdfcpu.Context, resources pdfcpu.Dict) error {
+	conv := newPDFAConverter(ctx, 0)
+	fonts, err := ctx.DereferenceDict(resources["Font"])
+	if err != nil {
+		return err
+	}
+	for _, o := range fonts {
+		if d, err := ctx.DereferenceDict(o); err == nil && d != nil {
+			conv.fixFont(d)
+		}
+	}
+	if len(conv.issues) > 0 {
+		return fmt.Errorf("%s", strings.Join(conv.issues, "; "))
+	}
+	return nil
+}
+
+// 替换字体：嵌入的 Go 字体及对应的宽度
+type substituteFont struct {
+	Name       string
+	Widths     pdfcpu.Array
+	Descriptor pdfcpu.IndirectRef
+}
+
+// 按字体名称选择 Go 字体：Courier 及等宽字体用 Go Mono，其他用 Go，并区分粗体及斜体
+func substituteFontData(name string) (string, []byte, int) {
+	lower := strings.ToLower(name)
+	mono := strings.Contains(lower, "courier") || strings.Contains(lower, "mono")
+	bold := strings.Contains(lower, "bold") || strings.Contains(lower, "black") || strings.Contains(lower, "heavy")
+	italic := strings.Contains(lower, "italic") || strings.Contains(lower, "oblique")
+	flags := 32 // Nonsymbolic
+	if italic {
+		flags |= 64
+	}
+	if mono {
+		flags |= 1
+		switch {
+		case bold && italic:
+			return "GoMono-BoldItalic", gomonobolditalic.TTF, flags
+		case bold:
+			return "GoMono-Bold", gomonobold.TTF, flags
+		case italic:
+			return "GoMono-Italic", gomonoitalic.TTF, flags
+		}
+		return "GoMono", gomono.TTF, flags
+	}
+	switch {
+	case bold && italic:
+		return "Go-BoldItalic", gobolditalic.TTF, flags
+	case bold:
+		return "Go-Bold", gobold.TTF, flags
+	case italic:
+		return "Go-Italic", goitalic.TTF, flags
+	}
+	return "Go-Regular", goregular.TTF, flags
+}
+
+func (conv *pdfaConverter) substituteFont(name string) (*substituteFont, error) {
+	fontName, ttf, flags := substituteFontData(name)
+	if substitute, ok := conv.fonts[fontName]; ok {
+		return substitute, nil
+	}
+	// gofpdf 只能从文件解析 TrueType 字体
+	tmp, err := os.CreateTemp("", "*.ttf")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(ttf)
+	tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+	f, err := gofpdf.TtfParse(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	scale := func(v int) pdfcpu.Integer {
+		return pdfcpu.Integer(math.Round(float64(v) * 1000 / float64(f.UnitsPerEm)))
+	}
+
+	// 宽度必须与嵌入字体中实际使用的字形一致，未映射的编码使用 .notdef 的宽度
+	widths := make(pdfcpu.Array, 0, 224)
+	for code := 32; code <= 255; code++ {
+		glyph := f.Chars[uint16(winAnsiRune(byte(code)))]
+		width := 0
+		if int(glyph) < len(f.Widths) {
+			width = int(f.Widths[glyph])
+		}
+		widths = append(widths, scale(width))
+	}
+
+	capHeight := int(f.CapHeight)
+	if capHeight == 0 {
+		capHeight = int(f.TypoAscender)
+	}
+	stemV := 80
+	if f.Bold {
+		stemV = 120
+	}
+
+	fontFile, err := newFlateStream(pdfcpu.Dict{"Length1": pdfcpu.Integer(len(ttf))}, ttf)
+	if err != nil {
+		return nil, err
+	}
+	fontFileRef, err := conv.ctx.IndRefForNewObject(*fontFile)
+	if err != nil {
+		return nil, err
+	}
+	descriptor := pdfcpu.Dict{
+		"Type":        pdfcpu.Name("FontDescriptor"),
+		"FontName":    pdfcpu.Name(fontName),
+		"Flags":       pdfcpu.Integer(flags),
+		"FontBBox":    pdfcpu.Array{scale(int(f.Xmin)), scale(int(f.Ymin)), scale(int(f.Xmax)), scale(int(f.Ymax))},
+		"ItalicAngle": pdfcpu.Integer(f.ItalicAngle),
+		"Ascent":      scale(int(f.TypoAscender)),
+		"Descent":     scale(int(f.TypoDescender)),
+		"CapHeight":   scale(capHeight),
+		"StemV":       pdfcpu.Integer(stemV),
+		"FontFile2":   *fontFileRef,
+	}
+	descriptorRef, err := conv.ctx.IndRefForNewObject(descriptor)
+	if err != nil {
+		return nil, err
+	}
+	substitute := &substituteFont{Name: fontName, Widths: widths, Descriptor: *descriptorRef}
+	conv.fonts[fontName] = substitute
+	return substitute, nil
+}
+
+// WinAnsiEncoding 中 0x80-0x9F 的字符，其余与 Latin-1 相同
+var winAnsiHigh = map[byte]rune{
+	0x80: 0x20ac, 0x82: 0x201a, 0x83: 0x0192, 0x84: 0x201e, 0x85: 0x2026, 0x86: 0x2020, 0x87: 0x2021,
+	0x88: 0x02c6, 0x89: 0x2030, 0x8a: 0x0160, 0x8b: 0x2039, 0x8c: 0x0152, 0x8e: 0x017d,
+	0x91: 0x2018, 0x92: 0x2019, 0x93: 0x201c, 0x94: 0x201d, 0x95: 0x2022, 0x96: 0x2013, 0x97: 0x2014,
+	0x98: 0x02dc, 0x99: 0x2122, 0x9a: 0x0161, 0x9b: 0x203a, 0x9c: 0x0153, 0x9e: 0x017e, 0x9f: 0x0178,
+}
+
+func winAnsiRune(c byte) rune {
+	if c >= 0x80 && c <= 0x9f {
+		return winAnsiHigh[c]
+	}
+	return rune(c)
+}
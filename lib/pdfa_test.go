@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func Test_ParseConformance(t *testing.T) {
+	for value, expected := range map[string]int{"2b": 2, "PDF/A-3b": 3, "a-2B": 2} {
+		c, err := parseConformance(newFormRequest(url.Values{"pdfa": {value}}))
+		if err != nil || c == nil || c.Part != expected || c.String() != "PDF/A-"+string(rune('0'+expected))+"b" {
+			t.Errorf("%s: unexpected conformance %v %v", value, c, err)
+		}
+	}
+	if _, err := parseConformance(newFormRequest(url.Values{"pdfa": {"1a"}})); err == nil {
+		t.Error("expected error for pdfa=1a")
+	}
+	if _, err := ParseOutputOptions(newFormRequest(url.Values{"pdfa": {"2b"}, "user_password": {"secret"}})); err == nil {
+		t.Error("expected error for encrypted pdf/a")
+	}
+}
+
+func Test_SRGBICCProfile(t *testing.T) {
+	profile := srgbICCProfile()
+	if int(binary.BigEndian.Uint32(profile)) != len(profile) || string(profile[36:40]) != "acsp" ||
+		string(profile[12:20]) != "mntrRGB " {
+		t.Fatalf("invalid profile header: % x", profile[:40])
+	}
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := profile[132+i*12:]
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if offset%4 != 0 || int(offset+size) > len(profile) {
+			t.Errorf("invalid tag %s: %d+%d", entry[:4], offset, size)
+		}
+	}
+}
+
+func writeTextTestPDF(t *testing.T, file string) {
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.SetFont("Helvetica", "B", 12)
+	pdf.AddPage()
+	pdf.Text(50, 50, "Contract")
+	if err := pdf.OutputFileAndClose(file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_ConvertPDFA(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "contract.pdf")
+	writeTextTestPDF(t, file)
+	if err := SetPDFMetadata(file, &Metadata{Title: "Contract", Custom: map[string]string{"Department": "Legal"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	conformance := &Conformance{Part: 3, Level: "b"}
+	if err := ConvertPDFA(file, conformance); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ValidateFile(file, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+	// 替换的字体记录在转换结果中，并通过响应头返回
+	if list := conformance.Substitutions; len(list) != 1 || list[0].Font != "Helvetica-Bold" || list[0].Substitute != "Go-Bold" {
+		t.Errorf("unexpected substitutions: %v", list)
+	}
+	recorder := httptest.NewRecorder()
+	setConformanceHeaders(recorder, &OutputOptions{Conformance: conformance})
+	if header := recorder.Header().Get("X-PDFA-Font-Substitutions"); header != "Helvetica-Bold=Go-Bold" {
+		t.Errorf("unexpected header: %s", header)
+	}
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _, _ := ctx.PageDict(1)
+	resources, _ := ctx.DereferenceDict(page["Resources"])
+	fonts, _ := ctx.DereferenceDict(resources["Font"])
+	for name, o := range fonts {
+		font, _ := ctx.DereferenceDict(o)
+		if !fontEmbedded(ctx.XRefTable, font) || font.NameEntry("BaseFont") == nil || *font.NameEntry("BaseFont") != "Go-Bold" {
+			t.Errorf("font %s not embedded: %v", name, font)
+		}
+		widths, _ := ctx.DereferenceArray(font["Widths"])
+		if len(widths) != 224 {
+			t.Errorf("unexpected widths: %d", len(widths))
+		}
+	}
+
+	root, _ := ctx.Catalog()
+	intents, _ := ctx.DereferenceArray(root["OutputIntents"])
+	if len(intents) != 1 {
+		t.Errorf("unexpected output intents: %v", intents)
+	}
+	metadata, _ := root["Metadata"].(pdfcpu.IndirectRef)
+	xmp, err := pdfcpu.ExtractStreamData(ctx, metadata.ObjectNumber.Value())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"<pdfaid:part>3</pdfaid:part>", "<pdfaid:conformance>B</pdfaid:conformance>",
+		"<pdf:Producer>FPDF 1.7</pdf:Producer>", "<pdfaProperty:name>Department</pdfaProperty:name>", "Contract"} {
+		if !bytes.Contains(xmp, []byte(expected)) {
+			t.Errorf("xmp does not contain %s", expected)
+		}
+	}
+	if meta, err := ReadMetadata(file); err != nil || meta.Producer != "FPDF 1.7" || meta.Custom["Department"] != "Legal" {
+		t.Errorf("unexpected metadata: %+v %v", meta, err)
+	}
+}
+
+func Test_ConvertPDFAIssues(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cmyk.pdf")
+	writeTextTestPDF(t, file)
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _, _ := ctx.PageDict(1)
+	before, _ := newContentStream(ctx, "0 0 0 1 k\n")
+	after, _ := newContentStream(ctx, "\n")
+	wrapPageContents(ctx.XRefTable, page, *before, *after)
+	if err = writePDFContext(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+
+	err = ConvertPDFA(file, &Conformance{Part: 2, Level: "b"})
+	var conformance *ConformanceError
+	if !errors.As(err, &conformance) || len(conformance.Issues) != 1 || !strings.Contains(err.Error(), "page 1 uses DeviceCMYK") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if ctx, err = readPDFContext(file); err != nil {
+		t.Fatal(err)
+	}
+	if root, _ := ctx.Catalog(); root["OutputIntents"] != nil {
+		t.Error("file was modified")
+	}
+}
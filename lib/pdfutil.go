@@ -0,0 +1,662 @@
+package lib
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+type PageInfo struct {
+	Width    float64 `json:"width"`  // 点（1/72 英寸）
+	Height   float64 `json:"height"` // 点（1/72 英寸）
+	Rotation int     `json:"rotation"`
+}
+
+func newPDFConfig() *pdfcpu.Configuration {
+	config := pdfcpu.NewDefaultConfiguration()
+	config.ValidationMode = pdfcpu.ValidationRelaxed
+	return config
+}
+
+func readPDFContext(file string) (*pdfcpu.Context, error) {
+	return readPDFContextWithConfig(file, newPDFConfig())
+}
+
+func readPDFContextWithConfig(file string, config *pdfcpu.Configuration) (*pdfcpu.Context, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	ctx, err := api.ReadContext(in, config)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.EnsurePageCount(); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+func writePDFContext(ctx *pdfcpu.Context, file string) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err = writePDFContextTo(ctx, out); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// pdfcpu 写文件时会把 Info 中的 Producer、CreationDate、ModDate 替换成自己的值。
+// 写完后以增量更新的方式追加一个新版本的 Info 对象，恢复原来的值
+func writePDFContextTo(ctx *pdfcpu.Context, w io.Writer) error {
+	info, kept := infoEntriesToKeep(ctx)
+	if len(kept) == 0 {
+		return api.WriteContext(ctx, w)
+	}
+
+	var buf bytes.Buffer
+	err := api.WriteContext(ctx, &buf)
+	for key, value := range kept {
+		info.Update(key, value)
+	}
+	if err != nil {
+		return err
+	}
+	if err = appendInfoUpdate(&buf, ctx, info); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+var (
+	startXRefPattern = regexp.MustCompile(`startxref\s+(\d+)\s+%%EOF\s*$`)
+	xrefSizePattern  = regexp.MustCompile(`/Size\s+(\d+)`)
+	xrefRefPattern   = regexp.MustCompile(`/(Root|Info)\s+(\d+)\s+(\d+)\s+R`)
+)
+
+// 追加只包含 Info 对象的增量更新
+func appendInfoUpdate(buf *bytes.Buffer, ctx *pdfcpu.Context, info pdfcpu.Dict) error {
+	_, _, refs, err := lastXRefSection(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("unable to keep document info: %v", err)
+	}
+	infoRef, hasInfo := refs["Info"]
+	if !hasInfo {
+		return errors.New("unable to keep document info: trailer references not found")
+	}
+	if err = appendUpdate(buf, ctx, []pdfUpdateObject{{infoRef[0], infoRef[1], info}}); err != nil {
+		return fmt.Errorf("unable to keep document info: %v", err)
+	}
+	return nil
+}
+
+// 增量更新中写入的对象
+type pdfUpdateObject struct {
+	nr, gen int
+	obj     pdfcpu.Object
+}
+
+// 文件最后一个交叉引用段的位置、trailer 中的 Size 及 Root、Info 引用
+func lastXRefSection(data []byte) (prev int, size int, refs map[string][2]int, err error) {
+	tail := data
+	if len(tail) > 1024 {
+		tail = tail[len(tail)-1024:]
+	}
+	match := startXRefPattern.FindSubmatch(tail)
+	if match == nil {
+		return 0, 0, nil, errors.New("startxref not found")
+	}
+	prev, _ = strconv.Atoi(string(match[1]))
+	if prev <= 0 || prev >= len(data) {
+		return 0, 0, nil, errors.New("invalid startxref")
+	}
+	section := data[prev:]
+	sizeMatch := xrefSizePattern.FindSubmatch(section)
+	if sizeMatch == nil {
+		return 0, 0, nil, errors.New("trailer size not found")
+	}
+	size, _ = strconv.Atoi(string(sizeMatch[1]))
+	refs = make(map[string][2]int)
+	for _, m := range xrefRefPattern.FindAllSubmatch(section, -1) {
+		nr, _ := strconv.Atoi(string(m[2]))
+		gen, _ := strconv.Atoi(string(m[3]))
+		refs[string(m[1])] = [2]int{nr, gen}
+	}
+	return prev, size, refs, nil
+}
+
+// 追加包含指定对象的增量更新。交叉引用与文件最后一段的形式一致：
+// 交叉引用表追加 xref 段及 trailer，交叉引用流追加一个新的交叉引用流
+func appendUpdate(buf *bytes.Buffer, ctx *pdfcpu.Context, objects []pdfUpdateObject) error {
+	prev, size, refs, err := lastXRefSection(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	root, hasRoot := refs["Root"]
+	if !hasRoot {
+		return errors.New("trailer references not found")
+	}
+	trailer := fmt.Sprintf("/Root %d %d R", root[0], root[1])
+	if info, hasInfo := refs["Info"]; hasInfo {
+		trailer += fmt.Sprintf("/Info %d %d R", info[0], info[1])
+	}
+	if len(ctx.ID) > 0 {
+		trailer += "/ID" + ctx.ID.PDFString()
+	}
+	xrefTable := bytes.HasPrefix(buf.Bytes()[prev:], []byte("xref"))
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].nr < objects[j].nr })
+	offsets := make([]int, len(objects))
+	buf.WriteString("\n")
+	for i, o := range objects {
+		if o.nr >= size {
+			size = o.nr + 1
+		}
+		offsets[i] = buf.Len()
+		if sd, ok := o.obj.(pdfcpu.StreamDict); ok {
+			sd.Dict.Update("Length", pdfcpu.Integer(len(sd.Raw)))
+			fmt.Fprintf(buf, "%d %d obj\n%s\nstream\n", o.nr, o.gen, sd.Dict.PDFString())
+			buf.Write(sd.Raw)
+			buf.WriteString("\nendstream\nendobj\n")
+			continue
+		}
+		fmt.Fprintf(buf, "%d %d obj\n%s\nendobj\n", o.nr, o.gen, o.obj.PDFString())
+	}
+
+	xref := buf.Len()
+	if xrefTable {
+		buf.WriteString("xref\n")
+		for i, o := range objects {
+			fmt.Fprintf(buf, "%d 1\n%010d %05d n \n", o.nr, offsets[i], o.gen)
+		}
+		fmt.Fprintf(buf, "trailer\n<<%s/Size %d/Prev %d>>\n", trailer, size, prev)
+	} else {
+		// 交叉引用流本身使用新的对象号，W [1 4 2]：类型、偏移、代数
+		entry := func(offset, gen int) []byte {
+			return []byte{1, byte(offset >> 24), byte(offset >> 16), byte(offset >> 8), byte(offset), byte(gen >> 8), byte(gen)}
+		}
+		var stream []byte
+		var index []string
+		for i, o := range objects {
+			stream = append(stream, entry(offsets[i], o.gen)...)
+			index = append(index, fmt.Sprintf("%d 1", o.nr))
+		}
+		stream = append(stream, entry(xref, 0)...)
+		index = append(index, fmt.Sprintf("%d 1", size))
+		fmt.Fprintf(buf, "%d 0 obj\n<</Type/XRef%s/Size %d/Prev %d/Index[%s]/W[1 4 2]/Length %d>>\nstream\n",
+			size, trailer, size+1, prev, strings.Join(index, " "), len(stream))
+		buf.Write(stream)
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+	fmt.Fprintf(buf, "startxref\n%d\n%%%%EOF\n", xref)
+	return nil
+}
+
+// 增量更新需要写入的对象：对象号不小于 firstNew 的新对象，以及 refs 中间接引用的对象
+func updateObjects(ctx *pdfcpu.Context, firstNew int, refs ...pdfcpu.Object) ([]pdfUpdateObject, error) {
+	var objects []pdfUpdateObject
+	seen := make(map[int]bool)
+	add := func(nr int) error {
+		entry, found := ctx.FindTableEntryLight(nr)
+		if !found || entry.Free || entry.Object == nil {
+			return fmt.Errorf("missing object %d", nr)
+		}
+		gen := 0
+		if entry.Generation != nil {
+			gen = *entry.Generation
+		}
+		seen[nr] = true
+		objects = append(objects, pdfUpdateObject{nr, gen, entry.Object})
+		return nil
+	}
+	for nr := firstNew; nr < *ctx.XRefTable.Size; nr++ {
+		if err := add(nr); err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range refs {
+		if ir, ok := o.(pdfcpu.IndirectRef); ok && !seen[ir.ObjectNumber.Value()] {
+			if err := add(ir.ObjectNumber.Value()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return objects, nil
+}
+
+// 需要保留的 Info 条目，加密的文件无法覆盖，不做处理
+func infoEntriesToKeep(ctx *pdfcpu.Context) (pdfcpu.Dict, map[string]pdfcpu.Object) {
+	if ctx.Info == nil || ctx.Encrypt != nil {
+		return nil, nil
+	}
+	info, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || info == nil {
+		return nil, nil
+	}
+	kept := make(map[string]pdfcpu.Object)
+	for _, key := range []string{"Producer", "CreationDate", "ModDate"} {
+		if o, found := info.Find(key); found {
+			if value, err := ctx.Dereference(o); err == nil && value != nil {
+				kept[key] = value
+			}
+		}
+	}
+	return info, kept
+}
+
+// 查找页面的属性，找不到时沿 Parent 向上查找继承的属性
+func inheritedPageEntry(xRefTable *pdfcpu.XRefTable, page pdfcpu.Dict, key string) pdfcpu.Object {
+	for depth := 0; page != nil && depth < 32; depth++ {
+		if o, found := page.Find(key); found {
+			return o
+		}
+		parent := page.IndirectRefEntry("Parent")
+		if parent == nil {
+			return nil
+		}
+		d, err := xRefTable.DereferenceDict(*parent)
+		if err != nil {
+			return nil
+		}
+		page = d
+	}
+	return nil
+}
+
+// 页面的可见区域，优先使用 CropBox
+func pageBox(xRefTable *pdfcpu.XRefTable, page pdfcpu.Dict) ([4]float64, bool) {
+	var rect [4]float64
+	box := inheritedPageEntry(xRefTable, page, "CropBox")
+	if box == nil {
+		box = inheritedPageEntry(xRefTable, page, "MediaBox")
+	}
+	a, err := xRefTable.DereferenceArray(box)
+	if err != nil || len(a) != 4 {
+		return rect, false
+	}
+	for i, o := range a {
+		rect[i], _ = xRefTable.DereferenceNumber(o)
+	}
+	return rect, true
+}
+
+func pageInfo(xRefTable *pdfcpu.XRefTable, page pdfcpu.Dict) PageInfo {
+	info := PageInfo{Width: 595.27, Height: 841.89}
+
+	if rect, ok := pageBox(xRefTable, page); ok {
+		info.Width = rect[2] - rect[0]
+		info.Height = rect[3] - rect[1]
+		if info.Width < 0 {
+			info.Width = -info.Width
+		}
+		if info.Height < 0 {
+			info.Height = -info.Height
+		}
+	}
+
+	if o := inheritedPageEntry(xRefTable, page, "Rotate"); o != nil {
+		if rotate, err := xRefTable.DereferenceInteger(o); err == nil && rotate != nil {
+			info.Rotation = ((rotate.Value() % 360) + 360) % 360
+		}
+	}
+	return info
+}
+
+// 读取每一页的尺寸及旋转角度
+func ReadPageInfo(file string) ([]PageInfo, error) {
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		return nil, err
+	}
+	return contextPageInfo(ctx)
+}
+
+func contextPageInfo(ctx *pdfcpu.Context) ([]PageInfo, error) {
+	list := make([]PageInfo, ctx.PageCount)
+	for i := 1; i <= ctx.PageCount; i++ {
+		page, _, err := ctx.PageDict(i)
+		if err != nil {
+			return nil, err
+		}
+		list[i-1] = pageInfo(ctx.XRefTable, page)
+	}
+	return list, nil
+}
+
+// 查找第 n 页（从 1 开始）的间接引用
+func pageRef(ctx *pdfcpu.Context, n int) (*pdfcpu.IndirectRef, error) {
+	root, err := ctx.Pages()
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	ref, err := findPageRef(ctx.XRefTable, *root, n, &count)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("page %d not found", n)
+	}
+	return ref, nil
+}
+
+func findPageRef(xRefTable *pdfcpu.XRefTable, node pdfcpu.IndirectRef, n int, count *int) (*pdfcpu.IndirectRef, error) {
+	d, err := xRefTable.DereferenceDict(node)
+	if err != nil {
+		return nil, err
+	}
+	if t := d.Type(); t != nil && *t == "Page" {
+		*count++
+		if *count == n {
+			return &node, nil
+		}
+		return nil, nil
+	}
+	// 跳过不包含目标页的子树
+	if c := d.IntEntry("Count"); c != nil && *count+*c < n {
+		*count += *c
+		return nil, nil
+	}
+	kids, err := xRefTable.DereferenceArray(d["Kids"])
+	if err != nil {
+		return nil, err
+	}
+	for _, kid := range kids {
+		ir, ok := kid.(pdfcpu.IndirectRef)
+		if !ok {
+			continue
+		}
+		ref, err := findPageRef(xRefTable, ir, n, count)
+		if err != nil || ref != nil {
+			return ref, err
+		}
+	}
+	return nil, nil
+}
+
+// 按 order（从 1 开始的页码）重建页面树，未列出的页面被删除。
+// 所有页面直接挂在根节点下，继承的属性先复制到页面上
+func reorderPages(ctx *pdfcpu.Context, order []int) error {
+	rootRef, err := ctx.Pages()
+	if err != nil {
+		return err
+	}
+	root, err := ctx.DereferenceDict(*rootRef)
+	if err != nil {
+		return err
+	}
+
+	kids := make(pdfcpu.Array, 0, len(order))
+	seen := make(map[int]bool)
+	for _, n := range order {
+		if n < 1 || n > ctx.PageCount {
+			return fmt.Errorf("page %d out of range (%d pages)", n, ctx.PageCount)
+		}
+		if seen[n] {
+			return fmt.Errorf("page %d selected more than once", n)
+		}
+		seen[n] = true
+		ref, err := pageRef(ctx, n)
+		if err != nil {
+			return err
+		}
+		page, _, err := ctx.PageDict(n)
+		if err != nil {
+			return err
+		}
+		for _, key := range []string{"Resources", "MediaBox", "CropBox", "Rotate"} {
+			if _, found := page.Find(key); !found {
+				if o := inheritedPageEntry(ctx.XRefTable, page, key); o != nil {
+					page.Insert(key, o)
+				}
+			}
+		}
+		kids = append(kids, *ref)
+	}
+	for _, kid := range kids {
+		page, err := ctx.DereferenceDict(kid)
+		if err != nil {
+			return err
+		}
+		page.Update("Parent", *rootRef)
+	}
+
+	for _, key := range []string{"Resources", "MediaBox", "CropBox", "Rotate"} {
+		root.Delete(key)
+	}
+	root.Update("Kids", kids)
+	root.Update("Count", pdfcpu.Integer(len(kids)))
+	ctx.PageCount = len(kids)
+	return nil
+}
+
+// 向字典中的数组追加元素，数组为间接对象时直接修改该对象
+func appendArrayEntry(xRefTable *pdfcpu.XRefTable, d pdfcpu.Dict, key string, items ...pdfcpu.Object) error {
+	o, found := d.Find(key)
+	if !found {
+		d.Insert(key, pdfcpu.Array(items))
+		return nil
+	}
+	a, err := xRefTable.DereferenceArray(o)
+	if err != nil {
+		return err
+	}
+	a = append(a, items...)
+	if ir, ok := o.(pdfcpu.IndirectRef); ok {
+		entry, found := xRefTable.FindTableEntryForIndRef(&ir)
+		if !found {
+			return fmt.Errorf("missing object %s", ir)
+		}
+		entry.Object = a
+		return nil
+	}
+	d.Update(key, a)
+	return nil
+}
+
+// PDF 文本字符串，非 ASCII 内容使用 UTF-16BE 编码
+func pdfTextString(s string) pdfcpu.Object {
+	ascii := true
+	for _, r := range s {
+		if r > 0x7e || (r < 0x20 && r != '\n' && r != '\r' && r != '\t') {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		escaped, _ := pdfcpu.Escape(s)
+		return pdfcpu.StringLiteral(*escaped)
+	}
+	var b bytes.Buffer
+	b.Write([]byte{0xfe, 0xff})
+	for _, c := range utf16.Encode([]rune(s)) {
+		b.WriteByte(byte(c >> 8))
+		b.WriteByte(byte(c))
+	}
+	return pdfcpu.HexLiteral(hex.EncodeToString(b.Bytes()))
+}
+
+// PDF 名称对象，pdfcpu 按原样写出名称，分隔符及非常规字符需要转义为 #xx
+func pdfName(s string) pdfcpu.Name {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || strings.IndexByte("#()<>[]{}/%", c) >= 0 {
+			fmt.Fprintf(&b, "#%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return pdfcpu.Name(b.String())
+}
+
+// 使用 FlateDecode 压缩的流对象
+func newFlateStream(d pdfcpu.Dict, content []byte) (*pdfcpu.StreamDict, error) {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	length := int64(b.Len())
+	d.Update("Filter", pdfcpu.Name("FlateDecode"))
+	d.Update("Length", pdfcpu.Integer(length))
+	sd := pdfcpu.NewStreamDict(d, 0, &length, nil, []pdfcpu.PDFFilter{{Name: "FlateDecode"}})
+	sd.Content = content
+	sd.Raw = b.Bytes()
+	return &sd, nil
+}
+
+// 解析页面选择，返回按选择顺序排列的页码。支持 "3"、"1-5"、"5-1"（倒序）、"4-"、"-3"、
+// "last"、"odd"、"even"，以逗号分隔；以 "!" 开头的项从结果中排除。没有选中任何页面时返回空列表
+func ParsePageRanges(selection string, pageCount int) ([]int, error) {
+	pages := make([]int, 0)
+	excludes := make(map[int]bool)
+
+	parse := func(value string) (int, error) {
+		if strings.EqualFold(value, "last") {
+			return pageCount, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid page: %s", value)
+		}
+		if n > pageCount {
+			return 0, fmt.Errorf("page %d out of range (%d pages)", n, pageCount)
+		}
+		return n, nil
+	}
+
+	for _, item := range strings.Split(selection, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		exclude := strings.HasPrefix(item, "!")
+		item = strings.TrimPrefix(item, "!")
+
+		list := make([]int, 0)
+		switch {
+		case strings.EqualFold(item, "odd"), strings.EqualFold(item, "even"):
+			start := 1
+			if strings.EqualFold(item, "even") {
+				start = 2
+			}
+			for i := start; i <= pageCount; i += 2 {
+				list = append(list, i)
+			}
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			from, to := 1, pageCount
+			var err error
+			if len(bounds[0]) > 0 {
+				if from, err = parse(bounds[0]); err != nil {
+					return nil, err
+				}
+			}
+			if len(bounds[1]) > 0 {
+				if to, err = parse(bounds[1]); err != nil {
+					return nil, err
+				}
+			}
+			step := 1
+			if from > to {
+				step = -1
+			}
+			for i := from; ; i += step {
+				list = append(list, i)
+				if i == to {
+					break
+				}
+			}
+		default:
+			n, err := parse(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, n)
+		}
+
+		if exclude {
+			for _, n := range list {
+				excludes[n] = true
+			}
+		} else {
+			pages = append(pages, list...)
+		}
+	}
+
+	// 只有排除项时从全部页面中排除
+	if len(pages) == 0 && len(excludes) > 0 {
+		for i := 1; i <= pageCount; i++ {
+			pages = append(pages, i)
+		}
+	}
+	result := make([]int, 0, len(pages))
+	for _, n := range pages {
+		if !excludes[n] {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+// 文件名中带有扩展名时，pdfcpu 才能识别水印等文件的类型
+func copyWithExt(src string, ext string) (string, error) {
+	dest := src + ext
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	return dest, os.WriteFile(dest, data, 0666)
+}
+
+// PDF 日期格式 D:YYYYMMDDHHmmSS+HH'mm'
+func pdfDate(t time.Time) string {
+	_, offset := t.Zone()
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("D:%s%s%02d'%02d'", t.Format("20060102150405"), sign, offset/3600, offset%3600/60)
+}
+
+// 解析 PDF 日期，时分秒及时区可以省略
+func parsePDFDate(s string) (time.Time, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	s = strings.ReplaceAll(s, "'", "")
+	if i := strings.IndexByte(s, 'Z'); i >= 0 {
+		s = s[:i+1]
+	}
+	layouts := []string{"20060102150405-0700", "20060102150405Z0700", "20060102150405",
+		"200601021504", "2006010215", "20060102", "200601", "2006"}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
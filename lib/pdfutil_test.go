@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func Test_ParsePageRanges(t *testing.T) {
+	cases := map[string][]int{
+		"1-3,5":   {1, 2, 3, 5},
+		"4-2":     {4, 3, 2},
+		"4-":      {4, 5, 6},
+		"-2,last": {1, 2, 6},
+		"even":    {2, 4, 6},
+		"!1,!6":   {2, 3, 4, 5},
+		"odd,!3":  {1, 5},
+	}
+	for selection, expected := range cases {
+		pages, err := ParsePageRanges(selection, 6)
+		if err != nil {
+			t.Errorf("%s: %v", selection, err)
+			continue
+		}
+		if !reflect.DeepEqual(pages, expected) {
+			t.Errorf("%s: expected %v, got %v", selection, expected, pages)
+		}
+	}
+
+	if pages, err := ParsePageRanges("!1-6", 6); err != nil || len(pages) != 0 {
+		t.Errorf("expected empty selection, got %v %v", pages, err)
+	}
+
+	for _, selection := range []string{"7", "0", "a-b"} {
+		if _, err := ParsePageRanges(selection, 6); err == nil {
+			t.Errorf("%s: expected error", selection)
+		}
+	}
+}
+
+func Test_WriteKeepsDocumentInfo(t *testing.T) {
+	dir := t.TempDir()
+	for _, xrefStream := range []bool{true, false} {
+		file := filepath.Join(dir, fmt.Sprintf("info-%t.pdf", xrefStream))
+		writeTestPDF(t, file, 1)
+		config := newPDFConfig()
+		config.WriteXRefStream = xrefStream
+		config.WriteObjectStream = xrefStream
+		ctx, err := readPDFContextWithConfig(file, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, _ := ctx.DereferenceDict(*ctx.Info)
+		info.Update("Producer", pdfcpu.StringLiteral("Report Service"))
+		if err = writePDFContext(ctx, file); err != nil {
+			t.Fatal(err)
+		}
+		if err = api.ValidateFile(file, newPDFConfig()); err != nil {
+			t.Fatalf("xref stream %t: %v", xrefStream, err)
+		}
+		if meta, err := ReadMetadata(file); err != nil || meta.Producer != "Report Service" {
+			t.Errorf("xref stream %t: producer not kept: %+v %v", xrefStream, meta, err)
+		}
+	}
+
+	// 找不到交叉引用时返回错误，而不是丢掉 Info
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	ctx, err := readPDFContext(filepath.Join(dir, "info-true.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := ctx.DereferenceDict(*ctx.Info)
+	if err = appendInfoUpdate(&buf, ctx, info); err == nil {
+		t.Error("expected error without startxref")
+	}
+}
@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 渲染脚本输出的打印分页信息（点，文档坐标）：PageHeight 为纸张高度，Breaks 为强制分页的位置，
+// Blocks 为打印时不会跨页的区域（文字行、图片、表单控件等）
+type printLayout struct {
+	PageHeight float64      `json:"page_height"`
+	Breaks     []float64    `json:"breaks"`
+	Blocks     [][2]float64 `json:"blocks"`
+}
+
+// 分页后文档位置 Y 及其后内容的累计下移距离
+type layoutShift struct {
+	Y     float64
+	Shift float64
+}
+
+// 计算位置时允许的误差（点）
+const layoutEpsilon = 0.01
+
+// 按打印规则模拟分页：强制分页把后面的内容移到下一页的顶部，
+// 不高于一页且跨越页面边界的区域整体移到下一页。返回按位置排列的累计下移距离。
+// PhantomJS 在 page.render 中由 QPrinter 完成打印分页，页面脚本无法读取打印后的位置，
+// 只能在纸张宽度下测量屏幕布局后按上述规则推算；不支持的分页规则（如 orphans/widows、
+// 表格行不跨页）会使推算结果与实际分页不同
+func (l *printLayout) shifts() []layoutShift {
+	type event struct {
+		top, bottom float64
+		force       bool
+	}
+	events := make([]event, 0, len(l.Breaks)+len(l.Blocks))
+	for _, y := range l.Breaks {
+		events = append(events, event{top: y, bottom: y, force: true})
+	}
+	for _, b := range l.Blocks {
+		events = append(events, event{top: b[0], bottom: b[1]})
+	}
+	// 位置相同时先处理强制分页
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].top != events[j].top {
+			return events[i].top < events[j].top
+		}
+		return events[i].force && !events[j].force
+	})
+
+	height := l.PageHeight
+	shift := 0.0
+	list := make([]layoutShift, 0, len(events))
+	for _, ev := range events {
+		y := ev.top + shift
+		offset := y - math.Floor(y/height)*height
+		rest := height - offset
+		if ev.force {
+			if offset > layoutEpsilon && rest > layoutEpsilon {
+				shift += rest
+			}
+		} else if h := ev.bottom - ev.top; h < height-layoutEpsilon && offset+h > height+layoutEpsilon {
+			shift += rest
+		}
+		list = append(list, layoutShift{Y: ev.top, Shift: shift})
+	}
+	return list
+}
+
+// 文档位置 y 处内容的累计下移距离
+func shiftAt(shifts []layoutShift, y float64) float64 {
+	i := sort.Search(len(shifts), func(i int) bool {
+		return shifts[i].Y > y+layoutEpsilon
+	})
+	if i == 0 {
+		return 0
+	}
+	return shifts[i-1].Shift
+}
+
+// 文档中位于 top、高度为 height 的元素打印后所在的页码（从 1 开始）及距页面顶部的距离（点）。
+// 元素内第一个不跨页的区域（如标题的文字行）被移到下一页时，元素按该页顶部计算
+func (l *printLayout) locate(shifts []layoutShift, top float64, height float64) (int, float64) {
+	anchor := top
+	i := sort.Search(len(shifts), func(i int) bool {
+		return shifts[i].Y >= top-layoutEpsilon
+	})
+	if i < len(shifts) && shifts[i].Y < top+height {
+		anchor = math.Max(top, shifts[i].Y)
+	}
+	shift := shiftAt(shifts, anchor)
+	page := math.Floor((anchor + shift + layoutEpsilon) / l.PageHeight)
+	pos := math.Max(top+shift, page*l.PageHeight)
+	return int(page) + 1, pos - page*l.PageHeight
+}
+
+func (l *printLayout) validate() error {
+	if l == nil || l.PageHeight <= 0 {
+		return fmt.Errorf("invalid print layout")
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 页面高 100 点：第一页底部的文字行跨页被移到第二页，150 处强制分页
+var testPrintLayout = &printLayout{
+	PageHeight: 100,
+	Breaks:     []float64{150},
+	Blocks:     [][2]float64{{10, 20}, {92, 104}, {150, 160}, {260, 275}, {300, 520}},
+}
+
+func Test_PrintLayoutLocate(t *testing.T) {
+	shifts := testPrintLayout.shifts()
+	cases := []struct {
+		top, height float64
+		page        int
+		offset      float64
+	}{
+		{10, 10, 1, 10},
+		// 标题的文字行被移到下一页，标题从该页顶部开始
+		{90, 14, 2, 0},
+		{120, 5, 2, 28},
+		{150, 10, 3, 0},
+		{260, 15, 4, 10},
+		// 高于一页的区域不整体移动
+		{300, 220, 4, 50},
+	}
+	for _, c := range cases {
+		page, offset := testPrintLayout.locate(shifts, c.top, c.height)
+		if page != c.page || math.Abs(offset-c.offset) > 0.001 {
+			t.Errorf("locate(%v): got page %d offset %v, want page %d offset %v", c.top, page, offset, c.page, c.offset)
+		}
+	}
+}
+
+// 强制分页与高于一页的区域：页面边界处的分页不产生空白页，同一位置的多个分页只换一页，
+// 高于一页的区域及其中的强制分页都按原样处理
+func Test_PrintLayoutForcedBreaks(t *testing.T) {
+	layout := &printLayout{
+		PageHeight: 100,
+		Breaks:     []float64{100, 130, 130, 250},
+		Blocks:     [][2]float64{{140, 400}, {395, 410}, {440, 460}, {500, 600}},
+	}
+	shifts := layout.shifts()
+	cases := []struct {
+		top, height float64
+		page        int
+		offset      float64
+	}{
+		{100, 10, 2, 0},
+		{130, 5, 3, 0},
+		{140, 260, 3, 10},
+		{250, 10, 5, 0},
+		{395, 15, 6, 45},
+		// 高于一页的区域中的强制分页之后，跨页的文字行仍移到下一页
+		{440, 20, 7, 0},
+		// 与页面等高的区域不整体移动
+		{500, 100, 7, 60},
+		{700, 10, 9, 60},
+	}
+	for _, c := range cases {
+		page, offset := layout.locate(shifts, c.top, c.height)
+		if page != c.page || math.Abs(offset-c.offset) > 0.001 {
+			t.Errorf("locate(%v): got page %d offset %v, want page %d offset %v", c.top, page, offset, c.page, c.offset)
+		}
+	}
+}
+
+func Test_ReadRenderedOutline(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"layout":{"page_height":100,"breaks":[150],"blocks":[[10,20],[92,104],[150,160],[260,275]]},
+		"elements":[
+			{"title":"概述","level":1,"top":10,"left":5,"width":50,"height":10},
+			{"title":"Details","level":2,"top":90,"left":5,"width":50,"height":14},
+			{"title":"附录","level":1,"top":150,"left":5,"width":50,"height":10,"key":"appendix"},
+			{"title":"Summary","level":1,"top":260,"left":5,"width":50,"height":15}]}`
+	file := filepath.Join(dir, "outline.json")
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	elements, err := readRenderedElements(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := []int{1, 2, 3, 4}
+	for i, el := range elements {
+		if el.Page != pages[i] {
+			t.Errorf("%s: got page %d, want %d", el.Title, el.Page, pages[i])
+		}
+	}
+	if elements[2].Key != "appendix" || elements[2].Top != 0 {
+		t.Errorf("unexpected element: %+v", elements[2])
+	}
+
+	pdf := filepath.Join(dir, "outline.pdf")
+	writeTestPDF(t, pdf, 4)
+	if err = SetPDFOutline(pdf, renderedOutline(elements)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := readPDFContext(pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := contextOutline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 3 || read[0].Children[0].Page != 2 || read[1].Page != 3 || read[2].Page != 4 || int(read[2].Top+0.5) != 10 {
+		t.Errorf("unexpected outline: %+v", read)
+	}
+
+	if err = os.WriteFile(file, []byte(`[{"title":"概述","page":1}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = readRenderedElements(file); err == nil {
+		t.Error("expected error without print layout")
+	}
+}
@@ -0,0 +1,238 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// 未配置 max_page_height 时单页模式的最大高度（毫米）
+const DefaultMaxPageHeight = 5000
+
+const (
+	IsolateHide    = "hide"
+	IsolateSubtree = "subtree"
+)
+
+const (
+	FormatPDF  = "pdf"
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+)
+
+type ClipRect struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// 传递给渲染脚本（render/pdf.js）的参数，以 JSON 形式作为第三个命令行参数
+type RenderOptions struct {
+	Format         string    `json:"format"`
+	ViewportWidth  int       `json:"viewport_width,omitempty"`
+	ViewportHeight int       `json:"viewport_height,omitempty"`
+	ScaleFactor    float64   `json:"scale_factor,omitempty"`
+	FullPage       bool      `json:"full_page,omitempty"`
+	Clip           *ClipRect `json:"clip,omitempty"`
+	Selector       string    `json:"selector,omitempty"`
+	Transparent    bool      `json:"transparent,omitempty"`
+	Quality        int       `json:"quality,omitempty"`
+	Isolate        string    `json:"isolate,omitempty"`
+	FitElement     bool      `json:"fit_element,omitempty"`
+	SinglePage     bool      `json:"single_page,omitempty"`
+	PageWidth      float64   `json:"page_width,omitempty"`
+	MaxPageHeight  float64   `json:"max_page_height,omitempty"`
+	Outline        bool      `json:"outline,omitempty"`
+	OutlineSel     string    `json:"outline_selector,omitempty"`
+	OutlineFile    string    `json:"outline_file,omitempty"` // 渲染脚本把标题位置写入该文件
+	FormFields     bool      `json:"form_fields,omitempty"`
+	FormFile       string    `json:"form_file,omitempty"` // 渲染脚本把表单元素的位置写入该文件
+}
+
+func NewRenderOptions() *RenderOptions {
+	return &RenderOptions{
+		Format: FormatPDF,
+	}
+}
+
+// 从请求参数中读取渲染参数
+func ParseRenderOptions(request *http.Request) (*RenderOptions, error) {
+	options := NewRenderOptions()
+
+	if value := strings.ToLower(request.FormValue("format")); len(value) > 0 {
+		if value == "jpg" {
+			value = FormatJPEG
+		}
+		switch value {
+		case FormatPDF, FormatPNG, FormatJPEG, FormatWebP:
+			options.Format = value
+		default:
+			return nil, fmt.Errorf("unsupported format: %s", value)
+		}
+	}
+
+	var err error
+	if value := request.FormValue("viewport_width"); len(value) > 0 {
+		if options.ViewportWidth, err = strconv.Atoi(value); err != nil || options.ViewportWidth <= 0 {
+			return nil, fmt.Errorf("invalid viewport_width: %s", value)
+		}
+	}
+	if value := request.FormValue("viewport_height"); len(value) > 0 {
+		if options.ViewportHeight, err = strconv.Atoi(value); err != nil || options.ViewportHeight <= 0 {
+			return nil, fmt.Errorf("invalid viewport_height: %s", value)
+		}
+	}
+	if value := request.FormValue("scale"); len(value) > 0 {
+		if options.ScaleFactor, err = strconv.ParseFloat(value, 64); err != nil || options.ScaleFactor <= 0 {
+			return nil, fmt.Errorf("invalid scale: %s", value)
+		}
+	}
+	if value := request.FormValue("quality"); len(value) > 0 {
+		if options.Quality, err = strconv.Atoi(value); err != nil || options.Quality < 0 || options.Quality > 100 {
+			return nil, fmt.Errorf("invalid quality: %s", value)
+		}
+	}
+	if value := request.FormValue("clip"); len(value) > 0 {
+		parts := strings.Split(value, ",")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid clip: %s", value)
+		}
+		rect := make([]float64, 4)
+		for i, part := range parts {
+			if rect[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+				return nil, fmt.Errorf("invalid clip: %s", value)
+			}
+		}
+		if rect[0] < 0 || rect[1] < 0 || rect[2] <= 0 || rect[3] <= 0 {
+			return nil, fmt.Errorf("invalid clip: %s", value)
+		}
+		options.Clip = &ClipRect{X: rect[0], Y: rect[1], Width: rect[2], Height: rect[3]}
+	}
+	options.FullPage = parseBool(request.FormValue("full_page"))
+	options.Transparent = parseBool(request.FormValue("transparent"))
+	options.Selector = request.FormValue("selector")
+	options.FitElement = parseBool(request.FormValue("fit_element"))
+	if value := strings.ToLower(request.FormValue("isolate")); len(value) > 0 {
+		switch value {
+		case IsolateHide, IsolateSubtree:
+			options.Isolate = value
+		default:
+			return nil, fmt.Errorf("unsupported isolate mode: %s", value)
+		}
+	}
+	options.SinglePage = parseBool(request.FormValue("single_page"))
+	if value := request.FormValue("page_width"); len(value) > 0 {
+		if options.PageWidth, err = strconv.ParseFloat(value, 64); err != nil || options.PageWidth <= 0 {
+			return nil, fmt.Errorf("invalid page_width: %s", value)
+		}
+	}
+	if value := request.FormValue("max_page_height"); len(value) > 0 {
+		if options.MaxPageHeight, err = strconv.ParseFloat(value, 64); err != nil || options.MaxPageHeight <= 0 {
+			return nil, fmt.Errorf("invalid max_page_height: %s", value)
+		}
+	}
+	options.OutlineSel = request.FormValue("outline_selector")
+	options.Outline = parseBool(request.FormValue("outline")) || len(options.OutlineSel) > 0
+	options.FormFields = parseBool(request.FormValue("form_fields"))
+	if (len(options.Isolate) > 0 || options.FitElement) && len(options.Selector) == 0 {
+		return nil, fmt.Errorf("isolate and fit_element require a selector")
+	}
+	// WebP 为无损编码，quality 表示压缩力度
+	if options.Format == FormatWebP && len(request.FormValue("quality")) == 0 {
+		options.Quality = DefaultWebPQuality
+	}
+	if options.Format == FormatPDF && len(options.Selector) > 0 && len(options.Isolate) == 0 {
+		options.Isolate = IsolateSubtree
+	}
+
+	return options, nil
+}
+
+func parseBool(value string) bool {
+	result, _ := strconv.ParseBool(value)
+	return result
+}
+
+// 单页模式的最大高度不能超过配置的上限
+func (o *RenderOptions) limitPageHeight(conf *Config) {
+	if o == nil || !o.SinglePage {
+		return
+	}
+	limit := conf.MaxPageHeight
+	if limit <= 0 {
+		limit = DefaultMaxPageHeight
+	}
+	if o.MaxPageHeight <= 0 || o.MaxPageHeight > limit {
+		o.MaxPageHeight = limit
+	}
+}
+
+func (o *RenderOptions) Ext() string {
+	if o == nil || len(o.Format) == 0 {
+		return "." + FormatPDF
+	}
+	return "." + o.Format
+}
+
+func (o *RenderOptions) ContentType() string {
+	switch o.Ext() {
+	case ".png":
+		return "image/png"
+	case ".jpeg":
+		return "image/jpeg"
+	case ".webp":
+		return "image/webp"
+	}
+	return "application/pdf"
+}
+
+// PhantomJS 无法输出 WebP，先输出 PNG 再转换。超过 WebP 最大尺寸的截图（如很长的整页截图）按比例缩小
+func convertPNGToWebP(src_path string, dest_path string, quality int) error {
+	in, err := os.Open(src_path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	img, err := png.Decode(in)
+	if err != nil {
+		return err
+	}
+
+	img = fitWebPSize(img)
+
+	out, err := os.Create(dest_path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err = EncodeWebP(out, img, quality); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func fitWebPSize(img image.Image) image.Image {
+	rect := img.Bounds()
+	width, height := rect.Dx(), rect.Dy()
+	if width <= vp8lMaxSize && height <= vp8lMaxSize {
+		return img
+	}
+	scale := math.Min(float64(vp8lMaxSize)/float64(width), float64(vp8lMaxSize)/float64(height))
+	w := int(math.Max(1, math.Min(vp8lMaxSize, math.Round(float64(width)*scale))))
+	h := int(math.Max(1, math.Min(vp8lMaxSize, math.Round(float64(height)*scale))))
+	Logger.Infof("webp screenshot %dx%d exceeds %dpx, scaled to %dx%d\n", width, height, vp8lMaxSize, w, h)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, rect, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_ParseRenderOptions(t *testing.T) {
+	form := url.Values{}
+	form.Set("format", "jpg")
+	form.Set("viewport_width", "1200")
+	form.Set("scale", "2")
+	form.Set("clip", "0, 10, 300, 200")
+	form.Set("transparent", "true")
+	form.Set("quality", "80")
+	form.Set("form_fields", "true")
+
+	request := httptest.NewRequest("POST", "/linkpdf", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	options, err := ParseRenderOptions(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Format != FormatJPEG || options.Ext() != ".jpeg" || options.ContentType() != "image/jpeg" {
+		t.Errorf("unexpected format: %+v", options)
+	}
+	if options.ViewportWidth != 1200 || options.ScaleFactor != 2 || options.Quality != 80 || !options.Transparent || !options.FormFields {
+		t.Errorf("unexpected options: %+v", options)
+	}
+	if options.Clip == nil || options.Clip.Y != 10 || options.Clip.Width != 300 {
+		t.Errorf("unexpected clip: %+v", options.Clip)
+	}
+
+	for _, query := range []string{
+		"format=gif",
+		"format=png&viewport_width=0",
+		"format=png&viewport_height=-10",
+		"format=png&clip=0,0,-100,200",
+		"format=png&clip=-5,0,100,200",
+		"format=webp&quality=101",
+	} {
+		request = httptest.NewRequest("POST", "/linkpdf?"+query, nil)
+		if _, err = ParseRenderOptions(request); err == nil {
+			t.Errorf("expected error for %s", query)
+		}
+	}
+
+	request = httptest.NewRequest("POST", "/linkpdf?format=webp", nil)
+	if options, err = ParseRenderOptions(request); err != nil || options.Quality != DefaultWebPQuality {
+		t.Errorf("unexpected webp options: %+v %v", options, err)
+	}
+	request = httptest.NewRequest("POST", "/linkpdf?format=webp&quality=0", nil)
+	if options, err = ParseRenderOptions(request); err != nil || options.Quality != 0 {
+		t.Errorf("unexpected webp options: %+v %v", options, err)
+	}
+
+	var empty *RenderOptions
+	if empty.Ext() != ".pdf" || empty.ContentType() != "application/pdf" {
+		t.Errorf("nil options should render pdf")
+	}
+}
+
+func Test_ParseRenderOptionsIsolate(t *testing.T) {
+	request := httptest.NewRequest("POST", "/htmlpdf?selector=%23invoice&fit_element=1", nil)
+	options, err := ParseRenderOptions(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Isolate != IsolateSubtree || !options.FitElement {
+		t.Errorf("unexpected options: %+v", options)
+	}
+
+	request = httptest.NewRequest("POST", "/htmlpdf?isolate=hide", nil)
+	if _, err = ParseRenderOptions(request); err == nil {
+		t.Errorf("expected error for isolate without selector")
+	}
+}
+
+func Test_LimitPageHeight(t *testing.T) {
+	conf := &Config{MaxPageHeight: 2000}
+
+	options := NewRenderOptions()
+	options.SinglePage = true
+	options.limitPageHeight(conf)
+	if options.MaxPageHeight != 2000 {
+		t.Errorf("expected config limit, got %v", options.MaxPageHeight)
+	}
+
+	options.MaxPageHeight = 9000
+	options.limitPageHeight(conf)
+	if options.MaxPageHeight != 2000 {
+		t.Errorf("request should not exceed config limit, got %v", options.MaxPageHeight)
+	}
+
+	options.MaxPageHeight = 500
+	options.limitPageHeight(&Config{})
+	if options.MaxPageHeight != 500 {
+		t.Errorf("expected request limit, got %v", options.MaxPageHeight)
+	}
+}
+
+// 使用 render/pdf.js 的渲染器，需要通过 WEBKIT_BIN 指定 PhantomJS，未设置时跳过
+func newTestRenderer(t *testing.T) *HTMLPDF {
+	bin := os.Getenv("WEBKIT_BIN")
+	if len(bin) == 0 {
+		t.Skip("WEBKIT_BIN is not set")
+	}
+	return &HTMLPDF{
+		config: &Config{
+			Timeout:    60,
+			TempPath:   filepath.ToSlash(t.TempDir()),
+			WebKitBin:  bin,
+			WebKitArgs: []string{getLocalConfigPath("../render/pdf.js")},
+		},
+		buildJob: make(chan bool, 1),
+	}
+}
+
+// 隐藏模式下被隐藏的内容不占用版面，祖先元素的边距及边框不会把内容挤到第二页，
+// fit_element 输出的 PDF 只有目标元素一页
+func Test_RenderIsolatePageCount(t *testing.T) {
+	pdf := newTestRenderer(t)
+
+	html := []byte(`<html><body>
+<div style="height: 5000px">before</div>
+<div style="margin: 40px; padding: 30px; border: 5px solid #000"><section id="invoice" style="width: 600px; height: 300px; margin: 20px">invoice</section></div>
+<div style="height: 5000px">after</div>
+</body></html>`)
+
+	for _, mode := range []string{IsolateHide, IsolateSubtree} {
+		options := NewRenderOptions()
+		options.Selector = "#invoice"
+		options.Isolate = mode
+		options.FitElement = true
+
+		file, err := pdf.BuildFromSource(html, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, err := ReadPageInfo(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) != 1 {
+			t.Errorf("%s: expected 1 page, got %d", mode, len(pages))
+		}
+	}
+}
+
+// 测量书签及表单域位置时加载视口为纸张宽度，静态内容的分页与不测量时相同
+func Test_RenderMeasurePageCount(t *testing.T) {
+	pdf := newTestRenderer(t)
+	html := []byte(`<html><body>
+<h1>Report</h1>
+<p style="height: 1500px">summary</p>
+<h2>Details</h2>
+<p><input name="name" value="Alice"> <textarea name="note">text</textarea></p>
+<h2 style="page-break-before: always">Appendix</h2>
+<p style="height: 2500px">appendix</p>
+</body></html>`)
+
+	count := func(configure func(options *RenderOptions)) int {
+		options := NewRenderOptions()
+		configure(options)
+		file, err := pdf.BuildFromSource(html, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, err := ReadPageInfo(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(pages)
+	}
+	plain := count(func(options *RenderOptions) {})
+	if outline := count(func(options *RenderOptions) { options.Outline = true }); outline != plain {
+		t.Errorf("outline changed the page count: %d != %d", outline, plain)
+	}
+	if fields := count(func(options *RenderOptions) { options.FormFields = true }); fields != plain {
+		t.Errorf("form_fields changed the page count: %d != %d", fields, plain)
+	}
+}
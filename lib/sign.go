@@ -0,0 +1,456 @@
+package lib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// 配置中的签名证书，Type 为 pkcs12 或 pem。pem 文件中包含证书链及私钥，
+// 私钥也可以单独放在 KeyPath 中
+type SignerConfig struct {
+	Type     string `json:"type"`
+	Path     string `json:"path"`
+	KeyPath  string `json:"key_path"`
+	Password string `json:"password"`
+}
+
+// 签名参数，Rect 为空时生成不可见签名
+type Signature struct {
+	Signer      string
+	Reason      string
+	Location    string
+	ContactInfo string
+	Page        string    // 页码或 last
+	Rect        []float64 // 签名框 "x,y,宽,高"（毫米，按显示方向从可见区域左上角起算）
+	Timestamp   bool
+
+	embedFonts bool // PDF/A 输出时嵌入签名外观使用的字体
+}
+
+const (
+	// ByteRange 占位数字的宽度，写入实际值时用空格补齐
+	byteRangePlaceholder = 9999999999
+)
+
+var (
+	signerCache = make(map[string]*cmsSigner)
+	signerLock  sync.Mutex
+)
+
+// 读取签名参数：sign（配置中的签名证书名称）、sign_reason、sign_location、sign_contact、
+// sign_page、sign_rect、sign_timestamp
+func parseSignature(request *http.Request) (*Signature, error) {
+	sig := &Signature{
+		Signer:      request.FormValue("sign"),
+		Reason:      request.FormValue("sign_reason"),
+		Location:    request.FormValue("sign_location"),
+		ContactInfo: request.FormValue("sign_contact"),
+		Page:        strings.ToLower(request.FormValue("sign_page")),
+	}
+	if len(sig.Signer) == 0 {
+		return nil, nil
+	}
+	if len(sig.Page) == 0 {
+		sig.Page = "1"
+	} else if n, err := strconv.Atoi(sig.Page); sig.Page != "last" && (err != nil || n < 1) {
+		return nil, fmt.Errorf("invalid sign_page: %s", sig.Page)
+	}
+	if value := request.FormValue("sign_rect"); len(value) > 0 {
+		parts := strings.Split(value, ",")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid sign_rect: %s", value)
+		}
+		for _, part := range parts {
+			n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid sign_rect: %s", value)
+			}
+			sig.Rect = append(sig.Rect, n)
+		}
+		if sig.Rect[2] == 0 || sig.Rect[3] == 0 {
+			return nil, fmt.Errorf("invalid sign_rect: %s", value)
+		}
+	}
+	sig.Timestamp = parseBool(request.FormValue("sign_timestamp"))
+	return sig, nil
+}
+
+// 读取配置中的签名证书，读取后缓存
+func (pdf *HTMLPDF) loadSigner(name string) (*cmsSigner, error) {
+	signerLock.Lock()
+	defer signerLock.Unlock()
+
+	if signer, ok := signerCache[name]; ok {
+		return signer, nil
+	}
+	conf, ok := pdf.config.Signers[name]
+	if !ok || conf == nil {
+		return nil, fmt.Errorf("unknown signer: %s", name)
+	}
+	signer, err := loadKeystore(conf)
+	if err != nil {
+		// 错误信息中不包含密码
+		return nil, fmt.Errorf("unable to load signer %s: %v", name, err)
+	}
+	signerCache[name] = signer
+	return signer, nil
+}
+
+func loadKeystore(conf *SignerConfig) (*cmsSigner, error) {
+	data, err := os.ReadFile(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	var certs []*x509.Certificate
+	switch strings.ToLower(conf.Type) {
+	case "pkcs12", "p12", "pfx":
+		var cert *x509.Certificate
+		var chain []*x509.Certificate
+		key, cert, chain, err = pkcs12.DecodeChain(data, conf.Password)
+		if err != nil {
+			return nil, err
+		}
+		certs = append([]*x509.Certificate{cert}, chain...)
+	case "pem", "":
+		if len(conf.KeyPath) > 0 {
+			keyData, err := os.ReadFile(conf.KeyPath)
+			if err != nil {
+				return nil, err
+			}
+			data = append(append(data, '\n'), keyData...)
+		}
+		if key, certs, err = parsePEMKeystore(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported keystore type: %s", conf.Type)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+	// 把与私钥匹配的证书放在证书链的第一位
+	for i, cert := range certs {
+		if publicKeyEqual(cert.PublicKey, signer.Public()) {
+			certs[0], certs[i] = certs[i], certs[0]
+			return &cmsSigner{Key: signer, Chain: certs}, nil
+		}
+	}
+	return nil, errors.New("no certificate matches the private key")
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	if key, ok := a.(interface{ Equal(crypto.PublicKey) bool }); ok {
+		return key.Equal(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func parsePEMKeystore(data []byte) (interface{}, []*x509.Certificate, error) {
+	var key interface{}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certs = append(certs, cert)
+		case "PRIVATE KEY":
+			k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			key = k
+		case "RSA PRIVATE KEY":
+			k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			key = k
+		case "EC PRIVATE KEY":
+			k, err := x509.ParseECPrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			key = k
+		}
+	}
+	if key == nil {
+		return nil, nil, errors.New("missing private key")
+	}
+	if len(certs) == 0 {
+		return nil, nil, errors.New("missing certificate")
+	}
+	return key, certs, nil
+}
+
+// 对 PDF 文件进行 PAdES（ETSI.CAdES.detached）签名（原地修改）。
+// 以增量更新的方式追加带占位内容的签名域，不改动原有内容，已有的签名仍然有效；
+// 再计算 ByteRange 覆盖范围的签名并回填
+func SignPDFFile(pdf_path string, signer *cmsSigner, sig *Signature, tsaURL string) error {
+	if sig.Timestamp && len(tsaURL) == 0 {
+		return errors.New("timestamp requested but tsa_url is not configured")
+	}
+
+	original, err := os.ReadFile(pdf_path)
+	if err != nil {
+		return err
+	}
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	if ctx.Encrypt != nil {
+		return errors.New("unable to sign an encrypted pdf")
+	}
+	firstNew := *ctx.XRefTable.Size
+
+	pageNr := ctx.PageCount
+	if sig.Page != "last" {
+		pageNr, _ = strconv.Atoi(sig.Page)
+	}
+	if pageNr < 1 || pageNr > ctx.PageCount {
+		return fmt.Errorf("sign_page %s out of range (%d pages)", sig.Page, ctx.PageCount)
+	}
+
+	// 签名值的预留空间
+	size := 4096
+	for _, cert := range signer.Chain {
+		size += len(cert.Raw)
+	}
+	if sig.Timestamp {
+		size += 8192
+	}
+	placeholder := strings.Repeat("0", size*2)
+
+	signedAt := time.Now()
+	sigDict := pdfcpu.Dict{
+		"Type":      pdfcpu.Name("Sig"),
+		"Filter":    pdfcpu.Name("Adobe.PPKLite"),
+		"SubFilter": pdfcpu.Name("ETSI.CAdES.detached"),
+		"ByteRange": pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(byteRangePlaceholder),
+			pdfcpu.Integer(byteRangePlaceholder), pdfcpu.Integer(byteRangePlaceholder)},
+		"Contents": pdfcpu.HexLiteral(placeholder),
+		"M":        pdfcpu.StringLiteral(pdfDate(signedAt)),
+		"Name":     pdfTextString(signer.Chain[0].Subject.CommonName),
+	}
+	for key, value := range map[string]string{"Reason": sig.Reason, "Location": sig.Location, "ContactInfo": sig.ContactInfo} {
+		if len(value) > 0 {
+			sigDict.Insert(key, pdfTextString(value))
+		}
+	}
+	sigRef, err := ctx.IndRefForNewObject(sigDict)
+	if err != nil {
+		return err
+	}
+
+	page, _, err := ctx.PageDict(pageNr)
+	if err != nil {
+		return err
+	}
+	pRef, err := pageRef(ctx, pageNr)
+	if err != nil {
+		return err
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	acroForm, err := ctx.DereferenceDict(root["AcroForm"])
+	if err != nil {
+		return err
+	}
+	if acroForm == nil {
+		acroForm = pdfcpu.NewDict()
+		root.Update("AcroForm", acroForm)
+	}
+	fields, _ := ctx.DereferenceArray(acroForm["Fields"])
+
+	widget := pdfcpu.Dict{
+		"Type":    pdfcpu.Name("Annot"),
+		"Subtype": pdfcpu.Name("Widget"),
+		"FT":      pdfcpu.Name("Sig"),
+		"T":       pdfcpu.StringLiteral(fmt.Sprintf("Signature%d", len(fields)+1)),
+		"V":       *sigRef,
+		"F":       pdfcpu.Integer(132), // Print | Locked
+		"P":       *pRef,
+		"Rect":    pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Integer(0)},
+	}
+	if len(sig.Rect) == 4 {
+		w, h := sig.Rect[2]*72/25.4, sig.Rect[3]*72/25.4
+		rect, m := signatureRect(ctx.XRefTable, page, sig.Rect[0]*72/25.4, sig.Rect[1]*72/25.4, w, h)
+		widget.Update("Rect", rect)
+
+		ap, err := signatureAppearance(w, h, signer, sig, signedAt)
+		if err != nil {
+			return err
+		}
+		// 外观流按显示方向绘制，旋转后与页面的 /Rotate 抵消
+		if m[0] != 1 {
+			ap.Dict.Insert("Matrix", pdfcpu.Array{pdfcpu.Float(m[0]), pdfcpu.Float(m[1]),
+				pdfcpu.Float(m[2]), pdfcpu.Float(m[3]), pdfcpu.Integer(0), pdfcpu.Integer(0)})
+		}
+		if sig.embedFonts {
+			if err = embedResourceFonts(ctx, ap.Dict["Resources"].(pdfcpu.Dict)); err != nil {
+				return err
+			}
+		}
+		apRef, err := ctx.IndRefForNewObject(*ap)
+		if err != nil {
+			return err
+		}
+		widget.Insert("AP", pdfcpu.Dict{"N": *apRef})
+	}
+	widgetRef, err := ctx.IndRefForNewObject(widget)
+	if err != nil {
+		return err
+	}
+
+	if err = appendArrayEntry(ctx.XRefTable, page, "Annots", *widgetRef); err != nil {
+		return err
+	}
+	if err = appendArrayEntry(ctx.XRefTable, acroForm, "Fields", *widgetRef); err != nil {
+		return err
+	}
+	acroForm.Update("SigFlags", pdfcpu.Integer(3))
+
+	// 修改过的对象：页面、AcroForm 所在的对象（内联时为 Catalog）及间接引用的 Annots、Fields
+	changed := []pdfcpu.Object{*pRef, page["Annots"], acroForm["Fields"], root["AcroForm"]}
+	if _, ok := root["AcroForm"].(pdfcpu.IndirectRef); !ok {
+		changed = append(changed, *ctx.Root)
+	}
+	objects, err := updateObjects(ctx, firstNew, changed...)
+	if err != nil {
+		return err
+	}
+	buf := bytes.NewBuffer(append([]byte(nil), original...))
+	if err = appendUpdate(buf, ctx, objects); err != nil {
+		return fmt.Errorf("unable to sign: %v", err)
+	}
+	data := buf.Bytes()
+
+	// 占位内容只在追加的部分中查找
+	contents := []byte("<" + placeholder + ">")
+	start := bytes.Index(data[len(original):], contents)
+	byteRange := []byte(fmt.Sprintf("[0 %d %d %d]", byteRangePlaceholder, byteRangePlaceholder, byteRangePlaceholder))
+	rangeAt := bytes.Index(data[len(original):], byteRange)
+	if start < 0 || rangeAt < 0 {
+		return errors.New("signature placeholder not found")
+	}
+	start += len(original)
+	rangeAt += len(original)
+	end := start + len(contents)
+
+	actual := fmt.Sprintf("[0 %d %d %d]", start, end, len(data)-end)
+	actual += strings.Repeat(" ", len(byteRange)-len(actual))
+	copy(data[rangeAt:], actual)
+
+	var timestamp func([]byte) ([]byte, error)
+	if sig.Timestamp {
+		timestamp = func(signature []byte) ([]byte, error) {
+			return requestTimestamp(tsaURL, signature)
+		}
+	}
+	signed := make([]byte, 0, len(data)-len(contents))
+	signed = append(signed, data[:start]...)
+	signed = append(signed, data[end:]...)
+	cms, err := signer.Sign(signed, oidData, true, timestamp)
+	if err != nil {
+		return err
+	}
+	encoded := hex.EncodeToString(cms)
+	if len(encoded) > len(placeholder) {
+		return errors.New("signature exceeds the reserved space")
+	}
+	copy(data[start+1:], encoded)
+
+	return os.WriteFile(pdf_path, data, 0666)
+}
+
+// 可见签名的外观：边框及签名信息
+// 签名框在页面坐标中的位置。x、y、w、h 为阅读器中显示方向的尺寸（点，从可见区域左上角起算），
+// 按页面的 /Rotate 及可见区域的原点换算；同时返回显示方向到页面坐标的变换矩阵
+func signatureRect(xRefTable *pdfcpu.XRefTable, page pdfcpu.Dict, x, y, w, h float64) (pdfcpu.Array, pdfMatrix) {
+	info := pageInfo(xRefTable, page)
+	x0, y0 := pageOrigin(xRefTable, page)
+	visualH := info.Height
+	m := pdfMatrix{1, 0, 0, 1, x0, y0}
+	switch info.Rotation {
+	case 90:
+		m = pdfMatrix{0, 1, -1, 0, x0 + info.Width, y0}
+		visualH = info.Width
+	case 180:
+		m = pdfMatrix{-1, 0, 0, -1, x0 + info.Width, y0 + info.Height}
+	case 270:
+		m = pdfMatrix{0, -1, 1, 0, x0, y0 + info.Height}
+		visualH = info.Width
+	}
+
+	ax, ay := m.transform(x, visualH-y-h)
+	bx, by := m.transform(x+w, visualH-y)
+	return pdfcpu.Array{pdfcpu.Float(math.Min(ax, bx)), pdfcpu.Float(math.Min(ay, by)),
+		pdfcpu.Float(math.Max(ax, bx)), pdfcpu.Float(math.Max(ay, by))}, m
+}
+
+func signatureAppearance(w, h float64, signer *cmsSigner, sig *Signature, signedAt time.Time) (*pdfcpu.StreamDict, error) {
+	lines := []string{"Digitally signed by " + signer.Chain[0].Subject.CommonName,
+		"Date: " + signedAt.Format("2006-01-02 15:04:05 -07:00")}
+	if len(sig.Reason) > 0 {
+		lines = append(lines, "Reason: "+sig.Reason)
+	}
+	if len(sig.Location) > 0 {
+		lines = append(lines, "Location: "+sig.Location)
+	}
+
+	fontSize := h / (float64(len(lines))*1.25 + 0.5)
+	if fontSize > 10 {
+		fontSize = 10
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "q 0.5 w 0 0 0 RG 0.25 0.25 %.2f %.2f re S Q\n", w-0.5, h-0.5)
+	fmt.Fprintf(&b, "q 0.5 0.5 %.2f %.2f re W n BT /Helv %.2f Tf 0 g\n", w-1, h-1, fontSize)
+	for i, line := range lines {
+		escaped, _ := pdfcpu.Escape(winAnsiString(line))
+		fmt.Fprintf(&b, "1 0 0 1 %.2f %.2f Tm (%s) Tj\n", fontSize*0.4, h-fontSize*(1.25*float64(i)+1.1), *escaped)
+	}
+	b.WriteString("ET Q\n")
+
+	d := pdfcpu.Dict{
+		"Type":    pdfcpu.Name("XObject"),
+		"Subtype": pdfcpu.Name("Form"),
+		"BBox":    pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Float(w), pdfcpu.Float(h)},
+		"Resources": pdfcpu.Dict{"Font": pdfcpu.Dict{"Helv": pdfcpu.Dict{
+			"Type":     pdfcpu.Name("Font"),
+			"Subtype":  pdfcpu.Name("Type1"),
+			"BaseFont": pdfcpu.Name("Helvetica"),
+			"Encoding": pdfcpu.Name("WinAnsiEncoding"),
+		}}},
+	}
+	return newFlateStream(d, b.Bytes())
+}
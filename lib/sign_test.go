@@ -0,0 +1,456 @@
+package lib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// 测试用的自签名证书
+func newTestSigner(t *testing.T, name string) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+type testSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type testSignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo cmsEncapContentInfo
+	Certificates     asn1.RawValue    `asn1:"optional,tag:0"`
+	SignerInfos      []testSignerInfo `asn1:"set"`
+}
+
+type testAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// 检查 SignedData 中的 messageDigest 及签名值，返回 SignerInfo
+func verifyCMS(t *testing.T, cms []byte, content []byte, cert *x509.Certificate) testSignerInfo {
+	var ci cmsContentInfo
+	if _, err := asn1.Unmarshal(cms, &ci); err != nil {
+		t.Fatal(err)
+	}
+	var sd testSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		t.Fatalf("signer infos: %d", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+
+	digest := sha256.Sum256(content)
+	found := false
+	for rest := si.SignedAttrs.Bytes; len(rest) > 0; {
+		var attr testAttribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type.Equal(oidAttrMessageDigest) {
+			var value []byte
+			if _, err = asn1.Unmarshal(attr.Values.Bytes, &value); err != nil {
+				t.Fatal(err)
+			}
+			found = bytes.Equal(value, digest[:])
+		}
+	}
+	if !found {
+		t.Fatal("messageDigest does not match the signed content")
+	}
+
+	// 签名是对 SET OF 编码的属性计算的
+	attrs := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	attrsDigest := sha256.Sum256(attrs)
+	if err := rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, attrsDigest[:], si.Signature); err != nil {
+		t.Fatal(err)
+	}
+	return si
+}
+
+func writeSignedTestKeystores(t *testing.T, dir string) (*x509.Certificate, map[string]*SignerConfig) {
+	key, cert := newTestSigner(t, "Test Signer")
+
+	pemPath := filepath.Join(dir, "signer.pem")
+	keyPath := filepath.Join(dir, "signer.key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(pemPath, append(append([]byte{}, certPEM...), keyPEM...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, "signer.crt")
+	if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p12, err := pkcs12.Modern2023.Encode(key, cert, nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12Path := filepath.Join(dir, "signer.p12")
+	if err = os.WriteFile(p12Path, p12, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, map[string]*SignerConfig{
+		"pem":    {Type: "pem", Path: pemPath},
+		"split":  {Type: "pem", Path: certPath, KeyPath: keyPath},
+		"pkcs12": {Type: "pkcs12", Path: p12Path, Password: "secret"},
+		"wrong":  {Type: "pkcs12", Path: p12Path, Password: "wrong"},
+	}
+}
+
+func Test_LoadSigner(t *testing.T) {
+	cert, signers := writeSignedTestKeystores(t, t.TempDir())
+	pdf := &HTMLPDF{config: &Config{Signers: signers}}
+
+	for _, name := range []string{"pem", "split", "pkcs12"} {
+		signer, err := pdf.loadSigner(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !signer.Chain[0].Equal(cert) {
+			t.Errorf("%s: unexpected certificate", name)
+		}
+	}
+	if _, err := pdf.loadSigner("wrong"); err == nil {
+		t.Error("expected error for wrong password")
+	}
+	if _, err := pdf.loadSigner("missing"); err == nil {
+		t.Error("expected error for unknown signer")
+	}
+}
+
+func Test_ParseSignature(t *testing.T) {
+	sig, err := parseSignature(newFormRequest(map[string][]string{
+		"sign": {"default"}, "sign_reason": {"Approved"}, "sign_page": {"last"},
+		"sign_rect": {"10, 20, 60, 20"}, "sign_timestamp": {"true"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Signer != "default" || sig.Reason != "Approved" || sig.Page != "last" || !sig.Timestamp || len(sig.Rect) != 4 {
+		t.Errorf("unexpected signature: %+v", sig)
+	}
+
+	if sig, err = parseSignature(newFormRequest(map[string][]string{"sign_reason": {"x"}})); err != nil || sig != nil {
+		t.Errorf("expected no signature, got %v %v", sig, err)
+	}
+
+	for _, form := range []map[string][]string{
+		{"sign": {"default"}, "sign_page": {"0"}},
+		{"sign": {"default"}, "sign_page": {"first"}},
+		{"sign": {"default"}, "sign_rect": {"10,20,60"}},
+		{"sign": {"default"}, "sign_rect": {"10,20,0,20"}},
+	} {
+		if _, err := parseSignature(newFormRequest(form)); err == nil {
+			t.Errorf("expected error for %v", form)
+		}
+	}
+}
+
+var byteRangePattern = regexp.MustCompile(`/ByteRange\s*\[\s*(\d+)\s+(\d+)\s+(\d+)\s+(\d+)\s*\]`)
+
+// 读取签名后的文件，返回最后一个签名覆盖的内容及签名值
+func signedRanges(t *testing.T, data []byte) ([]byte, []byte) {
+	all := byteRangePattern.FindAllSubmatch(data, -1)
+	if len(all) == 0 {
+		t.Fatal("ByteRange not found")
+	}
+	m := all[len(all)-1]
+	r := make([]int, 4)
+	for i := range r {
+		r[i], _ = strconv.Atoi(string(m[i+1]))
+	}
+	if r[0] != 0 || r[2]+r[3] != len(data) || data[r[1]] != '<' || data[r[2]-1] != '>' {
+		t.Fatalf("invalid ByteRange %v for %d bytes", r, len(data))
+	}
+	content := append(append([]byte{}, data[:r[1]]...), data[r[2]:]...)
+	// 签名值后面补齐的 0 在解析 DER 时会被忽略
+	cms, err := hex.DecodeString(string(data[r[1]+1 : r[2]-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content, cms
+}
+
+func Test_SignPDFFile(t *testing.T) {
+	dir := t.TempDir()
+	cert, signers := writeSignedTestKeystores(t, dir)
+	signer, err := loadKeystore(signers["pkcs12"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sig := range []*Signature{
+		{Signer: "pkcs12", Page: "1"},
+		{Signer: "pkcs12", Page: "last", Reason: "Approved", Location: "上海", Rect: []float64{10, 10, 60, 20}},
+	} {
+		file := filepath.Join(dir, "signed.pdf")
+		writeTestPDF(t, file, 2)
+		if err := SignPDFFile(file, signer, sig, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, cms := signedRanges(t, data)
+		verifyCMS(t, cms, content, cert)
+
+		// 签名后的文件仍然可以正常解析
+		ctx, err := readPDFContext(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctx.PageCount != 2 {
+			t.Errorf("page count: %d", ctx.PageCount)
+		}
+	}
+
+	if err := SignPDFFile(filepath.Join(dir, "signed.pdf"), signer, &Signature{Page: "5"}, ""); err == nil {
+		t.Error("expected error for page out of range")
+	}
+	if err := SignPDFFile(filepath.Join(dir, "signed.pdf"), signer, &Signature{Page: "1", Timestamp: true}, ""); err == nil {
+		t.Error("expected error for missing tsa_url")
+	}
+}
+
+// 再次签名以增量更新的方式追加，之前的签名覆盖的内容保持不变
+func Test_SignPDFFileTwice(t *testing.T) {
+	dir := t.TempDir()
+	cert, signers := writeSignedTestKeystores(t, dir)
+	signer, err := loadKeystore(signers["pkcs12"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, xrefStream := range []bool{true, false} {
+		file := filepath.Join(dir, fmt.Sprintf("signed-%t.pdf", xrefStream))
+		writeTestPDF(t, file, 2)
+		config := newPDFConfig()
+		config.WriteXRefStream = xrefStream
+		config.WriteObjectStream = xrefStream
+		ctx, err := readPDFContextWithConfig(file, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = writePDFContext(ctx, file); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = SignPDFFile(file, signer, &Signature{Page: "1", Rect: []float64{10, 10, 60, 20}}, ""); err != nil {
+			t.Fatal(err)
+		}
+		first, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = SignPDFFile(file, signer, &Signature{Page: "last", Reason: "Approved", Rect: []float64{10, 10, 60, 20}}, ""); err != nil {
+			t.Fatal(err)
+		}
+		second, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.HasPrefix(second, first) {
+			t.Fatalf("xref stream %t: first revision was rewritten", xrefStream)
+		}
+		content, cms := signedRanges(t, first)
+		verifyCMS(t, cms, content, cert)
+		content, cms = signedRanges(t, second)
+		verifyCMS(t, cms, content, cert)
+
+		if err = api.ValidateFile(file, newPDFConfig()); err != nil {
+			t.Fatalf("xref stream %t: %v", xrefStream, err)
+		}
+		ctx, err = readPDFContext(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, _ := ctx.Catalog()
+		acroForm, _ := ctx.DereferenceDict(root["AcroForm"])
+		fields, _ := ctx.DereferenceArray(acroForm["Fields"])
+		if len(fields) != 2 {
+			t.Errorf("xref stream %t: signature fields: %d", xrefStream, len(fields))
+		}
+	}
+}
+
+// 本地的 RFC 3161 时间戳服务，nonce 为空时返回请求中的 nonce
+func newTestTSA(t *testing.T, nonce *big.Int) *httptest.Server {
+	key, cert := newTestSigner(t, "Test TSA")
+	tsa := &cmsSigner{Key: key, Chain: []*x509.Certificate{cert}}
+
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		var req timeStampReq
+		if _, err := asn1.Unmarshal(body, &req); err != nil {
+			http.Error(writer, err.Error(), 400)
+			return
+		}
+		if nonce == nil {
+			nonce = req.Nonce
+		}
+		info, err := asn1.Marshal(tstInfo{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 2, 3, 4},
+			MessageImprint: req.MessageImprint,
+			SerialNumber:   big.NewInt(1),
+			GenTime:        time.Now().UTC().Truncate(time.Second),
+			Accuracy:       tstAccuracy{Seconds: 1},
+			Nonce:          nonce,
+		})
+		if err != nil {
+			http.Error(writer, err.Error(), 500)
+			return
+		}
+		token, err := tsa.Sign(info, oidTSTInfo, false, nil)
+		if err != nil {
+			http.Error(writer, err.Error(), 500)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/timestamp-reply")
+		writer.Write(derTLV(0x30, derTLV(0x30, mustMarshal(0)), token))
+	}))
+}
+
+func Test_SignPDFFileTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	cert, signers := writeSignedTestKeystores(t, dir)
+	signer, err := loadKeystore(signers["pem"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsa := newTestTSA(t, nil)
+	defer tsa.Close()
+
+	file := filepath.Join(dir, "signed.pdf")
+	writeTestPDF(t, file, 1)
+	if err := SignPDFFile(file, signer, &Signature{Page: "1", Timestamp: true}, tsa.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, cms := signedRanges(t, data)
+	si := verifyCMS(t, cms, content, cert)
+
+	var attr testAttribute
+	if _, err := asn1.Unmarshal(si.UnsignedAttrs.Bytes, &attr); err != nil {
+		t.Fatal(err)
+	}
+	if !attr.Type.Equal(oidAttrTimeStampToken) {
+		t.Fatalf("unexpected unsigned attribute: %v", attr.Type)
+	}
+	info, err := parseTimestampToken(attr.Values.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(si.Signature)
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digest[:]) {
+		t.Error("timestamp does not cover the signature")
+	}
+}
+
+// 时间戳的 nonce 与请求不一致（重放或缓存的响应）时签名失败
+func Test_RequestTimestampNonce(t *testing.T) {
+	tsa := newTestTSA(t, big.NewInt(42))
+	defer tsa.Close()
+
+	if _, err := requestTimestamp(tsa.URL, []byte("signature")); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("expected nonce mismatch, got %v", err)
+	}
+}
+
+// 签名框按显示方向（从可见区域左上角起算）定位，换算到带 /Rotate 及非零 CropBox 原点的页面坐标
+func Test_SignatureRect(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "rotated.pdf")
+	writeTestPDF(t, file, 1)
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _, err := ctx.PageDict(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Update("CropBox", pdfcpu.Array{pdfcpu.Integer(100), pdfcpu.Integer(200), pdfcpu.Integer(500), pdfcpu.Integer(800)})
+
+	for rotation, expected := range map[int][4]float64{
+		0:   {110, 750, 170, 780},
+		90:  {120, 210, 150, 270},
+		180: {430, 220, 490, 250},
+		270: {450, 730, 480, 790},
+	} {
+		page.Update("Rotate", pdfcpu.Integer(rotation))
+		rect, _ := signatureRect(ctx.XRefTable, page, 10, 20, 60, 30)
+		for i, o := range rect {
+			if v, _ := ctx.DereferenceNumber(o); math.Abs(v-expected[i]) > 0.01 {
+				t.Errorf("rotate %d: unexpected rect %v", rotation, rect)
+				break
+			}
+		}
+	}
+}
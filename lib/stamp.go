@@ -0,0 +1,506 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+const (
+	StampText  = "text"
+	StampImage = "image"
+	StampPDF   = "pdf"
+)
+
+// 水印/印章，OnTop 为 true 时绘制在页面内容之上，否则绘制在内容之下
+type Stamp struct {
+	Type     string  `json:"type"`
+	Text     string  `json:"text"`
+	Src      string  `json:"src"`  // 图片或 PDF 的 URL
+	Page     int     `json:"page"` // PDF 水印使用的页码
+	Position string  `json:"position"`
+	OffsetX  float64 `json:"offset_x"` // 毫米
+	OffsetY  float64 `json:"offset_y"` // 毫米
+	Margin   float64 `json:"margin"`   // 与页面边缘的距离（毫米）
+	Rotation float64 `json:"rotation"`
+	Opacity  float64 `json:"opacity"`
+	Font     string  `json:"font"` // PDF 标准字体，如 Helvetica、Times-Roman、Courier-Bold
+	FontSize float64 `json:"font_size"`
+	Color    string  `json:"color"`
+	Scale    float64 `json:"scale"` // 图片及 PDF 水印相对页面宽度的比例
+	Pages    string  `json:"pages"` // 页面选择，如 "1-3,5"
+	OnTop    bool    `json:"on_top"`
+
+	localPath string
+	present   map[string]bool // JSON 中出现的字段，用于区分未设置与显式设置的 0
+}
+
+func (stamp *Stamp) UnmarshalJSON(data []byte) error {
+	type plain Stamp
+	if err := json.Unmarshal(data, (*plain)(stamp)); err != nil {
+		return err
+	}
+	present, err := jsonKeys(data)
+	stamp.present = present
+	return err
+}
+
+// 数值参数是否设置：非 0，或者 JSON 中显式给出
+func (stamp *Stamp) isSet(key string, value float64) bool {
+	return value != 0 || stamp.present[key]
+}
+
+// JSON 对象中出现的字段名
+func jsonKeys(data []byte) (map[string]bool, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(fields))
+	for key := range fields {
+		keys[key] = true
+	}
+	return keys, nil
+}
+
+// 补全未设置的参数并检查参数，超出范围的值返回错误
+func (stamp *Stamp) normalize() error {
+	if len(stamp.Type) == 0 {
+		switch {
+		case len(stamp.Src) == 0:
+			stamp.Type = StampText
+		case strings.EqualFold(filepath.Ext(stamp.Src), ".pdf"):
+			stamp.Type = StampPDF
+		default:
+			stamp.Type = StampImage
+		}
+	}
+	switch stamp.Type {
+	case StampText:
+		if len(stamp.Text) == 0 {
+			return fmt.Errorf("text stamp requires text")
+		}
+		// 文字印章使用 gofpdf 的标准字体（WinAnsi 编码），无法显示的字符会变成 "?"
+		for _, line := range stampLines(stamp.Text) {
+			if _, ok := winAnsiEncode(line); !ok {
+				return fmt.Errorf("stamp text contains characters not supported by the standard fonts: %s", line)
+			}
+		}
+	case StampImage, StampPDF:
+		if len(stamp.Src) == 0 {
+			return fmt.Errorf("%s stamp requires src", stamp.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported stamp type: %s", stamp.Type)
+	}
+
+	if len(stamp.Position) == 0 {
+		stamp.Position = "center"
+	}
+	if _, _, ok := stampAnchor(stamp.Position); !ok {
+		return fmt.Errorf("unsupported stamp position: %s", stamp.Position)
+	}
+	// PDF 水印由 pdfcpu 直接叠加，只能居中放置
+	if stamp.Type == StampPDF && (!strings.EqualFold(stamp.Position, "center") || stamp.OffsetX != 0 || stamp.OffsetY != 0) {
+		return fmt.Errorf("pdf stamps only support the center position without offsets")
+	}
+	if !stamp.isSet("opacity", stamp.Opacity) {
+		stamp.Opacity = 1
+	} else if stamp.Opacity < 0 || stamp.Opacity > 1 {
+		return fmt.Errorf("stamp opacity must be between 0 and 1")
+	}
+	if stamp.Rotation < -180 || stamp.Rotation > 180 {
+		return fmt.Errorf("stamp rotation must be between -180 and 180")
+	}
+	if len(stamp.Font) == 0 {
+		stamp.Font = "Helvetica"
+	}
+	// 与页码一样只接受 PDF 标准字体的名称
+	if _, ok := standardFonts[stamp.Font]; !ok {
+		return fmt.Errorf("unsupported stamp font: %s", stamp.Font)
+	}
+	if !stamp.isSet("font_size", stamp.FontSize) {
+		stamp.FontSize = 48
+	} else if stamp.FontSize <= 0 {
+		return fmt.Errorf("stamp font_size must be positive")
+	}
+	if len(stamp.Color) == 0 {
+		stamp.Color = "#808080"
+	}
+	if _, _, _, err := parseHexColor(stamp.Color); err != nil {
+		return err
+	}
+	if !stamp.isSet("scale", stamp.Scale) {
+		stamp.Scale = 0.5
+		if stamp.Type == StampPDF {
+			stamp.Scale = 1
+		}
+	} else if stamp.Scale <= 0 || stamp.Scale > 1 {
+		return fmt.Errorf("stamp scale must be greater than 0 and at most 1")
+	}
+	if !stamp.isSet("page", float64(stamp.Page)) {
+		stamp.Page = 1
+	} else if stamp.Page < 1 {
+		return fmt.Errorf("stamp page must be at least 1")
+	}
+	if !stamp.isSet("margin", stamp.Margin) {
+		stamp.Margin = 10
+	} else if stamp.Margin < 0 {
+		return fmt.Errorf("stamp margin must not be negative")
+	}
+	return nil
+}
+
+// 位置对应的水平及垂直锚点：-1 靠左/上，0 居中，1 靠右/下
+func stampAnchor(position string) (int, int, bool) {
+	switch strings.ToLower(position) {
+	case "center":
+		return 0, 0, true
+	case "top":
+		return 0, -1, true
+	case "bottom":
+		return 0, 1, true
+	case "left":
+		return -1, 0, true
+	case "right":
+		return 1, 0, true
+	case "top-left":
+		return -1, -1, true
+	case "top-right":
+		return 1, -1, true
+	case "bottom-left":
+		return -1, 1, true
+	case "bottom-right":
+		return 1, 1, true
+	}
+	return 0, 0, false
+}
+
+// 文字印章按换行（包括转义的 \n）分行
+func stampLines(text string) []string {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), `\n`, "\n")
+	return strings.Split(text, "\n")
+}
+
+func parseHexColor(value string) (int, int, int, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return 0, 0, 0, fmt.Errorf("invalid color: %s", value)
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid color: %s", value)
+	}
+	return int(rgb >> 16 & 0xff), int(rgb >> 8 & 0xff), int(rgb & 0xff), nil
+}
+
+// 将水印/印章逐个添加到 PDF 文件中（原地修改）
+func StampPDFFile(pdf_path string, stamps []*Stamp) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	list, err := contextPageInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	removes := make([]string, 0)
+	defer func() {
+		for _, item := range removes {
+			os.Remove(item)
+		}
+	}()
+
+	for _, stamp := range stamps {
+		if err := stamp.normalize(); err != nil {
+			return err
+		}
+
+		pages := make([]int, 0, ctx.PageCount)
+		if len(stamp.Pages) > 0 {
+			if pages, err = ParsePageRanges(stamp.Pages, ctx.PageCount); err != nil {
+				return err
+			}
+		} else {
+			for i := 1; i <= ctx.PageCount; i++ {
+				pages = append(pages, i)
+			}
+		}
+		if len(pages) == 0 {
+			// 例如单页文档的偶数页
+			continue
+		}
+
+		if stamp.Type == StampPDF {
+			details := fmt.Sprintf("%s:%d, s:%g rel, r:%g, o:%g",
+				stamp.localPath, stamp.Page, stamp.Scale, stamp.Rotation, stamp.Opacity)
+			if err = applyStamp(ctx, pages, details, stamp.OnTop); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// 尺寸或旋转角度不同的页面分别生成印章页面
+		for _, group := range stampPageGroups(list, pages) {
+			overlay, err := buildStampOverlay(filepath.Dir(pdf_path), stamp, group.size)
+			if err != nil {
+				return err
+			}
+			removes = append(removes, overlay)
+			// pdfcpu 按页面的 /Rotate 旋转水印，印章页面已经按旋转后的方向绘制，这里抵消该旋转
+			details := fmt.Sprintf("%s:1, s:1 rel, r:%d, o:1", overlay, stampRotation(group.size.Rotation))
+			if err = applyStamp(ctx, group.pages, details, stamp.OnTop); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writePDFContext(ctx, pdf_path)
+}
+
+func applyStamp(ctx *pdfcpu.Context, pages []int, details string, onTop bool) error {
+	wm, err := pdfcpu.ParseWatermarkDetails(details, onTop)
+	if err != nil {
+		return err
+	}
+	selected := make(pdfcpu.IntSet)
+	for _, n := range pages {
+		selected[n] = true
+	}
+	if err = addWatermark(ctx, selected, wm); err != nil {
+		Logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+type stampPageGroup struct {
+	size  PageInfo
+	pages []int
+}
+
+// 按页面尺寸及旋转角度把选中的页面分组，保持页面顺序
+func stampPageGroups(list []PageInfo, pages []int) []*stampPageGroup {
+	groups := make([]*stampPageGroup, 0)
+	index := make(map[string]*stampPageGroup)
+	for _, n := range pages {
+		size := list[n-1]
+		key := fmt.Sprintf("%.2fx%.2f/%d", size.Width, size.Height, size.Rotation)
+		group, ok := index[key]
+		if !ok {
+			group = &stampPageGroup{size: size}
+			index[key] = group
+			groups = append(groups, group)
+		}
+		group.pages = append(group.pages, n)
+	}
+	return groups
+}
+
+// 抵消 pdfcpu 按 /Rotate 施加的旋转，取值范围为 -180 到 180
+func stampRotation(rotation int) int {
+	r := -rotation % 360
+	if r < -180 {
+		r += 360
+	}
+	return r
+}
+
+// pdfcpu 在文档已有 OCProperties 时拒绝添加新的水印，这里先移除已有的 OCProperties，
+// 添加完成后恢复，再把文档中所有未登记的可选内容组（新水印的，以及合并文件时丢失了 OCProperties 的旧水印）
+// 登记进去，以便叠加多个水印/印章，也可以再次处理已经加过水印的文件
+func addWatermark(ctx *pdfcpu.Context, pages pdfcpu.IntSet, wm *pdfcpu.Watermark) error {
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	existing, found := root.Find("OCProperties")
+	if found {
+		root.Delete("OCProperties")
+	}
+
+	if err = pdfcpu.AddWatermarks(ctx, pages, wm); err != nil {
+		if found {
+			root.Update("OCProperties", existing)
+		}
+		return err
+	}
+	fixStreamLengths(ctx)
+
+	// 已有的 OCProperties 无法解析时使用新生成的
+	if found {
+		if current, err := ctx.DereferenceDict(existing); err == nil && current != nil {
+			root.Update("OCProperties", existing)
+		}
+	}
+	properties, err := ctx.DereferenceDict(root["OCProperties"])
+	if err != nil || properties == nil {
+		return fmt.Errorf("invalid optional content properties: %v", err)
+	}
+	registerOCGs(ctx, properties)
+	return nil
+}
+
+// 把文档中未登记的可选内容组加入 OCProperties：OCGs、默认配置的 ON 及 AS 中的各个事件
+func registerOCGs(ctx *pdfcpu.Context, properties pdfcpu.Dict) {
+	list, _ := ctx.DereferenceArray(properties["OCGs"])
+	known := make(map[int]bool)
+	for _, o := range list {
+		if ref, ok := o.(pdfcpu.IndirectRef); ok {
+			known[ref.ObjectNumber.Value()] = true
+		}
+	}
+	numbers := make([]int, 0)
+	for n, entry := range ctx.Table {
+		if entry == nil || entry.Free || known[n] {
+			continue
+		}
+		if d, ok := entry.Object.(pdfcpu.Dict); ok && d.Type() != nil && *d.Type() == "OCG" {
+			numbers = append(numbers, n)
+		}
+	}
+	if len(numbers) == 0 {
+		return
+	}
+	sort.Ints(numbers)
+	ocgs := make(pdfcpu.Array, 0, len(numbers))
+	for _, n := range numbers {
+		ocgs = append(ocgs, *pdfcpu.NewIndirectRef(n, *ctx.Table[n].Generation))
+	}
+
+	properties.Update("OCGs", append(append(pdfcpu.Array{}, list...), ocgs...))
+	config, _ := ctx.DereferenceDict(properties["D"])
+	if config == nil {
+		config = pdfcpu.Dict{}
+		properties.Update("D", config)
+	}
+	on, _ := ctx.DereferenceArray(config["ON"])
+	config.Update("ON", append(append(pdfcpu.Array{}, on...), ocgs...))
+	usages, _ := ctx.DereferenceArray(config["AS"])
+	for _, o := range usages {
+		if usage, _ := ctx.DereferenceDict(o); usage != nil {
+			list, _ := ctx.DereferenceArray(usage["OCGs"])
+			usage.Update("OCGs", append(append(pdfcpu.Array{}, list...), ocgs...))
+		}
+	}
+}
+
+// pdfcpu 修改内容流后没有更新已有的 /Length（Dict.Insert 在键已存在时也返回 true），
+// 写出的文件中流的长度与数据不符，再次读取时解压失败。这里按流数据修正长度
+func fixStreamLengths(ctx *pdfcpu.Context) {
+	for _, entry := range ctx.Table {
+		if entry == nil || entry.Free {
+			continue
+		}
+		sd, ok := entry.Object.(pdfcpu.StreamDict)
+		if !ok || sd.Raw == nil {
+			continue
+		}
+		if length, ok := sd.Find("Length"); ok {
+			if n, ok := length.(pdfcpu.Integer); ok && int(n) == len(sd.Raw) {
+				continue
+			}
+		}
+		sd.Update("Length", pdfcpu.Integer(len(sd.Raw)))
+	}
+}
+
+// 文字及图片印章先用 gofpdf 绘制在与页面同样大小的透明页面上，再由 pdfcpu 叠加到页面上，
+// 这样可以控制印章在页面中的位置。有 /Rotate 的页面按旋转后（阅读器中显示）的方向及尺寸定位
+func buildStampOverlay(dir string, stamp *Stamp, size PageInfo) (string, error) {
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPageFormat("P", gofpdf.SizeType{Wd: size.Width, Ht: size.Height})
+	pdf.TransformBegin()
+	// 把显示方向的坐标转换为页面坐标：/Rotate 为顺时针，这里逆时针旋转后平移回页面内
+	switch size.Rotation {
+	case 90:
+		pdf.TransformTranslate(0, size.Height)
+	case 180:
+		pdf.TransformTranslate(size.Width, size.Height)
+	case 270:
+		pdf.TransformTranslate(size.Width, 0)
+	}
+	if size.Rotation != 0 {
+		pdf.TransformRotate(float64(size.Rotation), 0, 0)
+	}
+	if size.Rotation == 90 || size.Rotation == 270 {
+		size.Width, size.Height = size.Height, size.Width
+	}
+	pdf.SetAlpha(stamp.Opacity, "Normal")
+
+	var boxW, boxH float64
+	var draw func(x, y float64)
+
+	if stamp.Type == StampImage {
+		frames, err := LoadImageFrames(stamp.localPath)
+		if err != nil {
+			return "", err
+		}
+		rect := frames[0].Bounds()
+		options, data, err := encodeFrame(frames[0])
+		if err != nil {
+			return "", err
+		}
+		pdf.RegisterImageOptionsReader("stamp", options, bytes.NewReader(data))
+		boxW = size.Width * stamp.Scale
+		boxH = boxW * float64(rect.Dy()) / float64(rect.Dx())
+		draw = func(x, y float64) {
+			pdf.ImageOptions("stamp", x, y, boxW, boxH, false, options, 0, "")
+		}
+	} else {
+		r, g, b, _ := parseHexColor(stamp.Color)
+		pdf.SetTextColor(r, g, b)
+		family := standardFonts[stamp.Font]
+		pdf.SetFont(family[0], family[1], stamp.FontSize)
+		tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+		lines := stampLines(stamp.Text)
+		lineH := stamp.FontSize * 1.2
+		for i, line := range lines {
+			lines[i] = tr(line)
+			if w := pdf.GetStringWidth(lines[i]); w > boxW {
+				boxW = w
+			}
+		}
+		boxH = lineH * float64(len(lines))
+		draw = func(x, y float64) {
+			for i, line := range lines {
+				lineX := x + (boxW-pdf.GetStringWidth(line))/2
+				baseline := y + float64(i)*lineH + (lineH+stamp.FontSize*0.7)/2
+				pdf.Text(lineX, baseline, line)
+			}
+		}
+	}
+
+	margin := stamp.Margin * 72 / 25.4
+	horizontal, vertical, _ := stampAnchor(stamp.Position)
+	x := (size.Width-boxW)/2 + float64(horizontal)*((size.Width-boxW)/2-margin)
+	y := (size.Height-boxH)/2 + float64(vertical)*((size.Height-boxH)/2-margin)
+	x += stamp.OffsetX * 72 / 25.4
+	y += stamp.OffsetY * 72 / 25.4
+
+	pdf.TransformBegin()
+	pdf.TransformRotate(stamp.Rotation, x+boxW/2, y+boxH/2)
+	draw(x, y)
+	pdf.TransformEnd()
+	pdf.TransformEnd()
+
+	overlay := filepath.Join(dir, MakeUUID()+".pdf")
+	if err := pdf.OutputFileAndClose(overlay); err != nil {
+		return "", err
+	}
+	return overlay, nil
+}
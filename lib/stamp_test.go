@@ -0,0 +1,248 @@
+package lib
+
+import (
+	"encoding/json"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func writeTestPDF(t *testing.T, file string, pages int) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetFont("Helvetica", "", 12)
+	for i := 0; i < pages; i++ {
+		pdf.AddPage()
+		pdf.Text(20, 20, "content")
+	}
+	if err := pdf.OutputFileAndClose(file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_StampPDFFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "source.pdf")
+	writeTestPDF(t, file, 3)
+
+	logo := filepath.Join(dir, "logo.png")
+	out, err := os.Create(logo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(out, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+	out.Close()
+
+	stamps := []*Stamp{
+		{Text: "DRAFT", Rotation: 45, Opacity: 0.3, Color: "#f00"},
+		{Type: StampImage, Src: logo, Position: "bottom-right", Pages: "1-2", OnTop: true, localPath: logo},
+	}
+	if err = StampPDFFile(file, stamps); err != nil {
+		t.Fatal(err)
+	}
+	if err = api.ValidateFile(file, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+	list, err := ReadPageInfo(file)
+	if err != nil || len(list) != 3 {
+		t.Fatalf("unexpected pages: %v %v", list, err)
+	}
+}
+
+func Test_StampMixedPages(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "mixed.pdf")
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.SetFont("Helvetica", "", 12)
+	pdf.AddPage()
+	pdf.Text(20, 20, "portrait")
+	pdf.AddPageFormat("L", gofpdf.SizeType{Wd: 595.28, Ht: 841.89})
+	pdf.Text(20, 20, "landscape")
+	pdf.AddPage()
+	pdf.Text(20, 20, "rotated")
+	pdf.AddPageFormat("P", gofpdf.SizeType{Wd: 300, Ht: 400})
+	pdf.Text(20, 20, "small")
+	if err := pdf.OutputFileAndClose(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := RotatePages(file, file, "3", 90); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ReadPageInfo(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := stampPageGroups(list, []int{1, 2, 3, 4})
+	if len(groups) != 4 || groups[2].pages[0] != 3 || groups[2].size.Rotation != 90 {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+
+	stamps := []*Stamp{{Text: "CONFIDENTIAL", Position: "bottom-right", FontSize: 12}}
+	if err = StampPDFFile(file, stamps); err != nil {
+		t.Fatal(err)
+	}
+	if err = api.ValidateFile(file, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每种页面使用各自尺寸的印章页面，旋转页面的水印不再额外旋转
+	pattern := regexp.MustCompile(`([-\d.]+) ([-\d.]+) ([-\d.]+) ([-\d.]+) [-\d.]+ [-\d.]+ cm /GS\d+ gs /(Fm\d+) Do`)
+	for i := 1; i <= ctx.PageCount; i++ {
+		match := pattern.FindSubmatch(pageContent(t, ctx, i))
+		if match == nil {
+			t.Fatalf("page %d: stamp not found", i)
+		}
+		if string(match[1]) != "1.00" || string(match[4]) != "1.00" {
+			t.Errorf("page %d: unexpected matrix %s", i, match[0])
+		}
+		page, _, _ := ctx.PageDict(i)
+		resources, _ := ctx.DereferenceDict(page["Resources"])
+		xobjects, _ := ctx.DereferenceDict(resources["XObject"])
+		form, err := ctx.DereferenceStreamDict(xobjects[string(match[5])])
+		if err != nil || form == nil {
+			t.Fatalf("page %d: form not found", i)
+		}
+		box, _ := ctx.DereferenceArray(form.Dict["BBox"])
+		w, _ := ctx.DereferenceNumber(box[2])
+		h, _ := ctx.DereferenceNumber(box[3])
+		if math.Abs(w-list[i-1].Width) > 1 || math.Abs(h-list[i-1].Height) > 1 {
+			t.Errorf("page %d: overlay %gx%g for page %+v", i, w, h, list[i-1])
+		}
+	}
+
+	if err = StampPDFFile(file, []*Stamp{{Text: "草稿"}}); err == nil {
+		t.Error("expected error for text the standard fonts cannot encode")
+	}
+}
+
+// 已经加过水印的文件（包括合并后的文件）可以再次加水印，内容流的长度与数据一致
+func Test_StampTwice(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "stamped.pdf")
+	writeTestPDF(t, file, 2)
+	if err := StampPDFFile(file, []*Stamp{{Text: "DRAFT"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := StampPDFFile(file, []*Stamp{{Text: "COPY", OnTop: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	other := filepath.Join(dir, "other.pdf")
+	writeTestPDF(t, other, 1)
+	combined := filepath.Join(dir, "combined.pdf")
+	if err := CombinePDF([]string{file, other}, combined); err != nil {
+		t.Fatal(err)
+	}
+	if err := StampPDFFile(combined, []*Stamp{{Text: "FINAL", Position: "top"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []string{file, combined} {
+		if err := api.ValidateFile(f, newPDFConfig()); err != nil {
+			t.Fatalf("%s: %v", filepath.Base(f), err)
+		}
+		ctx, err := readPDFContext(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n, entry := range ctx.Table {
+			if entry == nil || entry.Free {
+				continue
+			}
+			if _, ok := entry.Object.(pdfcpu.StreamDict); ok {
+				if _, err := pdfcpu.ExtractStreamData(ctx, n); err != nil {
+					t.Errorf("%s: stream %d: %v", filepath.Base(f), n, err)
+				}
+			}
+		}
+		root, _ := ctx.Catalog()
+		properties, _ := ctx.DereferenceDict(root["OCProperties"])
+		ocgs, _ := ctx.DereferenceArray(properties["OCGs"])
+		config, _ := ctx.DereferenceDict(properties["D"])
+		on, _ := ctx.DereferenceArray(config["ON"])
+		if len(ocgs) < 2 || len(on) != len(ocgs) {
+			t.Errorf("%s: unexpected optional content: %v", filepath.Base(f), properties)
+		}
+	}
+}
+
+func Test_StampFont(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "font.pdf")
+	writeTestPDF(t, file, 1)
+	stamps := []*Stamp{
+		{Text: "COPY", Font: "Times-Roman"},
+		{Text: "DRAFT", Font: "Helvetica-Bold", OnTop: true},
+	}
+	if err := StampPDFFile(file, stamps); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ValidateFile(file, newPDFConfig()); err != nil {
+		t.Fatal(err)
+	}
+	for _, font := range []string{"Times", "Helvetic"} {
+		if err := (&Stamp{Text: "DRAFT", Font: font}).normalize(); err == nil {
+			t.Errorf("expected error for font %s", font)
+		}
+	}
+}
+
+// PDF 水印只能居中放置，其他位置或偏移返回错误而不是忽略
+func Test_StampPDFPosition(t *testing.T) {
+	for _, stamp := range []*Stamp{
+		{Src: "http://example.com/logo.pdf", Position: "top-left"},
+		{Src: "http://example.com/logo.pdf", OffsetX: 5},
+		{Type: StampPDF, Src: "http://example.com/logo", OffsetY: -5},
+	} {
+		if err := stamp.normalize(); err == nil {
+			t.Errorf("expected error for %+v", stamp)
+		}
+	}
+	stamp := &Stamp{Src: "http://example.com/logo.pdf", Position: "Center"}
+	if err := stamp.normalize(); err != nil {
+		t.Error(err)
+	}
+}
+
+// 未设置的字段使用默认值，显式设置的 0 保留，超出范围的值返回错误
+func Test_StampNormalizeRanges(t *testing.T) {
+	stamp := &Stamp{}
+	if err := json.Unmarshal([]byte(`{"text":"DRAFT","opacity":0,"margin":0}`), stamp); err != nil {
+		t.Fatal(err)
+	}
+	if err := stamp.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if stamp.Opacity != 0 || stamp.Margin != 0 || stamp.FontSize != 48 || stamp.Scale != 0.5 {
+		t.Errorf("unexpected stamp: %+v", stamp)
+	}
+
+	for _, value := range []string{
+		`{"text":"DRAFT","opacity":1.5}`,
+		`{"text":"DRAFT","opacity":-0.1}`,
+		`{"text":"DRAFT","margin":-1}`,
+		`{"text":"DRAFT","font_size":0}`,
+		`{"src":"http://example.com/a.png","scale":1.5}`,
+		`{"src":"http://example.com/a.png","scale":0}`,
+		`{"src":"http://example.com/a.pdf","page":0}`,
+	} {
+		stamp := &Stamp{}
+		if err := json.Unmarshal([]byte(value), stamp); err != nil {
+			t.Fatal(err)
+		}
+		if err := stamp.normalize(); err == nil {
+			t.Errorf("expected error for %s", value)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// 目录参数，Template 为空时使用配置的 toc_template 或内置模板，
+// Duplex 为 true 时目录补空白页到偶数页，双面打印时正文从右页开始
+type TOCOptions struct {
+	Title    string
+	Depth    int
+	Template string
+	Duplex   bool
+}
+
+type tocEntry struct {
+	Index int
+	Title string
+	Level int
+	Page  int // 显示的页码
+
+	item *OutlineItem
+}
+
+type tocData struct {
+	Title   string
+	Entries []*tocEntry
+}
+
+// 内置目录模板，每个条目需要带 data-toc-entry 属性，渲染后在该元素的位置添加链接
+const defaultTOCTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style>
+body { font-family: sans-serif; margin: 80px 90px; color: #222; }
+h1 { font-size: 32px; margin: 0 0 40px; }
+a { display: block; color: inherit; text-decoration: none; font-size: 20px; line-height: 1.9; overflow: hidden; white-space: nowrap; }
+a .page { float: right; padding-left: 8px; background: #fff; }
+a .title { padding-right: 8px; background: #fff; }
+a:after { content: ""; display: block; margin-top: -0.55em; border-bottom: 2px dotted #999; }
+.level-1 { font-weight: bold; }
+.level-2 { padding-left: 30px; }
+.level-3 { padding-left: 60px; }
+.level-4, .level-5, .level-6 { padding-left: 90px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Entries}}<a class="level-{{.Level}}" data-toc-entry="{{.Index}}"><span class="page">{{.Page}}</span><span class="title">{{.Title}}</span></a>
+{{end}}
+</body>
+</html>`
+
+// 读取目录参数：toc、toc_title、toc_depth（目录包含的书签层级，默认 2）、
+// toc_template（Go html/template 格式的模板，可以上传文件）
+func parseTOC(request *http.Request) (*TOCOptions, error) {
+	if !parseBool(request.FormValue("toc")) {
+		return nil, nil
+	}
+	toc := &TOCOptions{Title: request.FormValue("toc_title"), Depth: 2}
+	if len(toc.Title) == 0 {
+		toc.Title = "Contents"
+	}
+	if value := request.FormValue("toc_depth"); len(value) > 0 {
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth < 1 || depth > 6 {
+			return nil, fmt.Errorf("invalid toc_depth: %s", value)
+		}
+		toc.Depth = depth
+	}
+	if tpl, err := readUpload(request, "toc_template"); err == nil {
+		toc.Template = string(tpl)
+	}
+	return toc, nil
+}
+
+// 把书签展开为目录条目，页码为加上目录页数后的最终页码
+func tocEntries(items []*OutlineItem, depth int, offset int) []*tocEntry {
+	entries := make([]*tocEntry, 0)
+	var walk func(items []*OutlineItem, level int)
+	walk = func(items []*OutlineItem, level int) {
+		for _, item := range items {
+			entries = append(entries, &tocEntry{Index: len(entries), Title: item.Title, Level: level,
+				Page: item.Page + offset, item: item})
+			if level < depth {
+				walk(item.Children, level+1)
+			}
+		}
+	}
+	walk(items, 1)
+	return entries
+}
+
+func (pdf *HTMLPDF) tocTemplate(toc *TOCOptions) (*template.Template, error) {
+	source := toc.Template
+	if len(source) == 0 && len(pdf.config.TOCTemplate) > 0 {
+		data, err := os.ReadFile(pdf.config.TOCTemplate)
+		if err != nil {
+			return nil, err
+		}
+		source = string(data)
+	}
+	if len(source) == 0 {
+		source = defaultTOCTemplate
+	}
+	return template.New("toc").Parse(source)
+}
+
+// 根据文档的书签生成目录并插入到文档最前面（原地修改）
+func (pdf *HTMLPDF) PrependTOC(pdf_path string, toc *TOCOptions) error {
+	ctx, err := readPDFContext(pdf_path)
+	if err != nil {
+		return err
+	}
+	items, err := contextOutline(ctx)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no headings or documents for the table of contents")
+	}
+	tpl, err := pdf.tocTemplate(toc)
+	if err != nil {
+		return err
+	}
+
+	html_path := filepath.Join(pdf.config.TempPath, MakeUUID()+".html")
+	toc_path := filepath.Join(pdf.config.TempPath, MakeUUID()+".pdf")
+	defer os.Remove(html_path)
+	defer os.Remove(toc_path)
+
+	options := NewRenderOptions()
+	options.OutlineSel = "[data-toc-entry]"
+
+	var elements []*renderedElement
+	var entries []*tocEntry
+	pages, err := settleTOCPages(toc.Duplex, func(pages int) (int, error) {
+		entries = tocEntries(items, toc.Depth, pages)
+		var b bytes.Buffer
+		err := tpl.Execute(&b, &tocData{Title: toc.Title, Entries: entries})
+		if err != nil {
+			return 0, err
+		}
+		if err = os.WriteFile(html_path, b.Bytes(), 0666); err != nil {
+			return 0, err
+		}
+		if elements, err = pdf.renderElements("file:///"+filepath.ToSlash(html_path), toc_path, options); err != nil {
+			return 0, err
+		}
+		info, err := ReadPageInfo(toc_path)
+		if err != nil {
+			return 0, err
+		}
+		return len(info), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return attachTOC(pdf_path, toc_path, pages, toc.Title, items, entries, elements)
+}
+
+// 目录页数确定之前无法得知最终页码：先按一页渲染，实际页数多于假定的页数时按实际页数重新渲染。
+// 实际页数不超过假定的页数时返回假定的页数，合并时补空白页到该页数，目录中的页码保持正确；
+// 渲染 3 次仍未确定时报错。even 为 true 时页数取偶数
+func settleTOCPages(even bool, render func(pages int) (int, error)) (int, error) {
+	pages := 1
+	if even {
+		pages = 2
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		count, err := render(pages)
+		if err != nil {
+			return 0, err
+		}
+		if count <= pages {
+			return pages, nil
+		}
+		pages = count
+		if even && pages%2 == 1 {
+			pages++
+		}
+	}
+	return 0, fmt.Errorf("the page count of the table of contents did not settle after 3 attempts")
+}
+
+// 合并目录及文档，目录不足 pages 页时补空白页，在目录条目的位置添加跳转链接，并把目录加入书签
+func attachTOC(pdf_path string, toc_path string, pages int, title string, items []*OutlineItem,
+	entries []*tocEntry, elements []*renderedElement) error {
+	tocInfo, err := ReadPageInfo(toc_path)
+	if err != nil {
+		return err
+	}
+	if len(tocInfo) > pages {
+		return fmt.Errorf("the table of contents has %d pages, expected %d", len(tocInfo), pages)
+	}
+	// 以原文档为主合并，保留原文档的元数据及目录以外的文档级设置，再把目录页移到最前面
+	merged := pdf_path + ".toc.pdf"
+	defer os.Remove(merged)
+	if err = CombinePDF([]string{pdf_path, toc_path}, merged); err != nil {
+		return err
+	}
+
+	ctx, err := readPDFContext(merged)
+	if err != nil {
+		return err
+	}
+	for i := len(tocInfo); i < pages; i++ {
+		if _, err = appendBlankPage(ctx, ctx.PageCount); err != nil {
+			return err
+		}
+	}
+	offset := pages
+	order := make([]int, 0, ctx.PageCount)
+	for i := ctx.PageCount - offset + 1; i <= ctx.PageCount; i++ {
+		order = append(order, i)
+	}
+	for i := 1; i <= ctx.PageCount-offset; i++ {
+		order = append(order, i)
+	}
+	if err = reorderPages(ctx, order); err != nil {
+		return err
+	}
+	shiftOutline(items, offset, 1)
+	for _, el := range elements {
+		index, err := strconv.Atoi(el.Key)
+		if err != nil || index < 0 || index >= len(entries) || el.Page < 1 || el.Page > offset {
+			continue
+		}
+		if err = addPageLink(ctx, el, entries[index].item); err != nil {
+			return err
+		}
+	}
+
+	outline := append([]*OutlineItem{{Title: title, Level: 1, Page: 1}}, items...)
+	if err = setContextOutline(ctx, outline); err != nil {
+		return err
+	}
+	if err = writePDFContext(ctx, merged); err != nil {
+		return err
+	}
+	return os.Rename(merged, pdf_path)
+}
+
+// 在元素所在的位置添加跳转到书签目标的链接
+func addPageLink(ctx *pdfcpu.Context, el *renderedElement, target *OutlineItem) error {
+	if target.Page < 1 || target.Page > ctx.PageCount {
+		return nil
+	}
+	page, _, err := ctx.PageDict(el.Page)
+	if err != nil {
+		return err
+	}
+	targetRef, err := pageRef(ctx, target.Page)
+	if err != nil {
+		return err
+	}
+	targetPage, _, err := ctx.PageDict(target.Page)
+	if err != nil {
+		return err
+	}
+	height := pageInfo(ctx.XRefTable, page).Height
+
+	link := pdfcpu.Dict{
+		"Type":    pdfcpu.Name("Annot"),
+		"Subtype": pdfcpu.Name("Link"),
+		"Rect": pdfcpu.Array{pdfcpu.Float(el.Left), pdfcpu.Float(height - el.Top - el.Height),
+			pdfcpu.Float(el.Left + el.Width), pdfcpu.Float(height - el.Top)},
+		"Border": pdfcpu.Array{pdfcpu.Integer(0), pdfcpu.Integer(0), pdfcpu.Integer(0)},
+		"Dest": pdfcpu.Array{*targetRef, pdfcpu.Name("FitH"),
+			pdfcpu.Float(pageInfo(ctx.XRefTable, targetPage).Height - target.Top)},
+	}
+	ref, err := ctx.IndRefForNewObject(link)
+	if err != nil {
+		return err
+	}
+	return appendArrayEntry(ctx.XRefTable, page, "Annots", *ref)
+}
@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func Test_ParseTOC(t *testing.T) {
+	toc, err := parseTOC(newFormRequest(url.Values{"toc": {"true"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toc == nil || toc.Title != "Contents" || toc.Depth != 2 || len(toc.Template) != 0 {
+		t.Errorf("unexpected toc options: %+v", toc)
+	}
+
+	toc, err = parseTOC(newFormRequest(url.Values{"toc": {"1"}, "toc_title": {"目录"}, "toc_depth": {"3"}}))
+	if err != nil || toc.Title != "目录" || toc.Depth != 3 {
+		t.Errorf("unexpected toc options: %+v %v", toc, err)
+	}
+	if toc, err = parseTOC(newFormRequest(url.Values{"toc_title": {"目录"}})); toc != nil || err != nil {
+		t.Errorf("expected no toc, got %+v %v", toc, err)
+	}
+	for _, depth := range []string{"0", "7", "x"} {
+		if _, err = parseTOC(newFormRequest(url.Values{"toc": {"true"}, "toc_depth": {depth}})); err == nil {
+			t.Errorf("expected error for toc_depth %s", depth)
+		}
+	}
+}
+
+func Test_TOCEntries(t *testing.T) {
+	items := buildOutlineTree([]*OutlineItem{
+		{Title: "1", Level: 1, Page: 1},
+		{Title: "1.1", Level: 2, Page: 2},
+		{Title: "1.1.1", Level: 3, Page: 2},
+		{Title: "2", Level: 1, Page: 4},
+	})
+	entries := tocEntries(items, 2, 2)
+	if len(entries) != 3 {
+		t.Fatalf("unexpected entry count: %d", len(entries))
+	}
+	if entries[1].Title != "1.1" || entries[1].Level != 2 || entries[1].Page != 4 || entries[2].Index != 2 || entries[2].Page != 6 {
+		t.Errorf("unexpected entries: %+v %+v", entries[1], entries[2])
+	}
+}
+
+func Test_AttachTOC(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "doc.pdf")
+	writeTestPDF(t, file, 3)
+	if err := SetPDFMetadata(file, &Metadata{Title: "Report"}); err != nil {
+		t.Fatal(err)
+	}
+	items := buildOutlineTree([]*OutlineItem{
+		{Title: "Intro", Level: 1, Page: 1},
+		{Title: "Results", Level: 1, Page: 3, Top: 100},
+	})
+	toc_path := filepath.Join(dir, "toc.pdf")
+	writeTestPDF(t, toc_path, 1)
+
+	entries := tocEntries(items, 2, 1)
+	elements := []*renderedElement{
+		{Page: 1, Top: 100, Left: 50, Width: 400, Height: 20, Key: "1"},
+		{Page: 1, Top: 130, Left: 50, Width: 400, Height: 20, Key: "9"},
+	}
+	if err := attachTOC(file, toc_path, 1, "Contents", items, entries, elements); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.PageCount != 4 {
+		t.Fatalf("unexpected page count: %d", ctx.PageCount)
+	}
+	if meta, err := contextMetadata(ctx); err != nil || meta.Title != "Report" {
+		t.Errorf("metadata not kept: %+v %v", meta, err)
+	}
+	read, err := contextOutline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 3 || read[0].Title != "Contents" || read[0].Page != 1 || read[1].Page != 2 || read[2].Page != 4 {
+		t.Fatalf("unexpected outline: %+v", read)
+	}
+
+	page, _, err := ctx.PageDict(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annots, err := ctx.DereferenceArray(page["Annots"])
+	if err != nil || len(annots) != 1 {
+		t.Fatalf("unexpected annotations: %v %v", annots, err)
+	}
+	link, err := ctx.DereferenceDict(annots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, err := ctx.DereferenceArray(link["Dest"])
+	if err != nil || len(dest) != 3 {
+		t.Fatalf("unexpected link destination: %v %v", dest, err)
+	}
+	target, err := pageRef(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref, ok := dest[0].(pdfcpu.IndirectRef); !ok || ref.ObjectNumber != target.ObjectNumber {
+		t.Errorf("link does not point to page 4: %v", dest)
+	}
+}
+
+func Test_SettleTOCPages(t *testing.T) {
+	// 按 2 页渲染时页码变短只需 1 页，补空白页到 2 页
+	counts := map[int]int{1: 2, 2: 1}
+	pages, err := settleTOCPages(false, func(pages int) (int, error) {
+		return counts[pages], nil
+	})
+	if err != nil || pages != 2 {
+		t.Errorf("unexpected pages: %d %v", pages, err)
+	}
+
+	// 页数一直增加时报错，不输出错误的页码
+	if _, err = settleTOCPages(false, func(pages int) (int, error) {
+		return pages + 1, nil
+	}); err == nil {
+		t.Error("expected error for unsettled page count")
+	}
+
+	// 双面打印时目录按偶数页计算页码：渲染出 3 页时按 4 页重新渲染
+	rendered := make([]int, 0)
+	pages, err = settleTOCPages(true, func(pages int) (int, error) {
+		rendered = append(rendered, pages)
+		return 3, nil
+	})
+	if err != nil || pages != 4 || len(rendered) != 2 || rendered[0] != 2 || rendered[1] != 4 {
+		t.Errorf("unexpected pages: %d %v %v", pages, rendered, err)
+	}
+}
+
+func Test_AttachPaddedTOC(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "doc.pdf")
+	writeTestPDF(t, file, 3)
+	items := buildOutlineTree([]*OutlineItem{
+		{Title: "Intro", Level: 1, Page: 1},
+		{Title: "Results", Level: 1, Page: 3},
+	})
+	toc_path := filepath.Join(dir, "toc.pdf")
+	writeTestPDF(t, toc_path, 1)
+
+	if err := attachTOC(file, toc_path, 2, "Contents", items, tocEntries(items, 2, 2), nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := readPDFContext(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.PageCount != 5 {
+		t.Fatalf("unexpected page count: %d", ctx.PageCount)
+	}
+	read, err := contextOutline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 3 || read[1].Page != 3 || read[2].Page != 5 {
+		t.Errorf("unexpected outline: %+v", read)
+	}
+
+	if err = attachTOC(file, toc_path, 0, "Contents", items, nil, nil); err == nil {
+		t.Error("expected error for a table of contents longer than expected")
+	}
+}
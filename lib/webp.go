@@ -0,0 +1,393 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// golang.org/x/image/webp 只提供解码，这里实现一个简单的 VP8L 无损编码器：
+// 不使用变换及颜色缓存，只对像素字面量及与相邻像素相同的连续像素（LZ77 回溯引用）做 Huffman 编码。
+// 与 cwebp 的无损模式一样，quality 表示压缩力度：越高查找的相邻像素越多，文件越小，编码越慢
+
+const (
+	vp8lMaxSize       = 1 << 14
+	vp8lMaxCodeLength = 15
+	vp8lMaxCopyLength = 4096
+
+	vp8lDistanceAbove      = 1 // 平面编码 1：上方像素
+	vp8lDistanceLeft       = 2 // 平面编码 2：左侧像素
+	vp8lDistanceAboveLeft  = 3 // 平面编码 3：左上方像素
+	vp8lDistanceAboveRight = 4 // 平面编码 4：右上方像素
+)
+
+// 未设置 quality 时 WebP 的压缩力度
+const DefaultWebPQuality = 75
+
+var vp8lCodeLengthCodeOrder = [19]int{
+	17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+type vp8lBitWriter struct {
+	buf   bytes.Buffer
+	bits  uint64
+	nBits uint
+}
+
+func (w *vp8lBitWriter) write(value uint32, n uint) {
+	w.bits |= uint64(value) << w.nBits
+	w.nBits += n
+	for w.nBits >= 8 {
+		w.buf.WriteByte(byte(w.bits))
+		w.bits >>= 8
+		w.nBits -= 8
+	}
+}
+
+// Huffman 编码按从高位到低位的顺序逐位写入
+func (w *vp8lBitWriter) writeCode(code uint32, length uint32) {
+	for i := int(length) - 1; i >= 0; i-- {
+		w.write((code>>uint(i))&1, 1)
+	}
+}
+
+func (w *vp8lBitWriter) flush() []byte {
+	if w.nBits > 0 {
+		w.buf.WriteByte(byte(w.bits))
+		w.bits, w.nBits = 0, 0
+	}
+	return w.buf.Bytes()
+}
+
+type vp8lSymbol struct {
+	green    uint32 // < 256 为字面量，否则为长度前缀码 + 256
+	red      uint32
+	blue     uint32
+	alpha    uint32
+	extra    uint32 // 长度的额外位
+	nExtra   uint
+	distance uint32 // 距离前缀码
+	dExtra   uint32
+	dNExtra  uint
+}
+
+// 将 1 开始的长度或距离编码为前缀码及额外位
+func vp8lPrefix(value int) (prefix uint32, extraBits uint, extra uint32) {
+	d := uint32(value - 1)
+	if d < 4 {
+		return d, 0, 0
+	}
+	hb := uint(0)
+	for (d >> (hb + 1)) != 0 {
+		hb++
+	}
+	second := (d >> (hb - 1)) & 1
+	extraBits = hb - 1
+	return uint32(2*hb) + second, extraBits, d & (1<<extraBits - 1)
+}
+
+// EncodeWebP 将图片编码为无损 WebP，quality（0-100）为压缩力度
+func EncodeWebP(out io.Writer, img image.Image, quality int) error {
+	rect := img.Bounds()
+	width, height := rect.Dx(), rect.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return errors.New("webp: invalid image size")
+	}
+
+	argb := make([]uint32, width*height)
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(rect.Min.X+x, rect.Min.Y+y)).(color.NRGBA)
+			argb[y*width+x] = uint32(c.A)<<24 | uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+		}
+	}
+
+	symbols := vp8lTokenize(argb, width, quality)
+
+	var histograms [5][]uint32
+	histograms[0] = make([]uint32, 256+24)
+	histograms[1] = make([]uint32, 256)
+	histograms[2] = make([]uint32, 256)
+	histograms[3] = make([]uint32, 256)
+	histograms[4] = make([]uint32, 40)
+	for _, s := range symbols {
+		histograms[0][s.green]++
+		if s.green < 256 {
+			histograms[1][s.red]++
+			histograms[2][s.blue]++
+			histograms[3][s.alpha]++
+		} else {
+			histograms[4][s.distance]++
+		}
+	}
+
+	w := &vp8lBitWriter{}
+	w.write(0x2f, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if hasAlpha {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(0, 3) // version
+	w.write(0, 1) // 无变换
+	w.write(0, 1) // 无颜色缓存
+	w.write(0, 1) // 无 meta Huffman
+
+	var lengths, codes [5][]uint32
+	for i, histogram := range histograms {
+		lengths[i] = vp8lCodeLengths(histogram, vp8lMaxCodeLength)
+		codes[i] = vp8lCodes(lengths[i])
+		vp8lWriteTree(w, lengths[i])
+	}
+
+	emit := func(tree int, symbol uint32) {
+		// 只有一个符号的树不占用任何位
+		if vp8lSingleSymbol(lengths[tree]) {
+			return
+		}
+		w.writeCode(codes[tree][symbol], lengths[tree][symbol])
+	}
+	for _, s := range symbols {
+		emit(0, s.green)
+		if s.green < 256 {
+			emit(1, s.red)
+			emit(2, s.blue)
+			emit(3, s.alpha)
+			continue
+		}
+		w.write(s.extra, s.nExtra)
+		emit(4, s.distance)
+		w.write(s.dExtra, s.dNExtra)
+	}
+
+	data := w.flush()
+	chunk := len(data)
+	padded := chunk + chunk&1
+
+	header := make([]byte, 20)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(4+8+padded))
+	copy(header[8:12], "WEBP")
+	copy(header[12:16], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:20], uint32(chunk))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		return err
+	}
+	if padded != chunk {
+		_, err := out.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+type vp8lCandidate struct {
+	offset, code int
+}
+
+// 按压缩力度选择查找的相邻像素：低于 30 只查找左侧，低于 70 增加上方，否则再增加左上方及右上方
+func vp8lCandidates(width int, quality int) []vp8lCandidate {
+	list := []vp8lCandidate{{1, vp8lDistanceLeft}}
+	if quality >= 30 {
+		list = append(list, vp8lCandidate{width, vp8lDistanceAbove})
+	}
+	if quality >= 70 {
+		list = append(list, vp8lCandidate{width + 1, vp8lDistanceAboveLeft})
+		// 宽度为 1 时右上方即当前像素，解码器会将距离按 1 处理
+		if width > 1 {
+			list = append(list, vp8lCandidate{width - 1, vp8lDistanceAboveRight})
+		}
+	}
+	return list
+}
+
+// 贪心地查找与相邻像素相同的连续像素
+func vp8lTokenize(argb []uint32, width int, quality int) []vp8lSymbol {
+	candidates := vp8lCandidates(width, quality)
+	symbols := make([]vp8lSymbol, 0, len(argb)/2)
+	for p := 0; p < len(argb); {
+		bestLength, bestCode := 0, 0
+		for _, candidate := range candidates {
+			if p < candidate.offset {
+				continue
+			}
+			n := 0
+			for p+n < len(argb) && n < vp8lMaxCopyLength && argb[p+n] == argb[p+n-candidate.offset] {
+				n++
+			}
+			if n > bestLength {
+				bestLength, bestCode = n, candidate.code
+			}
+		}
+
+		if bestLength >= 2 {
+			prefix, nExtra, extra := vp8lPrefix(bestLength)
+			dPrefix, dNExtra, dExtra := vp8lPrefix(bestCode)
+			symbols = append(symbols, vp8lSymbol{
+				green:    256 + prefix,
+				extra:    extra,
+				nExtra:   nExtra,
+				distance: dPrefix,
+				dExtra:   dExtra,
+				dNExtra:  dNExtra,
+			})
+			p += bestLength
+			continue
+		}
+
+		c := argb[p]
+		symbols = append(symbols, vp8lSymbol{
+			green: (c >> 8) & 0xff,
+			red:   (c >> 16) & 0xff,
+			blue:  c & 0xff,
+			alpha: c >> 24,
+		})
+		p++
+	}
+	return symbols
+}
+
+func vp8lSingleSymbol(lengths []uint32) bool {
+	count := 0
+	for _, l := range lengths {
+		if l > 0 {
+			count++
+		}
+	}
+	return count <= 1
+}
+
+// 根据频率计算 Huffman 码长，超过 maxLength 时压缩频率后重新计算
+func vp8lCodeLengths(histogram []uint32, maxLength uint32) []uint32 {
+	lengths := make([]uint32, len(histogram))
+
+	used := make([]int, 0)
+	for symbol, freq := range histogram {
+		if freq > 0 {
+			used = append(used, symbol)
+		}
+	}
+	switch len(used) {
+	case 0:
+		lengths[0] = 1
+		return lengths
+	case 1:
+		lengths[used[0]] = 1
+		return lengths
+	}
+
+	freqs := make([]uint32, len(histogram))
+	copy(freqs, histogram)
+	for {
+		type node struct {
+			weight      uint64
+			symbol      int
+			left, right int
+		}
+		nodes := make([]node, 0, 2*len(used))
+		for _, symbol := range used {
+			nodes = append(nodes, node{weight: uint64(freqs[symbol]), symbol: symbol, left: -1, right: -1})
+		}
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+
+		// 两个队列的 Huffman 构造：叶子队列与合并节点队列都是有序的
+		leaf, merged := 0, len(nodes)
+		pick := func() int {
+			if leaf < len(used) && (merged >= len(nodes) || nodes[leaf].weight <= nodes[merged].weight) {
+				leaf++
+				return leaf - 1
+			}
+			merged++
+			return merged - 1
+		}
+		for i := 0; i < len(used)-1; i++ {
+			a := pick()
+			b := pick()
+			nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, symbol: -1, left: a, right: b})
+		}
+
+		depth := make([]uint32, len(nodes))
+		tooLong := false
+		for i := len(nodes) - 1; i >= 0; i-- {
+			if nodes[i].symbol >= 0 {
+				lengths[nodes[i].symbol] = depth[i]
+				if depth[i] > maxLength {
+					tooLong = true
+				}
+				continue
+			}
+			depth[nodes[i].left] = depth[i] + 1
+			depth[nodes[i].right] = depth[i] + 1
+		}
+		if !tooLong {
+			return lengths
+		}
+		for _, symbol := range used {
+			freqs[symbol] = freqs[symbol]/2 + 1
+		}
+	}
+}
+
+// 根据码长生成规范 Huffman 编码
+func vp8lCodes(lengths []uint32) []uint32 {
+	var histogram [vp8lMaxCodeLength + 1]uint32
+	for _, l := range lengths {
+		histogram[l]++
+	}
+	histogram[0] = 0
+	var next [vp8lMaxCodeLength + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + histogram[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for symbol, l := range lengths {
+		if l > 0 {
+			codes[symbol] = next[l]
+			next[l]++
+		}
+	}
+	return codes
+}
+
+// 写入 Huffman 树，码长本身用码长编码（只使用 0-15 的字面量）
+func vp8lWriteTree(w *vp8lBitWriter, lengths []uint32) {
+	w.write(0, 1) // 非简单编码
+
+	histogram := make([]uint32, 19)
+	for _, l := range lengths {
+		histogram[l]++
+	}
+	clLengths := vp8lCodeLengths(histogram, 7)
+	clCodes := vp8lCodes(clLengths)
+
+	nCodes := 19
+	for nCodes > 4 && clLengths[vp8lCodeLengthCodeOrder[nCodes-1]] == 0 {
+		nCodes--
+	}
+	w.write(uint32(nCodes-4), 4)
+	for i := 0; i < nCodes; i++ {
+		w.write(clLengths[vp8lCodeLengthCodeOrder[i]], 3)
+	}
+
+	w.write(0, 1) // 写入全部符号的码长
+	single := vp8lSingleSymbol(clLengths)
+	for _, l := range lengths {
+		if !single {
+			w.writeCode(clCodes[l], clLengths[l])
+		}
+	}
+}
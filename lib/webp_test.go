@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func Test_EncodeWebP(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 67, 41))
+	for y := 0; y < 41; y++ {
+		for x := 0; x < 67; x++ {
+			c := color.NRGBA{255, 255, 255, 255}
+			if x > 10 && x < 30 {
+				c = color.NRGBA{uint8(x * 3), uint8(y * 5), uint8(x ^ y), 128}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	// 不同的压缩力度都必须无损
+	for _, quality := range []int{0, 50, DefaultWebPQuality, 100} {
+		var buf bytes.Buffer
+		if err := EncodeWebP(&buf, img, quality); err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Bounds() != img.Bounds() {
+			t.Fatalf("quality %d: unexpected bounds: %v", quality, decoded.Bounds())
+		}
+		for y := 0; y < 41; y++ {
+			for x := 0; x < 67; x++ {
+				want := img.NRGBAAt(x, y)
+				got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+				if want != got {
+					t.Fatalf("quality %d, pixel (%d,%d): want %v, got %v", quality, x, y, want, got)
+				}
+			}
+		}
+	}
+}
+
+func Test_ConvertTallPNGToWebP(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "full.png")
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20000))
+	for y := 0; y < 20000; y++ {
+		img.Set(y%20, y, color.NRGBA{0, 0, 255, 255})
+	}
+	out, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(out, img)
+	out.Close()
+
+	dest := filepath.Join(dir, "full.webp")
+	if err = convertPNGToWebP(src, dest, DefaultWebPQuality); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	config, err := webp.DecodeConfig(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Height != vp8lMaxSize || config.Width != 16 {
+		t.Errorf("unexpected size: %dx%d", config.Width, config.Height)
+	}
+}
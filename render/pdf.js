@@ -2,30 +2,383 @@
 
 var page = require('webpage').create(),
     system = require('system'),
-    address, 
-    output = "pdf";
-    
+    address,
+    output = "pdf",
+    options = {format: "pdf"};
+
 if (system.args.length > 2) {
     address = system.args[1];
     output = system.args[2];
 }
+if (system.args.length > 3) {
+    options = JSON.parse(system.args[3]);
+}
+
+var isImage = options.format && options.format !== "pdf";
+
+// A4 宽度约为 1240px，PDF 输出时缩放页面使其铺满纸张
+var PDF_ZOOM = 0.48;
+
+// A4 纸张尺寸（点）
+var A4_WIDTH_PT = 595.28,
+    A4_HEIGHT_PT = 841.89;
+
+// 需要测量打印位置（书签、目录、表单域）或单页高度的 PDF 渲染
+var measurePrint = !isImage && (options.outline || options.form_fields || options.single_page);
+
+if (isImage || (!measurePrint && (options.viewport_width || options.viewport_height))) {
+    // 截图时未设置视口尺寸同样使用 1280x800，避免按 PhantomJS 默认的 400x300 裁剪
+    page.viewportSize = {
+        width: options.viewport_width || 1280,
+        height: options.viewport_height || 800
+    };
+} else if (measurePrint) {
+    // 打印时页面按 PDF_ZOOM 缩放，版面宽度为纸张宽度（点）/ PDF_ZOOM，
+    // 加载时即按该宽度布局，测量的位置与打印时的版面一致。不测量时视口保持 PhantomJS 的默认值，
+    // 依赖视口宽度的页面脚本及媒体查询在两种情况下可能得到不同的布局
+    page.viewportSize = {
+        width: Math.round((options.single_page && options.page_width ? options.page_width * 72 / 25.4 : A4_WIDTH_PT) / PDF_ZOOM),
+        height: Math.round(A4_HEIGHT_PT / PDF_ZOOM)
+    };
+}
 
 page.paperSize = {
     margin: '0px',
     format: "A4"
 }
-page.zoomFactor = 1;
+page.zoomFactor = isImage ? (options.scale_factor || 1) : 1;
+
+// 截图区域，坐标以 CSS 像素为单位，需要乘以缩放比例
+function clipRect(rect) {
+    var zoom = page.zoomFactor;
+    return {
+        top: rect.y * zoom,
+        left: rect.x * zoom,
+        width: rect.width * zoom,
+        height: rect.height * zoom
+    };
+}
+
+function renderImage() {
+    if (options.transparent) {
+        page.evaluate(function () {
+            document.documentElement.style.background = 'transparent';
+            document.body.style.background = 'transparent';
+        });
+    }
+
+    if (options.selector) {
+        var rect = page.evaluate(function (selector) {
+            var el = document.querySelector(selector);
+            if (!el) {
+                return null;
+            }
+            var box = el.getBoundingClientRect();
+            return {
+                x: box.left + window.scrollX,
+                y: box.top + window.scrollY,
+                width: box.width,
+                height: box.height
+            };
+        }, options.selector);
+        if (!rect) {
+            console.log('Unable to find the selector: ' + options.selector);
+            phantom.exit(1);
+            return;
+        }
+        page.clipRect = clipRect(rect);
+    } else if (options.clip) {
+        page.clipRect = clipRect(options.clip);
+    } else if (!options.full_page) {
+        page.clipRect = clipRect({
+            x: 0,
+            y: 0,
+            width: page.viewportSize.width,
+            height: page.viewportSize.height
+        });
+    }
+
+    var renderOptions = {format: options.format};
+    if (options.quality) {
+        renderOptions.quality = String(options.quality);
+    }
+    page.render(output, renderOptions);
+    phantom.exit();
+}
+
+// 只保留匹配选择器的元素：hide 对目标元素祖先链以外的元素设置 display: none，
+// 并去掉祖先元素的外边距、内边距及边框，使目标元素位于页面左上角，版面只包含目标元素；
+// subtree 只保留该元素的子树。两种模式都去掉目标元素自身的外边距，fit_element 按元素的边框盒设置纸张尺寸
+function isolateElement(selector, mode) {
+    return page.evaluate(function (selector, mode) {
+        var el = document.querySelector(selector);
+        if (!el) {
+            return false;
+        }
+        function collapse(node) {
+            node.style.setProperty('margin', '0', 'important');
+            node.style.setProperty('padding', '0', 'important');
+            node.style.setProperty('border', '0', 'important');
+        }
+        if (mode === 'hide') {
+            for (var node = el; node !== document.documentElement && node.parentNode; node = node.parentNode) {
+                var siblings = node.parentNode.children;
+                for (var i = 0; i < siblings.length; i++) {
+                    if (siblings[i] !== node) {
+                        siblings[i].style.setProperty('display', 'none', 'important');
+                    }
+                }
+                collapse(node.parentNode);
+            }
+        } else {
+            document.body.innerHTML = '';
+            document.body.appendChild(el);
+            collapse(document.body);
+            collapse(document.documentElement);
+        }
+        el.style.setProperty('margin', '0', 'important');
+        return true;
+    }, selector, mode);
+}
+
+function elementSize(selector) {
+    return page.evaluate(function (selector) {
+        var el = document.querySelector(selector);
+        var box = el.getBoundingClientRect();
+        return {width: box.width, height: box.height};
+    }, selector);
+}
+
+// 单页模式：按文档在纸张宽度下的滚动高度设置纸张高度并返回该高度（点），超过上限时报错。
+// 视口在加载时已设为该纸张宽度对应的版面宽度
+function fitDocumentHeight() {
+    var widthMM = options.page_width || 210;
+    var height = page.evaluate(function () {
+        return Math.max(document.documentElement.scrollHeight, document.body.scrollHeight);
+    });
+    var heightPt = Math.ceil(height * PDF_ZOOM);
+    var limitPt = options.max_page_height * 72 / 25.4;
+    if (limitPt && heightPt > limitPt) {
+        console.log('Page height ' + Math.round(heightPt * 25.4 / 72) + 'mm exceeds the limit of ' +
+            options.max_page_height + 'mm');
+        return 0;
+    }
+    page.paperSize = {
+        margin: '0px',
+        width: widthMM + 'mm',
+        height: heightPt + 'px'
+    };
+    return heightPt;
+}
+
+// 打印时的分页信息（点，文档坐标）：page_height 为纸张高度，breaks 为强制分页（page-break-before/after）的位置，
+// blocks 为打印时不会跨页的区域（文字行、图片及表单控件等替换元素、page-break-inside: avoid 的元素）。
+// 元素位置按文档坐标输出，由服务端按这些信息换算为页码及页面内的位置
+function printLayout(pageHeightPt) {
+    return page.evaluate(function (zoom, pageHeight) {
+        var breaks = [], blocks = [], seen = {};
+        var forced = /^(always|left|right|page)$/;
+        var replaced = /^(IMG|SVG|CANVAS|VIDEO|INPUT|SELECT|TEXTAREA|BUTTON|IFRAME|OBJECT|EMBED)$/i;
+        function addBlock(top, bottom) {
+            var key = top.toFixed(1) + ',' + bottom.toFixed(1);
+            if (bottom > top && !seen[key]) {
+                seen[key] = true;
+                blocks.push([top * zoom, bottom * zoom]);
+            }
+        }
+
+        var elements = document.body.getElementsByTagName('*');
+        for (var i = 0; i < elements.length; i++) {
+            var el = elements[i];
+            var style = window.getComputedStyle(el);
+            var box = el.getBoundingClientRect();
+            if (style.display === 'none' || (box.width === 0 && box.height === 0)) {
+                continue;
+            }
+            var top = box.top + window.scrollY, bottom = box.bottom + window.scrollY;
+            if (style.display !== 'inline') {
+                if (forced.test(style.pageBreakBefore)) {
+                    breaks.push(top * zoom);
+                }
+                if (forced.test(style.pageBreakAfter)) {
+                    breaks.push(bottom * zoom);
+                }
+            }
+            if (style.pageBreakInside === 'avoid' || replaced.test(el.tagName)) {
+                addBlock(top, bottom);
+            }
+        }
+
+        var walker = document.createTreeWalker(document.body, NodeFilter.SHOW_TEXT, null, false);
+        var range = document.createRange();
+        var node;
+        while ((node = walker.nextNode())) {
+            if (!/\S/.test(node.nodeValue)) {
+                continue;
+            }
+            range.selectNodeContents(node);
+            var rects = range.getClientRects();
+            for (var j = 0; j < rects.length; j++) {
+                addBlock(rects[j].top + window.scrollY, rects[j].bottom + window.scrollY);
+            }
+        }
+        return {page_height: pageHeight, breaks: breaks, blocks: blocks};
+    }, PDF_ZOOM, pageHeightPt);
+}
+
+// 记录标题距文档顶部的距离及尺寸（点），与分页信息一起写入文件，用于生成 PDF 书签及目录链接。
+// h1-h6 按标签确定层级，其他元素使用 data-outline-level，默认为 1
+function writeOutline(layout) {
+    var items = page.evaluate(function (selector, zoom) {
+        var list = [];
+        var elements = document.querySelectorAll(selector);
+        for (var i = 0; i < elements.length; i++) {
+            var el = elements[i];
+            var box = el.getBoundingClientRect();
+            if (box.width === 0 && box.height === 0) {
+                continue;
+            }
+            var title = el.getAttribute('data-outline-title') || el.textContent;
+            title = title.replace(/\s+/g, ' ').replace(/^\s+|\s+$/g, '');
+            if (!title) {
+                continue;
+            }
+            var level = parseInt(el.getAttribute('data-outline-level'), 10);
+            var match = /^H([1-6])$/.exec(el.tagName);
+            if (match) {
+                level = parseInt(match[1], 10);
+            }
+            list.push({
+                title: title,
+                level: level > 0 ? level : 1,
+                top: (box.top + window.scrollY) * zoom,
+                left: (box.left + window.scrollX) * zoom,
+                width: box.width * zoom,
+                height: box.height * zoom,
+                key: el.getAttribute('data-toc-entry') || ''
+            });
+        }
+        return list;
+    }, options.outline_selector || 'h1, h2, h3, h4, h5, h6', PDF_ZOOM);
+    require('fs').write(options.outline_file, JSON.stringify({layout: layout, elements: items}), 'w');
+}
+
+// 生成表单域时隐藏控件本身绘制的文字及勾选标记，由 PDF 表单域的外观代替，控件的边框及背景保留
+function hideFormValues() {
+    page.evaluate(function () {
+        var style = document.createElement('style');
+        style.id = 'html2pdf-form-values';
+        style.textContent = 'input, select, textarea { color: transparent !important; -webkit-text-fill-color: transparent !important; } ' +
+            'input[type=checkbox], input[type=radio] { visibility: hidden !important; }';
+        document.head.appendChild(style);
+    });
+}
+
+// 打印前读取表单元素距文档顶部的位置（点）、当前的值及文字样式，与分页信息一起写入文件，用于生成 PDF 表单域。
+// 与书签一样按 PDF_ZOOM 换算
+function writeFormFields(layout) {
+    var items = page.evaluate(function (zoom) {
+        var list = [];
+        var elements = document.querySelectorAll('input, select, textarea');
+        for (var i = 0; i < elements.length; i++) {
+            var el = elements[i];
+            var type = (el.type || el.tagName).toLowerCase();
+            if (!el.name || /^(hidden|submit|reset|button|image|file)$/.test(type)) {
+                continue;
+            }
+            var box = el.getBoundingClientRect();
+            if (box.width === 0 || box.height === 0) {
+                continue;
+            }
+            var style = window.getComputedStyle(el);
+            var item = {
+                name: el.name,
+                type: type,
+                value: el.value,
+                checked: !!el.checked,
+                required: !!el.required,
+                read_only: !!(el.readOnly || el.disabled),
+                max_length: el.maxLength > 0 ? el.maxLength : 0,
+                size: el.size || 0,
+                font_size: (parseFloat(style.fontSize) || 0) * zoom,
+                color: style.color,
+                options: []
+            };
+            if (el.options) {
+                for (var j = 0; j < el.options.length; j++) {
+                    var option = el.options[j];
+                    item.options.push({value: option.value, label: option.text, selected: option.selected});
+                }
+            }
+            item.top = (box.top + window.scrollY) * zoom;
+            item.left = (box.left + window.scrollX) * zoom;
+            item.width = box.width * zoom;
+            item.height = box.height * zoom;
+            list.push(item);
+        }
+        return list;
+    }, PDF_ZOOM);
+    require('fs').write(options.form_file, JSON.stringify({layout: layout, inputs: items}), 'w');
+}
+
+function renderPDF() {
+    // A4 纸张高度（点）
+    var pageHeightPt = 841.89;
+    if (options.selector) {
+        if (!isolateElement(options.selector, options.isolate)) {
+            console.log('Unable to find the selector: ' + options.selector);
+            phantom.exit(1);
+            return;
+        }
+        if (options.fit_element) {
+            // 缩放前测量元素尺寸，渲染时 1px 对应 1pt
+            var size = elementSize(options.selector);
+            page.paperSize = {
+                margin: '0px',
+                width: Math.ceil(size.width * PDF_ZOOM) + 'px',
+                height: Math.ceil(size.height * PDF_ZOOM) + 'px'
+            };
+            pageHeightPt = Math.ceil(size.height * PDF_ZOOM);
+        }
+    }
+
+    if (options.single_page && !options.fit_element) {
+        pageHeightPt = fitDocumentHeight();
+        if (!pageHeightPt) {
+            phantom.exit(1);
+            return;
+        }
+    }
+
+    var outline = options.outline && options.outline_file,
+        formFields = options.form_fields && options.form_file;
+    var layout = (outline || formFields) ? printLayout(pageHeightPt) : null;
+    if (outline) {
+        writeOutline(layout);
+    }
+    if (formFields) {
+        writeFormFields(layout);
+        hideFormValues();
+    }
+
+    page.evaluate(function(zoom){
+        document.body.style.zoom = zoom;
+    }, PDF_ZOOM);
+    page.render(output, {format: 'pdf', quality: '10'});
+    phantom.exit();
+}
+
 page.open(address, function (status) {
     if (status !== 'success') {
         console.log('Unable to load the address!');
         phantom.exit(1);
     } else {
         window.setTimeout(function () {
-            page.evaluate(function(){
-                document.body.style.zoom = 0.48;
-            });
-            page.render(output, {format: 'pdf', quality: '10'});
-            phantom.exit();
+            if (isImage) {
+                renderImage();
+            } else {
+                renderPDF();
+            }
         }, 200);
     }
-});
\ No newline at end of file
+});